@@ -23,7 +23,10 @@ import (
 	"github.com/weiwei929/mypicoclaw/pkg/channels"
 	"github.com/weiwei929/mypicoclaw/pkg/config"
 	"github.com/weiwei929/mypicoclaw/pkg/cron"
+	"github.com/weiwei929/mypicoclaw/pkg/gateway"
 	"github.com/weiwei929/mypicoclaw/pkg/heartbeat"
+	"github.com/weiwei929/mypicoclaw/pkg/identity"
+	"github.com/weiwei929/mypicoclaw/pkg/kb"
 	"github.com/weiwei929/mypicoclaw/pkg/logger"
 	"github.com/weiwei929/mypicoclaw/pkg/providers"
 	"github.com/weiwei929/mypicoclaw/pkg/skills"
@@ -87,6 +90,12 @@ func main() {
 		statusCmd()
 	case "cron":
 		cronCmd()
+	case "exec-policy":
+		execPolicyCmd()
+	case "kb":
+		kbCmd()
+	case "identity":
+		identityCmd()
 	case "skills":
 		if len(os.Args) < 3 {
 			skillsHelp()
@@ -155,6 +164,9 @@ func printHelp() {
 	fmt.Println("  gateway     Start MyPicoClaw gateway")
 	fmt.Println("  status      Show MyPicoClaw status")
 	fmt.Println("  cron        Manage scheduled tasks")
+	fmt.Println("  exec-policy Test commands against the exec safety policy")
+	fmt.Println("  kb          Manage the local knowledge base (add, search, rm, stats)")
+	fmt.Println("  identity    Manage accounts linked across channels (list, link, unlink)")
 	fmt.Println("  skills      Manage skills (install, list, remove)")
 	fmt.Println("  version     Show version information")
 }
@@ -595,7 +607,34 @@ func gatewayCmd() {
 		fmt.Println("⚠ Warning: No channels enabled")
 	}
 
-	fmt.Printf("✓ Gateway started on %s:%d\n", cfg.Gateway.Host, cfg.Gateway.Port)
+	apiServer := gateway.NewServer(cfg.Gateway, agentLoop, agentLoop.Sessions(), cronService, channelManager)
+	if cfg.Gateway.Token != "" {
+		apiChannel := channels.NewAPIChannel(msgBus)
+		channelManager.RegisterChannel("api", apiChannel)
+		apiServer.SetReplies(apiChannel)
+
+		if cfg.Channels.Web.Enabled {
+			webConfig := cfg.Channels.Web
+			if webConfig.Token == "" && len(webConfig.Users) == 0 {
+				webConfig.Token = cfg.Gateway.Token
+			}
+			webChannel, err := channels.NewWebChannel(webConfig, msgBus, agentLoop.Sessions())
+			if err != nil {
+				fmt.Printf("Error creating web channel: %v\n", err)
+			} else {
+				channelManager.RegisterChannel("web", webChannel)
+				apiServer.Mount("/web/", webChannel.Handler())
+				fmt.Printf("✓ Web chat UI at http://%s:%d/web/\n", cfg.Gateway.Host, cfg.Gateway.Port)
+			}
+		}
+	}
+	if cfg.Gateway.Token == "" {
+		fmt.Println("⚠ HTTP API disabled: set gateway.token to enable it")
+	} else if err := apiServer.Start(); err != nil {
+		fmt.Printf("Error starting HTTP API: %v\n", err)
+	} else {
+		fmt.Printf("✓ Gateway started on %s:%d\n", cfg.Gateway.Host, cfg.Gateway.Port)
+	}
 	fmt.Println("Press Ctrl+C to stop")
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -623,6 +662,9 @@ func gatewayCmd() {
 
 	fmt.Println("\nShutting down...")
 	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	apiServer.Stop(shutdownCtx)
+	shutdownCancel()
 	heartbeatService.Stop()
 	cronService.Stop()
 	agentLoop.Stop()
@@ -766,7 +808,7 @@ func cronHelp() {
 	fmt.Println("  -c, --cron       Cron expression (e.g. '0 9 * * *')")
 	fmt.Println("  -d, --deliver     Deliver response to channel")
 	fmt.Println("  --to             Recipient for delivery")
-	fmt.Println("  --channel        Channel for delivery")
+	fmt.Println("  --channel        Channel for delivery (or http:<name> for an HTTP target)")
 }
 
 func cronListCmd(storePath string) {
@@ -927,6 +969,276 @@ func cronEnableCmd(storePath string, disable bool) {
 	}
 }
 
+func execPolicyCmd() {
+	if len(os.Args) < 4 || os.Args[2] != "test" {
+		execPolicyHelp()
+		return
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	policy, err := tools.NewExecPolicy(cfg.Tools.Exec)
+	if err != nil {
+		fmt.Printf("✗ Invalid exec policy: %v\n", err)
+		os.Exit(1)
+	}
+
+	command := strings.Join(os.Args[3:], " ")
+	decision := policy.Evaluate(command, cfg.WorkspacePath())
+
+	icon := "✓"
+	switch decision.Action {
+	case tools.ExecActionDeny:
+		icon = "⛔"
+	case tools.ExecActionWarn:
+		icon = "⚠️"
+	}
+
+	fmt.Printf("Command:  %s\n", command)
+	fmt.Printf("Decision: %s %s\n", icon, decision.Action)
+	fmt.Printf("Rule:     %s\n", decision.Rule)
+	if decision.Pattern != "" {
+		fmt.Printf("Pattern:  %s\n", decision.Pattern)
+	}
+	fmt.Printf("Reason:   %s\n", decision.Reason)
+	fmt.Printf("Timeout:  %v\n", decision.Timeout)
+}
+
+func execPolicyHelp() {
+	fmt.Println("\nExec policy commands:")
+	fmt.Println("  test \"<command>\"    Show which exec rule would fire for a command")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  MyPicoClaw exec-policy test \"rm -rf /tmp/cache\"")
+	fmt.Println("  MyPicoClaw exec-policy test \"dd if=/dev/zero of=/dev/sda\"")
+}
+
+func kbCmd() {
+	if len(os.Args) < 3 {
+		kbHelp()
+		return
+	}
+
+	subcommand := os.Args[2]
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	index, err := kb.Open(kb.DirFor(cfg), cfg.WorkspacePath(), kb.NewEmbedderFromConfig(cfg), cfg.KB)
+	if err != nil {
+		fmt.Printf("Error opening knowledge base: %v\n", err)
+		os.Exit(1)
+	}
+	index.Exclude(agent.NewMemoryStoreWithConfig(cfg.WorkspacePath(), cfg.Memory).UsersDir())
+
+	switch subcommand {
+	case "add":
+		kbAddCmd(index, os.Args[3:])
+	case "search":
+		kbSearchCmd(index, os.Args[3:])
+	case "rm", "remove":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: MyPicoClaw kb rm <path>")
+			return
+		}
+		kbRemoveCmd(index, os.Args[3])
+	case "stats":
+		kbStatsCmd(index, cfg)
+	default:
+		fmt.Printf("Unknown kb command: %s\n", subcommand)
+		kbHelp()
+	}
+}
+
+func kbHelp() {
+	fmt.Println("\nKnowledge base commands:")
+	fmt.Println("  add [paths...]        Ingest files or directories (no paths: re-sync everything added before)")
+	fmt.Println("  search <query>        Search the knowledge base")
+	fmt.Println("  rm <path>             Remove a file or directory from the knowledge base")
+	fmt.Println("  stats                 Show index statistics")
+	fmt.Println()
+	fmt.Println("Search options:")
+	fmt.Println("  -k, --top-k <n>       Number of results")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  MyPicoClaw kb add ~/.mypicoclaw/workspace/memory ~/notes")
+	fmt.Println("  MyPicoClaw kb search \"how do I renew the certificate\" -k 3")
+}
+
+func kbAddCmd(index *kb.Index, paths []string) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	stats, err := index.Ingest(ctx, paths)
+	if err != nil {
+		fmt.Printf("✗ Ingest failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ %s\n", stats)
+}
+
+func kbSearchCmd(index *kb.Index, args []string) {
+	k := 0
+	var words []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-k", "--top-k":
+			if i+1 < len(args) {
+				fmt.Sscanf(args[i+1], "%d", &k)
+				i++
+			}
+		default:
+			words = append(words, args[i])
+		}
+	}
+	if len(words) == 0 {
+		fmt.Println("Usage: MyPicoClaw kb search <query> [-k N]")
+		return
+	}
+
+	results, err := index.Search(context.Background(), strings.Join(words, " "), k, nil)
+	if err != nil {
+		fmt.Printf("✗ Search failed: %v\n", err)
+		os.Exit(1)
+	}
+	if len(results) == 0 {
+		fmt.Println("No results. Add files with: MyPicoClaw kb add <path>")
+		return
+	}
+	for i, r := range results {
+		fmt.Printf("\n%d. %s (%s)  score %.3f\n", i+1, r.Path, r.Location, r.Score)
+		if r.Heading != "" {
+			fmt.Printf("   %s\n", r.Heading)
+		}
+		text := r.Text
+		if runes := []rune(text); len(runes) > 400 {
+			text = string(runes[:400]) + "..."
+		}
+		fmt.Printf("   %s\n", strings.ReplaceAll(text, "\n", "\n   "))
+	}
+}
+
+func kbRemoveCmd(index *kb.Index, path string) {
+	removed, err := index.Remove(path)
+	if err != nil {
+		fmt.Printf("✗ Remove failed: %v\n", err)
+		os.Exit(1)
+	}
+	if removed == 0 {
+		fmt.Printf("✓ %s is no longer synced (no indexed files)\n", path)
+		return
+	}
+	fmt.Printf("✓ Removed %d file(s) under %s\n", removed, path)
+}
+
+func kbStatsCmd(index *kb.Index, cfg *config.Config) {
+	st, err := index.Stats()
+	if err != nil {
+		fmt.Printf("Error reading index: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Index:     %s\n", st.Dir)
+	if cfg.KB.Enabled {
+		fmt.Println("Tools:     enabled")
+	} else {
+		fmt.Println("Tools:     disabled (set kb.enabled to use kb_search)")
+	}
+	if st.Model != "" {
+		fmt.Printf("Model:     %s (%d dimensions)\n", st.Model, st.Dims)
+	}
+	fmt.Printf("Files:     %d\n", st.Files)
+	fmt.Printf("Chunks:    %d\n", st.Chunks)
+	fmt.Printf("Size:      %.1f KB\n", float64(st.Bytes)/1024)
+	if !st.Updated.IsZero() {
+		fmt.Printf("Updated:   %s\n", st.Updated.Format("2006-01-02 15:04"))
+	}
+	if len(st.Sources) > 0 {
+		fmt.Println("Sources:")
+		for _, s := range st.Sources {
+			fmt.Printf("  %s\n", s)
+		}
+	}
+}
+
+func identityCmd() {
+	if len(os.Args) < 3 {
+		identityHelp()
+		return
+	}
+
+	subcommand := os.Args[2]
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	registry, err := identity.Open(filepath.Join(cfg.WorkspacePath(), "identities.json"), 0)
+	if err != nil {
+		fmt.Printf("Error opening identities: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch subcommand {
+	case "list":
+		identities := registry.List()
+		if len(identities) == 0 {
+			fmt.Println("No linked accounts.")
+			return
+		}
+		for _, ident := range identities {
+			fmt.Printf("%s  %s\n", ident.ID, strings.Join(ident.Accounts, ", "))
+		}
+	case "link":
+		if len(os.Args) < 5 {
+			fmt.Println("Usage: MyPicoClaw identity link <account> <account>")
+			return
+		}
+		ident, err := registry.Link(os.Args[3], os.Args[4])
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
+		memory := agent.NewMemoryStoreWithConfig(cfg.WorkspacePath(), cfg.Memory)
+		for _, acc := range ident.Accounts {
+			memory.MergeUser(acc, ident.ID)
+		}
+		fmt.Printf("✓ Linked %s as %s\n", strings.Join(ident.Accounts, ", "), ident.ID)
+	case "unlink":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: MyPicoClaw identity unlink <account>")
+			return
+		}
+		if err := registry.Unlink(os.Args[3]); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Unlinked %s\n", os.Args[3])
+	default:
+		fmt.Printf("Unknown identity command: %s\n", subcommand)
+		identityHelp()
+	}
+}
+
+func identityHelp() {
+	fmt.Println("\nIdentity commands:")
+	fmt.Println("  list                  List identities and their linked accounts")
+	fmt.Println("  link <a> <b>          Link two accounts, e.g. telegram:123 discord:456")
+	fmt.Println("  unlink <account>      Detach an account from its identity")
+	fmt.Println()
+	fmt.Println("Users can also link themselves: send /link on one channel, then")
+	fmt.Println("/link <code> from the other account.")
+}
+
 func skillsCmd() {
 	if len(os.Args) < 3 {
 		skillsHelp()
@@ -0,0 +1,125 @@
+// Package access decides what a sender may do: which role they have, which
+// tools that role may call, which model answers them and how many messages
+// they may send per hour.
+package access
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+)
+
+// OwnerRole is the role of the local operator: the CLI, cron jobs and
+// background tasks.
+const OwnerRole = "owner"
+
+// Role is a resolved role.
+type Role struct {
+	Name             string
+	Model            string // empty means the default model
+	RateLimitPerHour int    // 0 means unlimited
+	tools            []string
+	deny             []string
+}
+
+// AllowsTool reports whether the role may call the named tool. Patterns
+// use path.Match syntax, e.g. "*" or "process_*".
+func (r Role) AllowsTool(name string) bool {
+	return matchAny(r.tools, name) && !matchAny(r.deny, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy maps users to roles and tracks their message rate. It is safe for
+// concurrent use.
+type Policy struct {
+	cfg config.AccessConfig
+
+	mu   sync.Mutex
+	sent map[string][]time.Time
+}
+
+func NewPolicy(cfg config.AccessConfig) *Policy {
+	return &Policy{cfg: cfg, sent: make(map[string][]time.Time)}
+}
+
+// Role returns the role with the given name. An owner role missing from
+// the config may use every tool.
+func (p *Policy) Role(name string) (Role, bool) {
+	rc, ok := p.cfg.Roles[name]
+	if !ok {
+		if name == OwnerRole {
+			return Role{Name: OwnerRole, tools: []string{"*"}}, true
+		}
+		return Role{}, false
+	}
+	return Role{
+		Name:             name,
+		Model:            rc.Model,
+		RateLimitPerHour: rc.RateLimitPerHour,
+		tools:            rc.Tools,
+		deny:             rc.DenyTools,
+	}, true
+}
+
+// RoleFor returns the role of a user known by keys, e.g. their identity ID
+// and channel account; the first key assigned a role in the config wins.
+// Users without one get the default role, or the group role in group
+// chats.
+func (p *Policy) RoleFor(keys []string, group bool) Role {
+	name := ""
+	for _, k := range keys {
+		if n, ok := p.cfg.Users[k]; ok {
+			name = n
+			break
+		}
+	}
+	if name == "" {
+		name = p.cfg.DefaultRole
+		if group && p.cfg.GroupRole != "" {
+			name = p.cfg.GroupRole
+		}
+	}
+	role, ok := p.Role(name)
+	if !ok {
+		logger.WarnCF("access", "Unknown role, allowing no tools",
+			map[string]interface{}{
+				"role": name,
+			})
+		return Role{Name: name}
+	}
+	return role
+}
+
+// Allow records a message from user and reports whether it is within the
+// role's hourly limit. When it is not, it also returns how long until the
+// user may send again.
+func (p *Policy) Allow(user string, role Role) (bool, time.Duration) {
+	if role.RateLimitPerHour <= 0 {
+		return true, 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	sent := p.sent[user]
+	for len(sent) > 0 && now.Sub(sent[0]) >= time.Hour {
+		sent = sent[1:]
+	}
+	if len(sent) >= role.RateLimitPerHour {
+		p.sent[user] = sent
+		return false, time.Hour - now.Sub(sent[0])
+	}
+	p.sent[user] = append(sent, now)
+	return true, 0
+}
@@ -0,0 +1,65 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func TestRoles(t *testing.T) {
+	cfg := config.DefaultConfig().Access
+	cfg.Users = map[string]string{"user-1": "owner", "telegram:7": "guest"}
+	p := NewPolicy(cfg)
+
+	tests := []struct {
+		keys  []string
+		group bool
+		want  string
+	}{
+		{[]string{"user-1", "telegram:1"}, true, "owner"},
+		{[]string{"telegram:7"}, false, "guest"},
+		{[]string{"discord:2"}, false, "member"},
+		{[]string{"discord:2"}, true, "guest"},
+	}
+	for _, tt := range tests {
+		if got := p.RoleFor(tt.keys, tt.group).Name; got != tt.want {
+			t.Errorf("RoleFor(%v, %v) = %s, want %s", tt.keys, tt.group, got, tt.want)
+		}
+	}
+
+	member, _ := p.Role("member")
+	guest, _ := p.Role("guest")
+	for tool, want := range map[string][2]bool{
+		"write_file":    {true, false},
+		"exec":          {false, false},
+		"process_start": {false, false},
+		"web_search":    {true, true},
+	} {
+		if member.AllowsTool(tool) != want[0] || guest.AllowsTool(tool) != want[1] {
+			t.Errorf("%s: member %v guest %v, want %v", tool, member.AllowsTool(tool), guest.AllowsTool(tool), want)
+		}
+	}
+	if unknown := p.RoleFor([]string{"x"}, false); unknown.Name != "member" {
+		t.Errorf("unassigned user got %s", unknown.Name)
+	}
+	cfg.DefaultRole = "missing"
+	if r := NewPolicy(cfg).RoleFor(nil, false); r.AllowsTool("web_search") {
+		t.Error("an unknown role must not allow tools")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	p := NewPolicy(config.DefaultConfig().Access)
+	role := Role{Name: "guest", RateLimitPerHour: 2}
+	for i := 0; i < 2; i++ {
+		if ok, _ := p.Allow("a", role); !ok {
+			t.Fatalf("message %d refused", i+1)
+		}
+	}
+	if ok, wait := p.Allow("a", role); ok || wait <= 0 {
+		t.Fatalf("third message allowed=%v wait=%v", ok, wait)
+	}
+	if ok, _ := p.Allow("b", role); !ok {
+		t.Fatal("limits must be per user")
+	}
+}
@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"github.com/weiwei929/mypicoclaw/pkg/access"
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/identity"
+	"github.com/weiwei929/mypicoclaw/pkg/tools"
+)
+
+// roleFor returns the role of the sender of msg and the key their message
+// rate is counted under: their identity ID when their accounts are linked,
+// otherwise their account. The role is nil when access control is off.
+func (al *AgentLoop) roleFor(msg bus.InboundMessage) (*access.Role, string) {
+	if al.access == nil {
+		return nil, ""
+	}
+	// The CLI, cron jobs and the token-protected HTTP API act for the owner
+	if msg.Trusted {
+		owner, _ := al.access.Role(access.OwnerRole)
+		return &owner, ""
+	}
+
+	account := identity.Account(msg.Channel, msg.SenderID)
+	keys := []string{account}
+	if al.identities != nil {
+		if ident, ok := al.identities.Resolve(account); ok {
+			keys = []string{ident.ID, account}
+		}
+	}
+	role := al.access.RoleFor(keys, !isDirectMessage(msg))
+	return &role, keys[0]
+}
+
+// pathFilter keeps the file tools of every sender out of the memory of other
+// users; their own stays accessible. It applies whether or not access control
+// is on, and is nil only for trusted messages and the owner.
+func (al *AgentLoop) pathFilter(trusted bool, role *access.Role, scope MemoryScope) tools.PathFilter {
+	if trusted || role != nil && role.Name == access.OwnerRole {
+		return nil
+	}
+	memory := al.contextBuilder.Memory()
+	return tools.DenyWithin(memory.UsersDir(), memory.UserDir(scope))
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/weiwei929/mypicoclaw/pkg/access"
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func TestRoleForTrust(t *testing.T) {
+	al := &AgentLoop{access: access.NewPolicy(config.DefaultConfig().Access)}
+
+	tests := []struct {
+		name string
+		msg  bus.InboundMessage
+		want string
+	}{
+		{"cron job", bus.InboundMessage{Channel: "telegram", SenderID: "cron", ChatID: "42", Trusted: true}, "owner"},
+		{"web user named cron", bus.InboundMessage{Channel: "web", SenderID: "cron", ChatID: "cron.1a2b", Metadata: map[string]string{"is_dm": "true"}}, "member"},
+		{"group member named cron", bus.InboundMessage{Channel: "telegram", SenderID: "cron", ChatID: "-100", Metadata: map[string]string{"is_group": "true"}}, "guest"},
+		{"empty sender", bus.InboundMessage{Channel: "api", ChatID: "x", Metadata: map[string]string{"is_group": "true"}}, "guest"},
+	}
+	for _, tt := range tests {
+		role, _ := al.roleFor(tt.msg)
+		if role == nil || role.Name != tt.want {
+			t.Errorf("%s: role = %+v, want %s", tt.name, role, tt.want)
+		}
+	}
+}
+
+func TestPathFilterWithoutAccessControl(t *testing.T) {
+	workspace := t.TempDir()
+	al := &AgentLoop{contextBuilder: NewContextBuilder(workspace, nil)}
+	memory := al.contextBuilder.Memory()
+	alice := MemoryScope{User: "telegram:1"}
+	own := filepath.Join(memory.UserDir(alice), "facts.json")
+	other := filepath.Join(memory.UserDir(MemoryScope{User: "telegram:2"}), "facts.json")
+
+	// Access control is off, so there is no role
+	allow := al.pathFilter(false, nil, alice)
+	if allow == nil {
+		t.Fatal("no path filter for a channel sender")
+	}
+	if !allow(own) || allow(other) {
+		t.Errorf("own allowed = %v, other allowed = %v", allow(own), allow(other))
+	}
+	if allow = al.pathFilter(false, nil, MemoryScope{}); allow(other) {
+		t.Error("a sender without a scope can read users' memory")
+	}
+	if al.pathFilter(true, nil, MemoryScope{}) != nil {
+		t.Error("trusted messages are filtered")
+	}
+}
@@ -38,26 +38,43 @@ func NewContextBuilder(workspace string, cfg *config.Config) *ContextBuilder {
 	builtinSkillsDir := filepath.Join(wd, "skills")
 	globalSkillsDir := filepath.Join(getGlobalConfigDir(), "skills")
 
+	memoryStore := NewMemoryStore(workspace)
+	if cfg != nil {
+		memoryStore = NewMemoryStoreWithConfig(workspace, cfg.Memory)
+	}
+
 	return &ContextBuilder{
 		workspace:    workspace,
 		config:       cfg,
 		skillsLoader: skills.NewSkillsLoader(workspace, globalSkillsDir, builtinSkillsDir),
-		memory:       NewMemoryStore(workspace),
+		memory:       memoryStore,
 	}
 }
 
+// Memory returns the memory store used for the system prompt.
+func (cb *ContextBuilder) Memory() *MemoryStore {
+	return cb.memory
+}
+
 // SetToolsRegistry sets the tools registry for dynamic tool summary generation.
 func (cb *ContextBuilder) SetToolsRegistry(registry *tools.ToolRegistry) {
 	cb.tools = registry
 }
 
-func (cb *ContextBuilder) getIdentity() string {
+func (cb *ContextBuilder) getIdentity(scope MemoryScope, allow tools.ToolFilter) string {
 	now := time.Now().Format("2006-01-02 15:04 (Monday)")
 	workspacePath, _ := filepath.Abs(filepath.Join(cb.workspace))
 	runtime := fmt.Sprintf("%s %s, Go %s", runtime.GOOS, runtime.GOARCH, runtime.Version())
 
+	// Users other than the owner keep notes of their own
+	notesFile := cb.memory.NotesPath(scope)
+	memorySection := fmt.Sprintf("- Memory: %s (facts are saved with memory_save)", notesFile)
+	if scope.sharesNotes() {
+		memorySection += fmt.Sprintf("\n- Daily Notes: %s/memory/YYYYMM/YYYYMMDD.md", workspacePath)
+	}
+
 	// Build tools section dynamically
-	toolsSection := cb.buildToolsSection()
+	toolsSection := cb.buildToolsSection(allow)
 
 	// Build storage VPS section dynamically
 	storageSection := ""
@@ -87,8 +104,7 @@ You are MyPicoClaw, a concise and efficient personal AI assistant.
 
 ## Workspace
 Your workspace is at: %s
-- Memory: %s/memory/MEMORY.md
-- Daily Notes: %s/memory/YYYYMM/YYYYMMDD.md
+%s
 - Skills: %s/skills/{skill-name}/SKILL.md
 
 %s
@@ -107,16 +123,17 @@ You have the following core skills built-in. Use the "exec" tool to run them dir
 
 2. **Skill First** - If the user asks for something covered by a Skill, read the skill definition first.
 
-3. **Memory** - When remembering something, write to %s/memory/MEMORY.md`,
-		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, storageSection, workspacePath)
+3. **Memory** - When the user tells you something worth remembering (preferences, facts about them, decisions), call memory_save; use memory_search to recall and memory_forget when asked to forget. Longer notes go in %s`,
+		now, runtime, workspacePath, memorySection, workspacePath, toolsSection, storageSection, notesFile)
 }
 
-func (cb *ContextBuilder) buildToolsSection() string {
+// buildToolsSection lists the tools that allow accepts; nil lists all.
+func (cb *ContextBuilder) buildToolsSection(allow tools.ToolFilter) string {
 	if cb.tools == nil {
 		return ""
 	}
 
-	summaries := cb.tools.GetSummaries()
+	summaries := cb.tools.GetAllowedSummaries(allow)
 	if len(summaries) == 0 {
 		return ""
 	}
@@ -133,14 +150,17 @@ func (cb *ContextBuilder) buildToolsSection() string {
 	return sb.String()
 }
 
-func (cb *ContextBuilder) BuildSystemPrompt() string {
+// BuildSystemPrompt assembles the system prompt for a conversation in the
+// given memory scope, listing the tools allow accepts. currentMessage
+// selects which memories are relevant enough to include.
+func (cb *ContextBuilder) BuildSystemPrompt(currentMessage string, scope MemoryScope, allow tools.ToolFilter) string {
 	parts := []string{}
 
 	// Core identity section
-	parts = append(parts, cb.getIdentity())
+	parts = append(parts, cb.getIdentity(scope, allow))
 
 	// Bootstrap files
-	bootstrapContent := cb.LoadBootstrapFiles()
+	bootstrapContent := cb.LoadBootstrapFiles(scope)
 	if bootstrapContent != "" {
 		parts = append(parts, bootstrapContent)
 	}
@@ -156,16 +176,19 @@ The following skills extend your capabilities. To use a skill, read its SKILL.md
 	}
 
 	// Memory context
-	memoryContext := cb.memory.GetMemoryContext()
+	memoryContext := cb.memory.GetMemoryContext(currentMessage, scope)
 	if memoryContext != "" {
-		parts = append(parts, "# Memory\n\n"+memoryContext)
+		parts = append(parts, memoryContext)
 	}
 
 	// Join with "---" separator
 	return strings.Join(parts, "\n\n---\n\n")
 }
 
-func (cb *ContextBuilder) LoadBootstrapFiles() string {
+// LoadBootstrapFiles reads the workspace bootstrap files. A user with their
+// own USER.md in memory/users/<user>/ gets it instead of the workspace one,
+// which describes the owner and is left out for everyone else.
+func (cb *ContextBuilder) LoadBootstrapFiles(scope MemoryScope) string {
 	bootstrapFiles := []string{
 		"AGENTS.md",
 		"SOUL.md",
@@ -176,6 +199,13 @@ func (cb *ContextBuilder) LoadBootstrapFiles() string {
 	var result string
 	for _, filename := range bootstrapFiles {
 		filePath := filepath.Join(cb.workspace, filename)
+		if profile := cb.memory.UserProfilePath(scope); filename == "USER.md" && profile != "" {
+			if _, err := os.Stat(profile); err == nil {
+				filePath = profile
+			} else if !scope.sharesNotes() {
+				continue
+			}
+		}
 		if data, err := os.ReadFile(filePath); err == nil {
 			result += fmt.Sprintf("## %s\n\n%s\n\n", filename, string(data))
 		}
@@ -184,14 +214,18 @@ func (cb *ContextBuilder) LoadBootstrapFiles() string {
 	return result
 }
 
-func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary string, currentMessage string, media []string, channel, chatID string) []providers.Message {
+func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary string, currentMessage string, media []string, channel, chatID string, scope MemoryScope, allow tools.ToolFilter) []providers.Message {
 	messages := []providers.Message{}
 
-	systemPrompt := cb.BuildSystemPrompt()
+	systemPrompt := cb.BuildSystemPrompt(currentMessage, scope, allow)
 
 	// Add Current Session info if provided
 	if channel != "" && chatID != "" {
 		systemPrompt += fmt.Sprintf("\n\n## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
+		if scope.User != "" {
+			systemPrompt += fmt.Sprintf("\nUser: %s\nUser profile: %s (keep notes on how to help this user there; memory_save stores facts for this user only unless shared is set)",
+				scope.User, cb.memory.UserProfilePath(scope))
+		}
 	}
 
 	// Log system prompt summary for debugging (debug mode only)
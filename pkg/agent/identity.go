@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/identity"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+)
+
+// handleIdentityCommand answers the account linking commands:
+//
+//	/link         issue a pairing code on this account
+//	/link <code>  confirm a code issued on another account
+//	/unlink       detach this account from its identity
+//
+// It reports false for any other message.
+func (al *AgentLoop) handleIdentityCommand(msg bus.InboundMessage) (string, bool) {
+	fields := strings.Fields(msg.Content)
+	if len(fields) == 0 || (fields[0] != "/link" && fields[0] != "/unlink") {
+		return "", false
+	}
+	if al.identities == nil {
+		return "🦞 账号关联未启用。", true
+	}
+	if msg.Trusted || msg.SenderID == "" {
+		return "🦞 请在聊天渠道中使用账号关联。", true
+	}
+	// Anyone who sees a code can use it, so codes are never shown in groups
+	if fields[0] == "/link" && !isDirectMessage(msg) {
+		return "🦞 请在与我的私聊中使用 /link，不要在群聊里发送关联码。", true
+	}
+	account := identity.Account(msg.Channel, msg.SenderID)
+	memory := al.contextBuilder.Memory()
+
+	switch {
+	case fields[0] == "/unlink":
+		if err := al.identities.Unlink(account); err != nil {
+			return fmt.Sprintf("🦞 %v", err), true
+		}
+		logger.InfoCF("agent", "Account unlinked",
+			map[string]interface{}{
+				"account": account,
+			})
+		return "🦞 已解除关联，此账号今后使用独立的记忆与会话。", true
+
+	case len(fields) == 1:
+		code, expires, err := al.identities.StartPairing(account)
+		if err != nil {
+			return fmt.Sprintf("🦞 %v", err), true
+		}
+		reply := fmt.Sprintf("🦞 关联码：%s\n请在 %s 前，用你在其他渠道的账号向我发送：/link %s",
+			code, expires.Format("15:04"), code)
+		if ident, ok := al.identities.Resolve(account); ok {
+			reply += "\n\n已关联的账号：" + strings.Join(ident.Accounts, ", ")
+		}
+		return reply, true
+
+	default:
+		ident, err := al.identities.ConfirmPairing(account, fields[1])
+		if err != nil {
+			return fmt.Sprintf("🦞 %v", err), true
+		}
+		// Memory kept per account until now moves to the identity
+		for _, acc := range ident.Accounts {
+			memory.MergeUser(acc, ident.ID)
+		}
+		logger.InfoCF("agent", "Accounts linked",
+			map[string]interface{}{
+				"identity": ident.ID,
+				"accounts": strings.Join(ident.Accounts, ","),
+			})
+		return "🦞 关联成功！这些账号现在共享记忆：" + strings.Join(ident.Accounts, ", "), true
+	}
+}
+
+// sharedSessionKey returns the session that direct messages of a linked
+// user continue across channels, or "" to keep the channel's own session.
+func (al *AgentLoop) sharedSessionKey(msg bus.InboundMessage) string {
+	if al.identities == nil || !al.sharedSession || !isDirectMessage(msg) {
+		return ""
+	}
+	ident, ok := al.identities.Resolve(identity.Account(msg.Channel, msg.SenderID))
+	if !ok {
+		return ""
+	}
+	return "user:" + ident.ID
+}
+
+// isDirectMessage reports whether msg came from a one-to-one chat, going by
+// the metadata each channel sets.
+func isDirectMessage(msg bus.InboundMessage) bool {
+	md := msg.Metadata
+	switch {
+	case md["is_dm"] != "":
+		return md["is_dm"] == "true"
+	case md["is_group"] != "":
+		return md["is_group"] == "false"
+	case md["chat_type"] != "":
+		return md["chat_type"] == "p2p"
+	case md["conversation_type"] != "":
+		return md["conversation_type"] == "1"
+	}
+	return identity.Account(msg.Channel, msg.SenderID) == msg.Channel+":"+msg.ChatID
+}
@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/identity"
+)
+
+func TestLinkOnlyInDirectMessages(t *testing.T) {
+	reg, err := identity.Open(filepath.Join(t.TempDir(), "identities.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al := &AgentLoop{identities: reg}
+
+	for _, content := range []string{"/link", "/link 123456"} {
+		msg := bus.InboundMessage{Channel: "telegram", SenderID: "1", ChatID: "-100", Content: content,
+			Metadata: map[string]string{"is_group": "true"}}
+		reply, ok := al.handleIdentityCommand(msg)
+		if !ok || !strings.Contains(reply, "私聊") {
+			t.Errorf("%s in a group: %q", content, reply)
+		}
+	}
+}
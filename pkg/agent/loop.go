@@ -16,9 +16,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/weiwei929/mypicoclaw/pkg/access"
 	"github.com/weiwei929/mypicoclaw/pkg/bus"
 	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/identity"
+	"github.com/weiwei929/mypicoclaw/pkg/kb"
 	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/memory"
 	"github.com/weiwei929/mypicoclaw/pkg/providers"
 	"github.com/weiwei929/mypicoclaw/pkg/session"
 	"github.com/weiwei929/mypicoclaw/pkg/tools"
@@ -36,8 +40,27 @@ type AgentLoop struct {
 	sessions       *session.SessionManager
 	contextBuilder *ContextBuilder
 	tools          *tools.ToolRegistry
+	processes      *tools.ProcessManager
 	running        bool
 	summarizing    sync.Map      // Tracks which sessions are currently being summarized
+	extractor      *memory.Extractor // Extracts facts from finished conversations; nil if disabled
+	sessionScopes  sync.Map          // Session key -> sessionScope, whose memory a session's facts go to
+	identities     *identity.Registry // Links one person's accounts across channels; nil if disabled
+	sharedSession  bool               // Whether linked users' direct messages share one session
+	sessionRoutes  sync.Map           // "channel:chatID" -> shared session key last used there
+	access         *access.Policy     // Roles, tool permissions and rate limits; nil if disabled
+	sessionLocks   sync.Map           // Session key -> *sync.Mutex, one message at a time per session
+}
+
+// sessionScope records whose memory a session belongs to. Sessions that
+// several users talked in, such as group chats, are mixed and facts are not
+// extracted from them automatically.
+type sessionScope struct {
+	scope   MemoryScope
+	account string       // last sender, e.g. "telegram:123"
+	role    *access.Role // role of the last sender, for background results
+	trusted bool         // whether the last message came from the owner's own tools
+	mixed   bool
 }
 
 // processOptions configures how a message is processed
@@ -49,21 +72,77 @@ type processOptions struct {
 	DefaultResponse string // Response when LLM returns empty
 	EnableSummary   bool   // Whether to trigger summarization
 	SendResponse    bool   // Whether to send response via bus
+	MemoryScope     MemoryScope // Whose memory and profile to use
+	Role            *access.Role // Limits tools and picks the model; nil means unrestricted
+	Trusted         bool         // From the CLI, cron or the HTTP API rather than a channel user
 }
 
 func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers.LLMProvider) *AgentLoop {
 	workspace := cfg.WorkspacePath()
 	os.MkdirAll(workspace, 0755)
 
+	// File tools are confined to the workspace, configured extra roots, and
+	// read-only access to skills and downloaded media
+	wd, _ := os.Getwd()
+	pathResolver := tools.NewPathResolverFromConfig(workspace, cfg.Tools.Filesystem,
+		tools.PathRoot{Path: filepath.Join(wd, "skills"), ReadOnly: true},
+		tools.PathRoot{Path: filepath.Join(getGlobalConfigDir(), "skills"), ReadOnly: true},
+		tools.PathRoot{Path: utils.MediaDir(), ReadOnly: true},
+	)
+
 	toolsRegistry := tools.NewToolRegistry()
-	toolsRegistry.Register(&tools.ReadFileTool{})
-	toolsRegistry.Register(&tools.WriteFileTool{})
-	toolsRegistry.Register(&tools.ListDirTool{})
-	toolsRegistry.Register(tools.NewExecTool(workspace))
+	toolsRegistry.Register(tools.NewReadFileTool(pathResolver, cfg.Tools.Filesystem.MaxReadBytes))
+	toolsRegistry.Register(tools.NewReadDocumentTool(pathResolver, cfg.Tools.Filesystem.MaxReadBytes))
+	toolsRegistry.Register(tools.NewWriteFileTool(pathResolver))
+	toolsRegistry.Register(tools.NewListDirTool(pathResolver))
+	toolsRegistry.Register(tools.NewGlobTool(pathResolver, cfg.Tools.Filesystem.IgnorePatterns))
+	toolsRegistry.Register(tools.NewGrepTool(pathResolver, cfg.Tools.Filesystem.IgnorePatterns))
+	toolsRegistry.Register(tools.NewApplyPatchTool(pathResolver))
+
+	// A broken exec policy disables shell commands rather than falling back
+	// to the looser defaults the operator meant to tighten
+	var processManager *tools.ProcessManager
+	execTool, err := tools.NewExecToolWithConfig(workspace, cfg.Tools.Exec)
+	if err != nil {
+		logger.ErrorCF("agent", "Invalid exec policy in config, exec and process tools disabled",
+			map[string]interface{}{
+				"error": err.Error(),
+			})
+	} else {
+		toolsRegistry.Register(execTool)
 
-	braveAPIKey := cfg.Tools.Web.Search.APIKey
-	toolsRegistry.Register(tools.NewWebSearchTool(braveAPIKey, cfg.Tools.Web.Search.MaxResults))
-	toolsRegistry.Register(tools.NewWebFetchTool(8000))
+		// Register background process tools; processes outlive a single agent turn
+		processManager = tools.NewProcessManager(workspace, execTool.Policy(), msgBus, cfg.Tools.Process)
+		toolsRegistry.Register(tools.NewProcessStartTool(processManager))
+		toolsRegistry.Register(tools.NewProcessOutputTool(processManager))
+		toolsRegistry.Register(tools.NewProcessSendInputTool(processManager))
+		toolsRegistry.Register(tools.NewProcessKillTool(processManager))
+		toolsRegistry.Register(tools.NewProcessListTool(processManager))
+	}
+
+	searchTool, err := tools.NewWebSearchToolWithConfig(cfg.Tools.Web.Search)
+	if err != nil {
+		logger.ErrorCF("agent", "Invalid web search config, web_search disabled",
+			map[string]interface{}{
+				"error": err.Error(),
+			})
+	}
+	fetchTool := tools.NewWebFetchToolWithConfig(cfg.Tools.Web.Fetch)
+	if cfg.Tools.Web.Cache.Enabled {
+		cacheDir := cfg.Tools.Web.Cache.Dir
+		if cacheDir == "" {
+			cacheDir = filepath.Join(workspace, "cache", "web")
+		}
+		webCache := tools.NewWebCache(config.ExpandHome(cacheDir), cfg.Tools.Web.Cache)
+		if searchTool != nil {
+			searchTool.SetCache(webCache)
+		}
+		fetchTool.SetCache(webCache)
+	}
+	if searchTool != nil {
+		toolsRegistry.Register(searchTool)
+	}
+	toolsRegistry.Register(fetchTool)
 
 	// Register message tool
 	messageTool := tools.NewMessageTool()
@@ -82,9 +161,10 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	spawnTool := tools.NewSpawnTool(subagentManager)
 	toolsRegistry.Register(spawnTool)
 
-	// Register edit file tool
-	editFileTool := tools.NewEditFileTool(workspace)
+	// Register edit and append file tools
+	editFileTool := tools.NewEditFileTool(pathResolver)
 	toolsRegistry.Register(editFileTool)
+	toolsRegistry.Register(tools.NewAppendFileTool(pathResolver))
 
 	sessionsManager := session.NewSessionManager(filepath.Join(workspace, "sessions"))
 
@@ -92,6 +172,57 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	contextBuilder := NewContextBuilder(workspace, cfg)
 	contextBuilder.SetToolsRegistry(toolsRegistry)
 
+	// Register structured memory tools; they switch to the current user's
+	// facts for each message
+	facts := contextBuilder.Memory().SharedFacts()
+	toolsRegistry.Register(tools.NewMemorySaveTool(facts))
+	toolsRegistry.Register(tools.NewMemorySearchTool(facts))
+	toolsRegistry.Register(tools.NewMemoryForgetTool(facts))
+
+	// Register knowledge base tools
+	if cfg.KB.Enabled {
+		index, err := kb.Open(kb.DirFor(cfg), workspace, kb.NewEmbedderFromConfig(cfg), cfg.KB)
+		if err != nil {
+			logger.ErrorCF("agent", "Knowledge base unavailable",
+				map[string]interface{}{
+					"error": err.Error(),
+				})
+		} else {
+			index.Exclude(contextBuilder.Memory().UsersDir())
+			toolsRegistry.Register(tools.NewKBSearchTool(index))
+			toolsRegistry.Register(tools.NewKBIngestTool(index, pathResolver))
+		}
+	}
+
+	var identities *identity.Registry
+	if cfg.Identity.Enabled {
+		var err error
+		identities, err = identity.Open(filepath.Join(workspace, "identities.json"),
+			time.Duration(cfg.Identity.PairingTTLMinutes)*time.Minute)
+		if err != nil {
+			logger.WarnCF("agent", "Account linking disabled",
+				map[string]interface{}{
+					"error": err.Error(),
+				})
+		} else {
+			contextBuilder.Memory().SetIdentities(identities)
+		}
+	}
+
+	var policy *access.Policy
+	if cfg.Access.Enabled {
+		policy = access.NewPolicy(cfg.Access)
+	}
+
+	var extractor *memory.Extractor
+	if cfg.Memory.AutoExtract {
+		extractModel := cfg.Memory.ExtractModel
+		if extractModel == "" {
+			extractModel = cfg.Agents.Defaults.Model
+		}
+		extractor = memory.NewExtractor(provider, extractModel)
+	}
+
 	return &AgentLoop{
 		bus:            msgBus,
 		provider:       provider,
@@ -103,8 +234,13 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 		sessions:       sessionsManager,
 		contextBuilder: contextBuilder,
 		tools:          toolsRegistry,
+		processes:      processManager,
 		running:        false,
 		summarizing:    sync.Map{},
+		extractor:      extractor,
+		identities:     identities,
+		sharedSession:  cfg.Identity.SharedSession,
+		access:         policy,
 	}
 }
 
@@ -141,6 +277,9 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 
 func (al *AgentLoop) Stop() {
 	al.running = false
+	if al.processes != nil {
+		al.processes.KillAll()
+	}
 }
 
 func (al *AgentLoop) RegisterTool(tool tools.Tool) {
@@ -151,6 +290,17 @@ func (al *AgentLoop) ProcessDirect(ctx context.Context, content, sessionKey stri
 	return al.ProcessDirectWithChannel(ctx, content, sessionKey, "cli", "direct")
 }
 
+// ProcessInbound processes a message as if a channel had received it and
+// returns the reply instead of sending it. The gateway HTTP API uses it.
+func (al *AgentLoop) ProcessInbound(ctx context.Context, msg bus.InboundMessage) (string, error) {
+	return al.processMessage(ctx, msg)
+}
+
+// Sessions returns the conversation sessions of the agent.
+func (al *AgentLoop) Sessions() *session.SessionManager {
+	return al.sessions
+}
+
 func (al *AgentLoop) ProcessDirectWithChannel(ctx context.Context, content, sessionKey, channel, chatID string) (string, error) {
 	msg := bus.InboundMessage{
 		Channel:    channel,
@@ -158,6 +308,7 @@ func (al *AgentLoop) ProcessDirectWithChannel(ctx context.Context, content, sess
 		ChatID:     chatID,
 		Content:    content,
 		SessionKey: sessionKey,
+		Trusted:    true,
 	}
 
 	return al.processMessage(ctx, msg)
@@ -179,7 +330,39 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		return al.processSystemMessage(ctx, msg)
 	}
 
+	if reply, ok := al.handleIdentityCommand(msg); ok {
+		return reply, nil
+	}
+
+	// Direct messages of a linked user continue one session across channels
+	if key := al.sharedSessionKey(msg); key != "" {
+		msg.SessionKey = key
+		al.sessionRoutes.Store(msg.Channel+":"+msg.ChatID, key)
+	} else {
+		al.sessionRoutes.Delete(msg.Channel + ":" + msg.ChatID)
+	}
+	defer al.lockSession(msg.SessionKey)()
+
 	// Process as user message
+	var scope MemoryScope
+	if !msg.Trusted {
+		scope = al.contextBuilder.Memory().ScopeFor(msg.Channel, msg.SenderID, msg.ChatID)
+	}
+	role, user := al.roleFor(msg)
+	if scope.User != "" && role != nil && role.Name == access.OwnerRole && isDirectMessage(msg) {
+		scope.Owner = true
+	}
+	if role != nil {
+		if ok, wait := al.access.Allow(user, *role); !ok {
+			logger.WarnCF("agent", "Rate limit reached",
+				map[string]interface{}{
+					"sender_id": msg.SenderID,
+					"role":      role.Name,
+				})
+			return fmt.Sprintf("🦞 消息太频繁了，请在 %d 分钟后再试。", int(wait.Minutes())+1), nil
+		}
+	}
+	al.recordSessionScope(msg.SessionKey, identity.Account(msg.Channel, msg.SenderID), scope, role, msg.Trusted)
 	return al.runAgentLoop(ctx, processOptions{
 		SessionKey:      msg.SessionKey,
 		Channel:         msg.Channel,
@@ -188,6 +371,9 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		DefaultResponse: "I've completed processing but have no response to give.",
 		EnableSummary:   true,
 		SendResponse:    false,
+		MemoryScope:     scope,
+		Role:            role,
+		Trusted:         msg.Trusted,
 	})
 }
 
@@ -214,8 +400,22 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 		originChatID = msg.ChatID
 	}
 
-	// Use the origin session for context
+	// Use the origin session for context, and the memory of its user
 	sessionKey := fmt.Sprintf("%s:%s", originChannel, originChatID)
+	if key, ok := al.sessionRoutes.Load(sessionKey); ok {
+		sessionKey = key.(string)
+	}
+	defer al.lockSession(sessionKey)()
+	var scope MemoryScope
+	var role *access.Role
+	trusted := true
+	if v, ok := al.sessionScopes.Load(sessionKey); ok {
+		if !v.(sessionScope).mixed {
+			scope = v.(sessionScope).scope
+		}
+		role = v.(sessionScope).role
+		trusted = v.(sessionScope).trusted
+	}
 
 	// Process as system message with routing back to origin
 	return al.runAgentLoop(ctx, processOptions{
@@ -226,6 +426,9 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 		DefaultResponse: "Background task completed.",
 		EnableSummary:   false,
 		SendResponse:    true, // Send response back to original channel
+		MemoryScope:     scope,
+		Role:            role,
+		Trusted:         trusted,
 	})
 }
 
@@ -235,12 +438,23 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 	// Check for slash commands: /new or /reset
 	msgContent := strings.TrimSpace(opts.UserMessage)
 	if msgContent == "/new" || msgContent == "/reset" {
+		if history := al.sessions.GetHistory(opts.SessionKey); len(history) > 0 {
+			go al.extractMemories(opts.SessionKey, history)
+		}
 		al.sessions.ArchiveAndReset(opts.SessionKey)
 		return "🗑️ 已归档上文，咱们重新开始吧！", nil
 	}
 
-	// 1. Update tool contexts
-	al.updateToolContexts(opts.Channel, opts.ChatID)
+	// 1. Tell tools the chat and user they serve, limited to the sender's role.
+	// This is per call, so messages of different sessions run in parallel.
+	var allow tools.ToolFilter
+	if opts.Role != nil {
+		allow = opts.Role.AllowsTool
+	}
+	ctx = tools.WithToolFilter(ctx, allow)
+	ctx = tools.WithPathFilter(ctx, al.pathFilter(opts.Trusted, opts.Role, opts.MemoryScope))
+	ctx = tools.WithChat(ctx, opts.Channel, opts.ChatID)
+	ctx = tools.WithFactStore(ctx, al.contextBuilder.Memory().Facts(opts.MemoryScope))
 
 	// 2. Build messages
 	history := al.sessions.GetHistory(opts.SessionKey)
@@ -252,6 +466,8 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		nil,
 		opts.Channel,
 		opts.ChatID,
+		opts.MemoryScope,
+		allow,
 	)
 
 	// 2.5 Pre-flight check: if context usage > 90%, archive and reset
@@ -268,7 +484,7 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		al.sessions.ArchiveAndReset(opts.SessionKey)
 		// Re-build messages with empty history
 		messages = al.contextBuilder.BuildMessages(
-			nil, "", opts.UserMessage, nil, opts.Channel, opts.ChatID,
+			nil, "", opts.UserMessage, nil, opts.Channel, opts.ChatID, opts.MemoryScope, allow,
 		)
 	}
 
@@ -338,6 +554,16 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 	var finalContent string
 	messageSent := false
 
+	// The sender's role may limit the tools and pick another model
+	model := al.model
+	var allow tools.ToolFilter
+	if opts.Role != nil {
+		allow = opts.Role.AllowsTool
+		if opts.Role.Model != "" {
+			model = opts.Role.Model
+		}
+	}
+
 	for iteration < al.maxIterations {
 		iteration++
 
@@ -348,7 +574,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 
 		// Build tool definitions
-		toolDefs := al.tools.GetDefinitions()
+		toolDefs := al.tools.GetAllowedDefinitions(allow)
 		providerToolDefs := make([]providers.ToolDefinition, 0, len(toolDefs))
 		for _, td := range toolDefs {
 			providerToolDefs = append(providerToolDefs, providers.ToolDefinition{
@@ -364,12 +590,12 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		// Log LLM request details
 		logger.DebugCF("agent", "LLM request",
 			map[string]interface{}{
-				"iteration":         iteration,
-				"model":             al.model,
-				"messages_count":    len(messages),
-				"tools_count":       len(providerToolDefs),
-				"max_tokens":        8192,
-				"temperature":       0.7,
+				"iteration":      iteration,
+				"model":          model,
+				"messages_count": len(messages),
+				"tools_count":    len(providerToolDefs),
+				"max_tokens":     8192,
+				"temperature":    0.7,
 				"system_prompt_len": func() int {
 					if messages[0].Content != nil {
 						return len(*messages[0].Content)
@@ -387,7 +613,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 
 		// Call LLM
-		response, err := al.provider.Chat(ctx, messages, providerToolDefs, al.model, map[string]interface{}{
+		response, err := al.provider.Chat(ctx, messages, providerToolDefs, model, map[string]interface{}{
 			"max_tokens":  al.contextWindow,
 			"temperature": al.temperature,
 		})
@@ -459,11 +685,23 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 					"iteration": iteration,
 				})
 
+			toolEvent := bus.ToolEvent{
+				Channel:   opts.Channel,
+				ChatID:    opts.ChatID,
+				CallID:    tc.ID,
+				Tool:      tc.Name,
+				Arguments: string(argsJSON),
+			}
+			al.bus.PublishToolEvent(toolEvent)
+
 			result, err := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID)
 			if err != nil {
 				result = fmt.Sprintf("Error: %v", err)
 			}
 
+			toolEvent.Result, toolEvent.Done, toolEvent.IsError = result, true, err != nil
+			al.bus.PublishToolEvent(toolEvent)
+
 			// Track if message tool was called to current channel
 			if tc.Name == "message" {
 				messageSent = true
@@ -503,17 +741,68 @@ func (al *AgentLoop) buildErrorFallback(err error) string {
 	}
 }
 
-// updateToolContexts updates the context for tools that need channel/chatID info.
-func (al *AgentLoop) updateToolContexts(channel, chatID string) {
-	if tool, ok := al.tools.Get("message"); ok {
-		if mt, ok := tool.(*tools.MessageTool); ok {
-			mt.SetContext(channel, chatID)
-		}
+// lockSession waits for the message being processed in a session, if any,
+// and returns the function releasing it. Messages of one session run one
+// at a time so its history stays in order; other sessions are not held up.
+func (al *AgentLoop) lockSession(sessionKey string) func() {
+	v, _ := al.sessionLocks.LoadOrStore(sessionKey, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// recordSessionScope remembers whose memory a session belongs to, marking it
+// mixed once a second user talks in it. Accounts linked to one identity
+// count as one user, and so does an account whose scope changed because it
+// was just linked.
+func (al *AgentLoop) recordSessionScope(sessionKey, account string, scope MemoryScope, role *access.Role, trusted bool) {
+	next := sessionScope{scope: scope, account: account, role: role, trusted: trusted}
+	if v, ok := al.sessionScopes.Load(sessionKey); ok {
+		prev := v.(sessionScope)
+		next.mixed = prev.mixed || prev.scope.User != scope.User && prev.account != account
+	}
+	al.sessionScopes.Store(sessionKey, next)
+}
+
+// extractMemories saves durable facts from a conversation that is about to
+// be summarized or archived.
+func (al *AgentLoop) extractMemories(sessionKey string, messages []providers.Message) {
+	if al.extractor == nil {
+		return
 	}
-	if tool, ok := al.tools.Get("spawn"); ok {
-		if st, ok := tool.(*tools.SpawnTool); ok {
-			st.SetContext(channel, chatID)
+	var scope MemoryScope
+	if v, ok := al.sessionScopes.Load(sessionKey); ok {
+		if v.(sessionScope).mixed {
+			// Facts from a group conversation could end up in the wrong
+			// user's memory; leave them to memory_save
+			logger.InfoCF("agent", "Skipping memory extraction for a session with several users",
+				map[string]interface{}{
+					"session_key": sessionKey,
+				})
+			return
 		}
+		scope = v.(sessionScope).scope
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	store := al.contextBuilder.Memory().Facts(scope)
+	added, updated, err := al.extractor.Extract(ctx, store, messages, sessionKey)
+	if err != nil {
+		logger.WarnCF("agent", "Memory extraction failed",
+			map[string]interface{}{
+				"session_key": sessionKey,
+				"error":       err.Error(),
+			})
+		return
+	}
+	if added > 0 || updated > 0 {
+		logger.InfoCF("agent", "Extracted memories from conversation",
+			map[string]interface{}{
+				"session_key": sessionKey,
+				"added":       added,
+				"updated":     updated,
+			})
 	}
 }
 
@@ -616,6 +905,9 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 
 	toSummarize := history[:len(history)-4]
 
+	// Keep durable facts before the details are folded into a summary
+	al.extractMemories(sessionKey, toSummarize)
+
 	// Oversized Message Guard
 	// Skip messages larger than 50% of context window to prevent summarizer overflow
 	maxMessageTokens := al.contextWindow / 2
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/tools"
+)
+
+func TestStopKillsProcesses(t *testing.T) {
+	pm := tools.NewProcessManager(t.TempDir(), nil, nil, config.ProcessConfig{})
+	proc, err := pm.Start("sleep 30", "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	al := &AgentLoop{processes: pm, running: true}
+	al.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if status, _, _ := proc.Status(); status != "running" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("process still running after Stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInvalidExecPolicyDisablesExec(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+	cfg.Tools.Exec.AllowPatterns = []string{"(git"}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), nil)
+	for _, name := range []string{"exec", "process_start", "process_send_input"} {
+		if _, ok := al.tools.Get(name); ok {
+			t.Errorf("%s registered with an invalid exec policy", name)
+		}
+	}
+	al.Stop()
+}
+
+func TestInvalidSearchConfigDisablesSearch(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+	cfg.Tools.Web.Search.Backends = []string{"nosuchengine"}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), nil)
+	defer al.Stop()
+	if _, ok := al.tools.Get("web_search"); ok {
+		t.Error("web_search registered with an invalid search config")
+	}
+	if _, ok := al.tools.Get("web_fetch"); !ok {
+		t.Error("web_fetch not registered")
+	}
+}
@@ -10,32 +10,188 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/identity"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/memory"
 )
 
 // MemoryStore manages persistent memory for the agent.
-// - Long-term memory: memory/MEMORY.md
-// - Daily notes: memory/YYYYMM/YYYYMMDD.md
+// - Shared facts: memory/facts.json
+// - Per-user facts, profile and notes: memory/users/<user>/{facts.json,USER.md,MEMORY.md}
+// - Shared long-term memory: memory/MEMORY.md
+// - Shared daily notes: memory/YYYYMM/YYYYMMDD.md
 type MemoryStore struct {
-	workspace  string
-	memoryDir  string
-	memoryFile string
+	workspace     string
+	memoryDir     string
+	memoryFile    string
+	facts         *memory.Registry
+	scope         string // "user", "chat" or "global"
+	contextTokens int
+	identities    *identity.Registry // Maps linked accounts to one user; nil if disabled
+}
+
+// MemoryScope identifies whose memory a conversation sees. The zero value
+// means the shared memory only, used for the CLI and scheduled jobs.
+type MemoryScope struct {
+	User  string // e.g. "telegram:12345", or the identity ID of linked accounts
+	Chat  string // set when memory is kept per chat
+	Owner bool   // the owner in a direct chat, who also keeps the shared notes
+}
+
+// sharesNotes reports whether the scope sees the shared MEMORY.md and daily
+// notes. Other users have notes of their own, so what one says in a chat
+// does not reach the others.
+func (s MemoryScope) sharesNotes() bool {
+	return s.User == "" || s.Owner
 }
 
 // NewMemoryStore creates a new MemoryStore with the given workspace path.
 // It ensures the memory directory exists.
 func NewMemoryStore(workspace string) *MemoryStore {
+	return NewMemoryStoreWithConfig(workspace, config.DefaultConfig().Memory)
+}
+
+// NewMemoryStoreWithConfig creates a MemoryStore using the memory config
+// section for the fact limit and prompt budget.
+func NewMemoryStoreWithConfig(workspace string, cfg config.MemoryConfig) *MemoryStore {
 	memoryDir := filepath.Join(workspace, "memory")
 	memoryFile := filepath.Join(memoryDir, "MEMORY.md")
 
 	// Ensure memory directory exists
 	os.MkdirAll(memoryDir, 0755)
 
+	contextTokens := cfg.ContextTokens
+	if contextTokens <= 0 {
+		contextTokens = 1500
+	}
+
+	scope := cfg.Scope
+	if scope != "chat" && scope != "global" {
+		scope = "user"
+	}
+
 	return &MemoryStore{
-		workspace:  workspace,
-		memoryDir:  memoryDir,
-		memoryFile: memoryFile,
+		workspace:     workspace,
+		memoryDir:     memoryDir,
+		memoryFile:    memoryFile,
+		facts:         memory.NewRegistry(memoryDir, cfg.MaxFacts),
+		scope:         scope,
+		contextTokens: contextTokens,
+	}
+}
+
+// SetIdentities makes accounts linked to one identity share its memory.
+func (ms *MemoryStore) SetIdentities(identities *identity.Registry) {
+	ms.identities = identities
+}
+
+// ScopeFor returns the memory scope of a message from senderID in chatID.
+// Accounts linked to an identity share the memory of that identity.
+func (ms *MemoryStore) ScopeFor(channel, senderID, chatID string) MemoryScope {
+	if ms.scope == "global" || channel == "system" || senderID == "" {
+		return MemoryScope{}
+	}
+	scope := MemoryScope{User: identity.Account(channel, senderID)}
+	if ms.identities != nil {
+		if ident, ok := ms.identities.Resolve(scope.User); ok {
+			scope.User = ident.ID
+		}
+	}
+	if ms.scope == "chat" && channel+":"+chatID != identity.Account(channel, senderID) {
+		scope.Chat = chatID
+	}
+	return scope
+}
+
+// MergeUser moves the facts of user from into user to, and its USER.md and
+// MEMORY.md when to has none yet. It is used when an account is linked to an identity.
+func (ms *MemoryStore) MergeUser(from, to string) {
+	if from == "" || from == to {
+		return
+	}
+	src, dst := ms.facts.Scope(from, ""), ms.facts.Scope(to, "")
+	moved := 0
+	for _, f := range src.All() {
+		f.ID = ""
+		if _, _, err := dst.Save(f); err != nil {
+			logger.WarnCF("memory", "Failed to move fact to linked user",
+				map[string]interface{}{
+					"from":  from,
+					"to":    to,
+					"error": err.Error(),
+				})
+			return
+		}
+		moved++
+	}
+	for _, f := range src.All() {
+		src.Forget(f.ID)
+	}
+
+	for _, name := range []string{"USER.md", "MEMORY.md"} {
+		file := filepath.Join(ms.facts.UserDir(from), name)
+		target := filepath.Join(ms.facts.UserDir(to), name)
+		if _, err := os.Stat(file); err == nil {
+			if _, err := os.Stat(target); os.IsNotExist(err) {
+				os.MkdirAll(filepath.Dir(target), 0755)
+				os.Rename(file, target)
+			}
+		}
+	}
+	if moved > 0 {
+		logger.InfoCF("memory", "Moved facts to linked user",
+			map[string]interface{}{
+				"from":  from,
+				"to":    to,
+				"count": moved,
+			})
+	}
+}
+
+// Facts returns the fact store of scope.
+func (ms *MemoryStore) Facts(scope MemoryScope) *memory.Store {
+	return ms.facts.Scope(scope.User, scope.Chat)
+}
+
+// SharedFacts returns the fact store shared by all users.
+func (ms *MemoryStore) SharedFacts() *memory.Store {
+	return ms.facts.Global()
+}
+
+// UserProfilePath returns the USER.md of the user in scope, or "" for the
+// shared scope.
+func (ms *MemoryStore) UserProfilePath(scope MemoryScope) string {
+	if scope.User == "" {
+		return ""
+	}
+	return filepath.Join(ms.facts.UserDir(scope.User), "USER.md")
+}
+
+// NotesPath returns the long-term notes file of scope: the shared
+// memory/MEMORY.md, or the user's own MEMORY.md.
+func (ms *MemoryStore) NotesPath(scope MemoryScope) string {
+	if scope.sharesNotes() {
+		return ms.memoryFile
 	}
+	return filepath.Join(ms.facts.UserDir(scope.User), "MEMORY.md")
+}
+
+// UsersDir returns the directory holding every user's own memory.
+func (ms *MemoryStore) UsersDir() string {
+	return filepath.Join(ms.memoryDir, "users")
+}
+
+// UserDir returns the directory of the user in scope, or "" for the shared
+// scope.
+func (ms *MemoryStore) UserDir(scope MemoryScope) string {
+	if scope.User == "" {
+		return ""
+	}
+	return ms.facts.UserDir(scope.User)
 }
 
 // getTodayFile returns the path to today's daily note file (memory/YYYYMM/YYYYMMDD.md).
@@ -128,34 +284,99 @@ func (ms *MemoryStore) GetRecentDailyNotes(days int) string {
 	return result
 }
 
-// GetMemoryContext returns formatted memory context for the agent prompt.
-// Includes long-term memory and recent daily notes.
-func (ms *MemoryStore) GetMemoryContext() string {
+// GetMemoryContext returns formatted memory context for the agent prompt,
+// within the configured token budget. Facts relevant to query come first,
+// the user's own before shared ones, then the scope's long-term notes and,
+// where the scope shares them, recent daily notes, cut to what still fits.
+func (ms *MemoryStore) GetMemoryContext(query string, scope MemoryScope) string {
 	var parts []string
+	budget := ms.contextTokens
+
+	notesFile := ms.NotesPath(scope)
+	var longTerm, recentNotes string
+	if data, err := os.ReadFile(notesFile); err == nil {
+		longTerm = strings.TrimSpace(string(data))
+	}
+	if scope.sharesNotes() {
+		recentNotes = strings.TrimSpace(ms.GetRecentDailyNotes(3))
+	}
+
+	// Structured facts get half the budget when there is other memory,
+	// leaving the rest to whatever they do not use
+	factBudget := budget
+	if longTerm != "" || recentNotes != "" {
+		factBudget = budget / 2
+	}
+	factSection := func(title string, facts []memory.Fact) {
+		if len(facts) == 0 {
+			return
+		}
+		lines := make([]string, len(facts))
+		for i, f := range facts {
+			lines[i] = memory.FormatFact(f)
+		}
+		section := title + "\n\n" + strings.Join(lines, "\n")
+		parts = append(parts, section)
+		used := memory.EstimateTokens(section)
+		budget -= used
+		factBudget -= used
+	}
+	if scope.User != "" {
+		factSection("## Known Facts About This User", ms.Facts(scope).Select(query, factBudget*2/3))
+		factSection("## Shared Facts", ms.SharedFacts().Select(query, factBudget))
+	} else {
+		factSection("## Known Facts", ms.SharedFacts().Select(query, factBudget))
+	}
 
 	// Long-term memory
-	longTerm := ms.ReadLongTerm()
-	if longTerm != "" {
-		parts = append(parts, "## Long-term Memory\n\n"+longTerm)
+	if longTerm != "" && budget > 0 {
+		share := budget
+		if recentNotes != "" {
+			share = budget * 2 / 3
+		}
+		text, cut := headTokens(longTerm, share)
+		if cut {
+			text += fmt.Sprintf("\n\n[truncated; read %s for the rest]", notesFile)
+		}
+		section := "## Long-term Memory\n\n" + text
+		parts = append(parts, section)
+		budget -= memory.EstimateTokens(section)
 	}
 
-	// Recent daily notes (last 3 days)
-	recentNotes := ms.GetRecentDailyNotes(3)
-	if recentNotes != "" {
-		parts = append(parts, "## Recent Daily Notes\n\n"+recentNotes)
+	// Recent daily notes (last 3 days), newest first
+	if recentNotes != "" && budget > 0 {
+		text, cut := headTokens(recentNotes, budget)
+		if cut {
+			text += "\n\n[older notes omitted]"
+		}
+		parts = append(parts, "## Recent Daily Notes\n\n"+text)
 	}
 
 	if len(parts) == 0 {
 		return ""
 	}
+	return "# Memory\n\n" + strings.Join(parts, "\n\n---\n\n")
+}
 
-	// Join parts with separator
-	var result string
-	for i, part := range parts {
-		if i > 0 {
-			result += "\n\n---\n\n"
+// headTokens returns the beginning of s that fits in n tokens, cut at a line
+// break where possible, and whether anything was cut.
+func headTokens(s string, n int) (string, bool) {
+	if memory.EstimateTokens(s) <= n {
+		return s, false
+	}
+	used, end := 0, 0
+	for i, line := range strings.SplitAfter(s, "\n") {
+		t := memory.EstimateTokens(line)
+		if used+t > n {
+			if i == 0 {
+				// A single long first line: cut it by characters
+				r := []rune(line)
+				return string(r[:min(len(r), n*2)]), true
+			}
+			break
 		}
-		result += part
+		used += t
+		end += len(line)
 	}
-	return fmt.Sprintf("# Memory\n\n%s", result)
+	return strings.TrimRight(s[:end], "\n"), true
 }
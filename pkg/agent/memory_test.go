@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryContextScopes(t *testing.T) {
+	ms := NewMemoryStore(t.TempDir())
+	ms.WriteLongTerm("owner's shared notes")
+	ms.AppendToday("owner's daily note")
+	alice := MemoryScope{User: "telegram:1"}
+	os.MkdirAll(filepath.Dir(ms.NotesPath(alice)), 0755)
+	os.WriteFile(ms.NotesPath(alice), []byte("alice's own notes"), 0644)
+
+	tests := []struct {
+		name  string
+		scope MemoryScope
+		want  []string
+		skip  []string
+	}{
+		{"shared", MemoryScope{}, []string{"shared notes", "daily note"}, []string{"alice's"}},
+		{"owner in a direct chat", MemoryScope{User: "telegram:9", Owner: true}, []string{"shared notes", "daily note"}, []string{"alice's"}},
+		{"user", alice, []string{"alice's own notes"}, []string{"shared notes", "daily note"}},
+		{"other user", MemoryScope{User: "telegram:2"}, nil, []string{"alice's", "shared notes", "daily note"}},
+	}
+	for _, tt := range tests {
+		got := ms.GetMemoryContext("", tt.scope)
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: missing %q in %q", tt.name, w, got)
+			}
+		}
+		for _, s := range tt.skip {
+			if strings.Contains(got, s) {
+				t.Errorf("%s: leaked %q in %q", tt.name, s, got)
+			}
+		}
+	}
+}
@@ -9,6 +9,7 @@ type MessageBus struct {
 	inbound  chan InboundMessage
 	outbound chan OutboundMessage
 	handlers map[string]MessageHandler
+	tools    map[string]ToolEventHandler
 	mu       sync.RWMutex
 }
 
@@ -17,6 +18,7 @@ func NewMessageBus() *MessageBus {
 		inbound:  make(chan InboundMessage, 100),
 		outbound: make(chan OutboundMessage, 100),
 		handlers: make(map[string]MessageHandler),
+		tools:    make(map[string]ToolEventHandler),
 	}
 }
 
@@ -59,6 +61,25 @@ func (mb *MessageBus) GetHandler(channel string) (MessageHandler, bool) {
 	return handler, ok
 }
 
+// RegisterToolHandler makes handler receive the tool events of a channel's
+// chats. The handler is called by the agent and must not block.
+func (mb *MessageBus) RegisterToolHandler(channel string, handler ToolEventHandler) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.tools[channel] = handler
+}
+
+// PublishToolEvent passes a tool event to the handler of its channel, if
+// there is one.
+func (mb *MessageBus) PublishToolEvent(ev ToolEvent) {
+	mb.mu.RLock()
+	handler, ok := mb.tools[ev.Channel]
+	mb.mu.RUnlock()
+	if ok {
+		handler(ev)
+	}
+}
+
 func (mb *MessageBus) Close() {
 	close(mb.inbound)
 	close(mb.outbound)
@@ -8,6 +8,9 @@ type InboundMessage struct {
 	Media      []string          `json:"media,omitempty"`
 	SessionKey string            `json:"session_key"`
 	Metadata   map[string]string `json:"metadata,omitempty"`
+	// Trusted marks messages from the CLI, cron jobs and the HTTP API, which
+	// act for the owner. Channels never set it.
+	Trusted bool `json:"-"`
 }
 
 type OutboundMessage struct {
@@ -17,3 +20,18 @@ type OutboundMessage struct {
 }
 
 type MessageHandler func(InboundMessage) error
+
+// ToolEvent reports a tool call the agent starts, or finishes when Done is
+// set, while answering a chat.
+type ToolEvent struct {
+	Channel   string `json:"channel"`
+	ChatID    string `json:"chat_id"`
+	CallID    string `json:"call_id"`
+	Tool      string `json:"tool"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result,omitempty"`
+	Done      bool   `json:"done"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+type ToolEventHandler func(ToolEvent)
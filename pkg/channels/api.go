@@ -0,0 +1,83 @@
+package channels
+
+import (
+	"context"
+	"sync"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+)
+
+// APIChannel is the channel of conversations held over the gateway HTTP
+// API. The reply to a request is returned directly; messages the agent
+// sends to the chat on its own, e.g. with the message tool, are passed to
+// the requests open for that chat and dropped when there are none.
+type APIChannel struct {
+	*BaseChannel
+	mu        sync.Mutex
+	listeners map[string][]chan string
+}
+
+func NewAPIChannel(messageBus *bus.MessageBus) *APIChannel {
+	return &APIChannel{
+		BaseChannel: NewBaseChannel("api", nil, messageBus, nil),
+		listeners:   make(map[string][]chan string),
+	}
+}
+
+func (c *APIChannel) Start(ctx context.Context) error {
+	c.setRunning(true)
+	return nil
+}
+
+func (c *APIChannel) Stop(ctx context.Context) error {
+	c.setRunning(false)
+	return nil
+}
+
+func (c *APIChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	listeners := c.listeners[msg.ChatID]
+	if len(listeners) == 0 {
+		logger.DebugCF("api", "No open request for outbound message", map[string]interface{}{
+			"chat_id": msg.ChatID,
+		})
+		return nil
+	}
+	for _, ch := range listeners {
+		select {
+		case ch <- msg.Content:
+		default:
+			logger.WarnCF("api", "Dropped outbound message for a slow request", map[string]interface{}{
+				"chat_id": msg.ChatID,
+			})
+		}
+	}
+	return nil
+}
+
+// Subscribe returns the messages sent to chatID until the returned cancel
+// function is called.
+func (c *APIChannel) Subscribe(chatID string) (<-chan string, func()) {
+	ch := make(chan string, 16)
+	c.mu.Lock()
+	c.listeners[chatID] = append(c.listeners[chatID], ch)
+	c.mu.Unlock()
+
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		listeners := c.listeners[chatID]
+		for i, l := range listeners {
+			if l == ch {
+				c.listeners[chatID] = append(listeners[:i], listeners[i+1:]...)
+				break
+			}
+		}
+		if len(c.listeners[chatID]) == 0 {
+			delete(c.listeners, chatID)
+		}
+	}
+}
@@ -2,12 +2,20 @@ package channels
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/documents"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
 )
 
+// documentPreviewChars bounds the document text attached to inbound messages.
+const documentPreviewChars = 1500
+
 type Channel interface {
 	Name() string
 	Start(ctx context.Context) error
@@ -87,3 +95,44 @@ func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []st
 func (c *BaseChannel) setRunning(running bool) {
 	c.running = running
 }
+
+// errFileTooLarge is returned by saveDownload for content over its limit.
+var errFileTooLarge = errors.New("file is too large")
+
+// saveDownload writes r to a new file at path. Content longer than limit is
+// an error and leaves no file behind rather than a truncated one.
+func saveDownload(path string, r io.Reader, limit int64) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(r, limit+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("%w: more than %d bytes", errFileTooLarge, limit)
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// describeFile returns the "[file: path]" note for a received file, followed
+// by a preview of its text when it is a PDF, Office document or CSV file.
+func describeFile(path string) string {
+	note := fmt.Sprintf("[file: %s]", path)
+	if documents.Detect(path) == "" {
+		return note
+	}
+	preview, err := documents.Preview(path, documentPreviewChars)
+	if err != nil {
+		logger.WarnCF("channels", "Failed to extract document preview", map[string]interface{}{
+			"path":  path,
+			"error": err.Error(),
+		})
+		return note
+	}
+	return note + "\n" + preview
+}
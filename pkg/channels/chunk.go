@@ -0,0 +1,286 @@
+package channels
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// Message length limits of each platform, in UTF-16 code units as the
+// platforms count them.
+const (
+	telegramMaxMessageLength = 4096
+	discordMaxMessageLength  = 2000
+	feishuMaxMessageLength   = 10000
+	qqMaxMessageLength       = 2000
+)
+
+// WeCom limits text messages to 2048 and Markdown messages to 4096 bytes
+// of UTF-8, and a UTF-16 code unit takes at most three of them.
+const (
+	wecomMaxMessageLength  = 2048 / 3
+	wecomMaxMarkdownLength = 4096 / 3
+)
+
+const (
+	// maxOutboundParts is the most messages one answer is split into;
+	// longer answers are sent as a Markdown file where the channel can.
+	maxOutboundParts = 5
+	// partLabelReserve leaves room for the "(i/n)" label of each part.
+	partLabelReserve = 12
+)
+
+// splitOutbound splits an answer into messages of at most limit characters,
+// numbered "(i/n)" when there is more than one. It returns nil when that
+// takes more than maxOutboundParts messages.
+func splitOutbound(content string, limit int) []string {
+	if textLength(content) <= limit {
+		return []string{content}
+	}
+	parts := splitMarkdown(content, limit-partLabelReserve)
+	if len(parts) > maxOutboundParts {
+		return nil
+	}
+	return numberParts(parts)
+}
+
+// numberParts prefixes each part with its "(i/n)" label.
+func numberParts(parts []string) []string {
+	for i := range parts {
+		parts[i] = fmt.Sprintf("(%d/%d)\n%s", i+1, len(parts), parts[i])
+	}
+	return parts
+}
+
+// outboundFile returns the file an over-long answer is sent as, and the
+// short message that goes with it.
+func outboundFile(content string) (name string, data []byte, caption string) {
+	name = fmt.Sprintf("reply-%s.md", time.Now().Format("20060102-150405"))
+	title := ""
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(strings.TrimLeft(line, "#>*- ")); line != "" {
+			title = utils.Truncate(line, 100)
+			break
+		}
+	}
+	caption = fmt.Sprintf("🦞 回复较长（%d 字），已作为文件发送。\n%s", utf8.RuneCountInString(content), title)
+	return name, []byte(content), caption
+}
+
+// mdBlock is a paragraph or a fenced code block of a Markdown text.
+type mdBlock struct {
+	text  string
+	fence string // opening fence line of a code block, "" for a paragraph
+}
+
+// markdownBlocks splits Markdown into paragraphs, separated by blank lines,
+// and code blocks, which are kept whole whatever they contain.
+func markdownBlocks(content string) []mdBlock {
+	var blocks []mdBlock
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			blocks = append(blocks, mdBlock{text: strings.Join(para, "\n")})
+			para = nil
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if marker := fenceMarker(trimmed); marker != "" {
+			flush()
+			code := []string{line}
+			for i++; i < len(lines); i++ {
+				code = append(code, lines[i])
+				if closesFence(lines[i], marker) {
+					break
+				}
+			}
+			blocks = append(blocks, mdBlock{text: strings.Join(code, "\n"), fence: trimmed})
+			continue
+		}
+		if trimmed == "" {
+			flush()
+			continue
+		}
+		para = append(para, line)
+	}
+	flush()
+	return blocks
+}
+
+// fenceMarker returns the backticks or tildes opening a code fence, or ""
+// when line does not open one.
+func fenceMarker(line string) string {
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 {
+			return strings.Repeat(c, n)
+		}
+	}
+	return ""
+}
+
+// closesFence reports whether line closes a code block opened with marker:
+// a run of at least as many of the same characters and nothing else.
+func closesFence(line, marker string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) >= len(marker) && strings.Trim(line, marker[:1]) == ""
+}
+
+// splitMarkdown packs the blocks of content into parts of at most limit
+// characters. Blocks that are too long on their own are split, code blocks
+// being closed at the end of a part and reopened in the next.
+func splitMarkdown(content string, limit int) []string {
+	var parts []string
+	var cur strings.Builder
+	curLen := 0
+	flush := func() {
+		if cur.Len() > 0 {
+			parts = append(parts, cur.String())
+			cur.Reset()
+			curLen = 0
+		}
+	}
+
+	for _, b := range markdownBlocks(content) {
+		pieces := []string{b.text}
+		if textLength(b.text) > limit {
+			if b.fence != "" {
+				pieces = splitCodeBlock(b, limit)
+			} else {
+				pieces = splitText(b.text, limit)
+			}
+		}
+		for _, p := range pieces {
+			n := textLength(p)
+			if cur.Len() > 0 && curLen+2+n > limit {
+				flush()
+			}
+			if cur.Len() > 0 {
+				cur.WriteString("\n\n")
+				curLen += 2
+			}
+			cur.WriteString(p)
+			curLen += n
+		}
+	}
+	flush()
+	return parts
+}
+
+// splitCodeBlock splits a code block between lines, wrapping each piece in
+// the block's fences so every part renders as code.
+func splitCodeBlock(b mdBlock, limit int) []string {
+	lines := strings.Split(b.text, "\n")
+	marker := fenceMarker(b.fence)
+	body := lines[1:]
+	if len(body) > 0 && closesFence(body[len(body)-1], marker) {
+		body = body[:len(body)-1]
+	}
+	capacity := limit - textLength(b.fence) - textLength(marker) - 2
+	if capacity <= 0 {
+		return splitText(b.text, limit)
+	}
+
+	var pieces []string
+	var cur []string
+	curLen := 0
+	flush := func() {
+		if len(cur) > 0 {
+			pieces = append(pieces, b.fence+"\n"+strings.Join(cur, "\n")+"\n"+marker)
+			cur = nil
+			curLen = 0
+		}
+	}
+	for _, line := range body {
+		for _, l := range splitText(line, capacity) {
+			n := textLength(l)
+			if len(cur) > 0 && curLen+1+n > capacity {
+				flush()
+			}
+			if len(cur) > 0 {
+				curLen++
+			}
+			cur = append(cur, l)
+			curLen += n
+		}
+	}
+	flush()
+	return pieces
+}
+
+// splitText splits plain text into pieces of at most limit characters,
+// breaking at the last line break, sentence end or space that leaves the
+// piece at least half full, or mid-word when there is none.
+func splitText(s string, limit int) []string {
+	if limit <= 0 {
+		return []string{s}
+	}
+	var pieces []string
+	for textLength(s) > limit {
+		runes := []rune(s)
+		cut := fitRunes(runes, limit)
+		brk := -1
+		for _, breaks := range []string{"\n", "。！？.!?；;", " \t，,"} {
+			for i := cut - 1; i >= cut/2; i-- {
+				if strings.ContainsRune(breaks, runes[i]) {
+					brk = i + 1
+					break
+				}
+			}
+			if brk > 0 {
+				break
+			}
+		}
+		if brk <= 0 {
+			brk = cut
+		}
+		if piece := strings.TrimRight(string(runes[:brk]), " \t\n"); piece != "" {
+			pieces = append(pieces, piece)
+		}
+		s = strings.TrimLeft(string(runes[brk:]), " \t\n")
+	}
+	if s != "" {
+		pieces = append(pieces, s)
+	}
+	return pieces
+}
+
+// fitRunes returns how many leading runes fit in limit UTF-16 code units,
+// at least one so splitting always makes progress.
+func fitRunes(runes []rune, limit int) int {
+	n := 0
+	for i, r := range runes {
+		w := 1
+		if r > 0xFFFF {
+			w = 2
+		}
+		if n+w > limit {
+			if i == 0 {
+				return 1
+			}
+			return i
+		}
+		n += w
+	}
+	return len(runes)
+}
+
+// textLength returns the length of s in UTF-16 code units.
+func textLength(s string) int {
+	n := 0
+	for _, r := range s {
+		if r > 0xFFFF {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,84 @@
+package channels
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitOutbound(t *testing.T) {
+	para := strings.Repeat("word ", 30) // 150 characters
+	code := "```go\n" + strings.Repeat("fmt.Println(\"hello, world\")\n", 20) + "```"
+
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+		parts   int
+	}{
+		{"short", "hello", 100, 1},
+		{"paragraphs", para + "\n\n" + para + "\n\n" + para, 200, 3},
+		{"code block", "Here it is:\n\n" + code, 200, 5},
+		{"cjk sentences", strings.Repeat("这是一个很长的句子，没有空格。", 20), 200, 2},
+		{"emoji", strings.Repeat("🦞", 150), 200, 2},
+		{"too long", strings.Repeat(para+"\n\n", 20), 200, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitOutbound(tt.content, tt.limit)
+			if len(parts) != tt.parts {
+				t.Fatalf("got %d parts, want %d: %q", len(parts), tt.parts, parts)
+			}
+			if tt.parts == 1 && parts[0] != tt.content {
+				t.Errorf("single part changed: %q", parts[0])
+			}
+			for i, p := range parts {
+				if n := textLength(p); n > tt.limit {
+					t.Errorf("part %d has %d characters, limit %d", i+1, n, tt.limit)
+				}
+				if tt.parts > 1 && !strings.HasPrefix(p, "("+string(rune('1'+i))+"/") {
+					t.Errorf("part %d is not numbered: %q", i+1, p)
+				}
+				if strings.Count(p, "```")%2 != 0 {
+					t.Errorf("part %d has an unbalanced fence: %q", i+1, p)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitOutboundBoundaries(t *testing.T) {
+	code := "```python\n" + strings.Repeat("print('hello')\n", 30) + "```"
+	parts := splitOutbound(code, 200)
+	if len(parts) < 2 {
+		t.Fatalf("code block not split: %q", parts)
+	}
+	for i, p := range parts {
+		body := p[strings.Index(p, "\n")+1:]
+		if !strings.HasPrefix(body, "```python\n") || !strings.HasSuffix(body, "\n```") {
+			t.Errorf("part %d does not reopen the fence: %q", i+1, p)
+		}
+	}
+
+	cjk := splitText(strings.Repeat("这是一个很长的句子，没有空格。", 10), 100)
+	for i, p := range cjk[:len(cjk)-1] {
+		if !strings.HasSuffix(p, "。") {
+			t.Errorf("piece %d does not end at a sentence: %q", i+1, p)
+		}
+	}
+
+	// Blank lines inside a code block do not end it
+	blocks := markdownBlocks("intro\n\n~~~\na\n\nb\n~~~~\n\nafter")
+	if len(blocks) != 3 || blocks[1].fence != "~~~" || blocks[2].text != "after" {
+		t.Errorf("blocks = %+v", blocks)
+	}
+}
+
+func TestOutboundFile(t *testing.T) {
+	name, data, caption := outboundFile("# Report\n\nBody")
+	if !strings.HasPrefix(name, "reply-") || !strings.HasSuffix(name, ".md") {
+		t.Errorf("name = %q", name)
+	}
+	if string(data) != "# Report\n\nBody" || !strings.HasSuffix(caption, "\nReport") {
+		t.Errorf("data = %q, caption = %q", data, caption)
+	}
+}
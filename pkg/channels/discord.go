@@ -1,6 +1,7 @@
 package channels
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/weiwei929/mypicoclaw/pkg/bus"
 	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/documents"
 	"github.com/weiwei929/mypicoclaw/pkg/logger"
 	"github.com/weiwei929/mypicoclaw/pkg/utils"
 	"github.com/weiwei929/mypicoclaw/pkg/voice"
@@ -90,10 +92,19 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 		return fmt.Errorf("channel ID is empty")
 	}
 
-	message := msg.Content
+	parts := splitOutbound(msg.Content, discordMaxMessageLength)
+	if parts == nil {
+		name, data, caption := outboundFile(msg.Content)
+		if _, err := c.session.ChannelFileSendWithMessage(channelID, caption, name, bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("failed to send discord file: %w", err)
+		}
+		return nil
+	}
 
-	if _, err := c.session.ChannelMessageSend(channelID, message); err != nil {
-		return fmt.Errorf("failed to send discord message: %w", err)
+	for _, message := range parts {
+		if _, err := c.session.ChannelMessageSend(channelID, message); err != nil {
+			return fmt.Errorf("failed to send discord message: %w", err)
+		}
 	}
 
 	return nil
@@ -153,6 +164,18 @@ func (c *DiscordChannel) handleMessage(s *discordgo.Session, m *discordgo.Messag
 				}
 				content += fmt.Sprintf("[attachment: %s]", attachment.URL)
 			}
+		} else if documents.FormatFromName(attachment.Filename) != "" {
+			localPath := c.downloadAttachment(attachment.URL, attachment.Filename)
+			if content != "" {
+				content += "\n"
+			}
+			if localPath != "" {
+				mediaPaths = append(mediaPaths, localPath)
+				content += describeFile(localPath)
+			} else {
+				mediaPaths = append(mediaPaths, attachment.URL)
+				content += fmt.Sprintf("[attachment: %s]", attachment.URL)
+			}
 		} else {
 			mediaPaths = append(mediaPaths, attachment.URL)
 			if content != "" {
@@ -209,7 +232,7 @@ func isAudioFile(filename, contentType string) bool {
 }
 
 func (c *DiscordChannel) downloadAttachment(url, filename string) string {
-	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	mediaDir := utils.MediaDir()
 	if err := os.MkdirAll(mediaDir, 0755); err != nil {
 		log.Printf("Failed to create media directory: %v", err)
 		return ""
@@ -0,0 +1,649 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/htmlindex"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+const (
+	emailTimeout         = 60 * time.Second
+	emailMaxAttachment   = 20 << 20
+	emailDefaultSubject  = "MyPicoClaw"
+	emailThreadKeyLength = 12
+)
+
+// emailThread is what a reply to a conversation needs to stay in its
+// thread.
+type emailThread struct {
+	to         string
+	subject    string
+	inReplyTo  string
+	references []string
+}
+
+// EmailChannel polls an IMAP mailbox for unseen mail and replies over SMTP.
+// Each email thread, found through References and In-Reply-To, is its own
+// chat "<sender>:<thread key>"; a bare address as chat ID starts a new
+// thread.
+type EmailChannel struct {
+	*BaseChannel
+	config  config.EmailConfig
+	address string
+
+	mu      sync.Mutex
+	threads map[string]*emailThread
+	skipped map[uint32]bool // unseen UIDs from senders not allowed
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewEmailChannel(cfg config.EmailConfig, messageBus *bus.MessageBus) (*EmailChannel, error) {
+	if cfg.IMAPHost == "" || cfg.SMTPHost == "" || cfg.Username == "" {
+		return nil, fmt.Errorf("email imap_host, smtp_host and username are required")
+	}
+	address := cfg.Address
+	if address == "" {
+		address = cfg.Username
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address %q: %w", address, err)
+	}
+	if cfg.Mailbox == "" {
+		cfg.Mailbox = "INBOX"
+	}
+	if cfg.PollSeconds <= 0 {
+		cfg.PollSeconds = 60
+	}
+	// Addresses are compared in lower case
+	allow := make([]string, len(cfg.AllowFrom))
+	for i, a := range cfg.AllowFrom {
+		allow[i] = strings.ToLower(strings.TrimSpace(a))
+	}
+
+	return &EmailChannel{
+		BaseChannel: NewBaseChannel("email", cfg, messageBus, allow),
+		config:      cfg,
+		address:     strings.ToLower(parsed.Address),
+		threads:     make(map[string]*emailThread),
+		skipped:     make(map[uint32]bool),
+	}, nil
+}
+
+func (c *EmailChannel) Start(ctx context.Context) error {
+	logger.InfoCF("email", "Starting email channel", map[string]interface{}{
+		"imap":    c.config.IMAPHost,
+		"mailbox": c.config.Mailbox,
+	})
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go c.pollLoop(ctx)
+	c.setRunning(true)
+	return nil
+}
+
+func (c *EmailChannel) Stop(ctx context.Context) error {
+	logger.InfoC("email", "Stopping email channel")
+	c.setRunning(false)
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+	return nil
+}
+
+func (c *EmailChannel) pollLoop(ctx context.Context) {
+	defer close(c.done)
+	ticker := time.NewTicker(time.Duration(c.config.PollSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := c.poll(); err != nil {
+			logger.ErrorCF("email", "Failed to poll mailbox", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll handles the unseen mail in the mailbox and marks it seen.
+func (c *EmailChannel) poll() error {
+	addr := net.JoinHostPort(c.config.IMAPHost, strconv.Itoa(c.config.IMAPPort))
+	client, err := dialIMAP(addr, c.config.UseTLS, emailTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if err := client.Login(c.config.Username, c.config.Password); err != nil {
+		return err
+	}
+	if err := client.Select(c.config.Mailbox); err != nil {
+		return err
+	}
+	uids, err := client.SearchUnseen()
+	if err != nil {
+		return err
+	}
+
+	for _, uid := range uids {
+		c.mu.Lock()
+		skip := c.skipped[uid]
+		c.mu.Unlock()
+		if skip {
+			continue
+		}
+		raw, err := client.Fetch(uid)
+		if err != nil {
+			return err
+		}
+		if !c.handleRaw(raw) {
+			// Leave mail not meant for the bot unread for a human
+			c.mu.Lock()
+			c.skipped[uid] = true
+			c.mu.Unlock()
+			continue
+		}
+		if err := client.MarkSeen(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleRaw passes a fetched message to the agent and reports whether it
+// was for the bot.
+func (c *EmailChannel) handleRaw(raw []byte) bool {
+	email, err := parseEmail(raw)
+	if err != nil {
+		logger.WarnCF("email", "Failed to parse email", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return true
+	}
+	if email.From == "" || email.From == c.address {
+		return false
+	}
+	// Never answer auto-replies and bounces, so two bots cannot loop
+	if email.AutoSubmitted != "" && !strings.EqualFold(email.AutoSubmitted, "no") {
+		return false
+	}
+	if !c.IsAllowed(email.From) {
+		logger.DebugCF("email", "Email from sender not in allow_from", map[string]interface{}{
+			"from": email.From,
+		})
+		return false
+	}
+
+	chatID := email.From + ":" + threadKey(email.threadRoot())
+	references := append(append([]string{}, email.References...), email.MessageID)
+	c.mu.Lock()
+	c.threads[chatID] = &emailThread{
+		to:         email.From,
+		subject:    email.Subject,
+		inReplyTo:  email.MessageID,
+		references: references,
+	}
+	c.mu.Unlock()
+
+	content := stripQuotedReply(email.Text)
+	if email.InReplyTo == "" && email.Subject != "" {
+		content = fmt.Sprintf("Subject: %s\n\n%s", email.Subject, content)
+	}
+	var media []string
+	for _, a := range email.Attachments {
+		path, err := saveEmailAttachment(a)
+		if err != nil {
+			logger.WarnCF("email", "Failed to save attachment", map[string]interface{}{
+				"name":  a.Name,
+				"error": err.Error(),
+			})
+			continue
+		}
+		media = append(media, path)
+		content += "\n" + describeFile(path)
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return true
+	}
+
+	logger.InfoCF("email", "Received email", map[string]interface{}{
+		"from":    email.From,
+		"subject": email.Subject,
+		"chat_id": chatID,
+	})
+	c.HandleMessage(email.From, chatID, content, media, map[string]string{
+		"message_id": email.MessageID,
+		"subject":    email.Subject,
+		"from_name":  email.FromName,
+		"is_dm":      "true",
+	})
+	return true
+}
+
+func (c *EmailChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("email channel not running")
+	}
+	// The chat ID may carry a display name ("Name <a@b>"); SMTP needs the
+	// bare address
+	recipient, _, _ := strings.Cut(msg.ChatID, ":")
+	parsed, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", recipient, err)
+	}
+	to := parsed.Address
+
+	c.mu.Lock()
+	thread := c.threads[msg.ChatID]
+	var subject, inReplyTo string
+	var references []string
+	if thread != nil {
+		subject = thread.subject
+		inReplyTo = thread.inReplyTo
+		references = append([]string{}, thread.references...)
+	}
+	c.mu.Unlock()
+
+	switch {
+	case subject == "":
+		subject = emailDefaultSubject
+	case !strings.HasPrefix(strings.ToLower(subject), "re:"):
+		subject = "Re: " + subject
+	}
+	messageID := c.newMessageID()
+
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", (&mail.Address{Address: c.address}).String())
+	header("To", to)
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	if inReplyTo != "" {
+		header("In-Reply-To", inReplyTo)
+	}
+	if len(references) > 0 {
+		header("References", strings.Join(references, " "))
+	}
+	header("Auto-Submitted", "auto-replied")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.ReplaceAll(msg.Content, "\n", "\r\n")))
+	qp.Close()
+
+	if err := c.sendMail(to, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	// Later replies in the thread reference this one too
+	if thread != nil {
+		c.mu.Lock()
+		thread.references = append(thread.references, messageID)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *EmailChannel) sendMail(to string, data []byte) error {
+	host := c.config.SMTPHost
+	addr := net.JoinHostPort(host, strconv.Itoa(c.config.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: emailTimeout}
+	if c.config.UseTLS && c.config.SMTPPort == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(emailTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && c.config.SMTPPort != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	} else if c.config.UseTLS && c.config.SMTPPort != 465 {
+		return fmt.Errorf("%s does not support STARTTLS", addr)
+	}
+	if ok, _ := client.Extension("AUTH"); ok && c.config.Password != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.config.Username, c.config.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (c *EmailChannel) newMessageID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	domain := "localhost"
+	if i := strings.LastIndex(c.address, "@"); i >= 0 {
+		domain = c.address[i+1:]
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
+
+// threadKey shortens a thread's root Message-ID to a chat ID part.
+func threadKey(root string) string {
+	sum := sha256.Sum256([]byte(root))
+	return hex.EncodeToString(sum[:])[:emailThreadKeyLength]
+}
+
+var quoteHeaderRe = regexp.MustCompile(`(?i)^(on .+ wrote:|在.+写道[:：]?|-+\s*original message\s*-+)$`)
+
+// stripQuotedReply drops the quoted text a mail client adds under a reply,
+// which the session already holds.
+func stripQuotedReply(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if quoteHeaderRe.MatchString(trimmed) && i > 0 {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+func saveEmailAttachment(a emailAttachment) (string, error) {
+	mediaDir := utils.MediaDir()
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		return "", err
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	name := filepath.Base(a.Name)
+	if name == "." || name == "/" || name == "" {
+		name = "attachment"
+	}
+	localPath := filepath.Join(mediaDir, "email_"+hex.EncodeToString(b)+"_"+name)
+	return localPath, os.WriteFile(localPath, a.Data, 0644)
+}
+
+// parsedEmail is the part of a message the channel uses.
+type parsedEmail struct {
+	From          string // lower-case address
+	FromName      string
+	Subject       string
+	MessageID     string
+	InReplyTo     string
+	References    []string
+	AutoSubmitted string
+	Text          string
+	Attachments   []emailAttachment
+}
+
+type emailAttachment struct {
+	Name string
+	Data []byte
+}
+
+// threadRoot returns the Message-ID that identifies the conversation: the
+// first reference, the message replied to, or the message itself.
+func (e *parsedEmail) threadRoot() string {
+	if len(e.References) > 0 {
+		return e.References[0]
+	}
+	if e.InReplyTo != "" {
+		return e.InReplyTo
+	}
+	return e.MessageID
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+func parseEmail(raw []byte) (*parsedEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	e := &parsedEmail{
+		MessageID:     strings.TrimSpace(msg.Header.Get("Message-ID")),
+		InReplyTo:     firstMessageID(msg.Header.Get("In-Reply-To")),
+		References:    strings.Fields(msg.Header.Get("References")),
+		AutoSubmitted: strings.TrimSpace(msg.Header.Get("Auto-Submitted")),
+	}
+	if subject, err := wordDecoder.DecodeHeader(msg.Header.Get("Subject")); err == nil {
+		e.Subject = strings.TrimSpace(subject)
+	}
+	parser := &mail.AddressParser{WordDecoder: wordDecoder}
+	if from, err := parser.Parse(msg.Header.Get("From")); err == nil {
+		e.From = strings.ToLower(from.Address)
+		e.FromName = from.Name
+	}
+
+	var plain, htmlText strings.Builder
+	if err := e.walk(textproto.MIMEHeader(msg.Header), msg.Body, &plain, &htmlText); err != nil {
+		return nil, err
+	}
+	e.Text = strings.TrimSpace(plain.String())
+	if e.Text == "" {
+		e.Text = htmlToText(htmlText.String())
+	}
+	return e, nil
+}
+
+// walk collects the text bodies and attachments of a MIME part.
+func (e *parsedEmail) walk(header textproto.MIMEHeader, body io.Reader, plain, htmlText *strings.Builder) error {
+	ctype, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		ctype, params = "text/plain", map[string]string{}
+	}
+	body = transferDecoder(header.Get("Content-Transfer-Encoding"), body)
+
+	if strings.HasPrefix(ctype, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := e.walk(part.Header, part, plain, htmlText); err != nil {
+				return err
+			}
+		}
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if decoded, err := wordDecoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, emailMaxAttachment))
+	if err != nil {
+		return err
+	}
+	switch {
+	case disposition == "attachment" || (filename != "" && !strings.HasPrefix(ctype, "text/")) || ctype == "message/rfc822":
+		if filename == "" {
+			filename = "message.eml"
+		}
+		e.Attachments = append(e.Attachments, emailAttachment{Name: filename, Data: data})
+	case ctype == "text/plain":
+		plain.WriteString(decodeCharset(data, params["charset"]))
+	case ctype == "text/html":
+		htmlText.WriteString(decodeCharset(data, params["charset"]))
+	}
+	return nil
+}
+
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// newlineStripper drops the line breaks base64 bodies are wrapped with.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s *newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	j := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+			p[j] = b
+			j++
+		}
+	}
+	if j == 0 && n > 0 && err == nil {
+		return s.Read(p)
+	}
+	return j, err
+}
+
+func decodeCharset(data []byte, charset string) string {
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return string(data)
+	}
+	r, err := charsetReader(charset, bytes.NewReader(data))
+	if err != nil {
+		return string(data)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+func firstMessageID(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+var blankLinesRe = regexp.MustCompile(`\n{3,}`)
+
+// htmlToText reduces an HTML mail body to its text, one block per line.
+func htmlToText(doc string) string {
+	if doc == "" {
+		return ""
+	}
+	var sb strings.Builder
+	z := html.NewTokenizer(strings.NewReader(doc))
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			text := blankLinesRe.ReplaceAllString(sb.String(), "\n\n")
+			lines := strings.Split(text, "\n")
+			for i, l := range lines {
+				lines[i] = strings.TrimSpace(l)
+			}
+			return strings.TrimSpace(strings.Join(lines, "\n"))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "head":
+				skip++
+			case "br", "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote", "table":
+				sb.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "head":
+				if skip > 0 {
+					skip--
+				}
+			case "p", "div", "h1", "h2", "h3", "h4", "h5", "h6":
+				sb.WriteString("\n")
+			}
+		case html.TextToken:
+			if skip == 0 {
+				sb.WriteString(strings.Join(strings.Fields(string(z.Text())), " "))
+			}
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package channels
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+// fakeIMAP speaks just enough IMAP for the email channel: every command
+// succeeds, SEARCH lists the unseen messages and STORE marks one seen.
+type fakeIMAP struct {
+	ln       net.Listener
+	messages map[uint32]string
+
+	mu   sync.Mutex
+	seen map[uint32]bool
+}
+
+func newFakeIMAP(t *testing.T, messages map[uint32]string) *fakeIMAP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIMAP{ln: ln, messages: messages, seen: make(map[uint32]bool)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeIMAP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake IMAP ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		tag, cmd := fields[0], strings.ToUpper(strings.Join(fields[1:], " "))
+		switch {
+		case strings.HasPrefix(cmd, "UID SEARCH"):
+			f.mu.Lock()
+			var uids []string
+			for uid := uint32(1); uid <= uint32(len(f.messages)); uid++ {
+				if !f.seen[uid] {
+					uids = append(uids, strconv.Itoa(int(uid)))
+				}
+			}
+			f.mu.Unlock()
+			fmt.Fprintf(conn, "* SEARCH %s\r\n", strings.Join(uids, " "))
+		case strings.HasPrefix(cmd, "UID FETCH"):
+			uid, _ := strconv.Atoi(fields[3])
+			msg := f.messages[uint32(uid)]
+			fmt.Fprintf(conn, "* %d FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid, uid, len(msg), msg)
+		case strings.HasPrefix(cmd, "UID STORE"):
+			uid, _ := strconv.Atoi(fields[3])
+			f.mu.Lock()
+			f.seen[uint32(uid)] = true
+			f.mu.Unlock()
+		case cmd == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+			return
+		}
+		fmt.Fprintf(conn, "%s OK completed\r\n", tag)
+	}
+}
+
+func (f *fakeIMAP) isSeen(uid uint32) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seen[uid]
+}
+
+// fakeSMTP accepts mail without authentication and records each message.
+type fakeSMTP struct {
+	ln   net.Listener
+	mail chan string
+	rcpt chan string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSMTP{ln: ln, mail: make(chan string, 10), rcpt: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake SMTP ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 8BITMIME")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			f.mail <- string(data)
+			tp.PrintfLine("250 queued")
+		case "RCPT":
+			f.rcpt <- line
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func listenerPort(ln net.Listener) int {
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+const firstEmail = "From: Alice <Alice@Example.org>\r\n" +
+	"To: claw@example.org\r\n" +
+	"Subject: =?utf-8?q?Quarterly_report?=\r\n" +
+	"Message-ID: <m1@example.org>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<html><head><style>p{}</style></head><body><p>Please summarise=\r\n" +
+	" the attached.</p></body></html>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=\"q3.csv\"\r\n" +
+	"Content-Disposition: attachment; filename=\"q3.csv\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"cmV2ZW51ZSwx\r\n" +
+	"MDAK\r\n" +
+	"--outer--\r\n"
+
+const strangerEmail = "From: mallory@example.org\r\n" +
+	"Subject: hi\r\n" +
+	"Message-ID: <m2@example.org>\r\n" +
+	"\r\n" +
+	"let me in\r\n"
+
+const replyEmail = "From: alice@example.org\r\n" +
+	"Subject: Re: Quarterly report\r\n" +
+	"Message-ID: <m3@example.org>\r\n" +
+	"In-Reply-To: <r1@example.org>\r\n" +
+	"References: <m1@example.org> <r1@example.org>\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Thanks, now compare with Q2.\r\n" +
+	"\r\n" +
+	"On Mon, 1 Sep 2026, claw@example.org wrote:\r\n" +
+	"> Revenue was 100.\r\n"
+
+func TestEmailChannel(t *testing.T) {
+	imap := newFakeIMAP(t, map[uint32]string{1: firstEmail, 2: strangerEmail, 3: replyEmail})
+	smtp := newFakeSMTP(t)
+	msgBus := bus.NewMessageBus()
+	c, err := NewEmailChannel(config.EmailConfig{
+		IMAPHost:    "127.0.0.1",
+		IMAPPort:    listenerPort(imap.ln),
+		SMTPHost:    "127.0.0.1",
+		SMTPPort:    listenerPort(smtp.ln),
+		Username:    "claw@example.org",
+		PollSeconds: 60,
+		AllowFrom:   []string{"ALICE@example.org"},
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop(context.Background())
+
+	first, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no message for the first email")
+	}
+	if first.SenderID != "alice@example.org" || !strings.HasPrefix(first.ChatID, "alice@example.org:") {
+		t.Errorf("first email from %s in %s", first.SenderID, first.ChatID)
+	}
+	if !strings.Contains(first.Content, "Subject: Quarterly report") || !strings.Contains(first.Content, "Please summarise the attached.") {
+		t.Errorf("first content = %q", first.Content)
+	}
+	if len(first.Media) != 1 {
+		t.Fatalf("media = %v", first.Media)
+	}
+	defer os.Remove(first.Media[0])
+	if data, _ := os.ReadFile(first.Media[0]); string(data) != "revenue,100\n" {
+		t.Errorf("attachment = %q", data)
+	}
+
+	reply, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no message for the reply")
+	}
+	if reply.ChatID != first.ChatID {
+		t.Errorf("reply in %s, want thread %s", reply.ChatID, first.ChatID)
+	}
+	if reply.Content != "Thanks, now compare with Q2." {
+		t.Errorf("reply content = %q", reply.Content)
+	}
+
+	// The reply is marked seen just after it is handed to the bus
+	for !imap.isSeen(3) && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !imap.isSeen(1) || imap.isSeen(2) || !imap.isSeen(3) {
+		t.Errorf("seen 1=%v 2=%v 3=%v", imap.isSeen(1), imap.isSeen(2), imap.isSeen(3))
+	}
+
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "email", ChatID: reply.ChatID, Content: "Q3 is up 10%."}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sent := <-smtp.mail:
+		for _, want := range []string{
+			"To: alice@example.org",
+			"Subject: Re: Quarterly report",
+			"In-Reply-To: <m3@example.org>",
+			"References: <m1@example.org> <r1@example.org> <m3@example.org>",
+			"Q3 is up 10%.",
+		} {
+			if !strings.Contains(sent, want) {
+				t.Errorf("sent mail missing %q:\n%s", want, sent)
+			}
+		}
+	case <-ctx.Done():
+		t.Fatal("no mail sent")
+	}
+	if rcpt := <-smtp.rcpt; rcpt != "RCPT TO:<alice@example.org>" {
+		t.Errorf("recipient = %q", rcpt)
+	}
+
+	// A chat ID with a display name still sends to the bare address
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "email", ChatID: "Bob Smith <bob@example.org>", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	<-smtp.mail
+	if rcpt := <-smtp.rcpt; rcpt != "RCPT TO:<bob@example.org>" {
+		t.Errorf("recipient = %q", rcpt)
+	}
+}
+
+func TestIMAPResponseLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"negative literal", "* 1 FETCH (BODY[] {-1}\r\n"},
+		{"huge literal", "* 1 FETCH (BODY[] {99999999999}\r\n"},
+		{"endless line", "* SEARCH " + strings.Repeat("1 ", imapMaxLine) + "\r\n"},
+	}
+	for _, tt := range tests {
+		c := &imapClient{r: bufio.NewReader(strings.NewReader(tt.input))}
+		if _, err := c.readResponse(); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestStripQuotedReply(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"answer\n> quoted\n> more", "answer"},
+		{"answer\n\n在 2026年9月1日，claw 写道：\n> quoted", "answer"},
+		{"answer\n-----Original Message-----\nFrom: x", "answer"},
+	}
+	for _, tt := range tests {
+		if got := stripQuotedReply(tt.in); got != tt.want {
+			t.Errorf("stripQuotedReply(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
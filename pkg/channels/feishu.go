@@ -1,6 +1,7 @@
 package channels
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -95,7 +96,54 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("chat ID is empty")
 	}
 
-	payload, err := json.Marshal(map[string]string{"text": msg.Content})
+	parts := splitOutbound(msg.Content, feishuMaxMessageLength)
+	if parts == nil {
+		return c.sendFile(ctx, msg.ChatID, msg.Content)
+	}
+
+	for _, part := range parts {
+		if err := c.sendMessage(ctx, msg.ChatID, larkim.MsgTypeText, map[string]string{"text": part}); err != nil {
+			return err
+		}
+	}
+
+	logger.DebugCF("feishu", "Feishu message sent", map[string]interface{}{
+		"chat_id": msg.ChatID,
+		"parts":   len(parts),
+	})
+
+	return nil
+}
+
+// sendFile uploads an over-long answer as a Markdown file and sends it
+// after a short text saying so.
+func (c *FeishuChannel) sendFile(ctx context.Context, chatID, content string) error {
+	name, data, caption := outboundFile(content)
+
+	req := larkim.NewCreateFileReqBuilder().
+		Body(larkim.NewCreateFileReqBodyBuilder().
+			FileType("stream").
+			FileName(name).
+			File(bytes.NewReader(data)).
+			Build()).
+		Build()
+
+	resp, err := c.client.Im.V1.File.Create(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to upload feishu file: %w", err)
+	}
+	if !resp.Success() || resp.Data == nil || resp.Data.FileKey == nil {
+		return fmt.Errorf("feishu file upload error: code=%d msg=%s", resp.Code, resp.Msg)
+	}
+
+	if err := c.sendMessage(ctx, chatID, larkim.MsgTypeText, map[string]string{"text": caption}); err != nil {
+		return err
+	}
+	return c.sendMessage(ctx, chatID, larkim.MsgTypeFile, map[string]string{"file_key": *resp.Data.FileKey})
+}
+
+func (c *FeishuChannel) sendMessage(ctx context.Context, chatID, msgType string, content map[string]string) error {
+	payload, err := json.Marshal(content)
 	if err != nil {
 		return fmt.Errorf("failed to marshal feishu content: %w", err)
 	}
@@ -103,8 +151,8 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	req := larkim.NewCreateMessageReqBuilder().
 		ReceiveIdType(larkim.ReceiveIdTypeChatId).
 		Body(larkim.NewCreateMessageReqBodyBuilder().
-			ReceiveId(msg.ChatID).
-			MsgType(larkim.MsgTypeText).
+			ReceiveId(chatID).
+			MsgType(msgType).
 			Content(string(payload)).
 			Uuid(fmt.Sprintf("picoclaw-%d", time.Now().UnixNano())).
 			Build()).
@@ -119,10 +167,6 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("feishu api error: code=%d msg=%s", resp.Code, resp.Msg)
 	}
 
-	logger.DebugCF("feishu", "Feishu message sent", map[string]interface{}{
-		"chat_id": msg.ChatID,
-	})
-
 	return nil
 }
 
@@ -0,0 +1,255 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+)
+
+const (
+	defaultHTTPRetries  = 3
+	defaultHTTPTimeout  = 10 * time.Second
+	httpQueueSize       = 100
+	maxHTTPRetryBackoff = time.Minute
+)
+
+// httpTarget is a configured HTTP target with its queue of messages.
+type httpTarget struct {
+	name     string
+	config   config.HTTPTargetConfig
+	template *template.Template
+	client   *http.Client
+	queue    chan bus.OutboundMessage
+
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+// httpBody is the data given to target templates.
+type httpBody struct {
+	Content string    `json:"content"`
+	Target  string    `json:"target"`
+	Time    time.Time `json:"time"`
+}
+
+// HTTPChannel delivers agent output to HTTP endpoints. It only sends; each
+// target has its own queue so a slow or failing endpoint delays only its
+// own messages.
+type HTTPChannel struct {
+	*BaseChannel
+	targets map[string]*httpTarget
+	backoff time.Duration
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewHTTPChannel(cfg config.HTTPConfig, messageBus *bus.MessageBus) (*HTTPChannel, error) {
+	targets := make(map[string]*httpTarget, len(cfg.Targets))
+	for name, tc := range cfg.Targets {
+		if !strings.HasPrefix(tc.URL, "http://") && !strings.HasPrefix(tc.URL, "https://") {
+			return nil, fmt.Errorf("http target %q: url must be an http(s) URL", name)
+		}
+		target := &httpTarget{
+			name:   name,
+			config: tc,
+			queue:  make(chan bus.OutboundMessage, httpQueueSize),
+		}
+		if tc.Template != "" {
+			t, err := template.New(name).Funcs(templateFuncs).Parse(tc.Template)
+			if err != nil {
+				return nil, fmt.Errorf("http target %q: invalid template: %w", name, err)
+			}
+			target.template = t
+		}
+		timeout := defaultHTTPTimeout
+		if tc.TimeoutSeconds > 0 {
+			timeout = time.Duration(tc.TimeoutSeconds) * time.Second
+		}
+		target.client = &http.Client{Timeout: timeout}
+		targets[name] = target
+	}
+
+	return &HTTPChannel{
+		BaseChannel: NewBaseChannel("http", cfg, messageBus, nil),
+		targets:     targets,
+		backoff:     time.Second,
+	}, nil
+}
+
+func (c *HTTPChannel) Start(ctx context.Context) error {
+	ctx, c.cancel = context.WithCancel(ctx)
+	for _, target := range c.targets {
+		c.wg.Add(1)
+		go func(t *httpTarget) {
+			defer c.wg.Done()
+			c.deliverLoop(ctx, t)
+		}(target)
+	}
+	c.setRunning(true)
+	logger.InfoCF("http", "HTTP channel started", map[string]interface{}{
+		"targets": len(c.targets),
+	})
+	return nil
+}
+
+func (c *HTTPChannel) Stop(ctx context.Context) error {
+	c.setRunning(false)
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+	return nil
+}
+
+// Send queues a message for the target named by its chat ID. It fails when
+// the target is unknown, over its rate limit, or has a full queue.
+func (c *HTTPChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	target, ok := c.targets[msg.ChatID]
+	if !ok {
+		return fmt.Errorf("unknown http target: %s", msg.ChatID)
+	}
+	if !target.allow() {
+		return fmt.Errorf("http target %s is over its rate limit of %d per minute", target.name, target.config.RateLimitPerMinute)
+	}
+	select {
+	case target.queue <- msg:
+		return nil
+	default:
+		return fmt.Errorf("http target %s has too many pending messages", target.name)
+	}
+}
+
+// allow records a message and reports whether it is within the target's
+// per-minute limit.
+func (t *httpTarget) allow() bool {
+	limit := t.config.RateLimitPerMinute
+	if limit <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	sent := t.sent
+	for len(sent) > 0 && now.Sub(sent[0]) >= time.Minute {
+		sent = sent[1:]
+	}
+	if len(sent) >= limit {
+		t.sent = sent
+		return false
+	}
+	t.sent = append(sent, now)
+	return true
+}
+
+func (c *HTTPChannel) deliverLoop(ctx context.Context, t *httpTarget) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-t.queue:
+			if err := c.deliver(ctx, t, msg); err != nil {
+				logger.ErrorCF("http", "Failed to deliver message", map[string]interface{}{
+					"target": t.name,
+					"error":  err.Error(),
+				})
+			}
+		}
+	}
+}
+
+// deliver sends a message, retrying network errors, 429 and 5xx responses
+// with exponential backoff.
+func (c *HTTPChannel) deliver(ctx context.Context, t *httpTarget, msg bus.OutboundMessage) error {
+	body, err := t.body(msg)
+	if err != nil {
+		return fmt.Errorf("failed to render body: %w", err)
+	}
+
+	retries := t.config.MaxRetries
+	if retries <= 0 {
+		retries = defaultHTTPRetries
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := t.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= retries {
+			return err
+		}
+		logger.WarnCF("http", "Delivery failed, retrying", map[string]interface{}{
+			"target":  t.name,
+			"attempt": attempt + 1,
+			"error":   err.Error(),
+		})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxHTTPRetryBackoff {
+			backoff = maxHTTPRetryBackoff
+		}
+	}
+}
+
+// post makes one request and reports whether a failure is worth retrying.
+func (t *httpTarget) post(ctx context.Context, body []byte) (bool, error) {
+	method := t.config.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	contentType := t.config.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+		if t.template != nil {
+			contentType = "text/plain; charset=utf-8"
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("%s %s returned %s", method, t.config.URL, resp.Status)
+}
+
+func (t *httpTarget) body(msg bus.OutboundMessage) ([]byte, error) {
+	data := httpBody{Content: msg.Content, Target: t.name, Time: time.Now()}
+	if t.template == nil {
+		return json.Marshal(data)
+	}
+	var buf bytes.Buffer
+	if err := t.template.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package channels
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func TestHTTPChannelRetriesAndTemplates(t *testing.T) {
+	var calls atomic.Int32
+	got := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- string(body)
+	}))
+	defer server.Close()
+
+	c, err := NewHTTPChannel(config.HTTPConfig{Targets: map[string]config.HTTPTargetConfig{
+		"gotify": {
+			URL:                server.URL,
+			Headers:            map[string]string{"X-Gotify-Key": "k"},
+			ContentType:        "application/json",
+			Template:           `{"message": {{json .Content}}, "title": "{{.Target}}"}`,
+			RateLimitPerMinute: 1,
+		},
+	}}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.backoff = time.Millisecond
+	ctx := context.Background()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop(ctx)
+
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "http", ChatID: "gotify", Content: `disk "db-1" full`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "http", ChatID: "gotify", Content: "again"}); err == nil {
+		t.Error("second message within the rate limit was accepted")
+	}
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "http", ChatID: "bark", Content: "x"}); err == nil {
+		t.Error("unknown target was accepted")
+	}
+
+	select {
+	case r := <-got:
+		if r.Header.Get("X-Gotify-Key") != "k" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		if body := <-bodies; body != `{"message": "disk \"db-1\" full", "title": "gotify"}` {
+			t.Errorf("body = %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not delivered")
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestHTTPChannelClientErrorsAreNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	c, err := NewHTTPChannel(config.HTTPConfig{Targets: map[string]config.HTTPTargetConfig{
+		"ntfy": {URL: server.URL},
+	}}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.backoff = time.Millisecond
+	if err := c.deliver(context.Background(), c.targets["ntfy"], bus.OutboundMessage{Content: "x"}); err == nil {
+		t.Fatal("400 response was reported as delivered")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
@@ -0,0 +1,202 @@
+package channels
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapMaxLiteral bounds the size of a fetched message.
+const imapMaxLiteral = 32 << 20
+
+// imapMaxLine bounds a single response line, such as a long SEARCH result.
+const imapMaxLine = 1 << 20
+
+// imapClient is the small part of IMAP4rev1 the email channel needs:
+// login, select, search for unseen mail, fetch it and mark it seen.
+type imapClient struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	tag     int
+	timeout time.Duration
+}
+
+// imapResponse is an untagged response line with the literals it carried.
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+func dialIMAP(addr string, useTLS bool, timeout time.Duration) (*imapClient, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+	greeting, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting: %s", greeting)
+	}
+	return c, nil
+}
+
+func (c *imapClient) Close() error {
+	c.cmd("LOGOUT")
+	return c.conn.Close()
+}
+
+func (c *imapClient) Login(username, password string) error {
+	_, err := c.cmd("LOGIN %s %s", imapQuote(username), imapQuote(password))
+	return err
+}
+
+func (c *imapClient) Select(mailbox string) error {
+	_, err := c.cmd("SELECT %s", imapQuote(mailbox))
+	return err
+}
+
+// SearchUnseen returns the UIDs of unseen messages.
+func (c *imapClient) SearchUnseen() ([]uint32, error) {
+	resps, err := c.cmd("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, r := range resps {
+		fields := strings.Fields(r.line)
+		if len(fields) < 2 || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, f := range fields[2:] {
+			if uid, err := strconv.ParseUint(f, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// Fetch returns the raw message with the given UID without marking it seen.
+func (c *imapClient) Fetch(uid uint32) ([]byte, error) {
+	resps, err := c.cmd("UID FETCH %d (BODY.PEEK[])", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resps {
+		if strings.Contains(strings.ToUpper(r.line), "FETCH") && len(r.literals) > 0 {
+			return r.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("message %d not found", uid)
+}
+
+func (c *imapClient) MarkSeen(uid uint32) error {
+	_, err := c.cmd(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid)
+	return err
+}
+
+// cmd sends a tagged command and returns the untagged responses, or an
+// error when the command does not complete with OK.
+func (c *imapClient) cmd(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+
+	var resps []imapResponse
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if rest, ok := strings.CutPrefix(resp.line, tag+" "); ok {
+			if !strings.HasPrefix(strings.ToUpper(rest), "OK") {
+				name := strings.SplitN(format, " ", 2)[0]
+				if name == "LOGIN" {
+					return nil, fmt.Errorf("IMAP login failed: %s", rest)
+				}
+				return nil, fmt.Errorf("IMAP %s failed: %s", name, rest)
+			}
+			return resps, nil
+		}
+		resps = append(resps, resp)
+	}
+}
+
+// readResponse reads one response line, reading the literals ("{n}\r\n"
+// followed by n bytes) it contains.
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return resp, err
+		}
+		resp.line += line
+		if !strings.HasSuffix(line, "}") {
+			return resp, nil
+		}
+		open := strings.LastIndex(line, "{")
+		if open < 0 {
+			return resp, nil
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(line[open+1:len(line)-1], "+"))
+		if err != nil {
+			return resp, nil
+		}
+		if n < 0 || n > imapMaxLiteral {
+			return resp, fmt.Errorf("IMAP literal of %d bytes is invalid or too large", n)
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, literal)
+	}
+}
+
+func (c *imapClient) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := c.r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > imapMaxLine {
+			return "", fmt.Errorf("IMAP response line longer than %d bytes", imapMaxLine)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+func imapQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -136,6 +136,84 @@ func (m *Manager) initChannels() error {
 		}
 	}
 
+	if m.config.Channels.Slack.Enabled && m.config.Channels.Slack.BotToken != "" {
+		logger.DebugC("channels", "Attempting to initialize Slack channel")
+		slack, err := NewSlackChannel(m.config.Channels.Slack, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Slack channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["slack"] = slack
+			logger.InfoC("channels", "Slack channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Matrix.Enabled && m.config.Channels.Matrix.AccessToken != "" {
+		logger.DebugC("channels", "Attempting to initialize Matrix channel")
+		matrix, err := NewMatrixChannel(m.config.Channels.Matrix, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Matrix channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["matrix"] = matrix
+			logger.InfoC("channels", "Matrix channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Email.Enabled && m.config.Channels.Email.IMAPHost != "" {
+		logger.DebugC("channels", "Attempting to initialize Email channel")
+		email, err := NewEmailChannel(m.config.Channels.Email, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Email channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["email"] = email
+			logger.InfoC("channels", "Email channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.WeCom.Enabled && m.config.Channels.WeCom.CorpID != "" {
+		logger.DebugC("channels", "Attempting to initialize WeCom channel")
+		wecom, err := NewWeComChannel(m.config.Channels.WeCom, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize WeCom channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["wecom"] = wecom
+			logger.InfoC("channels", "WeCom channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Webhook.Enabled && len(m.config.Channels.Webhook.Routes) > 0 {
+		logger.DebugC("channels", "Attempting to initialize Webhook channel")
+		webhook, err := NewWebhookChannel(m.config.Channels.Webhook, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Webhook channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["webhook"] = webhook
+			logger.InfoC("channels", "Webhook channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.HTTP.Enabled && len(m.config.Channels.HTTP.Targets) > 0 {
+		logger.DebugC("channels", "Attempting to initialize HTTP channel")
+		httpChannel, err := NewHTTPChannel(m.config.Channels.HTTP, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize HTTP channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["http"] = httpChannel
+			logger.InfoC("channels", "HTTP channel enabled successfully")
+		}
+	}
+
 	logger.InfoCF("channels", "Channel initialization completed", map[string]interface{}{
 		"enabled_channels": len(m.channels),
 	})
@@ -0,0 +1,562 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+const (
+	matrixSyncTimeout   = 30 * time.Second
+	matrixRetryBackoff  = 5 * time.Second
+	matrixTypingRefresh = 20 * time.Second
+	matrixTypingMax     = 5 * time.Minute
+	matrixMaxMediaBytes = 20 << 20
+	matrixSyncFilter    = `{"room":{"timeline":{"limit":50}},"presence":{"not_types":["*"]}}`
+)
+
+// Media IDs and server names come from the sender's mxc:// URL and end up in
+// a local file name and a request path.
+var (
+	matrixMediaIDRe    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	matrixServerNameRe = regexp.MustCompile(`^[A-Za-z0-9.:\[\]-]+$`)
+)
+
+// MatrixChannel talks to a Matrix homeserver through the client-server API:
+// it long-polls /sync and replies with HTML formatted m.room.message events.
+// Encrypted rooms are not supported; their events arrive as m.room.encrypted,
+// and the bot says so once per room instead of staying silent.
+type MatrixChannel struct {
+	*BaseChannel
+	config config.MatrixConfig
+	client *http.Client
+	txnID  atomic.Int64
+
+	// mentionRe matches the bot's name in a message; leadingMentionRe
+	// matches it as the address at the start, e.g. "bot: ".
+	mentionRe        *regexp.Regexp
+	leadingMentionRe *regexp.Regexp
+
+	members    sync.Map // roomID -> joined member count
+	stopTyping sync.Map // roomID -> chan struct{}
+	encrypted  sync.Map // roomID -> struct{}, rooms told encryption is unsupported
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+type matrixEvent struct {
+	Type     string          `json:"type"`
+	EventID  string          `json:"event_id"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	FormattedBody string `json:"formatted_body"`
+	URL           string `json:"url"`
+	Info          struct {
+		Size     int64  `json:"size"`
+		Mimetype string `json:"mimetype"`
+	} `json:"info"`
+	Mentions *struct {
+		UserIDs []string `json:"user_ids"`
+	} `json:"m.mentions"`
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"invite_state"`
+		} `json:"invite"`
+	} `json:"rooms"`
+}
+
+func NewMatrixChannel(cfg config.MatrixConfig, messageBus *bus.MessageBus) (*MatrixChannel, error) {
+	if cfg.Homeserver == "" || cfg.UserID == "" || cfg.AccessToken == "" {
+		return nil, fmt.Errorf("matrix homeserver, user_id and access_token are required")
+	}
+	cfg.Homeserver = strings.TrimRight(cfg.Homeserver, "/")
+	localpart := strings.TrimPrefix(cfg.UserID, "@")
+	if i := strings.Index(localpart, ":"); i >= 0 {
+		localpart = localpart[:i]
+	}
+	name := regexp.QuoteMeta(localpart)
+	return &MatrixChannel{
+		BaseChannel:      NewBaseChannel("matrix", cfg, messageBus, cfg.AllowFrom),
+		config:           cfg,
+		client:           &http.Client{Timeout: matrixSyncTimeout + 30*time.Second},
+		mentionRe:        regexp.MustCompile(`(?i)(^|\W)@?` + name + `\b`),
+		leadingMentionRe: regexp.MustCompile(`(?i)^\s*@?` + name + `\s*[:,]?\s*`),
+	}, nil
+}
+
+func (c *MatrixChannel) Start(ctx context.Context) error {
+	logger.InfoC("matrix", "Starting Matrix channel")
+
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/_matrix/client/v3/account/whoami", nil, &whoami); err != nil {
+		return fmt.Errorf("matrix login check failed: %w", err)
+	}
+	if whoami.UserID != c.config.UserID {
+		return fmt.Errorf("access token belongs to %s, not %s", whoami.UserID, c.config.UserID)
+	}
+
+	// The first sync only fetches a position, so history is not answered
+	first, err := c.sync(ctx, "", 0)
+	if err != nil {
+		return fmt.Errorf("initial matrix sync failed: %w", err)
+	}
+	c.handleInvites(ctx, first)
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go c.syncLoop(ctx, first.NextBatch)
+	c.setRunning(true)
+
+	logger.InfoCF("matrix", "Matrix bot connected", map[string]interface{}{
+		"user_id":    c.config.UserID,
+		"homeserver": c.config.Homeserver,
+	})
+	return nil
+}
+
+func (c *MatrixChannel) Stop(ctx context.Context) error {
+	logger.InfoC("matrix", "Stopping Matrix channel")
+	c.setRunning(false)
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+	c.stopTyping.Range(func(key, value interface{}) bool {
+		close(value.(chan struct{}))
+		c.stopTyping.Delete(key)
+		return true
+	})
+	return nil
+}
+
+func (c *MatrixChannel) syncLoop(ctx context.Context, since string) {
+	defer close(c.done)
+	for ctx.Err() == nil {
+		resp, err := c.sync(ctx, since, matrixSyncTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.WarnCF("matrix", "Sync failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(matrixRetryBackoff):
+			}
+			continue
+		}
+		since = resp.NextBatch
+		c.handleInvites(ctx, resp)
+		for roomID, room := range resp.Rooms.Join {
+			for _, ev := range room.Timeline.Events {
+				c.handleEvent(ctx, roomID, ev)
+			}
+		}
+	}
+}
+
+func (c *MatrixChannel) sync(ctx context.Context, since string, timeout time.Duration) (*matrixSync, error) {
+	q := url.Values{}
+	q.Set("timeout", fmt.Sprintf("%d", timeout.Milliseconds()))
+	q.Set("filter", matrixSyncFilter)
+	if since != "" {
+		q.Set("since", since)
+	}
+	var resp matrixSync
+	if err := c.call(ctx, http.MethodGet, "/_matrix/client/v3/sync?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *MatrixChannel) handleInvites(ctx context.Context, resp *matrixSync) {
+	if !c.config.AutoJoin {
+		return
+	}
+	for roomID, invite := range resp.Rooms.Invite {
+		inviter := ""
+		for _, ev := range invite.InviteState.Events {
+			if ev.Type == "m.room.member" && ev.StateKey != nil && *ev.StateKey == c.config.UserID {
+				inviter = ev.Sender
+			}
+		}
+		if !c.roomAllowed(roomID) || !c.IsAllowed(inviter) {
+			logger.InfoCF("matrix", "Ignoring invite", map[string]interface{}{
+				"room_id": roomID,
+				"inviter": inviter,
+			})
+			continue
+		}
+		if err := c.call(ctx, http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(roomID), struct{}{}, nil); err != nil {
+			logger.ErrorCF("matrix", "Failed to join room", map[string]interface{}{
+				"room_id": roomID,
+				"error":   err.Error(),
+			})
+			continue
+		}
+		logger.InfoCF("matrix", "Joined room", map[string]interface{}{
+			"room_id": roomID,
+			"inviter": inviter,
+		})
+	}
+}
+
+func (c *MatrixChannel) roomAllowed(roomID string) bool {
+	if len(c.config.AllowRooms) == 0 {
+		return true
+	}
+	for _, r := range c.config.AllowRooms {
+		if r == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MatrixChannel) handleEvent(ctx context.Context, roomID string, ev matrixEvent) {
+	if ev.Type == "m.room.member" {
+		c.members.Delete(roomID)
+		return
+	}
+	if ev.Type == "m.room.encrypted" && ev.Sender != c.config.UserID {
+		if c.roomAllowed(roomID) && c.IsAllowed(ev.Sender) {
+			c.explainEncryption(ctx, roomID)
+		}
+		return
+	}
+	if ev.Type != "m.room.message" || ev.Sender == c.config.UserID {
+		return
+	}
+	if !c.roomAllowed(roomID) || !c.IsAllowed(ev.Sender) {
+		return
+	}
+
+	var msg matrixMessage
+	if err := json.Unmarshal(ev.Content, &msg); err != nil {
+		return
+	}
+	// Notices are what other bots send; answering them risks loops
+	if msg.MsgType == "m.notice" {
+		return
+	}
+
+	isDM := c.memberCount(ctx, roomID) <= 2
+	if !isDM && c.config.MentionOnly && !c.mentioned(msg) {
+		return
+	}
+
+	content := ""
+	var media []string
+	switch msg.MsgType {
+	case "m.text", "m.emote":
+		content = c.stripMention(msg.Body)
+	case "m.image", "m.file", "m.audio", "m.video":
+		path, err := c.downloadMedia(ctx, msg)
+		if err != nil {
+			logger.WarnCF("matrix", "Failed to download media", map[string]interface{}{
+				"room_id": roomID,
+				"error":   err.Error(),
+			})
+			content = fmt.Sprintf("[attachment: %s]", msg.Body)
+			break
+		}
+		media = append(media, path)
+		content = describeFile(path)
+	default:
+		return
+	}
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	logger.DebugCF("matrix", "Received message", map[string]interface{}{
+		"sender":  ev.Sender,
+		"room_id": roomID,
+		"preview": utils.Truncate(content, 50),
+	})
+
+	c.startTyping(roomID)
+	c.HandleMessage(ev.Sender, roomID, content, media, map[string]string{
+		"message_id": ev.EventID,
+		"user_id":    ev.Sender,
+		"room_id":    roomID,
+		"is_dm":      fmt.Sprintf("%t", isDM),
+	})
+}
+
+// explainEncryption tells a room, once, that its encrypted messages cannot
+// be read. It is sent as a notice so other bots do not answer it.
+func (c *MatrixChannel) explainEncryption(ctx context.Context, roomID string) {
+	if _, told := c.encrypted.LoadOrStore(roomID, struct{}{}); told {
+		return
+	}
+	logger.WarnCF("matrix", "Ignoring encrypted messages; end-to-end encryption is not supported", map[string]interface{}{
+		"room_id": roomID,
+	})
+	body := map[string]string{
+		"msgtype": "m.notice",
+		"body":    "🦞 这个房间开启了端到端加密，我暂时无法读取加密消息。请在未加密的房间中与我对话。",
+	}
+	txnID := fmt.Sprintf("mypicoclaw-%d-%d", time.Now().UnixNano(), c.txnID.Add(1))
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(roomID) + "/send/m.room.message/" + txnID
+	if err := c.call(ctx, http.MethodPut, path, body, nil); err != nil {
+		logger.WarnCF("matrix", "Failed to send encryption notice", map[string]interface{}{
+			"room_id": roomID,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// memberCount returns the number of joined members of a room, cached until
+// a membership event is seen.
+func (c *MatrixChannel) memberCount(ctx context.Context, roomID string) int {
+	if n, ok := c.members.Load(roomID); ok {
+		return n.(int)
+	}
+	var resp struct {
+		Joined map[string]json.RawMessage `json:"joined"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/_matrix/client/v3/rooms/"+url.PathEscape(roomID)+"/joined_members", nil, &resp); err != nil {
+		logger.WarnCF("matrix", "Failed to get room members", map[string]interface{}{
+			"room_id": roomID,
+			"error":   err.Error(),
+		})
+		return 3 // Treat as a group room
+	}
+	c.members.Store(roomID, len(resp.Joined))
+	return len(resp.Joined)
+}
+
+func (c *MatrixChannel) mentioned(msg matrixMessage) bool {
+	if msg.Mentions != nil {
+		for _, id := range msg.Mentions.UserIDs {
+			if id == c.config.UserID {
+				return true
+			}
+		}
+	}
+	if strings.Contains(msg.FormattedBody, "matrix.to/#/"+c.config.UserID) {
+		return true
+	}
+	return strings.Contains(msg.Body, c.config.UserID) || c.mentionRe.MatchString(msg.Body)
+}
+
+// stripMention removes a leading mention such as "bot: " or the full user
+// ID from the message.
+func (c *MatrixChannel) stripMention(body string) string {
+	body = strings.ReplaceAll(body, c.config.UserID, "")
+	return strings.TrimSpace(c.leadingMentionRe.ReplaceAllString(body, ""))
+}
+
+func (c *MatrixChannel) downloadMedia(ctx context.Context, msg matrixMessage) (string, error) {
+	serverName, mediaID, ok := strings.Cut(strings.TrimPrefix(msg.URL, "mxc://"), "/")
+	if !strings.HasPrefix(msg.URL, "mxc://") || !ok ||
+		!matrixServerNameRe.MatchString(serverName) || !matrixMediaIDRe.MatchString(mediaID) {
+		return "", fmt.Errorf("unsupported media URL %q", msg.URL)
+	}
+	if msg.Info.Size > matrixMaxMediaBytes {
+		return "", fmt.Errorf("media is larger than %d bytes", matrixMaxMediaBytes)
+	}
+
+	// Authenticated media first, then the legacy endpoint for older servers
+	var resp *http.Response
+	for _, prefix := range []string{"/_matrix/client/v1/media/download/", "/_matrix/media/v3/download/"} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			c.config.Homeserver+prefix+url.PathEscape(serverName)+"/"+url.PathEscape(mediaID), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.config.AccessToken)
+		if resp, err = c.client.Do(req); err != nil {
+			return "", err
+		}
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			return "", fmt.Errorf("media download returned %s", resp.Status)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("media download returned %s", resp.Status)
+	}
+	defer resp.Body.Close()
+
+	mediaDir := utils.MediaDir()
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Base(msg.Body)
+	if name == "." || name == "/" || name == "" {
+		name = "file"
+	}
+	localPath := filepath.Join(mediaDir, "matrix_"+mediaID+"_"+name)
+	if err := saveDownload(localPath, resp.Body, matrixMaxMediaBytes); err != nil {
+		return "", err
+	}
+	return localPath, nil
+}
+
+// startTyping shows the bot as typing in a room until the reply is sent or
+// matrixTypingMax passes.
+func (c *MatrixChannel) startTyping(roomID string) {
+	stop := make(chan struct{})
+	if old, loaded := c.stopTyping.Swap(roomID, stop); loaded {
+		close(old.(chan struct{}))
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), matrixTypingMax)
+		defer cancel()
+		ticker := time.NewTicker(matrixTypingRefresh)
+		defer ticker.Stop()
+		for {
+			c.setTyping(ctx, roomID, true)
+			select {
+			case <-stop:
+				c.setTyping(context.Background(), roomID, false)
+				return
+			case <-ctx.Done():
+				c.stopTyping.CompareAndDelete(roomID, stop)
+				c.setTyping(context.Background(), roomID, false)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *MatrixChannel) setTyping(ctx context.Context, roomID string, typing bool) {
+	body := map[string]interface{}{"typing": typing}
+	if typing {
+		body["timeout"] = (matrixTypingRefresh + 10*time.Second).Milliseconds()
+	}
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(roomID) + "/typing/" + url.PathEscape(c.config.UserID)
+	if err := c.call(ctx, http.MethodPut, path, body, nil); err != nil {
+		logger.DebugCF("matrix", "Failed to set typing", map[string]interface{}{
+			"room_id": roomID,
+			"error":   err.Error(),
+		})
+	}
+}
+
+func (c *MatrixChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("matrix channel not running")
+	}
+	if msg.ChatID == "" {
+		return fmt.Errorf("room ID is empty")
+	}
+	if stop, ok := c.stopTyping.LoadAndDelete(msg.ChatID); ok {
+		close(stop.(chan struct{}))
+	}
+
+	body := map[string]string{
+		"msgtype":        "m.text",
+		"body":           msg.Content,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": markdownToMatrixHTML(msg.Content),
+	}
+	txnID := fmt.Sprintf("mypicoclaw-%d-%d", time.Now().UnixNano(), c.txnID.Add(1))
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(msg.ChatID) + "/send/m.room.message/" + txnID
+	if err := c.call(ctx, http.MethodPut, path, body, nil); err != nil {
+		return fmt.Errorf("failed to send matrix message: %w", err)
+	}
+	return nil
+}
+
+// call makes a client-server API request and decodes the response into out.
+func (c *MatrixChannel) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.config.Homeserver+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.config.AccessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var merr struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		json.Unmarshal(data, &merr)
+		return fmt.Errorf("%s %s: %s %s %s", method, strings.SplitN(path, "?", 2)[0], resp.Status, merr.ErrCode, merr.Error)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+var matrixPreRe = regexp.MustCompile(`(?s)<pre>.*?</pre>`)
+
+// markdownToMatrixHTML renders Markdown as the HTML subset Matrix clients
+// display. Line breaks outside code blocks become <br>.
+func markdownToMatrixHTML(text string) string {
+	html := markdownToTelegramHTML(text)
+	var out strings.Builder
+	last := 0
+	for _, loc := range matrixPreRe.FindAllStringIndex(html, -1) {
+		out.WriteString(strings.ReplaceAll(html[last:loc[0]], "\n", "<br>"))
+		out.WriteString(html[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	out.WriteString(strings.ReplaceAll(html[last:], "\n", "<br>"))
+	return out.String()
+}
@@ -0,0 +1,206 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+// fakeHomeserver serves the client-server API endpoints the channel uses.
+// Each /sync after the first returns the next queued batch of room events.
+type fakeHomeserver struct {
+	server  *httptest.Server
+	batches chan map[string]interface{}
+
+	mu     sync.Mutex
+	typing []bool
+	sent   []map[string]string
+	joined []string
+}
+
+func newFakeHomeserver(t *testing.T) *fakeHomeserver {
+	f := &fakeHomeserver{batches: make(chan map[string]interface{}, 10)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v3/account/whoami", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"user_id": "@claw:example.org"})
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("since") == "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"next_batch": "s0", "rooms": map[string]interface{}{
+				"invite": map[string]interface{}{"!new:example.org": map[string]interface{}{"invite_state": map[string]interface{}{"events": []map[string]interface{}{
+					{"type": "m.room.member", "sender": "@alice:example.org", "state_key": "@claw:example.org", "content": map[string]string{"membership": "invite"}},
+				}}}},
+			}})
+			return
+		}
+		select {
+		case rooms := <-f.batches:
+			json.NewEncoder(w).Encode(map[string]interface{}{"next_batch": "s1", "rooms": map[string]interface{}{"join": rooms}})
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+			json.NewEncoder(w).Encode(map[string]interface{}{"next_batch": "s1"})
+		}
+	})
+	mux.HandleFunc("POST /_matrix/client/v3/join/{room}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.joined = append(f.joined, r.PathValue("room"))
+		f.mu.Unlock()
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{room}/joined_members", func(w http.ResponseWriter, r *http.Request) {
+		joined := map[string]interface{}{"@claw:example.org": map[string]string{}, "@alice:example.org": map[string]string{}}
+		if r.PathValue("room") == "!group:example.org" {
+			joined["@bob:example.org"] = map[string]string{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"joined": joined})
+	})
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/typing/{user}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Typing bool `json:"typing"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		f.typing = append(f.typing, body.Typing)
+		f.mu.Unlock()
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/send/m.room.message/{txn}", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		body["room"] = r.PathValue("room")
+		f.mu.Lock()
+		f.sent = append(f.sent, body)
+		f.mu.Unlock()
+		w.Write([]byte(`{"event_id": "$reply"}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v1/media/download/{server}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("PNGDATA"))
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func message(id, sender string, content map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "m.room.message", "event_id": id, "sender": sender, "content": content}
+}
+
+func TestMatrixChannel(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	msgBus := bus.NewMessageBus()
+	c, err := NewMatrixChannel(config.MatrixConfig{
+		Homeserver:  hs.server.URL + "/",
+		UserID:      "@claw:example.org",
+		AccessToken: "token",
+		AllowRooms:  []string{"!dm:example.org", "!group:example.org", "!new:example.org"},
+		MentionOnly: true,
+		AutoJoin:    true,
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop(context.Background())
+
+	text := func(body string) map[string]interface{} {
+		return map[string]interface{}{"msgtype": "m.text", "body": body}
+	}
+	hs.batches <- map[string]interface{}{
+		"!other:example.org": map[string]interface{}{"timeline": map[string]interface{}{"events": []interface{}{
+			message("$0", "@alice:example.org", text("not an allowed room")),
+		}}},
+		"!group:example.org": map[string]interface{}{"timeline": map[string]interface{}{"events": []interface{}{
+			message("$1", "@bob:example.org", text("no mention, ignored")),
+			message("$2", "@bob:example.org", text("claw: what's the weather?")),
+		}}},
+	}
+	hs.batches <- map[string]interface{}{
+		"!dm:example.org": map[string]interface{}{"timeline": map[string]interface{}{"events": []interface{}{
+			message("$3", "@alice:example.org", map[string]interface{}{"msgtype": "m.image", "body": "cat.png", "url": "mxc://example.org/abc"}),
+		}}},
+	}
+
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no message from the group room")
+	}
+	if msg.ChatID != "!group:example.org" || msg.Content != "what's the weather?" || msg.Metadata["is_dm"] != "false" {
+		t.Errorf("group message = %+v", msg)
+	}
+
+	msg, ok = msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no message from the direct room")
+	}
+	if msg.ChatID != "!dm:example.org" || msg.Metadata["is_dm"] != "true" || len(msg.Media) != 1 {
+		t.Fatalf("direct message = %+v", msg)
+	}
+	defer os.Remove(msg.Media[0])
+	if data, _ := os.ReadFile(msg.Media[0]); string(data) != "PNGDATA" {
+		t.Errorf("downloaded %q", data)
+	}
+
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "matrix", ChatID: "!dm:example.org", Content: "**Nice** cat\nline two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypted rooms are told once why the bot is silent, and media IDs
+	// that would escape the media directory are refused
+	encrypted := map[string]interface{}{"type": "m.room.encrypted", "event_id": "$4", "sender": "@bob:example.org", "content": map[string]string{"algorithm": "m.megolm.v1.aes-sha2"}}
+	hs.batches <- map[string]interface{}{
+		"!group:example.org": map[string]interface{}{"timeline": map[string]interface{}{"events": []interface{}{
+			encrypted, encrypted,
+			message("$5", "@bob:example.org", map[string]interface{}{"msgtype": "m.file", "body": "claw.txt", "url": "mxc://example.org/../../escape"}),
+		}}},
+	}
+	msg, ok = msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no message for the bad media URL")
+	}
+	if msg.Content != "[attachment: claw.txt]" || len(msg.Media) != 0 {
+		t.Errorf("bad media URL = %+v", msg)
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if len(hs.joined) != 1 || hs.joined[0] != "!new:example.org" {
+		t.Errorf("joined %v", hs.joined)
+	}
+	if len(hs.sent) != 2 || hs.sent[0]["room"] != "!dm:example.org" || hs.sent[0]["formatted_body"] != "<b>Nice</b> cat<br>line two" {
+		t.Fatalf("sent %v", hs.sent)
+	}
+	if hs.sent[1]["room"] != "!group:example.org" || hs.sent[1]["msgtype"] != "m.notice" || !strings.Contains(hs.sent[1]["body"], "加密") {
+		t.Errorf("encryption notice %v", hs.sent[1])
+	}
+	if len(hs.typing) == 0 || !hs.typing[0] {
+		t.Errorf("typing notifications %v", hs.typing)
+	}
+}
+
+func TestMarkdownToMatrixHTML(t *testing.T) {
+	got := markdownToMatrixHTML("one\n```\na < b\nc\n```\ntwo `x`")
+	want := "one<br><pre><code>a &lt; b\nc\n</code></pre><br>two <code>x</code>"
+	if got != want {
+		t.Errorf("markdownToMatrixHTML = %q, want %q", got, want)
+	}
+	if got := markdownToMatrixHTML("```\na\n```\n```\nb\n```"); !strings.Contains(got, "a\n") || !strings.Contains(got, "b\n") {
+		t.Errorf("second code block lost: %q", got)
+	}
+}
@@ -116,18 +116,26 @@ func (c *QQChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 		return fmt.Errorf("QQ bot not running")
 	}
 
-	// 构造消息
-	msgToCreate := &dto.MessageToCreate{
-		Content: msg.Content,
+	// 长消息分段；QQ 私聊只能按 URL 发送文件，超长时也只能全部分段发送
+	parts := splitOutbound(msg.Content, qqMaxMessageLength)
+	if parts == nil {
+		parts = numberParts(splitMarkdown(msg.Content, qqMaxMessageLength-partLabelReserve))
 	}
 
-	// C2C 消息发送
-	_, err := c.api.PostC2CMessage(ctx, msg.ChatID, msgToCreate)
-	if err != nil {
-		logger.ErrorCF("qq", "Failed to send C2C message", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return err
+	for _, part := range parts {
+		// 构造消息
+		msgToCreate := &dto.MessageToCreate{
+			Content: part,
+		}
+
+		// C2C 消息发送
+		_, err := c.api.PostC2CMessage(ctx, msg.ChatID, msgToCreate)
+		if err != nil {
+			logger.ErrorCF("qq", "Failed to send C2C message", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,458 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+const (
+	slackAPIURL           = "https://slack.com/api/"
+	slackFileHost         = "files.slack.com"
+	slackMaxFileBytes     = 20 << 20
+	slackReconnectBackoff = 5 * time.Second
+)
+
+// SlackChannel talks to Slack over Socket Mode, so no public URL is needed.
+// It answers direct messages and mentions of the bot. A thread is its own
+// chat, "<channel>:<thread_ts>", and a mention in a channel is answered in
+// a thread under it.
+type SlackChannel struct {
+	*BaseChannel
+	config config.SlackConfig
+	apiURL string
+	// fileHost is the only host the bot token is sent to for downloads
+	fileHost string
+	client   *http.Client
+	botID    string
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	cancel context.CancelFunc
+}
+
+type slackEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Reason     string          `json:"reason"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type slackEvent struct {
+	Type        string      `json:"type"`
+	Subtype     string      `json:"subtype"`
+	User        string      `json:"user"`
+	BotID       string      `json:"bot_id"`
+	Text        string      `json:"text"`
+	Channel     string      `json:"channel"`
+	ChannelType string      `json:"channel_type"`
+	TS          string      `json:"ts"`
+	ThreadTS    string      `json:"thread_ts"`
+	Files       []slackFile `json:"files"`
+}
+
+type slackFile struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Mimetype           string `json:"mimetype"`
+	Size               int64  `json:"size"`
+	URLPrivateDownload string `json:"url_private_download"`
+}
+
+func NewSlackChannel(cfg config.SlackConfig, messageBus *bus.MessageBus) (*SlackChannel, error) {
+	if !strings.HasPrefix(cfg.AppToken, "xapp-") {
+		return nil, fmt.Errorf("slack app_token must be an app-level xapp- token")
+	}
+	if cfg.BotToken == "" {
+		return nil, fmt.Errorf("slack bot_token is required")
+	}
+	return &SlackChannel{
+		BaseChannel: NewBaseChannel("slack", cfg, messageBus, cfg.AllowFrom),
+		config:      cfg,
+		apiURL:      slackAPIURL,
+		fileHost:    slackFileHost,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *SlackChannel) Start(ctx context.Context) error {
+	logger.InfoC("slack", "Starting Slack channel (Socket Mode)")
+
+	var auth struct {
+		UserID string `json:"user_id"`
+		Team   string `json:"team"`
+	}
+	if err := c.call(ctx, "auth.test", c.config.BotToken, nil, &auth); err != nil {
+		return fmt.Errorf("slack auth failed: %w", err)
+	}
+	c.botID = auth.UserID
+
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+	conn, err := c.connect(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	c.setRunning(true)
+	logger.InfoCF("slack", "Slack bot connected", map[string]interface{}{
+		"team":    auth.Team,
+		"user_id": auth.UserID,
+	})
+
+	go c.run(ctx, conn)
+	return nil
+}
+
+func (c *SlackChannel) Stop(ctx context.Context) error {
+	logger.InfoC("slack", "Stopping Slack channel")
+	c.setRunning(false)
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	return nil
+}
+
+// connect opens a Socket Mode websocket.
+func (c *SlackChannel) connect(ctx context.Context) (*websocket.Conn, error) {
+	var open struct {
+		URL string `json:"url"`
+	}
+	if err := c.call(ctx, "apps.connections.open", c.config.AppToken, nil, &open); err != nil {
+		return nil, fmt.Errorf("failed to open slack connection: %w", err)
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, open.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial slack websocket: %w", err)
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	return conn, nil
+}
+
+// run reads from conn and reconnects whenever Slack closes it, until ctx
+// is done.
+func (c *SlackChannel) run(ctx context.Context, conn *websocket.Conn) {
+	for {
+		if err := c.readLoop(ctx, conn); err != nil && ctx.Err() == nil {
+			logger.WarnCF("slack", "Socket Mode connection lost", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		conn.Close()
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			var err error
+			if conn, err = c.connect(ctx); err == nil {
+				break
+			}
+			logger.ErrorCF("slack", "Failed to reconnect", map[string]interface{}{
+				"error": err.Error(),
+			})
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(slackReconnectBackoff):
+			}
+		}
+	}
+}
+
+func (c *SlackChannel) readLoop(ctx context.Context, conn *websocket.Conn) error {
+	for {
+		var env slackEnvelope
+		if err := conn.ReadJSON(&env); err != nil {
+			return err
+		}
+		if env.EnvelopeID != "" {
+			if err := conn.WriteJSON(map[string]string{"envelope_id": env.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+
+		switch env.Type {
+		case "hello":
+			logger.DebugC("slack", "Socket Mode session ready")
+		case "disconnect":
+			logger.InfoCF("slack", "Slack asked to reconnect", map[string]interface{}{
+				"reason": env.Reason,
+			})
+			return nil
+		case "events_api":
+			var payload struct {
+				Event slackEvent `json:"event"`
+			}
+			if err := json.Unmarshal(env.Payload, &payload); err != nil {
+				logger.WarnCF("slack", "Failed to decode event", map[string]interface{}{
+					"error": err.Error(),
+				})
+				continue
+			}
+			go c.handleEvent(ctx, payload.Event)
+		}
+	}
+}
+
+func (c *SlackChannel) handleEvent(ctx context.Context, ev slackEvent) {
+	// Messages in channels arrive as app_mention; only direct messages are
+	// taken from message events so mentions are not handled twice.
+	switch ev.Type {
+	case "app_mention":
+	case "message":
+		if ev.ChannelType != "im" {
+			return
+		}
+	default:
+		return
+	}
+	if ev.BotID != "" || ev.User == "" || ev.User == c.botID {
+		return
+	}
+	if ev.Subtype != "" && ev.Subtype != "file_share" {
+		return
+	}
+	if !c.IsAllowed(ev.User) {
+		logger.DebugCF("slack", "Message from user not in allow_from", map[string]interface{}{
+			"user_id": ev.User,
+		})
+		return
+	}
+
+	isDM := ev.ChannelType == "im"
+	chatID := ev.Channel
+	threadTS := ev.ThreadTS
+	if threadTS == "" && !isDM {
+		threadTS = ev.TS
+	}
+	if threadTS != "" {
+		chatID += ":" + threadTS
+	}
+
+	content := strings.TrimSpace(strings.ReplaceAll(ev.Text, "<@"+c.botID+">", ""))
+	var media []string
+	for _, f := range ev.Files {
+		path, err := c.downloadFile(ctx, f)
+		if err != nil {
+			logger.WarnCF("slack", "Failed to download file", map[string]interface{}{
+				"file":  f.Name,
+				"error": err.Error(),
+			})
+			continue
+		}
+		media = append(media, path)
+		if content != "" {
+			content += "\n"
+		}
+		content += describeFile(path)
+	}
+	if content == "" {
+		return
+	}
+
+	logger.DebugCF("slack", "Received message", map[string]interface{}{
+		"user_id": ev.User,
+		"chat_id": chatID,
+		"preview": utils.Truncate(content, 50),
+	})
+
+	metadata := map[string]string{
+		"message_id": ev.TS,
+		"user_id":    ev.User,
+		"channel_id": ev.Channel,
+		"is_dm":      fmt.Sprintf("%t", isDM),
+	}
+	if threadTS != "" {
+		metadata["thread_ts"] = threadTS
+	}
+	c.HandleMessage(ev.User, chatID, content, media, metadata)
+}
+
+func (c *SlackChannel) downloadFile(ctx context.Context, f slackFile) (string, error) {
+	if f.URLPrivateDownload == "" {
+		return "", fmt.Errorf("file has no download URL")
+	}
+	if f.Size > slackMaxFileBytes {
+		return "", fmt.Errorf("file is larger than %d bytes", slackMaxFileBytes)
+	}
+	if !slackFileIDRe.MatchString(f.ID) {
+		return "", fmt.Errorf("invalid file ID %q", f.ID)
+	}
+	u, err := url.Parse(f.URLPrivateDownload)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	// Files of a workspace are served from one host; a URL anywhere else
+	// in an event must not receive the bot token
+	if u.Host == c.fileHost {
+		req.Header.Set("Authorization", "Bearer "+c.config.BotToken)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download returned %s", resp.Status)
+	}
+
+	mediaDir := utils.MediaDir()
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		return "", err
+	}
+	localPath := filepath.Join(mediaDir, "slack_"+f.ID+"_"+filepath.Base(f.Name))
+	if err := saveDownload(localPath, resp.Body, slackMaxFileBytes); err != nil {
+		return "", err
+	}
+	return localPath, nil
+}
+
+func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("slack channel not running")
+	}
+	channel, threadTS, _ := strings.Cut(msg.ChatID, ":")
+	if channel == "" {
+		return fmt.Errorf("channel ID is empty")
+	}
+
+	body := map[string]interface{}{
+		"channel": channel,
+		"text":    markdownToSlackMrkdwn(msg.Content),
+		"mrkdwn":  true,
+	}
+	if threadTS != "" {
+		body["thread_ts"] = threadTS
+	}
+	if err := c.call(ctx, "chat.postMessage", c.config.BotToken, body, nil); err != nil {
+		return fmt.Errorf("failed to send slack message: %w", err)
+	}
+	return nil
+}
+
+// call invokes a Slack Web API method and decodes its response into out.
+func (c *SlackChannel) call(ctx context.Context, method, token string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL+method, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("%s: unexpected response (%s)", method, resp.Status)
+	}
+	if !result.OK {
+		return fmt.Errorf("%s: %s", method, result.Error)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+var slackFileIDRe = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+var (
+	slackCodeBlockRe  = regexp.MustCompile("(?s)```[\\w+-]*\\n?(.*?)```")
+	slackInlineCodeRe = regexp.MustCompile("`[^`\\n]+`")
+	slackHeadingRe    = regexp.MustCompile(`(?m)^#{1,6}\s+(.+)$`)
+	slackListRe       = regexp.MustCompile(`(?m)^(\s*)[-*]\s+`)
+	slackLinkRe       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	slackBoldRe       = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	slackItalicRe     = regexp.MustCompile(`\*([^*\n]+)\*`)
+	slackStrikeRe     = regexp.MustCompile(`~~(.+?)~~`)
+)
+
+// markdownToSlackMrkdwn converts the Markdown the model writes to Slack's
+// mrkdwn: *bold*, _italic_, ~strike~, <url|text> links and bullets. Code is
+// kept as is, apart from the escaping Slack requires everywhere.
+func markdownToSlackMrkdwn(text string) string {
+	var codes []string
+	stash := func(code string) string {
+		codes = append(codes, code)
+		return fmt.Sprintf("\x00%d\x00", len(codes)-1)
+	}
+	text = slackCodeBlockRe.ReplaceAllStringFunc(text, func(m string) string {
+		return stash("```" + slackCodeBlockRe.FindStringSubmatch(m)[1] + "```")
+	})
+	text = slackInlineCodeRe.ReplaceAllStringFunc(text, stash)
+
+	text = slackEscape(text)
+	text = slackLinkRe.ReplaceAllString(text, "<$2|$1>")
+	text = slackListRe.ReplaceAllString(text, "$1• ")
+	// Bold becomes \x01, so the italic pass does not see its asterisks
+	text = slackBoldRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := slackBoldRe.FindStringSubmatch(m)
+		return "\x01" + sub[1] + sub[2] + "\x01"
+	})
+	text = slackHeadingRe.ReplaceAllStringFunc(text, func(m string) string {
+		heading := slackHeadingRe.FindStringSubmatch(m)[1]
+		return "\x01" + strings.ReplaceAll(heading, "\x01", "") + "\x01"
+	})
+	text = slackItalicRe.ReplaceAllString(text, "_${1}_")
+	text = strings.ReplaceAll(text, "\x01", "*")
+	text = slackStrikeRe.ReplaceAllString(text, "~$1~")
+
+	for i, code := range codes {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), slackEscape(code), 1)
+	}
+	return text
+}
+
+func slackEscape(text string) string {
+	text = strings.ReplaceAll(text, "&", "&amp;")
+	text = strings.ReplaceAll(text, "<", "&lt;")
+	text = strings.ReplaceAll(text, ">", "&gt;")
+	return text
+}
@@ -0,0 +1,184 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+// fakeSlack stands in for the Slack Web API and a Socket Mode connection.
+type fakeSlack struct {
+	server *httptest.Server
+	events chan interface{}
+	acks   chan string
+	posts  chan map[string]interface{}
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	f := &fakeSlack{
+		events: make(chan interface{}, 10),
+		acks:   make(chan string, 10),
+		posts:  make(chan map[string]interface{}, 10),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth.test", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "user_id": "UBOT", "team": "test"})
+	})
+	mux.HandleFunc("/api/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xapp-test" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+			return
+		}
+		url := "ws" + strings.TrimPrefix(f.server.URL, "http") + "/socket"
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "url": url})
+	})
+	mux.HandleFunc("/api/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.posts <- body
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
+	})
+	mux.HandleFunc("/files/report.txt", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("quarterly numbers"))
+	})
+	mux.HandleFunc("/socket", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(map[string]string{"type": "hello"})
+		go func() {
+			for {
+				var ack map[string]string
+				if err := conn.ReadJSON(&ack); err != nil {
+					return
+				}
+				f.acks <- ack["envelope_id"]
+			}
+		}()
+		for ev := range f.events {
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		}
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		close(f.events)
+		f.server.Close()
+	})
+	return f
+}
+
+func (f *fakeSlack) send(id string, event map[string]interface{}) {
+	f.events <- map[string]interface{}{
+		"type":        "events_api",
+		"envelope_id": id,
+		"payload":     map[string]interface{}{"event": event},
+	}
+}
+
+func TestSlackChannel(t *testing.T) {
+	fake := newFakeSlack(t)
+	msgBus := bus.NewMessageBus()
+	c, err := NewSlackChannel(config.SlackConfig{
+		BotToken:  "xoxb-test",
+		AppToken:  "xapp-test",
+		AllowFrom: []string{"UALICE"},
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.apiURL = fake.server.URL + "/api/"
+	c.fileHost = strings.TrimPrefix(fake.server.URL, "http://")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop(ctx)
+
+	// Not allowed, then a channel message without a mention: both ignored
+	fake.send("e1", map[string]interface{}{"type": "message", "channel_type": "im", "channel": "D1", "user": "UEVE", "text": "hi", "ts": "1.0"})
+	fake.send("e2", map[string]interface{}{"type": "message", "channel_type": "channel", "channel": "C1", "user": "UALICE", "text": "hi", "ts": "2.0"})
+	fake.send("e3", map[string]interface{}{
+		"type": "app_mention", "channel": "C1", "user": "UALICE", "text": "<@UBOT> summarize this", "ts": "3.0",
+		"files": []map[string]interface{}{{"id": "F1", "name": "report.txt", "url_private_download": fake.server.URL + "/files/report.txt"}},
+	})
+
+	for _, want := range []string{"e1", "e2", "e3"} {
+		select {
+		case got := <-fake.acks:
+			if got != want {
+				t.Errorf("acked %s, want %s", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatal("envelopes were not acknowledged")
+		}
+	}
+
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	if msg.ChatID != "C1:3.0" || msg.SenderID != "UALICE" || msg.Metadata["is_dm"] != "false" {
+		t.Errorf("inbound message = %+v", msg)
+	}
+	if !strings.HasPrefix(msg.Content, "summarize this\n[file: ") || len(msg.Media) != 1 {
+		t.Fatalf("content = %q, media = %v", msg.Content, msg.Media)
+	}
+	defer os.Remove(msg.Media[0])
+	if data, _ := os.ReadFile(msg.Media[0]); string(data) != "quarterly numbers" {
+		t.Errorf("downloaded %q", data)
+	}
+
+	// File IDs become part of the local path, and the bot token goes only
+	// to the file host
+	report := fake.server.URL + "/files/report.txt"
+	if _, err := c.downloadFile(ctx, slackFile{ID: "../../F1", Name: "report.txt", URLPrivateDownload: report}); err == nil {
+		t.Error("downloaded a file with a path in its ID")
+	}
+	c.fileHost = slackFileHost
+	if _, err := c.downloadFile(ctx, slackFile{ID: "F2", Name: "report.txt", URLPrivateDownload: report}); err == nil {
+		t.Error("sent the bot token to a host other than the file host")
+	}
+
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "slack", ChatID: msg.ChatID, Content: "**Done**, see [the doc](https://x.test)"}); err != nil {
+		t.Fatal(err)
+	}
+	post := <-fake.posts
+	if post["channel"] != "C1" || post["thread_ts"] != "3.0" || post["text"] != "*Done*, see <https://x.test|the doc>" {
+		t.Errorf("posted %v", post)
+	}
+}
+
+func TestMarkdownToSlackMrkdwn(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"**bold** and *italic*", "*bold* and _italic_"},
+		{"## Title\n- one\n- two", "*Title*\n• one\n• two"},
+		{"~~old~~ <new>", "~old~ &lt;new&gt;"},
+		{"run `a **b** c`", "run `a **b** c`"},
+		{"```go\nif a < b {}\n```", "```if a &lt; b {}\n```"},
+	}
+	for _, tt := range tests {
+		if got := markdownToSlackMrkdwn(tt.in); got != tt.want {
+			t.Errorf("markdownToSlackMrkdwn(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
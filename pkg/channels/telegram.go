@@ -120,29 +120,46 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		c.stopThinking.Delete(msg.ChatID)
 	}
 
-	htmlContent := markdownToTelegramHTML(msg.Content)
+	parts := splitOutbound(msg.Content, telegramMaxMessageLength)
+	if parts == nil {
+		// Too long for a few messages: send the answer as a Markdown file
+		if pID, ok := c.placeholders.Load(msg.ChatID); ok {
+			c.placeholders.Delete(msg.ChatID)
+			c.bot.Request(tgbotapi.NewDeleteMessage(chatID, pID.(int)))
+		}
+		name, data, caption := outboundFile(msg.Content)
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: data})
+		doc.Caption = caption
+		_, err := c.bot.Send(doc)
+		return err
+	}
 
-	// Try to edit placeholder
-	if pID, ok := c.placeholders.Load(msg.ChatID); ok {
-		c.placeholders.Delete(msg.ChatID)
-		editMsg := tgbotapi.NewEditMessageText(chatID, pID.(int), htmlContent)
-		editMsg.ParseMode = tgbotapi.ModeHTML
+	for i, part := range parts {
+		htmlContent := markdownToTelegramHTML(part)
 
-		if _, err := c.bot.Send(editMsg); err == nil {
-			return nil
+		// Try to edit placeholder with the first part
+		if pID, ok := c.placeholders.Load(msg.ChatID); ok && i == 0 {
+			c.placeholders.Delete(msg.ChatID)
+			editMsg := tgbotapi.NewEditMessageText(chatID, pID.(int), htmlContent)
+			editMsg.ParseMode = tgbotapi.ModeHTML
+
+			if _, err := c.bot.Send(editMsg); err == nil {
+				continue
+			}
+			// Fallback to new message if edit fails
 		}
-		// Fallback to new message if edit fails
-	}
 
-	tgMsg := tgbotapi.NewMessage(chatID, htmlContent)
-	tgMsg.ParseMode = tgbotapi.ModeHTML
+		tgMsg := tgbotapi.NewMessage(chatID, htmlContent)
+		tgMsg.ParseMode = tgbotapi.ModeHTML
 
-	if _, err := c.bot.Send(tgMsg); err != nil {
-		log.Printf("HTML parse failed, falling back to plain text: %v", err)
-		tgMsg = tgbotapi.NewMessage(chatID, msg.Content)
-		tgMsg.ParseMode = ""
-		_, err = c.bot.Send(tgMsg)
-		return err
+		if _, err := c.bot.Send(tgMsg); err != nil {
+			log.Printf("HTML parse failed, falling back to plain text: %v", err)
+			tgMsg = tgbotapi.NewMessage(chatID, part)
+			tgMsg.ParseMode = ""
+			if _, err := c.bot.Send(tgMsg); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -234,13 +251,13 @@ func (c *TelegramChannel) handleMessage(update tgbotapi.Update) {
 	}
 
 	if message.Document != nil {
-		docPath := c.downloadFile(message.Document.FileID, "")
+		docPath := c.downloadFile(message.Document.FileID, strings.ToLower(filepath.Ext(message.Document.FileName)))
 		if docPath != "" {
 			mediaPaths = append(mediaPaths, docPath)
 			if content != "" {
 				content += "\n"
 			}
-			content += fmt.Sprintf("[file: %s]", docPath)
+			content += describeFile(docPath)
 		}
 	}
 
@@ -310,7 +327,7 @@ func (c *TelegramChannel) downloadFileWithInfo(file *tgbotapi.File, ext string)
 	url := file.Link(c.bot.Token)
 	log.Printf("File URL: %s", url)
 
-	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	mediaDir := utils.MediaDir()
 	if err := os.MkdirAll(mediaDir, 0755); err != nil {
 		log.Printf("Failed to create media directory: %v", err)
 		return ""
@@ -373,7 +390,7 @@ func (c *TelegramChannel) downloadFile(fileID, ext string) string {
 	url := file.Link(c.bot.Token)
 	log.Printf("File URL: %s", url)
 
-	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	mediaDir := utils.MediaDir()
 	if err := os.MkdirAll(mediaDir, 0755); err != nil {
 		log.Printf("Failed to create media directory: %v", err)
 		return ""
@@ -458,8 +475,10 @@ func extractCodeBlocks(text string) codeBlockMatch {
 		codes = append(codes, match[1])
 	}
 
+	i := 0
 	text = re.ReplaceAllStringFunc(text, func(m string) string {
-		return fmt.Sprintf("\x00CB%d\x00", len(codes)-1)
+		i++
+		return fmt.Sprintf("\x00CB%d\x00", i-1)
 	})
 
 	return codeBlockMatch{text: text, codes: codes}
@@ -479,8 +498,10 @@ func extractInlineCodes(text string) inlineCodeMatch {
 		codes = append(codes, match[1])
 	}
 
+	i := 0
 	text = re.ReplaceAllStringFunc(text, func(m string) string {
-		return fmt.Sprintf("\x00IC%d\x00", len(codes)-1)
+		i++
+		return fmt.Sprintf("\x00IC%d\x00", i-1)
 	})
 
 	return inlineCodeMatch{text: text, codes: codes}
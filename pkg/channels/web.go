@@ -0,0 +1,582 @@
+package channels
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/session"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+//go:embed web/index.html
+var webIndexHTML []byte
+
+const (
+	webCookieName      = "mypicoclaw_web"
+	webLoginTTL        = 30 * 24 * time.Hour
+	webMaxUploadBytes  = 20 << 20
+	webUploadTTL       = time.Hour
+	webMaxMessageBytes = 64 << 10
+	webPingInterval    = 30 * time.Second
+	webPreviewChars    = 2000
+)
+
+var webNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// webClient is one open websocket of a logged-in user.
+type webClient struct {
+	name string
+	send chan []byte
+}
+
+// webUpload is a file uploaded for a later message.
+type webUpload struct {
+	owner   string
+	path    string
+	created time.Time
+}
+
+// webEvent is a message on the websocket in either direction.
+type webEvent struct {
+	Type      string   `json:"type"`
+	Session   string   `json:"session,omitempty"`
+	Role      string   `json:"role,omitempty"`
+	Content   string   `json:"content,omitempty"`
+	Media     []string `json:"media,omitempty"`
+	CallID    string   `json:"call_id,omitempty"`
+	Tool      string   `json:"tool,omitempty"`
+	Arguments string   `json:"arguments,omitempty"`
+	Result    string   `json:"result,omitempty"`
+	Done      bool     `json:"done,omitempty"`
+	IsError   bool     `json:"is_error,omitempty"`
+}
+
+// WebChannel is a browser chat UI mounted on the gateway under /web/. Users
+// log in with their own token, which decides their name, or with the shared
+// token as the single user "web"; each conversation is a chat
+// "<name>.<id>" whose history is the agent's session. Replies and the tool
+// calls the agent makes are pushed over a websocket.
+type WebChannel struct {
+	*BaseChannel
+	config   config.WebConfig
+	loginKey []byte
+	sessions *session.SessionManager
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*webClient]bool
+	uploads map[string]webUpload
+}
+
+func NewWebChannel(cfg config.WebConfig, messageBus *bus.MessageBus, sessions *session.SessionManager) (*WebChannel, error) {
+	if cfg.Token == "" && len(cfg.Users) == 0 {
+		return nil, fmt.Errorf("web token or users are required")
+	}
+	names := make([]string, 0, len(cfg.Users))
+	tokens := map[string]bool{cfg.Token: cfg.Token != ""}
+	for name, token := range cfg.Users {
+		if !webNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("web user %q: name may only contain letters, digits, _ and -", name)
+		}
+		if name == "web" {
+			return nil, fmt.Errorf("web user %q: the name is reserved for the shared token", name)
+		}
+		if token == "" || tokens[token] {
+			return nil, fmt.Errorf("web user %q needs a token of its own", name)
+		}
+		tokens[token] = true
+		names = append(names, name)
+	}
+
+	// Login cookies are signed with a key derived from every token, so
+	// changing or removing one logs its holders out
+	sort.Strings(names)
+	key := sha256.New()
+	fmt.Fprintf(key, "%s\n", cfg.Token)
+	for _, name := range names {
+		fmt.Fprintf(key, "%s=%s\n", name, cfg.Users[name])
+	}
+
+	c := &WebChannel{
+		BaseChannel: NewBaseChannel("web", cfg, messageBus, cfg.AllowFrom),
+		config:      cfg,
+		loginKey:    key.Sum(nil),
+		sessions:    sessions,
+		clients:     make(map[*webClient]bool),
+		uploads:     make(map[string]webUpload),
+	}
+	messageBus.RegisterToolHandler("web", c.handleToolEvent)
+	return c, nil
+}
+
+func (c *WebChannel) Start(ctx context.Context) error {
+	c.setRunning(true)
+	return nil
+}
+
+func (c *WebChannel) Stop(ctx context.Context) error {
+	c.setRunning(false)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for client := range c.clients {
+		close(client.send)
+		delete(c.clients, client)
+	}
+	return nil
+}
+
+// Handler returns the UI and its API, to be mounted at /web/.
+func (c *WebChannel) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /web/{$}", c.handleIndex)
+	mux.HandleFunc("POST /web/api/login", c.handleLogin)
+	mux.HandleFunc("POST /web/api/logout", c.handleLogout)
+	mux.HandleFunc("GET /web/api/me", c.requireLogin(c.handleMe))
+	mux.HandleFunc("GET /web/api/sessions", c.requireLogin(c.handleListSessions))
+	mux.HandleFunc("POST /web/api/sessions", c.requireLogin(c.handleNewSession))
+	mux.HandleFunc("GET /web/api/sessions/{id}", c.requireLogin(c.handleGetSession))
+	mux.HandleFunc("POST /web/api/upload", c.requireLogin(c.handleUpload))
+	mux.HandleFunc("GET /web/ws", c.requireLogin(c.handleWebsocket))
+	return mux
+}
+
+func (c *WebChannel) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; img-src 'self' data:; connect-src 'self' ws: wss:")
+	w.Write(webIndexHTML)
+}
+
+// loginMAC signs a login name and time with the login key.
+func (c *WebChannel) loginMAC(name string, issued int64) string {
+	mac := hmac.New(sha256.New, c.loginKey)
+	fmt.Fprintf(mac, "%s|%d", name, issued)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loginName returns the name of the user logged in with the request's
+// cookie, or "" when it is missing, expired or forged.
+func (c *WebChannel) loginName(r *http.Request) string {
+	cookie, err := r.Cookie(webCookieName)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(cookie.Value, "|")
+	if len(parts) != 3 {
+		return ""
+	}
+	issued, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > webLoginTTL {
+		return ""
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(c.loginMAC(parts[0], issued))) {
+		return ""
+	}
+	return parts[0]
+}
+
+func (c *WebChannel) requireLogin(next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := c.loginName(r)
+		if name == "" {
+			webError(w, http.StatusUnauthorized, "not logged in")
+			return
+		}
+		next(w, r, name)
+	}
+}
+
+// tokenUser returns the login name a token belongs to, or "" if none.
+// Every token is compared so the time taken does not reveal which matched.
+func (c *WebChannel) tokenUser(token string) string {
+	name := ""
+	if c.config.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.config.Token)) == 1 {
+		name = "web"
+	}
+	for user, userToken := range c.config.Users {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(userToken)) == 1 {
+			name = user
+		}
+	}
+	return name
+}
+
+func (c *WebChannel) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, webMaxMessageBytes)).Decode(&req); err != nil {
+		webError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	name := c.tokenUser(req.Token)
+	if name == "" {
+		logger.WarnCF("web", "Failed login", map[string]interface{}{
+			"remote": r.RemoteAddr,
+		})
+		webError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	if !c.IsAllowed(name) {
+		webError(w, http.StatusForbidden, "name is not allowed")
+		return
+	}
+
+	issued := time.Now().Unix()
+	http.SetCookie(w, &http.Cookie{
+		Name:     webCookieName,
+		Value:    fmt.Sprintf("%s|%d|%s", name, issued, c.loginMAC(name, issued)),
+		Path:     "/web/",
+		MaxAge:   int(webLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	webJSON(w, http.StatusOK, map[string]string{"name": name})
+}
+
+func (c *WebChannel) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: webCookieName, Path: "/web/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *WebChannel) handleMe(w http.ResponseWriter, r *http.Request, name string) {
+	webJSON(w, http.StatusOK, map[string]string{"name": name})
+}
+
+type webSessionSummary struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Messages int       `json:"messages"`
+	Updated  time.Time `json:"updated"`
+}
+
+// handleListSessions lists the user's conversations, most recent first.
+func (c *WebChannel) handleListSessions(w http.ResponseWriter, r *http.Request, name string) {
+	prefix := "web:" + name + "."
+	out := []webSessionSummary{}
+	for _, sess := range c.sessions.List() {
+		if !strings.HasPrefix(sess.Key, prefix) {
+			continue
+		}
+		title := "新对话"
+		for _, m := range sess.Messages {
+			if m.Role == "user" && m.Content != nil {
+				title = utils.Truncate(strings.TrimSpace(*m.Content), 40)
+				break
+			}
+		}
+		out = append(out, webSessionSummary{
+			ID:       strings.TrimPrefix(sess.Key, "web:"),
+			Title:    title,
+			Messages: len(sess.Messages),
+			Updated:  sess.Updated,
+		})
+	}
+	webJSON(w, http.StatusOK, map[string]interface{}{"sessions": out})
+}
+
+func (c *WebChannel) handleNewSession(w http.ResponseWriter, r *http.Request, name string) {
+	webJSON(w, http.StatusCreated, map[string]string{"id": name + "." + utils.RandomID(4)})
+}
+
+// handleGetSession returns a conversation as the UI shows it: user and
+// assistant messages, tool calls and their results.
+func (c *WebChannel) handleGetSession(w http.ResponseWriter, r *http.Request, name string) {
+	id := r.PathValue("id")
+	if !c.ownsChat(name, id) {
+		webError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	sess, ok := c.sessions.Get("web:" + id)
+	if !ok {
+		webJSON(w, http.StatusOK, map[string]interface{}{"id": id, "messages": []webEvent{}})
+		return
+	}
+
+	tools := make(map[string]string)
+	messages := []webEvent{}
+	for _, m := range sess.Messages {
+		content := ""
+		if m.Content != nil {
+			content = *m.Content
+		}
+		switch m.Role {
+		case "user", "assistant":
+			if content != "" {
+				messages = append(messages, webEvent{Type: "message", Role: m.Role, Content: content})
+			}
+			for _, tc := range m.ToolCalls {
+				if tc.Function == nil {
+					continue
+				}
+				tools[tc.ID] = tc.Function.Name
+				messages = append(messages, webEvent{Type: "tool", CallID: tc.ID, Tool: tc.Function.Name,
+					Arguments: utils.Truncate(tc.Function.Arguments, webPreviewChars)})
+			}
+		case "tool":
+			messages = append(messages, webEvent{Type: "tool", CallID: m.ToolCallID, Tool: tools[m.ToolCallID],
+				Result: utils.Truncate(content, webPreviewChars), Done: true})
+		}
+	}
+	webJSON(w, http.StatusOK, map[string]interface{}{"id": id, "messages": messages})
+}
+
+// handleUpload saves a file for the user's next message and returns its ID.
+func (c *WebChannel) handleUpload(w http.ResponseWriter, r *http.Request, name string) {
+	c.sweepUploads(time.Now())
+
+	r.Body = http.MaxBytesReader(w, r.Body, webMaxUploadBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			webError(w, http.StatusRequestEntityTooLarge, "file is larger than 20 MB")
+			return
+		}
+		webError(w, http.StatusBadRequest, "file is required (at most 20 MB)")
+		return
+	}
+	defer file.Close()
+
+	mediaDir := utils.MediaDir()
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		webError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	id := utils.RandomID(8)
+	base := filepath.Base(header.Filename)
+	if base == "." || base == "/" || base == "" {
+		base = "upload"
+	}
+	localPath := filepath.Join(mediaDir, "web_"+id+"_"+base)
+	if err := saveDownload(localPath, file, webMaxUploadBytes); err != nil {
+		if errors.Is(err, errFileTooLarge) {
+			webError(w, http.StatusRequestEntityTooLarge, "file is larger than 20 MB")
+			return
+		}
+		webError(w, http.StatusBadRequest, "upload failed")
+		return
+	}
+
+	c.mu.Lock()
+	c.uploads[id] = webUpload{owner: name, path: localPath, created: time.Now()}
+	c.mu.Unlock()
+	webJSON(w, http.StatusCreated, map[string]string{"id": id, "name": base})
+}
+
+// sweepUploads forgets uploads not used by a message within webUploadTTL
+// and removes their files.
+func (c *WebChannel) sweepUploads(now time.Time) {
+	c.mu.Lock()
+	var expired []string
+	for id, upload := range c.uploads {
+		if now.Sub(upload.created) > webUploadTTL {
+			expired = append(expired, upload.path)
+			delete(c.uploads, id)
+		}
+	}
+	c.mu.Unlock()
+	for _, path := range expired {
+		os.Remove(path)
+	}
+}
+
+func (c *WebChannel) handleWebsocket(w http.ResponseWriter, r *http.Request, name string) {
+	// The default origin check rejects pages of other sites
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	client := &webClient{name: name, send: make(chan []byte, 64)}
+	c.mu.Lock()
+	c.clients[client] = true
+	c.mu.Unlock()
+
+	go c.writeLoop(conn, client)
+	c.readLoop(conn, client)
+
+	c.mu.Lock()
+	if c.clients[client] {
+		delete(c.clients, client)
+		close(client.send)
+	}
+	c.mu.Unlock()
+}
+
+func (c *WebChannel) readLoop(conn *websocket.Conn, client *webClient) {
+	conn.SetReadLimit(webMaxMessageBytes)
+	conn.SetReadDeadline(time.Now().Add(2 * webPingInterval))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(2 * webPingInterval))
+		return nil
+	})
+	for {
+		var ev webEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			return
+		}
+		if ev.Type != "message" {
+			continue
+		}
+		if err := c.handleUserMessage(client.name, ev); err != nil {
+			c.push(client, webEvent{Type: "error", Session: ev.Session, Content: err.Error()})
+		}
+	}
+}
+
+func (c *WebChannel) writeLoop(conn *websocket.Conn, client *webClient) {
+	ticker := time.NewTicker(webPingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case data, ok := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleUserMessage passes a message typed in the UI to the agent, with
+// the files uploaded for it.
+func (c *WebChannel) handleUserMessage(name string, ev webEvent) error {
+	if !c.ownsChat(name, ev.Session) {
+		return fmt.Errorf("unknown session")
+	}
+	content := strings.TrimSpace(ev.Content)
+	var media []string
+	for _, id := range ev.Media {
+		c.mu.Lock()
+		upload, ok := c.uploads[id]
+		if ok && upload.owner == name {
+			delete(c.uploads, id)
+		}
+		c.mu.Unlock()
+		if !ok || upload.owner != name {
+			return fmt.Errorf("unknown upload %s", id)
+		}
+		media = append(media, upload.path)
+		content += "\n" + describeFile(upload.path)
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return fmt.Errorf("message is empty")
+	}
+
+	logger.InfoCF("web", "Received message", map[string]interface{}{
+		"sender_id": name,
+		"chat_id":   ev.Session,
+		"preview":   utils.Truncate(content, 50),
+	})
+	c.HandleMessage(name, ev.Session, content, media, map[string]string{
+		"is_dm": "true",
+	})
+	return nil
+}
+
+// ownsChat reports whether chatID is one of the user's conversations.
+func (c *WebChannel) ownsChat(name, chatID string) bool {
+	id, ok := strings.CutPrefix(chatID, name+".")
+	return ok && id != "" && webNamePattern.MatchString(id)
+}
+
+func (c *WebChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	c.broadcast(msg.ChatID, webEvent{Type: "message", Session: msg.ChatID, Role: "assistant", Content: msg.Content})
+	return nil
+}
+
+func (c *WebChannel) handleToolEvent(ev bus.ToolEvent) {
+	c.broadcast(ev.ChatID, webEvent{
+		Type:      "tool",
+		Session:   ev.ChatID,
+		CallID:    ev.CallID,
+		Tool:      ev.Tool,
+		Arguments: utils.Truncate(ev.Arguments, webPreviewChars),
+		Result:    utils.Truncate(ev.Result, webPreviewChars),
+		Done:      ev.Done,
+		IsError:   ev.IsError,
+	})
+}
+
+// broadcast pushes an event to every open websocket of the chat's owner.
+func (c *WebChannel) broadcast(chatID string, ev webEvent) {
+	owner, _, _ := strings.Cut(chatID, ".")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sent := false
+	for client := range c.clients {
+		if client.name == owner {
+			c.pushLocked(client, ev)
+			sent = true
+		}
+	}
+	if !sent {
+		logger.DebugCF("web", "No open websocket for chat", map[string]interface{}{
+			"chat_id": chatID,
+		})
+	}
+}
+
+func (c *WebChannel) push(client *webClient, ev webEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clients[client] {
+		c.pushLocked(client, ev)
+	}
+}
+
+func (c *WebChannel) pushLocked(client *webClient, ev webEvent) {
+	data, _ := json.Marshal(ev)
+	select {
+	case client.send <- data:
+	default:
+		logger.WarnCF("web", "Dropped event for a slow websocket", map[string]interface{}{
+			"name": client.name,
+		})
+	}
+}
+
+func webJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func webError(w http.ResponseWriter, status int, message string) {
+	webJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,250 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/session"
+)
+
+func TestWebChannel(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	sessions := session.NewSessionManager("")
+	c, err := NewWebChannel(config.WebConfig{
+		Users:     map[string]string{"alice": "alice-token", "bob": "bob-token"},
+		AllowFrom: []string{"alice"},
+	}, msgBus, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start(context.Background())
+	server := httptest.NewServer(c.Handler())
+	defer server.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	call := func(method, path string, body interface{}, out interface{}) int {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	if code := call("GET", "/web/api/sessions", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("sessions before login = %d", code)
+	}
+	if code := call("POST", "/web/api/login", map[string]string{"token": "wrong"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong token = %d", code)
+	}
+	if code := call("POST", "/web/api/login", map[string]string{"token": "bob-token"}, nil); code != http.StatusForbidden {
+		t.Errorf("login with a name not allowed = %d", code)
+	}
+	// The name comes from the token, not from the request
+	var me struct{ Name string }
+	if code := call("POST", "/web/api/login", map[string]string{"name": "bob", "token": "alice-token"}, &me); code != http.StatusOK || me.Name != "alice" {
+		t.Fatalf("login = %d as %q", code, me.Name)
+	}
+
+	var created struct{ ID string }
+	call("POST", "/web/api/sessions", nil, &created)
+	if !strings.HasPrefix(created.ID, "alice.") {
+		t.Fatalf("new session %q", created.ID)
+	}
+	if code := call("GET", "/web/api/sessions/bob.1234", nil, nil); code != http.StatusNotFound {
+		t.Errorf("another user's session = %d", code)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "notes.txt")
+	part.Write([]byte("hello"))
+	form.Close()
+	req, _ := http.NewRequest("POST", server.URL+"/web/api/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upload struct{ ID string }
+	json.NewDecoder(resp.Body).Decode(&upload)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || upload.ID == "" {
+		t.Fatalf("upload = %d %+v", resp.StatusCode, upload)
+	}
+
+	header := http.Header{}
+	for _, cookie := range jar.Cookies(req.URL) {
+		header.Add("Cookie", cookie.String())
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/web/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(webEvent{Type: "message", Session: created.ID, Content: "summarize", Media: []string{upload.ID}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	if msg.SenderID != "alice" || msg.ChatID != created.ID || msg.SessionKey != "web:"+created.ID || len(msg.Media) != 1 {
+		t.Fatalf("inbound = %+v", msg)
+	}
+	defer os.Remove(msg.Media[0])
+	if !strings.HasPrefix(msg.Content, "summarize\n[file: ") {
+		t.Errorf("content = %q", msg.Content)
+	}
+
+	// An upload is used once
+	conn.WriteJSON(webEvent{Type: "message", Session: created.ID, Content: "again", Media: []string{upload.ID}})
+	var ev webEvent
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&ev); err != nil || ev.Type != "error" {
+		t.Fatalf("reused upload = %+v, %v", ev, err)
+	}
+
+	msgBus.PublishToolEvent(bus.ToolEvent{Channel: "web", ChatID: created.ID, CallID: "c1", Tool: "exec", Arguments: `{"command":"ls"}`})
+	c.Send(ctx, bus.OutboundMessage{Channel: "web", ChatID: created.ID, Content: "**done**"})
+	for _, want := range []webEvent{
+		{Type: "tool", Session: created.ID, CallID: "c1", Tool: "exec", Arguments: `{"command":"ls"}`},
+		{Type: "message", Session: created.ID, Role: "assistant", Content: "**done**"},
+	} {
+		var got webEvent
+		if err := conn.ReadJSON(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != want.Type || got.Session != want.Session || got.Tool != want.Tool || got.Arguments != want.Arguments || got.Content != want.Content {
+			t.Errorf("event = %+v, want %+v", got, want)
+		}
+	}
+
+	sessions.AddMessage("web:"+created.ID, "user", "summarize")
+	sessions.AddMessage("web:"+created.ID, "assistant", "done")
+	var list struct{ Sessions []webSessionSummary }
+	call("GET", "/web/api/sessions", nil, &list)
+	if len(list.Sessions) != 1 || list.Sessions[0].ID != created.ID || list.Sessions[0].Title != "summarize" {
+		t.Errorf("sessions = %+v", list.Sessions)
+	}
+	var history struct{ Messages []webEvent }
+	call("GET", "/web/api/sessions/"+created.ID, nil, &history)
+	if len(history.Messages) != 2 || history.Messages[1].Role != "assistant" {
+		t.Errorf("history = %+v", history.Messages)
+	}
+}
+
+func TestWebLoginTokens(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	sessions := session.NewSessionManager("")
+
+	for _, cfg := range []config.WebConfig{
+		{},
+		{Users: map[string]string{"alice": ""}},
+		{Users: map[string]string{"alice": "same", "bob": "same"}},
+		{Token: "same", Users: map[string]string{"alice": "same"}},
+		{Users: map[string]string{"not a name": "x"}},
+		{Token: "shared", Users: map[string]string{"web": "x"}},
+	} {
+		if _, err := NewWebChannel(cfg, msgBus, sessions); err == nil {
+			t.Errorf("NewWebChannel(%+v) accepted", cfg)
+		}
+	}
+
+	c, err := NewWebChannel(config.WebConfig{Token: "shared", Users: map[string]string{"alice": "alice-token"}}, msgBus, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{"shared": "web", "alice-token": "alice", "": "", "alice": ""}
+	for token, want := range tests {
+		if got := c.tokenUser(token); got != want {
+			t.Errorf("tokenUser(%q) = %q, want %q", token, got, want)
+		}
+	}
+
+	// Cookies signed before a token changes no longer log in
+	issued := time.Now().Unix()
+	cookie := &http.Cookie{Name: webCookieName, Value: "alice|" + strconv.FormatInt(issued, 10) + "|" + c.loginMAC("alice", issued)}
+	req := httptest.NewRequest("GET", "/web/api/me", nil)
+	req.AddCookie(cookie)
+	if got := c.loginName(req); got != "alice" {
+		t.Fatalf("loginName = %q", got)
+	}
+	rotated, _ := NewWebChannel(config.WebConfig{Token: "shared", Users: map[string]string{"alice": "new-token"}}, msgBus, sessions)
+	if got := rotated.loginName(req); got != "" {
+		t.Errorf("loginName after rotating the token = %q", got)
+	}
+}
+
+func TestWebUploadLimits(t *testing.T) {
+	c, err := NewWebChannel(config.WebConfig{Token: "shared"}, bus.NewMessageBus(), session.NewSessionManager(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func(size int) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "big.bin")
+		part.Write(make([]byte, size))
+		form.Close()
+		req := httptest.NewRequest("POST", "/web/api/upload", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		c.handleUpload(w, req, "web")
+		return w
+	}
+
+	// A file over the limit is refused, not cut short
+	if w := upload(webMaxUploadBytes + 1); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload just over the limit = %d", w.Code)
+	}
+	if w := upload(webMaxUploadBytes + 2<<20); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload far over the limit = %d", w.Code)
+	}
+	if len(c.uploads) != 0 {
+		t.Errorf("refused uploads were kept: %+v", c.uploads)
+	}
+
+	if w := upload(10); w.Code != http.StatusCreated {
+		t.Fatalf("upload = %d", w.Code)
+	}
+	var kept webUpload
+	for _, u := range c.uploads {
+		kept = u
+	}
+	c.sweepUploads(time.Now())
+	if len(c.uploads) != 1 {
+		t.Fatalf("fresh upload was swept")
+	}
+	c.sweepUploads(time.Now().Add(webUploadTTL + time.Minute))
+	if len(c.uploads) != 0 {
+		t.Errorf("expired upload was kept")
+	}
+	if _, err := os.Stat(kept.path); !os.IsNotExist(err) {
+		t.Errorf("expired upload file remains: %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+const (
+	defaultSignatureHeader = "X-Hub-Signature-256"
+	maxWebhookBodyBytes    = 1 << 20
+)
+
+// templateFuncs are available in webhook templates.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) string {
+		data, _ := json.MarshalIndent(v, "", "  ")
+		return string(data)
+	},
+	"truncate": func(n int, s string) string {
+		return utils.Truncate(s, n)
+	},
+	"join": func(sep string, v []interface{}) string {
+		parts := make([]string, len(v))
+		for i, x := range v {
+			parts[i] = fmt.Sprint(x)
+		}
+		return strings.Join(parts, sep)
+	},
+}
+
+// webhookRoute is a configured route with its template parsed.
+type webhookRoute struct {
+	name     string
+	config   config.WebhookRouteConfig
+	template *template.Template
+}
+
+// WebhookChannel receives events pushed by other systems, e.g. alerting or
+// CI, on POST /webhook/<route>. Each route turns its payload into a message
+// for its own session; the agent's reply is forwarded to the route's
+// delivery target, or dropped when it has none.
+type WebhookChannel struct {
+	*BaseChannel
+	config config.WebhookConfig
+	routes map[string]*webhookRoute
+	server *http.Server
+}
+
+func NewWebhookChannel(cfg config.WebhookConfig, bus *bus.MessageBus) (*WebhookChannel, error) {
+	routes := make(map[string]*webhookRoute, len(cfg.Routes))
+	for name, rc := range cfg.Routes {
+		if rc.Secret == "" {
+			return nil, fmt.Errorf("webhook route %q has no secret", name)
+		}
+		if (rc.DeliverChannel == "") != (rc.DeliverChatID == "") {
+			return nil, fmt.Errorf("webhook route %q needs both deliver_channel and deliver_chat_id", name)
+		}
+		tmpl := rc.Template
+		if tmpl == "" {
+			tmpl = fmt.Sprintf("Webhook event from %s:\n{{json .}}", name)
+		}
+		t, err := template.New(name).Funcs(templateFuncs).Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("webhook route %q: invalid template: %w", name, err)
+		}
+		routes[name] = &webhookRoute{name: name, config: rc, template: t}
+	}
+
+	return &WebhookChannel{
+		BaseChannel: NewBaseChannel("webhook", cfg, bus, nil),
+		config:      cfg,
+		routes:      routes,
+	}, nil
+}
+
+func (c *WebhookChannel) Start(ctx context.Context) error {
+	addr := net.JoinHostPort(c.config.Host, strconv.Itoa(c.config.Port))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	c.server = &http.Server{
+		Handler:           c.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := c.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.ErrorCF("webhook", "Webhook server stopped", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+	c.setRunning(true)
+
+	logger.InfoCF("webhook", "Webhook server listening", map[string]interface{}{
+		"addr":   addr,
+		"routes": len(c.routes),
+	})
+	return nil
+}
+
+func (c *WebhookChannel) Stop(ctx context.Context) error {
+	c.setRunning(false)
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Shutdown(ctx)
+}
+
+// Handler returns the HTTP handler of the webhook routes.
+func (c *WebhookChannel) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook/{route}", c.handleWebhook)
+	return mux
+}
+
+// Send forwards the agent's reply to a route to its delivery target.
+func (c *WebhookChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	route, ok := c.routes[msg.ChatID]
+	if !ok {
+		return fmt.Errorf("unknown webhook route: %s", msg.ChatID)
+	}
+	if route.config.DeliverChannel == "" {
+		logger.DebugCF("webhook", "Route has no delivery target, dropping reply", map[string]interface{}{
+			"route": route.name,
+		})
+		return nil
+	}
+
+	// Publish from a new goroutine: Send runs on the outbound dispatcher,
+	// which would otherwise block on a full bus.
+	out := bus.OutboundMessage{
+		Channel: route.config.DeliverChannel,
+		ChatID:  route.config.DeliverChatID,
+		Content: msg.Content,
+	}
+	go c.bus.PublishOutbound(out)
+	return nil
+}
+
+func (c *WebhookChannel) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	route, ok := c.routes[r.PathValue("route")]
+	if !ok {
+		http.Error(w, "unknown route", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodyBytes+1))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebhookBodyBytes {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	header := route.config.SignatureHeader
+	if header == "" {
+		header = defaultSignatureHeader
+	}
+	if !validSignature(route.config.Secret, body, r.Header.Get(header)) {
+		logger.WarnCF("webhook", "Rejected webhook with invalid signature", map[string]interface{}{
+			"route":  route.name,
+			"remote": r.RemoteAddr,
+		})
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	content, err := route.render(body)
+	if err != nil {
+		logger.WarnCF("webhook", "Failed to render webhook template", map[string]interface{}{
+			"route": route.name,
+			"error": err.Error(),
+		})
+		http.Error(w, "failed to render payload", http.StatusUnprocessableEntity)
+		return
+	}
+	if strings.TrimSpace(content) == "" {
+		// The template chose to ignore this event
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	sessionKey := route.config.SessionKey
+	if sessionKey == "" {
+		sessionKey = "webhook:" + route.name
+	}
+	logger.InfoCF("webhook", "Webhook event received", map[string]interface{}{
+		"route":       route.name,
+		"session_key": sessionKey,
+		"preview":     utils.Truncate(content, 80),
+	})
+	metadata := map[string]string{"webhook_route": route.name}
+	if event := firstHeader(r, "X-GitHub-Event", "X-Event-Type"); event != "" {
+		metadata["event"] = event
+	}
+	c.bus.PublishInbound(bus.InboundMessage{
+		Channel:    c.name,
+		SenderID:   route.name,
+		ChatID:     route.name,
+		Content:    content,
+		SessionKey: sessionKey,
+		Metadata:   metadata,
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// render applies the route template to the payload, which is the decoded
+// JSON body or, for other bodies, the body as a string.
+func (r *webhookRoute) render(body []byte) (string, error) {
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		payload = string(body)
+	}
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, payload); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validSignature checks a hex HMAC-SHA256 of body, optionally prefixed
+// with "sha256=" as GitHub sends it.
+func validSignature(secret string, body []byte, signature string) bool {
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "sha256=")
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package channels
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookChannel(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	c, err := NewWebhookChannel(config.WebhookConfig{Routes: map[string]config.WebhookRouteConfig{
+		"grafana": {
+			Secret:         "s3cret",
+			Template:       `{{if eq .status "resolved"}}{{else}}Alert {{.title}}: {{join ", " .tags}}{{end}}`,
+			SessionKey:     "ops",
+			DeliverChannel: "telegram",
+			DeliverChatID:  "42",
+		},
+	}}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(c.Handler())
+	defer ts.Close()
+
+	post := func(route, body, signature string) int {
+		req, _ := http.NewRequest("POST", ts.URL+"/webhook/"+route, strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature-256", signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	firing := `{"status": "firing", "title": "disk full", "tags": ["db", "prod"]}`
+	tests := []struct {
+		name      string
+		route     string
+		body      string
+		signature string
+		want      int
+	}{
+		{"unknown route", "github", firing, sign("s3cret", firing), http.StatusNotFound},
+		{"missing signature", "grafana", firing, "", http.StatusUnauthorized},
+		{"wrong secret", "grafana", firing, sign("other", firing), http.StatusUnauthorized},
+		{"ignored by template", "grafana", `{"status": "resolved"}`, sign("s3cret", `{"status": "resolved"}`), http.StatusNoContent},
+		{"accepted", "grafana", firing, sign("s3cret", firing), http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if got := post(tt.route, tt.body, tt.signature); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	if msg.Content != "Alert disk full: db, prod" || msg.SessionKey != "ops" || msg.ChatID != "grafana" {
+		t.Fatalf("inbound message = %+v", msg)
+	}
+
+	// The reply goes to the delivery target
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "webhook", ChatID: "grafana", Content: "on it"}); err != nil {
+		t.Fatal(err)
+	}
+	out, ok := msgBus.SubscribeOutbound(ctx)
+	if !ok || out.Channel != "telegram" || out.ChatID != "42" || out.Content != "on it" {
+		t.Fatalf("delivered %+v", out)
+	}
+}
+
+func TestWebhookRouteNeedsSecret(t *testing.T) {
+	_, err := NewWebhookChannel(config.WebhookConfig{Routes: map[string]config.WebhookRouteConfig{
+		"hub": {Template: "{{.}}"},
+	}}, bus.NewMessageBus())
+	if err == nil {
+		t.Fatal("route without secret was accepted")
+	}
+}
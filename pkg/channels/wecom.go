@@ -0,0 +1,502 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+const (
+	wecomAPIURL        = "https://qyapi.weixin.qq.com/cgi-bin"
+	wecomMaxBodyBytes  = 1 << 20
+	wecomMaxMediaBytes = 20 << 20
+	wecomSeenMessages  = 256
+	// wecomTokenMargin is how long before expiry a token is refreshed.
+	wecomTokenMargin = 5 * time.Minute
+	// WeCom errcodes for a missing, invalid or expired access token
+	wecomErrTokenInvalid = 40014
+	wecomErrTokenMissing = 41001
+	wecomErrTokenExpired = 42001
+)
+
+// wecomEnvelope is the encrypted callback body.
+type wecomEnvelope struct {
+	ToUserName string `xml:"ToUserName"`
+	AgentID    string `xml:"AgentID"`
+	Encrypt    string `xml:"Encrypt"`
+}
+
+// wecomMessage is a decrypted callback message.
+type wecomMessage struct {
+	ToUserName   string `xml:"ToUserName"`
+	FromUserName string `xml:"FromUserName"`
+	CreateTime   int64  `xml:"CreateTime"`
+	MsgType      string `xml:"MsgType"`
+	Content      string `xml:"Content"`
+	MsgID        string `xml:"MsgId"`
+	AgentID      string `xml:"AgentID"`
+	MediaID      string `xml:"MediaId"`
+	Format       string `xml:"Format"`
+	FileName     string `xml:"FileName"`
+	ChatID       string `xml:"ChatId"`
+	Event        string `xml:"Event"`
+}
+
+// wecomResponse is the error part of every API response.
+type wecomResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// WeComChannel is a WeCom (WeChat Work) self-built app. WeCom posts
+// encrypted messages to the callback URL; replies go through the message
+// API with a cached access token. Users chat with the app directly, so each
+// user is a session; group chats ("chat:<chatid>") are sent to with the
+// appchat API.
+type WeComChannel struct {
+	*BaseChannel
+	config config.WeComConfig
+	crypto *wecomCrypto
+	apiURL string
+	client *http.Client
+	server *http.Server
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	seenMu    sync.Mutex
+	seen      map[string]bool
+	seenOrder []string
+}
+
+func NewWeComChannel(cfg config.WeComConfig, messageBus *bus.MessageBus) (*WeComChannel, error) {
+	if cfg.CorpID == "" || cfg.Secret == "" || cfg.AgentID == 0 {
+		return nil, fmt.Errorf("wecom corp_id, agent_id and secret are required")
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("wecom token is required")
+	}
+	crypto, err := newWecomCrypto(cfg.Token, cfg.EncodingAESKey, cfg.CorpID)
+	if err != nil {
+		return nil, fmt.Errorf("wecom: %w", err)
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/wecom"
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		cfg.Path = "/" + cfg.Path
+	}
+
+	return &WeComChannel{
+		BaseChannel: NewBaseChannel("wecom", cfg, messageBus, cfg.AllowFrom),
+		config:      cfg,
+		crypto:      crypto,
+		apiURL:      wecomAPIURL,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		seen:        make(map[string]bool),
+	}, nil
+}
+
+func (c *WeComChannel) Start(ctx context.Context) error {
+	addr := net.JoinHostPort(c.config.Host, strconv.Itoa(c.config.Port))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	c.server = &http.Server{
+		Handler:           c.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := c.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.ErrorCF("wecom", "WeCom callback server stopped", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+	c.setRunning(true)
+
+	logger.InfoCF("wecom", "WeCom callback server listening", map[string]interface{}{
+		"addr": addr,
+		"path": c.config.Path,
+	})
+	return nil
+}
+
+func (c *WeComChannel) Stop(ctx context.Context) error {
+	c.setRunning(false)
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Shutdown(ctx)
+}
+
+// Handler returns the HTTP handler of the callback URL: GET verifies the
+// URL when it is configured, POST receives messages.
+func (c *WeComChannel) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+c.config.Path, c.handleVerify)
+	mux.HandleFunc("POST "+c.config.Path, c.handleCallback)
+	return mux
+}
+
+func (c *WeComChannel) handleVerify(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	echo := q.Get("echostr")
+	if !c.crypto.verify(q.Get("msg_signature"), q.Get("timestamp"), q.Get("nonce"), echo) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	plain, err := c.crypto.decrypt(echo)
+	if err != nil {
+		http.Error(w, "invalid echostr", http.StatusBadRequest)
+		return
+	}
+	w.Write(plain)
+}
+
+func (c *WeComChannel) handleCallback(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, wecomMaxBodyBytes))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	var env wecomEnvelope
+	if err := xml.Unmarshal(body, &env); err != nil || env.Encrypt == "" {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if !c.crypto.verify(q.Get("msg_signature"), q.Get("timestamp"), q.Get("nonce"), env.Encrypt) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	plain, err := c.crypto.decrypt(env.Encrypt)
+	if err != nil {
+		logger.WarnCF("wecom", "Failed to decrypt callback", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "invalid message", http.StatusBadRequest)
+		return
+	}
+	var msg wecomMessage
+	if err := xml.Unmarshal(plain, &msg); err != nil {
+		http.Error(w, "invalid message", http.StatusBadRequest)
+		return
+	}
+
+	// WeCom retries a callback that takes over 5 seconds, so answer first
+	// and drop the retries by message ID.
+	w.WriteHeader(http.StatusOK)
+	if msg.MsgID != "" && !c.markSeen(msg.MsgID) {
+		return
+	}
+	go c.handleMessage(context.Background(), msg)
+}
+
+// markSeen records a message ID and reports whether it is new.
+func (c *WeComChannel) markSeen(id string) bool {
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+	if c.seen[id] {
+		return false
+	}
+	c.seen[id] = true
+	c.seenOrder = append(c.seenOrder, id)
+	if len(c.seenOrder) > wecomSeenMessages {
+		delete(c.seen, c.seenOrder[0])
+		c.seenOrder = c.seenOrder[1:]
+	}
+	return true
+}
+
+func (c *WeComChannel) handleMessage(ctx context.Context, msg wecomMessage) {
+	senderID := msg.FromUserName
+	if senderID == "" || msg.MsgType == "event" {
+		logger.DebugCF("wecom", "Ignoring callback", map[string]interface{}{
+			"type":  msg.MsgType,
+			"event": msg.Event,
+		})
+		return
+	}
+	if !c.IsAllowed(senderID) {
+		return
+	}
+
+	var content string
+	var media []string
+	switch msg.MsgType {
+	case "text":
+		content = msg.Content
+	case "image", "voice", "file", "video":
+		path, err := c.downloadMedia(ctx, msg)
+		if err != nil {
+			logger.ErrorCF("wecom", "Failed to download media", map[string]interface{}{
+				"type":  msg.MsgType,
+				"error": err.Error(),
+			})
+			content = fmt.Sprintf("[%s: download failed]", msg.MsgType)
+			break
+		}
+		media = append(media, path)
+		if msg.MsgType == "file" {
+			content = describeFile(path)
+		} else {
+			content = fmt.Sprintf("[%s: %s]", msg.MsgType, path)
+		}
+	default:
+		logger.DebugCF("wecom", "Unsupported message type", map[string]interface{}{
+			"type": msg.MsgType,
+		})
+		return
+	}
+
+	chatID, isDM := senderID, "true"
+	if msg.ChatID != "" {
+		chatID, isDM = "chat:"+msg.ChatID, "false"
+	}
+	logger.InfoCF("wecom", "Received message", map[string]interface{}{
+		"sender_id": senderID,
+		"chat_id":   chatID,
+		"type":      msg.MsgType,
+	})
+	c.HandleMessage(senderID, chatID, content, media, map[string]string{
+		"message_id": msg.MsgID,
+		"msg_type":   msg.MsgType,
+		"is_dm":      isDM,
+	})
+}
+
+func (c *WeComChannel) downloadMedia(ctx context.Context, msg wecomMessage) (string, error) {
+	if msg.MediaID == "" {
+		return "", fmt.Errorf("message has no media ID")
+	}
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		token, err := c.accessToken(ctx, attempt > 0)
+		if err != nil {
+			return "", err
+		}
+		u := c.apiURL + "/media/get?" + url.Values{"access_token": {token}, "media_id": {msg.MediaID}}.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return "", err
+		}
+		if resp, err = c.client.Do(req); err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("media download returned %s", resp.Status)
+		}
+		// Errors come back as JSON instead of the file
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+			break
+		}
+		var werr wecomResponse
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&werr)
+		resp.Body.Close()
+		if attempt == 0 && wecomTokenError(werr.ErrCode) {
+			continue
+		}
+		return "", fmt.Errorf("media download failed: %d %s", werr.ErrCode, werr.ErrMsg)
+	}
+	defer resp.Body.Close()
+
+	name := msg.FileName
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && name == "" {
+		name = params["filename"]
+	}
+	name = filepath.Base(name)
+	if name == "." || name == "/" || name == "" {
+		name = msg.MsgType + wecomMediaExt(msg)
+	}
+
+	mediaDir := utils.MediaDir()
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		return "", err
+	}
+	id := msg.MsgID
+	if id == "" {
+		id = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	localPath := filepath.Join(mediaDir, "wecom_"+filepath.Base(id)+"_"+name)
+	if err := saveDownload(localPath, resp.Body, wecomMaxMediaBytes); err != nil {
+		return "", err
+	}
+	return localPath, nil
+}
+
+func wecomMediaExt(msg wecomMessage) string {
+	switch msg.MsgType {
+	case "image":
+		return ".jpg"
+	case "voice":
+		if msg.Format != "" {
+			return "." + strings.ToLower(msg.Format)
+		}
+		return ".amr"
+	case "video":
+		return ".mp4"
+	}
+	return ""
+}
+
+// Send delivers a reply to a user, or to a group chat when the chat ID is
+// "chat:<chatid>".
+func (c *WeComChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("wecom channel not running")
+	}
+	if msg.ChatID == "" {
+		return fmt.Errorf("chat ID is empty")
+	}
+
+	msgType, limit := "text", wecomMaxMessageLength
+	if c.config.Markdown {
+		msgType, limit = "markdown", wecomMaxMarkdownLength
+	}
+	// Application messages cannot carry a text file, so an answer too long
+	// for maxOutboundParts is still sent in parts
+	parts := splitOutbound(msg.Content, limit)
+	if parts == nil {
+		parts = numberParts(splitMarkdown(msg.Content, limit-partLabelReserve))
+	}
+	for _, part := range parts {
+		if err := c.sendPart(ctx, msg.ChatID, msgType, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendPart sends one message of at most the WeCom length limit.
+func (c *WeComChannel) sendPart(ctx context.Context, chat, msgType, content string) error {
+	body := map[string]interface{}{
+		"msgtype": msgType,
+		msgType:   map[string]string{"content": content},
+	}
+	path := "/message/send"
+	if chatID, ok := strings.CutPrefix(chat, "chat:"); ok {
+		path = "/appchat/send"
+		body["chatid"] = chatID
+	} else {
+		body["touser"] = chat
+		body["agentid"] = c.config.AgentID
+	}
+
+	var resp struct {
+		wecomResponse
+		InvalidUser string `json:"invaliduser"`
+	}
+	if err := c.call(ctx, path, body, &resp); err != nil {
+		return fmt.Errorf("failed to send wecom message: %w", err)
+	}
+	if resp.InvalidUser != "" {
+		return fmt.Errorf("failed to send wecom message: invalid user %s", resp.InvalidUser)
+	}
+	return nil
+}
+
+// accessToken returns the cached access token, fetching a new one when it
+// is about to expire or refresh is set.
+func (c *WeComChannel) accessToken(ctx context.Context, refresh bool) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if !refresh && c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	u := c.apiURL + "/gettoken?" + url.Values{"corpid": {c.config.CorpID}, "corpsecret": {c.config.Secret}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		wecomResponse
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+	if result.ErrCode != 0 || result.AccessToken == "" {
+		return "", fmt.Errorf("failed to get access token: %d %s", result.ErrCode, result.ErrMsg)
+	}
+
+	c.token = result.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - wecomTokenMargin)
+	return c.token, nil
+}
+
+// call POSTs to the API, fetching a new token and retrying once when the
+// cached one was rejected.
+func (c *WeComChannel) call(ctx context.Context, path string, body interface{}, out interface{ errorCode() (int, string) }) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		token, err := c.accessToken(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			c.apiURL+path+"?access_token="+url.QueryEscape(token), bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		err = json.NewDecoder(resp.Body).Decode(out)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("POST %s: %s: %w", path, resp.Status, err)
+		}
+		code, errMsg := out.errorCode()
+		if code == 0 {
+			return nil
+		}
+		if attempt == 0 && wecomTokenError(code) {
+			continue
+		}
+		return fmt.Errorf("POST %s: %d %s", path, code, errMsg)
+	}
+}
+
+func (r *wecomResponse) errorCode() (int, string) {
+	return r.ErrCode, r.ErrMsg
+}
+
+func wecomTokenError(code int) bool {
+	return code == wecomErrTokenInvalid || code == wecomErrTokenMissing || code == wecomErrTokenExpired
+}
@@ -0,0 +1,111 @@
+package channels
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// wecomBlockSize is the PKCS#7 block size of the WeCom scheme, which pads
+// to 32 bytes rather than the AES block size.
+const wecomBlockSize = 32
+
+// wecomCrypto implements the WeCom callback encryption scheme. A message
+// is signed with SHA1 over the sorted token, timestamp, nonce and
+// ciphertext, and encrypted with AES-256-CBC keyed by the EncodingAESKey
+// (IV is the key's first 16 bytes) over
+// random(16) | length(4, big endian) | message | receiver ID.
+type wecomCrypto struct {
+	token      string
+	key        []byte
+	receiverID string
+}
+
+func newWecomCrypto(token, encodingAESKey, receiverID string) (*wecomCrypto, error) {
+	if len(encodingAESKey) != 43 {
+		return nil, fmt.Errorf("encoding_aes_key must be 43 characters")
+	}
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoding_aes_key: %w", err)
+	}
+	return &wecomCrypto{token: token, key: key, receiverID: receiverID}, nil
+}
+
+// signature returns the msg_signature of a ciphertext.
+func (w *wecomCrypto) signature(timestamp, nonce, encrypted string) string {
+	parts := []string{w.token, timestamp, nonce, encrypted}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (w *wecomCrypto) verify(signature, timestamp, nonce, encrypted string) bool {
+	want := w.signature(timestamp, nonce, encrypted)
+	return subtle.ConstantTimeCompare([]byte(want), []byte(signature)) == 1
+}
+
+func (w *wecomCrypto) decrypt(encrypted string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("ciphertext is not a whole number of blocks")
+	}
+	block, err := aes.NewCipher(w.key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, w.key[:aes.BlockSize]).CryptBlocks(plain, data)
+
+	pad := int(plain[len(plain)-1])
+	if pad < 1 || pad > wecomBlockSize || pad > len(plain) {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	plain = plain[:len(plain)-pad]
+	if len(plain) < 20 {
+		return nil, fmt.Errorf("decrypted message is too short")
+	}
+	n := int(binary.BigEndian.Uint32(plain[16:20]))
+	if n > len(plain)-20 {
+		return nil, fmt.Errorf("invalid message length")
+	}
+	msg, receiverID := plain[20:20+n], string(plain[20+n:])
+	if receiverID != w.receiverID {
+		return nil, fmt.Errorf("message is for %q, not %q", receiverID, w.receiverID)
+	}
+	return msg, nil
+}
+
+func (w *wecomCrypto) encrypt(msg []byte) (string, error) {
+	var buf bytes.Buffer
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	buf.Write(random)
+	binary.Write(&buf, binary.BigEndian, uint32(len(msg)))
+	buf.Write(msg)
+	buf.WriteString(w.receiverID)
+	pad := wecomBlockSize - buf.Len()%wecomBlockSize
+	buf.Write(bytes.Repeat([]byte{byte(pad)}, pad))
+
+	block, err := aes.NewCipher(w.key)
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, buf.Len())
+	cipher.NewCBCEncrypter(block, w.key[:aes.BlockSize]).CryptBlocks(out, buf.Bytes())
+	return base64.StdEncoding.EncodeToString(out), nil
+}
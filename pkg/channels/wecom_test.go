@@ -0,0 +1,225 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+const testWecomKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+
+func TestWecomCrypto(t *testing.T) {
+	w, err := newWecomCrypto("token", testWecomKey, "corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"", "hello", strings.Repeat("企业微信", 100)} {
+		enc, err := w.encrypt([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := w.decrypt(enc)
+		if err != nil || string(got) != msg {
+			t.Errorf("decrypt(encrypt(%q)) = %q, %v", msg, got, err)
+		}
+	}
+
+	enc, _ := w.encrypt([]byte("hello"))
+	other, _ := newWecomCrypto("token", testWecomKey, "other-corp")
+	if _, err := other.decrypt(enc); err == nil {
+		t.Error("decrypted a message for another receiver")
+	}
+	if _, err := w.decrypt(enc[:len(enc)-8]); err == nil {
+		t.Error("decrypted a truncated message")
+	}
+
+	sig := w.signature("1700000000", "nonce", enc)
+	if !w.verify(sig, "1700000000", "nonce", enc) || w.verify(sig, "1700000001", "nonce", enc) {
+		t.Error("signature verification is wrong")
+	}
+
+	if _, err := newWecomCrypto("token", "short", "corp"); err == nil {
+		t.Error("accepted a short key")
+	}
+}
+
+// fakeWecomAPI serves gettoken, media/get and message/send. The first
+// token it issues is rejected as expired.
+type fakeWecomAPI struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	tokens int
+	sent   []map[string]interface{}
+}
+
+func newFakeWecomAPI(t *testing.T) *fakeWecomAPI {
+	f := &fakeWecomAPI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /gettoken", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.tokens++
+		n := f.tokens
+		f.mu.Unlock()
+		fmt.Fprintf(w, `{"errcode":0,"access_token":"t%d","expires_in":7200}`, n)
+	})
+	mux.HandleFunc("GET /media/get", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		if r.URL.Query().Get("media_id") == "big" {
+			w.Write(make([]byte, wecomMaxMediaBytes+1))
+			return
+		}
+		w.Write([]byte("JPEGDATA"))
+	})
+	mux.HandleFunc("POST /message/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") == "t1" {
+			w.Write([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`))
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		f.sent = append(f.sent, body)
+		f.mu.Unlock()
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok","invaliduser":""}`))
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func TestWeComChannel(t *testing.T) {
+	api := newFakeWecomAPI(t)
+	msgBus := bus.NewMessageBus()
+	c, err := NewWeComChannel(config.WeComConfig{
+		CorpID:         "corp",
+		AgentID:        1000002,
+		Secret:         "secret",
+		Token:          "token",
+		EncodingAESKey: testWecomKey,
+		Markdown:       true,
+		AllowFrom:      []string{"zhangsan"},
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.apiURL = api.server.URL
+	c.setRunning(true)
+	handler := c.Handler()
+
+	// URL verification echoes the decrypted echostr
+	echo, _ := c.crypto.encrypt([]byte("echo-123"))
+	q := url.Values{"msg_signature": {c.crypto.signature("1", "n", echo)}, "timestamp": {"1"}, "nonce": {"n"}, "echostr": {echo}}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/wecom?"+q.Encode(), nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "echo-123" {
+		t.Errorf("verify = %d %q", rec.Code, rec.Body.String())
+	}
+
+	post := func(plain string, tamper bool) int {
+		enc, _ := c.crypto.encrypt([]byte(plain))
+		sig := c.crypto.signature("1", "n", enc)
+		if tamper {
+			sig = strings.Repeat("0", len(sig))
+		}
+		q := url.Values{"msg_signature": {sig}, "timestamp": {"1"}, "nonce": {"n"}}
+		body := fmt.Sprintf("<xml><ToUserName><![CDATA[corp]]></ToUserName><Encrypt><![CDATA[%s]]></Encrypt></xml>", enc)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/wecom?"+q.Encode(), strings.NewReader(body)))
+		return rec.Code
+	}
+	message := func(from, msgType, id, extra string) string {
+		return fmt.Sprintf("<xml><ToUserName>corp</ToUserName><FromUserName>%s</FromUserName><CreateTime>1700000000</CreateTime>"+
+			"<MsgType>%s</MsgType><MsgId>%s</MsgId><AgentID>1000002</AgentID>%s</xml>", from, msgType, id, extra)
+	}
+
+	if code := post(message("zhangsan", "text", "1", "<Content>forged</Content>"), true); code != http.StatusForbidden {
+		t.Errorf("tampered callback = %d", code)
+	}
+	for _, m := range []string{
+		message("lisi", "text", "2", "<Content>not allowed</Content>"),
+		message("zhangsan", "text", "3", "<Content><![CDATA[你好]]></Content>"),
+		message("zhangsan", "text", "3", "<Content><![CDATA[你好]]></Content>"),
+	} {
+		if code := post(m, false); code != http.StatusOK {
+			t.Fatalf("callback = %d", code)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no message for the text callback")
+	}
+	if msg.SenderID != "zhangsan" || msg.ChatID != "zhangsan" || msg.Content != "你好" || msg.Metadata["is_dm"] != "true" {
+		t.Errorf("text message = %+v", msg)
+	}
+
+	post(message("zhangsan", "image", "4", "<MediaId>m1</MediaId><PicUrl>http://example.org/p.jpg</PicUrl>"), false)
+	msg, ok = msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no message for the image callback (was the duplicate delivered?)")
+	}
+	if len(msg.Media) != 1 || !strings.HasPrefix(msg.Content, "[image: ") {
+		t.Fatalf("image message = %+v", msg)
+	}
+	defer os.Remove(msg.Media[0])
+	if data, _ := os.ReadFile(msg.Media[0]); string(data) != "JPEGDATA" {
+		t.Errorf("downloaded %q", data)
+	}
+
+	// Media over the limit is refused rather than saved cut short
+	post(message("zhangsan", "image", "5", "<MediaId>big</MediaId>"), false)
+	msg, ok = msgBus.ConsumeInbound(ctx)
+	if !ok || len(msg.Media) != 0 || msg.Content != "[image: download failed]" {
+		t.Fatalf("oversized image message = %+v", msg)
+	}
+	if _, err := os.Stat(filepath.Join(utils.MediaDir(), "wecom_5_image.jpg")); !os.IsNotExist(err) {
+		t.Errorf("oversized image was kept: %v", err)
+	}
+
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "wecom", ChatID: "zhangsan", Content: "**收到**"}); err != nil {
+		t.Fatal(err)
+	}
+	// WeCom counts bytes, so long Chinese answers are split well below
+	// the character limits of other platforms
+	long := strings.Repeat("龙虾很好吃。\n\n", 500)
+	if err := c.Send(ctx, bus.OutboundMessage{Channel: "wecom", ChatID: "zhangsan", Content: long}); err != nil {
+		t.Fatal(err)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.sent) < 3 {
+		t.Fatalf("sent %d messages", len(api.sent))
+	}
+	for _, part := range api.sent[1:] {
+		md, _ := part["markdown"].(map[string]interface{})
+		if content, _ := md["content"].(string); len(content) > 4096 {
+			t.Errorf("part of %d bytes", len(content))
+		}
+	}
+	sent := api.sent[0]
+	if sent["touser"] != "zhangsan" || sent["msgtype"] != "markdown" || sent["agentid"] != float64(1000002) {
+		t.Errorf("sent %v", sent)
+	}
+	if md, _ := sent["markdown"].(map[string]interface{}); md["content"] != "**收到**" {
+		t.Errorf("markdown = %v", sent["markdown"])
+	}
+	if api.tokens != 2 {
+		t.Errorf("fetched %d tokens, want a refresh after the expired one", api.tokens)
+	}
+}
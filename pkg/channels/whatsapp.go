@@ -12,6 +12,7 @@ import (
 
 	"github.com/weiwei929/mypicoclaw/pkg/bus"
 	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/documents"
 	"github.com/weiwei929/mypicoclaw/pkg/utils"
 )
 
@@ -166,6 +167,12 @@ func (c *WhatsAppChannel) handleIncomingMessage(msg map[string]interface{}) {
 		for _, m := range mediaData {
 			if path, ok := m.(string); ok {
 				mediaPaths = append(mediaPaths, path)
+				if documents.Detect(path) != "" {
+					if content != "" {
+						content += "\n"
+					}
+					content += describeFile(path)
+				}
 			}
 		}
 	}
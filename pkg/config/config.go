@@ -15,10 +15,81 @@ type Config struct {
 	Providers  ProvidersConfig  `json:"providers"`
 	Gateway    GatewayConfig    `json:"gateway"`
 	Tools      ToolsConfig      `json:"tools"`
+	KB         KBConfig         `json:"kb"`
+	Memory     MemoryConfig     `json:"memory"`
+	Identity   IdentityConfig   `json:"identity"`
+	Access     AccessConfig     `json:"access"`
 	StorageVPS StorageVPSConfig `json:"storage_vps"`
 	mu         sync.RWMutex
 }
 
+// KBConfig configures the local knowledge base searched with kb_search.
+// Dir defaults to kb inside the workspace.
+type KBConfig struct {
+	Enabled      bool            `json:"enabled" env:"MYPICOCLAW_KB_ENABLED"`
+	Dir          string          `json:"dir" env:"MYPICOCLAW_KB_DIR"`
+	ChunkSize    int             `json:"chunk_size" env:"MYPICOCLAW_KB_CHUNK_SIZE"`
+	ChunkOverlap int             `json:"chunk_overlap" env:"MYPICOCLAW_KB_CHUNK_OVERLAP"`
+	TopK         int             `json:"top_k" env:"MYPICOCLAW_KB_TOP_K"`
+	MaxFileBytes int64           `json:"max_file_bytes" env:"MYPICOCLAW_KB_MAX_FILE_BYTES"`
+	Embedding    EmbeddingConfig `json:"embedding"`
+}
+
+// EmbeddingConfig points at an OpenAI-compatible /embeddings endpoint.
+// An empty APIBase or APIKey falls back to providers.openai.
+type EmbeddingConfig struct {
+	APIBase        string `json:"api_base" env:"MYPICOCLAW_KB_EMBEDDING_API_BASE"`
+	APIKey         string `json:"api_key" env:"MYPICOCLAW_KB_EMBEDDING_API_KEY"`
+	Model          string `json:"model" env:"MYPICOCLAW_KB_EMBEDDING_MODEL"`
+	Dimensions     int    `json:"dimensions" env:"MYPICOCLAW_KB_EMBEDDING_DIMENSIONS"`
+	BatchSize      int    `json:"batch_size" env:"MYPICOCLAW_KB_EMBEDDING_BATCH_SIZE"`
+	TimeoutSeconds int    `json:"timeout_seconds" env:"MYPICOCLAW_KB_EMBEDDING_TIMEOUT_SECONDS"`
+}
+
+// MemoryConfig configures the structured long-term memory. ContextTokens
+// bounds how much memory is put into each system prompt. Scope is "user"
+// (facts per sender plus a shared layer), "chat" (per sender and chat) or
+// "global" (everyone shares one memory).
+type MemoryConfig struct {
+	Scope         string `json:"scope" env:"MYPICOCLAW_MEMORY_SCOPE"`
+	AutoExtract   bool   `json:"auto_extract" env:"MYPICOCLAW_MEMORY_AUTO_EXTRACT"`
+	ExtractModel  string `json:"extract_model" env:"MYPICOCLAW_MEMORY_EXTRACT_MODEL"`
+	ContextTokens int    `json:"context_tokens" env:"MYPICOCLAW_MEMORY_CONTEXT_TOKENS"`
+	MaxFacts      int    `json:"max_facts" env:"MYPICOCLAW_MEMORY_MAX_FACTS"`
+}
+
+// IdentityConfig configures linking one person's accounts across channels
+// with /link. With SharedSession, direct messages from linked accounts
+// continue one conversation whichever channel they arrive on.
+type IdentityConfig struct {
+	Enabled           bool `json:"enabled" env:"MYPICOCLAW_IDENTITY_ENABLED"`
+	SharedSession     bool `json:"shared_session" env:"MYPICOCLAW_IDENTITY_SHARED_SESSION"`
+	PairingTTLMinutes int  `json:"pairing_ttl_minutes" env:"MYPICOCLAW_IDENTITY_PAIRING_TTL_MINUTES"`
+}
+
+// AccessConfig assigns roles to users. Users maps an account such as
+// "telegram:123" or an identity ID to a role name; everyone else gets
+// DefaultRole, or GroupRole in group chats. The CLI and cron jobs run as
+// "owner".
+type AccessConfig struct {
+	Enabled     bool                  `json:"enabled" env:"MYPICOCLAW_ACCESS_ENABLED"`
+	DefaultRole string                `json:"default_role" env:"MYPICOCLAW_ACCESS_DEFAULT_ROLE"`
+	GroupRole   string                `json:"group_role" env:"MYPICOCLAW_ACCESS_GROUP_ROLE"`
+	Users       map[string]string     `json:"users"`
+	Roles       map[string]RoleConfig `json:"roles"`
+}
+
+// RoleConfig is what a role may do. Tools and DenyTools are tool name
+// patterns such as "*" or "process_*"; a tool must match Tools and not
+// DenyTools. An empty Model uses the default model, and a zero
+// RateLimitPerHour means no limit.
+type RoleConfig struct {
+	Tools            []string `json:"tools"`
+	DenyTools        []string `json:"deny_tools"`
+	Model            string   `json:"model"`
+	RateLimitPerHour int      `json:"rate_limit_per_hour"`
+}
+
 type StorageVPSConfig struct {
 	Host string `json:"host" env:"MYPICOCLAW_STORAGE_VPS_HOST"`
 	User string `json:"user" env:"MYPICOCLAW_STORAGE_VPS_USER"`
@@ -47,6 +118,13 @@ type ChannelsConfig struct {
 	MaixCam  MaixCamConfig  `json:"maixcam"`
 	QQ       QQConfig       `json:"qq"`
 	DingTalk DingTalkConfig `json:"dingtalk"`
+	Webhook  WebhookConfig  `json:"webhook"`
+	HTTP     HTTPConfig     `json:"http"`
+	Slack    SlackConfig    `json:"slack"`
+	Matrix   MatrixConfig   `json:"matrix"`
+	Email    EmailConfig    `json:"email"`
+	WeCom    WeComConfig    `json:"wecom"`
+	Web      WebConfig      `json:"web"`
 }
 
 type WhatsAppConfig struct {
@@ -97,6 +175,125 @@ type DingTalkConfig struct {
 	AllowFrom        []string `json:"allow_from" env:"MYPICOCLAW_CHANNELS_DINGTALK_ALLOW_FROM"`
 }
 
+// SlackConfig connects over Socket Mode. BotToken is the xoxb- token used
+// for the Web API and AppToken the xapp- token with connections:write.
+type SlackConfig struct {
+	Enabled   bool     `json:"enabled" env:"MYPICOCLAW_CHANNELS_SLACK_ENABLED"`
+	BotToken  string   `json:"bot_token" env:"MYPICOCLAW_CHANNELS_SLACK_BOT_TOKEN"`
+	AppToken  string   `json:"app_token" env:"MYPICOCLAW_CHANNELS_SLACK_APP_TOKEN"`
+	AllowFrom []string `json:"allow_from" env:"MYPICOCLAW_CHANNELS_SLACK_ALLOW_FROM"`
+}
+
+// MatrixConfig logs in to a homeserver with an access token. AllowRooms
+// limits the rooms the bot answers in; with MentionOnly it answers in group
+// rooms only when mentioned. AutoJoin accepts invites from allowed users to
+// allowed rooms.
+type MatrixConfig struct {
+	Enabled     bool     `json:"enabled" env:"MYPICOCLAW_CHANNELS_MATRIX_ENABLED"`
+	Homeserver  string   `json:"homeserver" env:"MYPICOCLAW_CHANNELS_MATRIX_HOMESERVER"`
+	UserID      string   `json:"user_id" env:"MYPICOCLAW_CHANNELS_MATRIX_USER_ID"`
+	AccessToken string   `json:"access_token" env:"MYPICOCLAW_CHANNELS_MATRIX_ACCESS_TOKEN"`
+	AllowFrom   []string `json:"allow_from" env:"MYPICOCLAW_CHANNELS_MATRIX_ALLOW_FROM"`
+	AllowRooms  []string `json:"allow_rooms" env:"MYPICOCLAW_CHANNELS_MATRIX_ALLOW_ROOMS"`
+	MentionOnly bool     `json:"mention_only" env:"MYPICOCLAW_CHANNELS_MATRIX_MENTION_ONLY"`
+	AutoJoin    bool     `json:"auto_join" env:"MYPICOCLAW_CHANNELS_MATRIX_AUTO_JOIN"`
+}
+
+// EmailConfig polls an IMAP mailbox and replies over SMTP. Address is the
+// From address of replies and defaults to Username. With UseTLS, IMAP uses
+// implicit TLS and SMTP uses implicit TLS on port 465 or STARTTLS
+// otherwise. AllowFrom holds sender addresses.
+type EmailConfig struct {
+	Enabled     bool     `json:"enabled" env:"MYPICOCLAW_CHANNELS_EMAIL_ENABLED"`
+	IMAPHost    string   `json:"imap_host" env:"MYPICOCLAW_CHANNELS_EMAIL_IMAP_HOST"`
+	IMAPPort    int      `json:"imap_port" env:"MYPICOCLAW_CHANNELS_EMAIL_IMAP_PORT"`
+	SMTPHost    string   `json:"smtp_host" env:"MYPICOCLAW_CHANNELS_EMAIL_SMTP_HOST"`
+	SMTPPort    int      `json:"smtp_port" env:"MYPICOCLAW_CHANNELS_EMAIL_SMTP_PORT"`
+	Username    string   `json:"username" env:"MYPICOCLAW_CHANNELS_EMAIL_USERNAME"`
+	Password    string   `json:"password" env:"MYPICOCLAW_CHANNELS_EMAIL_PASSWORD"`
+	Address     string   `json:"address" env:"MYPICOCLAW_CHANNELS_EMAIL_ADDRESS"`
+	Mailbox     string   `json:"mailbox" env:"MYPICOCLAW_CHANNELS_EMAIL_MAILBOX"`
+	PollSeconds int      `json:"poll_seconds" env:"MYPICOCLAW_CHANNELS_EMAIL_POLL_SECONDS"`
+	UseTLS      bool     `json:"use_tls" env:"MYPICOCLAW_CHANNELS_EMAIL_USE_TLS"`
+	AllowFrom   []string `json:"allow_from" env:"MYPICOCLAW_CHANNELS_EMAIL_ALLOW_FROM"`
+}
+
+// WeComConfig is a WeCom self-built app. Token and EncodingAESKey are the
+// callback settings of the app; the callback URL is Path on Host:Port.
+// With Markdown, replies are sent as WeCom Markdown, otherwise as text.
+type WeComConfig struct {
+	Enabled        bool     `json:"enabled" env:"MYPICOCLAW_CHANNELS_WECOM_ENABLED"`
+	CorpID         string   `json:"corp_id" env:"MYPICOCLAW_CHANNELS_WECOM_CORP_ID"`
+	AgentID        int64    `json:"agent_id" env:"MYPICOCLAW_CHANNELS_WECOM_AGENT_ID"`
+	Secret         string   `json:"secret" env:"MYPICOCLAW_CHANNELS_WECOM_SECRET"`
+	Token          string   `json:"token" env:"MYPICOCLAW_CHANNELS_WECOM_TOKEN"`
+	EncodingAESKey string   `json:"encoding_aes_key" env:"MYPICOCLAW_CHANNELS_WECOM_ENCODING_AES_KEY"`
+	Host           string   `json:"host" env:"MYPICOCLAW_CHANNELS_WECOM_HOST"`
+	Port           int      `json:"port" env:"MYPICOCLAW_CHANNELS_WECOM_PORT"`
+	Path           string   `json:"path" env:"MYPICOCLAW_CHANNELS_WECOM_PATH"`
+	Markdown       bool     `json:"markdown" env:"MYPICOCLAW_CHANNELS_WECOM_MARKDOWN"`
+	AllowFrom      []string `json:"allow_from" env:"MYPICOCLAW_CHANNELS_WECOM_ALLOW_FROM"`
+}
+
+// WebConfig is the browser chat UI served by the gateway under /web/. Users
+// maps login names to their own tokens, and the token decides who logs in.
+// Without Users, Token is a single shared login named "web"; it defaults to
+// the gateway token. AllowFrom holds login names.
+type WebConfig struct {
+	Enabled   bool              `json:"enabled" env:"MYPICOCLAW_CHANNELS_WEB_ENABLED"`
+	Token     string            `json:"token" env:"MYPICOCLAW_CHANNELS_WEB_TOKEN"`
+	Users     map[string]string `json:"users"`
+	AllowFrom []string          `json:"allow_from" env:"MYPICOCLAW_CHANNELS_WEB_ALLOW_FROM"`
+}
+
+// WebhookConfig serves POST /webhook/<route> for systems that push events
+// to the agent. Routes are keyed by name.
+type WebhookConfig struct {
+	Enabled bool                          `json:"enabled" env:"MYPICOCLAW_CHANNELS_WEBHOOK_ENABLED"`
+	Host    string                        `json:"host" env:"MYPICOCLAW_CHANNELS_WEBHOOK_HOST"`
+	Port    int                           `json:"port" env:"MYPICOCLAW_CHANNELS_WEBHOOK_PORT"`
+	Routes  map[string]WebhookRouteConfig `json:"routes"`
+}
+
+// WebhookRouteConfig is one webhook route. Requests must be signed with an
+// HMAC-SHA256 of the body keyed with Secret, sent hex-encoded in
+// SignatureHeader with or without a "sha256=" prefix. Template is a Go
+// template applied to the decoded JSON payload to build the message; it
+// defaults to the route name and the indented payload. SessionKey defaults
+// to "webhook:<route>". When DeliverChannel and DeliverChatID are set, the
+// agent's reply is forwarded there.
+type WebhookRouteConfig struct {
+	Secret          string `json:"secret"`
+	SignatureHeader string `json:"signature_header"`
+	Template        string `json:"template"`
+	SessionKey      string `json:"session_key"`
+	DeliverChannel  string `json:"deliver_channel"`
+	DeliverChatID   string `json:"deliver_chat_id"`
+}
+
+// HTTPConfig is the outbound-only channel that POSTs messages to HTTP
+// endpoints such as ntfy, Bark or Gotify. Targets are keyed by name and
+// addressed as channel "http" with the name as chat ID, or "http:<name>".
+type HTTPConfig struct {
+	Enabled bool                        `json:"enabled" env:"MYPICOCLAW_CHANNELS_HTTP_ENABLED"`
+	Targets map[string]HTTPTargetConfig `json:"targets"`
+}
+
+// HTTPTargetConfig is one HTTP target. Template is a Go template for the
+// request body, given .Content, .Target and .Time; without one the body is
+// the message as JSON. Failed requests are retried MaxRetries times with
+// exponential backoff; a zero RateLimitPerMinute means no limit.
+type HTTPTargetConfig struct {
+	URL                string            `json:"url"`
+	Method             string            `json:"method"`
+	Headers            map[string]string `json:"headers"`
+	ContentType        string            `json:"content_type"`
+	Template           string            `json:"template"`
+	MaxRetries         int               `json:"max_retries"`
+	RateLimitPerMinute int               `json:"rate_limit_per_minute"`
+	TimeoutSeconds     int               `json:"timeout_seconds"`
+}
+
 type ProvidersConfig struct {
 	Anthropic  ProviderConfig `json:"anthropic"`
 	OpenAI     ProviderConfig `json:"openai"`
@@ -113,22 +310,127 @@ type ProviderConfig struct {
 	APIBase string `json:"api_base" env:"MYPICOCLAW_PROVIDERS_{{.Name}}_API_BASE"`
 }
 
+// GatewayConfig is where the gateway serves its HTTP API. The API is only
+// started when Token is set; requests must send it as a bearer token.
 type GatewayConfig struct {
-	Host string `json:"host" env:"MYPICOCLAW_GATEWAY_HOST"`
-	Port int    `json:"port" env:"MYPICOCLAW_GATEWAY_PORT"`
+	Host  string `json:"host" env:"MYPICOCLAW_GATEWAY_HOST"`
+	Port  int    `json:"port" env:"MYPICOCLAW_GATEWAY_PORT"`
+	Token string `json:"token" env:"MYPICOCLAW_GATEWAY_TOKEN"`
 }
 
+// WebSearchConfig selects the web_search backends. Backends are tried in
+// order until one returns results; names are "brave", "searxng", or the name
+// of an entry in Custom. APIKey is the Brave API key.
 type WebSearchConfig struct {
-	APIKey     string `json:"api_key" env:"MYPICOCLAW_TOOLS_WEB_SEARCH_API_KEY"`
-	MaxResults int    `json:"max_results" env:"MYPICOCLAW_TOOLS_WEB_SEARCH_MAX_RESULTS"`
+	APIKey          string                    `json:"api_key" env:"MYPICOCLAW_TOOLS_WEB_SEARCH_API_KEY"`
+	MaxResults      int                       `json:"max_results" env:"MYPICOCLAW_TOOLS_WEB_SEARCH_MAX_RESULTS"`
+	Backends        []string                  `json:"backends"`
+	TimeoutSeconds  int                       `json:"timeout_seconds" env:"MYPICOCLAW_TOOLS_WEB_SEARCH_TIMEOUT_SECONDS"`
+	CacheTTLSeconds int                       `json:"cache_ttl_seconds" env:"MYPICOCLAW_TOOLS_WEB_SEARCH_CACHE_TTL_SECONDS"`
+	SearXNG         SearXNGConfig             `json:"searxng"`
+	Custom          []JSONSearchBackendConfig `json:"custom"`
+}
+
+// SearXNGConfig points at a SearXNG instance with the JSON format enabled.
+type SearXNGConfig struct {
+	BaseURL    string `json:"base_url" env:"MYPICOCLAW_TOOLS_WEB_SEARCH_SEARXNG_BASE_URL"`
+	Language   string `json:"language"`
+	Categories string `json:"categories"`
+}
+
+// JSONSearchBackendConfig describes a search API that returns JSON, such as
+// Tavily, Serper or Bing. URL, BodyTemplate and Headers may contain {query},
+// {count} and {api_key}. ResultsPath and the field names are dot-separated
+// paths into the response.
+type JSONSearchBackendConfig struct {
+	Name         string            `json:"name"`
+	Method       string            `json:"method"`
+	URL          string            `json:"url"`
+	APIKey       string            `json:"api_key"`
+	Headers      map[string]string `json:"headers"`
+	BodyTemplate string            `json:"body_template"`
+	ResultsPath  string            `json:"results_path"`
+	TitleField   string            `json:"title_field"`
+	URLField     string            `json:"url_field"`
+	SnippetField string            `json:"snippet_field"`
+	DateField    string            `json:"date_field"`
+}
+
+// WebFetchConfig is the fetch policy for web_fetch. Domain entries match the
+// domain itself and all of its subdomains.
+type WebFetchConfig struct {
+	MaxChars             int      `json:"max_chars" env:"MYPICOCLAW_TOOLS_WEB_FETCH_MAX_CHARS"`
+	MaxResponseBytes     int64    `json:"max_response_bytes" env:"MYPICOCLAW_TOOLS_WEB_FETCH_MAX_RESPONSE_BYTES"`
+	TimeoutSeconds       int      `json:"timeout_seconds" env:"MYPICOCLAW_TOOLS_WEB_FETCH_TIMEOUT_SECONDS"`
+	MaxRedirects         int      `json:"max_redirects" env:"MYPICOCLAW_TOOLS_WEB_FETCH_MAX_REDIRECTS"`
+	AllowPrivateNetworks bool     `json:"allow_private_networks" env:"MYPICOCLAW_TOOLS_WEB_FETCH_ALLOW_PRIVATE_NETWORKS"`
+	AllowedDomains       []string `json:"allowed_domains"`
+	DeniedDomains        []string `json:"denied_domains"`
+	AllowedContentTypes  []string `json:"allowed_content_types"`
+}
+
+// WebCacheConfig controls the on-disk cache shared by web_search and
+// web_fetch. Dir defaults to cache/web inside the workspace.
+type WebCacheConfig struct {
+	Enabled    bool   `json:"enabled" env:"MYPICOCLAW_TOOLS_WEB_CACHE_ENABLED"`
+	Dir        string `json:"dir" env:"MYPICOCLAW_TOOLS_WEB_CACHE_DIR"`
+	MaxSizeMB  int    `json:"max_size_mb" env:"MYPICOCLAW_TOOLS_WEB_CACHE_MAX_SIZE_MB"`
+	TTLSeconds int    `json:"ttl_seconds" env:"MYPICOCLAW_TOOLS_WEB_CACHE_TTL_SECONDS"`
 }
 
 type WebToolsConfig struct {
 	Search WebSearchConfig `json:"search"`
+	Fetch  WebFetchConfig  `json:"fetch"`
+	Cache  WebCacheConfig  `json:"cache"`
+}
+
+// ExecConfig controls the exec tool's safety policy. Patterns are Go regular
+// expressions matched against the lower-cased command line.
+type ExecConfig struct {
+	DenyPatterns        []string          `json:"deny_patterns"`
+	WarnPatterns        []string          `json:"warn_patterns"`
+	AllowPatterns       []string          `json:"allow_patterns"`
+	AllowlistOnly       bool              `json:"allowlist_only" env:"MYPICOCLAW_TOOLS_EXEC_ALLOWLIST_ONLY"`
+	TimeoutSeconds      int               `json:"timeout_seconds" env:"MYPICOCLAW_TOOLS_EXEC_TIMEOUT_SECONDS"`
+	CommandTimeouts     []ExecTimeoutRule `json:"command_timeouts"`
+	MaxOutputChars      int               `json:"max_output_chars" env:"MYPICOCLAW_TOOLS_EXEC_MAX_OUTPUT_CHARS"`
+	RestrictToWorkspace bool              `json:"restrict_to_workspace" env:"MYPICOCLAW_TOOLS_EXEC_RESTRICT_TO_WORKSPACE"`
+	ScrubEnv            bool              `json:"scrub_env" env:"MYPICOCLAW_TOOLS_EXEC_SCRUB_ENV"`
+	ScrubEnvPatterns    []string          `json:"scrub_env_patterns"`
+}
+
+// ExecTimeoutRule overrides the default timeout for commands matching Pattern.
+type ExecTimeoutRule struct {
+	Pattern        string `json:"pattern"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+}
+
+// ProcessConfig controls background processes started with process_start.
+type ProcessConfig struct {
+	MaxProcesses      int  `json:"max_processes" env:"MYPICOCLAW_TOOLS_PROCESS_MAX_PROCESSES"`
+	OutputBufferBytes int  `json:"output_buffer_bytes" env:"MYPICOCLAW_TOOLS_PROCESS_OUTPUT_BUFFER_BYTES"`
+	NotifyOnExit      bool `json:"notify_on_exit" env:"MYPICOCLAW_TOOLS_PROCESS_NOTIFY_ON_EXIT"`
+}
+
+// FilesystemConfig confines the file tools to the workspace plus extra roots.
+type FilesystemConfig struct {
+	RestrictToWorkspace bool             `json:"restrict_to_workspace" env:"MYPICOCLAW_TOOLS_FILESYSTEM_RESTRICT_TO_WORKSPACE"`
+	AllowedRoots        []PathRootConfig `json:"allowed_roots"`
+	MaxReadBytes        int              `json:"max_read_bytes" env:"MYPICOCLAW_TOOLS_FILESYSTEM_MAX_READ_BYTES"`
+	IgnorePatterns      []string         `json:"ignore_patterns"`
+}
+
+// PathRootConfig is an extra directory the file tools may access.
+type PathRootConfig struct {
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only"`
 }
 
 type ToolsConfig struct {
-	Web WebToolsConfig `json:"web"`
+	Web        WebToolsConfig   `json:"web"`
+	Exec       ExecConfig       `json:"exec"`
+	Process    ProcessConfig    `json:"process"`
+	Filesystem FilesystemConfig `json:"filesystem"`
 }
 
 func DefaultConfig() *Config {
@@ -174,6 +476,55 @@ func DefaultConfig() *Config {
 				Port:      18790,
 				AllowFrom: []string{},
 			},
+			Webhook: WebhookConfig{
+				Enabled: false,
+				Host:    "0.0.0.0",
+				Port:    18791,
+				Routes:  map[string]WebhookRouteConfig{},
+			},
+			Slack: SlackConfig{
+				Enabled:   false,
+				BotToken:  "",
+				AppToken:  "",
+				AllowFrom: []string{},
+			},
+			Matrix: MatrixConfig{
+				Enabled:     false,
+				Homeserver:  "",
+				UserID:      "",
+				AccessToken: "",
+				AllowFrom:   []string{},
+				AllowRooms:  []string{},
+				MentionOnly: true,
+				AutoJoin:    true,
+			},
+			Email: EmailConfig{
+				Enabled:     false,
+				IMAPPort:    993,
+				SMTPPort:    465,
+				Mailbox:     "INBOX",
+				PollSeconds: 60,
+				UseTLS:      true,
+				AllowFrom:   []string{},
+			},
+			WeCom: WeComConfig{
+				Enabled:   false,
+				Host:      "0.0.0.0",
+				Port:      18792,
+				Path:      "/wecom",
+				Markdown:  true,
+				AllowFrom: []string{},
+			},
+			Web: WebConfig{
+				Enabled:   false,
+				Token:     "",
+				Users:     map[string]string{},
+				AllowFrom: []string{},
+			},
+			HTTP: HTTPConfig{
+				Enabled: false,
+				Targets: map[string]HTTPTargetConfig{},
+			},
 			QQ: QQConfig{
 				Enabled:   false,
 				AppID:     "",
@@ -198,14 +549,138 @@ func DefaultConfig() *Config {
 			Moonshot:   ProviderConfig{},
 		},
 		Gateway: GatewayConfig{
-			Host: "0.0.0.0",
-			Port: 18790,
+			Host:  "0.0.0.0",
+			Port:  18790,
+			Token: "",
 		},
 		Tools: ToolsConfig{
 			Web: WebToolsConfig{
 				Search: WebSearchConfig{
-					APIKey:     "",
-					MaxResults: 5,
+					APIKey:          "",
+					MaxResults:      5,
+					Backends:        []string{"brave"},
+					TimeoutSeconds:  10,
+					CacheTTLSeconds: 600,
+					Custom:          []JSONSearchBackendConfig{},
+				},
+				Fetch: WebFetchConfig{
+					MaxChars:             8000,
+					MaxResponseBytes:     5 * 1024 * 1024,
+					TimeoutSeconds:       60,
+					MaxRedirects:         5,
+					AllowPrivateNetworks: false,
+					AllowedDomains:       []string{},
+					DeniedDomains:        []string{},
+					AllowedContentTypes: []string{
+						"text/*",
+						"application/json",
+						"application/xml",
+						"application/xhtml+xml",
+						"application/rss+xml",
+						"application/atom+xml",
+						"application/ld+json",
+					},
+				},
+				Cache: WebCacheConfig{
+					Enabled:    true,
+					Dir:        "",
+					MaxSizeMB:  100,
+					TTLSeconds: 3600,
+				},
+			},
+			Exec: ExecConfig{
+				// 🔴 Blocked: immediately rejected, never executed
+				DenyPatterns: []string{
+					`\brm\s+-[rf]{1,2}\s+/`,                     // rm -rf / (root path)
+					`\bdel\s+/[fq]\b`,                           // Windows del /f
+					`\brmdir\s+/s\b`,                            // Windows rmdir /s
+					`\b(format|mkfs|diskpart)\b\s`,              // Disk wiping
+					`\bdd\s+if=`,                                // Raw disk copy
+					`>\s*/dev/sd[a-z]\b`,                        // Raw disk write
+					`\b(shutdown|reboot|poweroff|halt)\b`,       // System control
+					`\bsystemctl\s+(stop|disable)\s+mypicoclaw`, // Don't let it stop itself
+					`:\(\)\s*\{.*\};\s*:`,                       // Fork bomb
+					`>\s*/etc/(passwd|shadow|sudoers|fstab)`,    // System file overwrite
+					`\biptables\s+-F`,                           // Firewall flush (locked out of VPS)
+					`\bchmod\s+(-R\s+)?777\s+/\s*$`,             // Dangerous permission changes on root
+					`\buserdel\b`,                               // User deletion
+				},
+				// 🟡 Warned: logged as warning but still executed
+				WarnPatterns: []string{
+					`\brm\b`,                                 // Any file deletion
+					`\b(kill|pkill|killall)\b`,               // Process killing
+					`\b(apt|yum|dnf|pacman)\s+install\b`,     // Package installation
+					`\bcurl\b.*\|\s*(ba)?sh`,                 // Pipe-to-shell
+					`\bwget\b.*\|\s*(ba)?sh`,                 // Pipe-to-shell
+					`\bchmod\b`,                              // Permission changes
+					`\bchown\b`,                              // Ownership changes
+					`\bsystemctl\s+(restart|start|enable)\b`, // Service management
+					`\bcrontab\b`,                            // Scheduled tasks
+					`\bnohup\b.*&`,                           // Background daemons
+				},
+				AllowPatterns:       []string{},
+				AllowlistOnly:       false,
+				TimeoutSeconds:      60,
+				CommandTimeouts:     []ExecTimeoutRule{},
+				MaxOutputChars:      10000,
+				RestrictToWorkspace: false,
+				ScrubEnv:            true,
+				ScrubEnvPatterns:    []string{"MYPICOCLAW_*", "*_API_KEY"},
+			},
+			Process: ProcessConfig{
+				MaxProcesses:      8,
+				OutputBufferBytes: 256 * 1024,
+				NotifyOnExit:      true,
+			},
+			Filesystem: FilesystemConfig{
+				RestrictToWorkspace: true,
+				AllowedRoots:        []PathRootConfig{},
+				MaxReadBytes:        64 * 1024,
+				IgnorePatterns:      []string{".git", "node_modules", "__pycache__", ".venv", "sessions/archive", "cache/web", "kb/index.*"},
+			},
+		},
+		KB: KBConfig{
+			Enabled:      false,
+			Dir:          "",
+			ChunkSize:    1200,
+			ChunkOverlap: 200,
+			TopK:         5,
+			MaxFileBytes: 10 * 1024 * 1024,
+			Embedding: EmbeddingConfig{
+				Model:          "text-embedding-3-small",
+				BatchSize:      32,
+				TimeoutSeconds: 60,
+			},
+		},
+		Memory: MemoryConfig{
+			Scope:         "user",
+			AutoExtract:   true,
+			ExtractModel:  "",
+			ContextTokens: 1500,
+			MaxFacts:      2000,
+		},
+		Identity: IdentityConfig{
+			Enabled:           true,
+			SharedSession:     false,
+			PairingTTLMinutes: 10,
+		},
+		Access: AccessConfig{
+			Enabled:     false,
+			DefaultRole: "member",
+			GroupRole:   "guest",
+			Users:       map[string]string{},
+			Roles: map[string]RoleConfig{
+				"owner": {
+					Tools: []string{"*"},
+				},
+				"member": {
+					Tools:            []string{"*"},
+					DenyTools:        []string{"exec", "process_*", "cron", "kb_ingest"},
+					RateLimitPerHour: 120,
+				},
+				"guest": {
+					Tools:            []string{"web_search", "web_fetch", "kb_search", "memory_search"},
+					RateLimitPerHour: 30,
 				},
 			},
 		},
@@ -316,6 +791,11 @@ func (c *Config) GetAPIBase() string {
 	return ""
 }
 
+// ExpandHome expands a leading "~" in path to the user's home directory.
+func ExpandHome(path string) string {
+	return expandHome(path)
+}
+
 func expandHome(path string) string {
 	if path == "" {
 		return path
@@ -0,0 +1,107 @@
+package documents
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+const maxCSVBytes = 32 * 1024 * 1024
+
+func extractCSV(path string, opts Options) (*Document, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if info.Size() > maxCSVBytes {
+		return nil, fmt.Errorf("CSV file too large (%d bytes, limit %d)", info.Size(), maxCSVBytes)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		// Spreadsheet exports on Chinese systems are usually GBK
+		if decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	}
+
+	delim := sniffDelimiter(data)
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		delim = '\t'
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = delim
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV: %w", err)
+	}
+
+	t := &table{rows: records}
+	rows, note, err := selectRows(opts.Rows, t.dataRows())
+	if err != nil {
+		return nil, err
+	}
+	return &Document{
+		Format:   FormatCSV,
+		Total:    1,
+		Sections: []Section{{Index: 1, Text: t.render(rows), Note: note}},
+	}, nil
+}
+
+// sniffDelimiter picks the separator that occurs the same, non-zero number
+// of times on the first lines, preferring the most frequent one.
+func sniffDelimiter(data []byte) rune {
+	lines := strings.SplitN(string(data), "\n", 11)
+	if len(lines) > 10 {
+		lines = lines[:10]
+	}
+
+	best, bestCount := ',', 0
+	for _, d := range []rune{',', ';', '\t', '|'} {
+		count := -1
+		for _, line := range lines {
+			line = strings.TrimRight(line, "\r")
+			if line == "" {
+				continue
+			}
+			n := countOutsideQuotes(line, d)
+			if count == -1 {
+				count = n
+			} else if n != count {
+				count = 0
+				break
+			}
+		}
+		if count > bestCount {
+			best, bestCount = d, count
+		}
+	}
+	return best
+}
+
+func countOutsideQuotes(line string, d rune) int {
+	n := 0
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == d && !quoted:
+			n++
+		}
+	}
+	return n
+}
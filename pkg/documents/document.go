@@ -0,0 +1,291 @@
+// Package documents extracts text from office documents and PDFs received
+// through channels, so the agent can read them without external tools.
+package documents
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported document formats.
+const (
+	FormatPDF  = "pdf"
+	FormatDOCX = "docx"
+	FormatXLSX = "xlsx"
+	FormatCSV  = "csv"
+)
+
+// DefaultMaxRows is the number of spreadsheet rows returned when no row
+// range is requested.
+const DefaultMaxRows = 200
+
+// Options selects the parts of a document to extract. Ranges use 1-based
+// numbers such as "3", "1-5", "8-" or "2,4-6"; Sheets also accepts sheet
+// names.
+type Options struct {
+	Pages  string // PDF and DOCX pages
+	Sheets string // XLSX sheets
+	Rows   string // data rows of spreadsheets and CSV files
+}
+
+// Section is one extracted page or sheet.
+type Section struct {
+	Index int    // 1-based page or sheet number
+	Name  string // sheet name, empty for pages
+	Text  string
+	Note  string // e.g. which rows are shown
+}
+
+// Document is the extracted text of a file.
+type Document struct {
+	Path     string
+	Format   string
+	Unit     string // "page" or "sheet"; empty for CSV
+	Total    int    // number of pages or sheets in the file
+	Sections []Section
+}
+
+// Detect returns the document format of path from its extension or, when
+// that is missing or unknown, from its content. It returns "" for files
+// that are not supported documents.
+func Detect(path string) string {
+	if format := FormatFromName(path); format != "" {
+		return format
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 8)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return FormatPDF
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return ""
+		}
+		defer zr.Close()
+		for _, zf := range zr.File {
+			switch zf.Name {
+			case "word/document.xml":
+				return FormatDOCX
+			case "xl/workbook.xml":
+				return FormatXLSX
+			}
+		}
+	}
+	return ""
+}
+
+// FormatFromName returns the document format implied by a file name's
+// extension, or "" when it is not a supported document.
+func FormatFromName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pdf":
+		return FormatPDF
+	case ".docx", ".docm":
+		return FormatDOCX
+	case ".xlsx", ".xlsm":
+		return FormatXLSX
+	case ".csv", ".tsv":
+		return FormatCSV
+	}
+	return ""
+}
+
+// Extract reads the document at path and returns the selected pages or
+// sheets as text.
+func Extract(path string, opts Options) (*Document, error) {
+	var doc *Document
+	var err error
+	switch Detect(path) {
+	case FormatPDF:
+		doc, err = extractPDF(path, opts)
+	case FormatDOCX:
+		doc, err = extractDOCX(path, opts)
+	case FormatXLSX:
+		doc, err = extractXLSX(path, opts)
+	case FormatCSV:
+		doc, err = extractCSV(path, opts)
+	default:
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".doc", ".xls", ".ppt":
+			return nil, fmt.Errorf("legacy Office format %s is not supported; convert it to .docx or .xlsx", filepath.Ext(path))
+		}
+		return nil, fmt.Errorf("unsupported document type: %s (supported: PDF, DOCX, XLSX, CSV)", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc.Path = path
+	return doc, nil
+}
+
+// Text renders the extracted sections, each under a heading when the
+// document has pages or sheets.
+func (d *Document) Text() string {
+	text, _ := d.Render(0)
+	return text
+}
+
+// Render is Text limited to maxChars characters (0 means no limit). When
+// the limit cuts the output, next is the number of the first page or sheet
+// that was not shown completely.
+func (d *Document) Render(maxChars int) (text string, next int) {
+	var sb strings.Builder
+	used := 0
+	for i, s := range d.Sections {
+		part := d.heading(s) + s.Text
+		if i > 0 {
+			part = "\n\n" + part
+		}
+		n := utf8.RuneCountInString(part)
+		if maxChars > 0 && used+n > maxChars {
+			sb.WriteString(string([]rune(part)[:maxChars-used]))
+			return sb.String(), s.Index
+		}
+		sb.WriteString(part)
+		used += n
+	}
+	return sb.String(), 0
+}
+
+func (d *Document) heading(s Section) string {
+	var h string
+	switch {
+	case d.Unit == "sheet":
+		h = fmt.Sprintf("## Sheet %d: %s", s.Index, s.Name)
+	case d.Unit != "":
+		h = fmt.Sprintf("## Page %d", s.Index)
+	}
+	if s.Note != "" {
+		if h != "" {
+			h += " "
+		}
+		h += "(" + s.Note + ")"
+	}
+	if h == "" {
+		return ""
+	}
+	return h + "\n\n"
+}
+
+// Summary describes the document in one line, e.g. "report.pdf, PDF, 12 pages".
+func (d *Document) Summary() string {
+	s := filepath.Base(d.Path) + ", " + strings.ToUpper(d.Format)
+	if d.Unit != "" {
+		s += fmt.Sprintf(", %d %s", d.Total, d.Unit)
+		if d.Total != 1 {
+			s += "s"
+		}
+	}
+	return s
+}
+
+// Preview extracts the beginning of a document for attaching to an inbound
+// message. It returns at most maxChars characters of text.
+func Preview(path string, maxChars int) (string, error) {
+	doc, err := Extract(path, Options{Pages: "1-3", Sheets: "1", Rows: "1-20"})
+	if err != nil {
+		return "", err
+	}
+	text, next := doc.Render(maxChars)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		text = "(no extractable text)"
+	}
+	if next != 0 || len(doc.Sections) < doc.Total {
+		text += "\n[preview truncated; use read_document for the rest]"
+	}
+	return fmt.Sprintf("[document: %s]\n%s", doc.Summary(), text), nil
+}
+
+// selectRange returns the sorted 1-based indexes chosen by spec out of n
+// items. names, when given, lets parts of the spec refer to items by name.
+// Ranges reaching past the end are clipped.
+func selectRange(spec string, n int, unit string, names []string) ([]int, error) {
+	if strings.TrimSpace(spec) == "" {
+		all := make([]int, n)
+		for i := range all {
+			all[i] = i + 1
+		}
+		return all, nil
+	}
+
+	chosen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if idx, ok := findName(names, part); ok {
+			chosen[idx] = true
+			continue
+		}
+
+		lo, hi, err := parseRangePart(part, n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s range %q", unit, part)
+		}
+		if lo < 1 || lo > n {
+			return nil, fmt.Errorf("%s %d out of range (document has %d)", unit, lo, n)
+		}
+		for i := lo; i <= min(hi, n); i++ {
+			chosen[i] = true
+		}
+	}
+
+	indexes := make([]int, 0, len(chosen))
+	for i := 1; i <= n; i++ {
+		if chosen[i] {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+func findName(names []string, name string) (int, bool) {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// parseRangePart parses "N", "N-M", "N-" or "-M"; open ends extend to n.
+func parseRangePart(part string, n int) (int, int, error) {
+	from, to, isRange := strings.Cut(part, "-")
+	if !isRange {
+		v, err := strconv.Atoi(part)
+		return v, v, err
+	}
+	lo, hi := 1, n
+	var err error
+	if s := strings.TrimSpace(from); s != "" {
+		if lo, err = strconv.Atoi(s); err != nil {
+			return 0, 0, err
+		}
+	}
+	if s := strings.TrimSpace(to); s != "" {
+		if hi, err = strconv.Atoi(s); err != nil {
+			return 0, 0, err
+		}
+	}
+	if hi < lo {
+		return 0, 0, fmt.Errorf("empty range")
+	}
+	return lo, hi, nil
+}
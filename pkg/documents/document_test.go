@@ -0,0 +1,255 @@
+package documents
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func writeTestPDF(t *testing.T, path string) {
+	t.Helper()
+
+	page1 := "BT /F1 12 Tf 72 700 Td (Hello) Tj [( W) -20 (orld)] TJ 0 -14 Td (Second \\(line\\)) Tj ET"
+	page2 := "BT /F2 12 Tf 72 700 Td <00010002> Tj 0 -20 Td <001000110012> Tj ET"
+	cmap := `/CIDInit /ProcSet findresource begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar <0001> <4F60> <0002> <597D> endbfchar
+1 beginbfrange <0010> <0012> <0041> endbfrange
+endcmap end`
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte(page2))
+	zw.Close()
+
+	// Object 5 lives in an object stream, as in PDF 1.5+ files
+	objStm := "5 0 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n")
+	obj := func(num int, body string) {
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", num, body)
+	}
+	stream := func(num int, dict string, data []byte) {
+		fmt.Fprintf(&buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", num, dict, len(data))
+		buf.Write(data)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+	obj(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	obj(2, "<< /Type /Pages /Kids [3 0 R 6 0 R] /Count 2 /Resources << /Font << /F1 5 0 R >> >> >>")
+	obj(3, "<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>")
+	stream(4, "", []byte(page1))
+	obj(6, "<< /Type /Page /Parent 2 0 R /Contents 8 0 R /Resources << /Font << /F2 10 0 R >> >> >>")
+	stream(7, "", []byte(cmap))
+	stream(8, "/Filter /FlateDecode", compressed.Bytes())
+	stream(9, "/Type /ObjStm /N 1 /First 4", []byte(objStm))
+	obj(10, "<< /Type /Font /Subtype /Type0 /BaseFont /SimSun /Encoding /Identity-H /ToUnicode 7 0 R >>")
+	buf.WriteString("trailer\n<< /Root 1 0 R /Size 11 >>\n%%EOF\n")
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractPDF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report")
+	writeTestPDF(t, path)
+
+	if got := Detect(path); got != FormatPDF {
+		t.Fatalf("Detect = %q", got)
+	}
+	doc, err := Extract(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Total != 2 || len(doc.Sections) != 2 {
+		t.Fatalf("got %d of %d pages", len(doc.Sections), doc.Total)
+	}
+	if want := "Hello World\nSecond (line)"; doc.Sections[0].Text != want {
+		t.Errorf("page 1 = %q, want %q", doc.Sections[0].Text, want)
+	}
+	if want := "你好\nABC"; doc.Sections[1].Text != want {
+		t.Errorf("page 2 = %q, want %q", doc.Sections[1].Text, want)
+	}
+
+	doc, err = Extract(path, Options{Pages: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Sections) != 1 || doc.Sections[0].Index != 2 {
+		t.Errorf("page selection returned %+v", doc.Sections)
+	}
+}
+
+func TestExtractDOCX(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.docx")
+	writeZip(t, path, map[string]string{
+		"word/document.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Quarterly plan</w:t></w:r></w:p>
+<w:p><w:pPr><w:tabs><w:tab w:val="left" w:pos="720"/></w:tabs></w:pPr><w:r><w:t xml:space="preserve">Ship the </w:t></w:r><w:r><w:t>release</w:t></w:r></w:p>
+<w:p><w:pPr><w:numPr><w:ilvl w:val="0"/></w:numPr></w:pPr><w:r><w:t>First item</w:t></w:r></w:p>
+<w:tbl><w:tr><w:tc><w:p><w:r><w:t>Owner</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Task</w:t></w:r></w:p></w:tc></w:tr>
+<w:tr><w:tc><w:p><w:r><w:t>Ann</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Docs</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+<w:p><w:r><w:br w:type="page"/><w:t>Appendix</w:t></w:r></w:p>
+</w:body></w:document>`,
+	})
+
+	doc, err := Extract(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Total != 2 {
+		t.Fatalf("Total = %d, want 2", doc.Total)
+	}
+	want := "# Quarterly plan\n\nShip the release\n\n- First item\n\n| Owner | Task |\n| --- | --- |\n| Ann | Docs |"
+	if doc.Sections[0].Text != want {
+		t.Errorf("page 1 =\n%s\nwant\n%s", doc.Sections[0].Text, want)
+	}
+	if doc.Sections[1].Text != "Appendix" {
+		t.Errorf("page 2 = %q", doc.Sections[1].Text)
+	}
+}
+
+func TestExtractXLSX(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.xlsx")
+	writeZip(t, path, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>
+<sheet name="Summary" sheetId="1" r:id="rId1"/><sheet name="Data" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships>
+<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/data.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>Name</t></si><si><t>Amount</t></si><si><t>Date</t></si>
+<si><r><t>Al</t></r><r><t>ice</t></r></si><si><t>Bob</t><rPh><t>ボブ</t></rPh></si></sst>`,
+		"xl/styles.xml":            `<styleSheet><cellXfs count="2"><xf numFmtId="0"/><xf numFmtId="14"/></cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>see Data</t></is></c></row></sheetData></worksheet>`,
+		"xl/worksheets/data.xml": `<worksheet><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c></row>
+<row r="2"><c r="A2" t="s"><v>3</v></c><c r="B2"><v>0.30000000000000004</v></c><c r="C2" s="1"><v>45292</v></c></row>
+<row r="3"><c r="A3" t="s"><v>4</v></c><c r="C3" s="1"><v>45293</v></c></row>
+</sheetData></worksheet>`,
+	})
+
+	doc, err := Extract(path, Options{Sheets: "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Total != 2 || len(doc.Sections) != 1 || doc.Sections[0].Name != "Data" {
+		t.Fatalf("unexpected sheets: %+v", doc.Sections)
+	}
+	want := "| Name | Amount | Date |\n| --- | --- | --- |\n| Alice | 0.3 | 2024-01-01 |\n| Bob |  | 2024-01-02 |"
+	if doc.Sections[0].Text != want {
+		t.Errorf("sheet =\n%s\nwant\n%s", doc.Sections[0].Text, want)
+	}
+
+	doc, err = Extract(path, Options{Sheets: "2", Rows: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := doc.Sections[0].Text; strings.Contains(text, "Alice") || !strings.Contains(text, "Bob") {
+		t.Errorf("row selection failed:\n%s", text)
+	}
+}
+
+func TestExtractCSV(t *testing.T) {
+	dir := t.TempDir()
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("姓名;年龄\n张三;30\n李四;41\n")
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"header.csv", "name,score\nann,1\nbob,2\n", "| name | score |\n| --- | --- |\n| ann | 1 |\n| bob | 2 |"},
+		{"noheader.csv", "1,2\n3,4\n", "| A | B |\n| --- | --- |\n| 1 | 2 |\n| 3 | 4 |"},
+		{"gbk.csv", gbk, "| 姓名 | 年龄 |\n| --- | --- |\n| 张三 | 30 |\n| 李四 | 41 |"},
+		{"pipes.tsv", "a\tb|c\n", "| A | B |\n| --- | --- |\n| a | b\\|c |"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			os.WriteFile(path, []byte(tt.content), 0644)
+			doc, err := Extract(path, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := doc.Sections[0].Text; got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectRange(t *testing.T) {
+	names := []string{"Summary", "Data"}
+	tests := []struct {
+		spec    string
+		want    []int
+		wantErr bool
+	}{
+		{"", []int{1, 2, 3, 4, 5}, false},
+		{"2", []int{2}, false},
+		{"4-", []int{4, 5}, false},
+		{"-2,5", []int{1, 2, 5}, false},
+		{"3-9", []int{3, 4, 5}, false},
+		{"data", []int{2}, false},
+		{"6", nil, true},
+		{"3-1", nil, true},
+		{"x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := selectRange(tt.spec, 5, "page", names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("selectRange(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectRange(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.csv")
+	var sb strings.Builder
+	sb.WriteString("name,score\n")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&sb, "user%d,%d\n", i, i)
+	}
+	os.WriteFile(path, []byte(sb.String()), 0644)
+
+	preview, err := Preview(path, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(preview, "[document: scores.csv, CSV]") ||
+		!strings.Contains(preview, "rows 1-20 of 50") || strings.Contains(preview, "user20") {
+		t.Errorf("unexpected preview:\n%s", preview)
+	}
+}
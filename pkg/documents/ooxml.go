@@ -0,0 +1,608 @@
+package documents
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPartBytes bounds how much of a single zip member is decompressed, as
+// protection against zip bombs.
+const maxPartBytes = 64 * 1024 * 1024
+
+// maxSheetRows bounds how many rows are read from one worksheet.
+const maxSheetRows = 100000
+
+func openZipPart(zr *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(rc, maxPartBytes), rc}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+func xmlAttr(el xml.StartElement, local string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// ---- DOCX ----
+
+func extractDOCX(filePath string, opts Options) (*Document, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DOCX: %w", err)
+	}
+	defer zr.Close()
+
+	part, err := openZipPart(&zr.Reader, "word/document.xml")
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCX: %w", err)
+	}
+	defer part.Close()
+
+	pages, err := parseDOCX(part)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DOCX: %w", err)
+	}
+
+	indexes, err := selectRange(opts.Pages, len(pages), "page", nil)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{Format: FormatDOCX, Unit: "page", Total: len(pages)}
+	for _, i := range indexes {
+		doc.Sections = append(doc.Sections, Section{Index: i, Text: pages[i-1]})
+	}
+	return doc, nil
+}
+
+// docxWriter collects paragraphs into pages while walking document.xml.
+// Pages are split at explicit page breaks and at the page breaks Word
+// recorded when the file was last saved.
+type docxWriter struct {
+	pages  [][]string
+	para   strings.Builder
+	prefix string
+	tables []*docxTable
+}
+
+type docxTable struct {
+	rows [][]string
+	row  []string
+	cell []string
+}
+
+func parseDOCX(r io.Reader) ([]string, error) {
+	w := &docxWriter{pages: [][]string{nil}}
+	dec := xml.NewDecoder(r)
+	inText, inTabs := false, false
+	paraDepth := 0
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				// Text boxes nest paragraphs inside a paragraph
+				if paraDepth > 0 {
+					w.endParagraph()
+				}
+				paraDepth++
+			case "pStyle":
+				if level := headingLevel(xmlAttr(t, "val")); level > 0 {
+					w.prefix = strings.Repeat("#", level) + " "
+				}
+			case "numPr":
+				if w.prefix == "" {
+					w.prefix = "- "
+				}
+			case "t":
+				inText = true
+			case "tabs":
+				inTabs = true
+			case "tab":
+				if !inTabs {
+					w.para.WriteString("\t")
+				}
+			case "br", "cr":
+				if xmlAttr(t, "type") == "page" {
+					w.pageBreak()
+				} else {
+					w.para.WriteString("\n")
+				}
+			case "lastRenderedPageBreak":
+				w.pageBreak()
+			case "tbl":
+				w.tables = append(w.tables, &docxTable{})
+			case "tr":
+				if tbl := w.table(); tbl != nil {
+					tbl.row = nil
+				}
+			case "tc":
+				if tbl := w.table(); tbl != nil {
+					tbl.cell = nil
+				}
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "tabs":
+				inTabs = false
+			case "p":
+				paraDepth--
+				w.endParagraph()
+			case "tc":
+				if tbl := w.table(); tbl != nil {
+					tbl.row = append(tbl.row, strings.Join(tbl.cell, " "))
+				}
+			case "tr":
+				if tbl := w.table(); tbl != nil {
+					tbl.rows = append(tbl.rows, tbl.row)
+				}
+			case "tbl":
+				w.endTable()
+			}
+
+		case xml.CharData:
+			if inText {
+				w.para.Write(t)
+			}
+		}
+	}
+
+	pages := make([]string, len(w.pages))
+	for i, blocks := range w.pages {
+		pages[i] = strings.Join(blocks, "\n\n")
+	}
+	return pages, nil
+}
+
+func (w *docxWriter) table() *docxTable {
+	if len(w.tables) == 0 {
+		return nil
+	}
+	return w.tables[len(w.tables)-1]
+}
+
+func (w *docxWriter) endParagraph() {
+	text := strings.TrimSpace(w.para.String())
+	prefix := w.prefix
+	w.para.Reset()
+	w.prefix = ""
+	if text == "" {
+		return
+	}
+	if tbl := w.table(); tbl != nil {
+		tbl.cell = append(tbl.cell, text)
+		return
+	}
+	w.addBlock(prefix + text)
+}
+
+func (w *docxWriter) endTable() {
+	tbl := w.table()
+	if tbl == nil {
+		return
+	}
+	w.tables = w.tables[:len(w.tables)-1]
+
+	// Nested tables are flattened into the enclosing cell
+	if parent := w.table(); parent != nil {
+		var rows []string
+		for _, row := range tbl.rows {
+			rows = append(rows, strings.Join(row, " "))
+		}
+		parent.cell = append(parent.cell, strings.Join(rows, "; "))
+		return
+	}
+
+	if len(tbl.rows) == 0 {
+		return
+	}
+	singleColumn := true
+	for _, row := range tbl.rows {
+		if len(row) > 1 {
+			singleColumn = false
+			break
+		}
+	}
+	if singleColumn {
+		for _, row := range tbl.rows {
+			if len(row) == 1 && row[0] != "" {
+				w.addBlock(row[0])
+			}
+		}
+		return
+	}
+	t := &table{rows: tbl.rows, header: true}
+	all, _ := selectRange("", t.dataRows(), "row", nil)
+	w.addBlock(t.render(all))
+}
+
+func (w *docxWriter) addBlock(text string) {
+	last := len(w.pages) - 1
+	w.pages[last] = append(w.pages[last], text)
+}
+
+func (w *docxWriter) pageBreak() {
+	// Tables are kept on one page
+	if len(w.tables) > 0 {
+		return
+	}
+	if text := strings.TrimSpace(w.para.String()); text != "" {
+		w.addBlock(w.prefix + text)
+	}
+	w.para.Reset()
+	w.pages = append(w.pages, nil)
+}
+
+// headingLevel maps a paragraph style to a Markdown heading level. Chinese
+// versions of Word name the built-in heading styles "1", "2", ...
+func headingLevel(style string) int {
+	s := strings.ToLower(strings.ReplaceAll(style, " ", ""))
+	switch s {
+	case "title":
+		return 1
+	case "subtitle":
+		return 2
+	}
+	s = strings.TrimPrefix(s, "heading")
+	if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= 6 {
+		return n
+	}
+	return 0
+}
+
+// ---- XLSX ----
+
+type xlsxSheet struct {
+	name string
+	part string
+}
+
+func extractXLSX(filePath string, opts Options) (*Document, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLSX: %w", err)
+	}
+	defer zr.Close()
+
+	sheets, err := readWorkbook(&zr.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX: %w", err)
+	}
+	shared, err := readSharedStrings(&zr.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX: %w", err)
+	}
+	dateStyles := readDateStyles(&zr.Reader)
+
+	names := make([]string, len(sheets))
+	for i, s := range sheets {
+		names[i] = s.name
+	}
+	indexes, err := selectRange(opts.Sheets, len(sheets), "sheet", names)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Format: FormatXLSX, Unit: "sheet", Total: len(sheets)}
+	for _, i := range indexes {
+		sheet := sheets[i-1]
+		t, err := readSheet(&zr.Reader, sheet.part, shared, dateStyles)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %s: %w", sheet.name, err)
+		}
+		rows, note, err := selectRows(opts.Rows, t.dataRows())
+		if err != nil {
+			return nil, err
+		}
+		doc.Sections = append(doc.Sections, Section{Index: i, Name: sheet.name, Text: t.render(rows), Note: note})
+	}
+	return doc, nil
+}
+
+func readWorkbook(zr *zip.Reader) ([]xlsxSheet, error) {
+	targets := make(map[string]string)
+	if rels, err := openZipPart(zr, "xl/_rels/workbook.xml.rels"); err == nil {
+		dec := xml.NewDecoder(rels)
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				break
+			}
+			if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "Relationship" {
+				target := xmlAttr(el, "Target")
+				if strings.HasPrefix(target, "/") {
+					target = strings.TrimPrefix(target, "/")
+				} else {
+					target = path.Join("xl", target)
+				}
+				targets[xmlAttr(el, "Id")] = target
+			}
+		}
+		rels.Close()
+	}
+
+	wb, err := openZipPart(zr, "xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer wb.Close()
+
+	var sheets []xlsxSheet
+	dec := xml.NewDecoder(wb)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok || el.Name.Local != "sheet" {
+			continue
+		}
+		part := targets[xmlAttr(el, "id")]
+		if part == "" {
+			part = fmt.Sprintf("xl/worksheets/sheet%d.xml", len(sheets)+1)
+		}
+		sheets = append(sheets, xlsxSheet{name: xmlAttr(el, "name"), part: part})
+	}
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("workbook has no sheets")
+	}
+	return sheets, nil
+}
+
+func readSharedStrings(zr *zip.Reader) ([]string, error) {
+	part, err := openZipPart(zr, "xl/sharedStrings.xml")
+	if err != nil {
+		return nil, nil // workbooks without text cells have none
+	}
+	defer part.Close()
+
+	var strs []string
+	var sb strings.Builder
+	inText, inPhonetic := false, false
+	dec := xml.NewDecoder(part)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				sb.Reset()
+			case "t":
+				inText = true
+			case "rPh":
+				inPhonetic = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				strs = append(strs, sb.String())
+			case "t":
+				inText = false
+			case "rPh":
+				inPhonetic = false
+			}
+		case xml.CharData:
+			if inText && !inPhonetic {
+				sb.Write(t)
+			}
+		}
+	}
+	return strs, nil
+}
+
+// readDateStyles returns the cell style indexes whose number format shows
+// a date or time.
+func readDateStyles(zr *zip.Reader) map[int]bool {
+	dates := make(map[int]bool)
+	part, err := openZipPart(zr, "xl/styles.xml")
+	if err != nil {
+		return dates
+	}
+	defer part.Close()
+
+	customFormats := make(map[int]string)
+	inCellXfs := false
+	xfIndex := 0
+	dec := xml.NewDecoder(part)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "numFmt":
+				id, _ := strconv.Atoi(xmlAttr(t, "numFmtId"))
+				customFormats[id] = xmlAttr(t, "formatCode")
+			case "cellXfs":
+				inCellXfs = true
+			case "xf":
+				if inCellXfs {
+					id, _ := strconv.Atoi(xmlAttr(t, "numFmtId"))
+					if isDateFormat(id, customFormats[id]) {
+						dates[xfIndex] = true
+					}
+					xfIndex++
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "cellXfs" {
+				inCellXfs = false
+			}
+		}
+	}
+	return dates
+}
+
+var formatLiteralRe = regexp.MustCompile(`"[^"]*"|\[[^\]]*\]|\\.`)
+
+func isDateFormat(id int, code string) bool {
+	switch {
+	case id >= 14 && id <= 22, id >= 27 && id <= 36, id >= 45 && id <= 47, id >= 50 && id <= 58:
+		return true
+	case code == "":
+		return false
+	}
+	code = strings.ToLower(formatLiteralRe.ReplaceAllString(code, ""))
+	return strings.ContainsAny(code, "ymdhs")
+}
+
+func readSheet(zr *zip.Reader, name string, shared []string, dateStyles map[int]bool) (*table, error) {
+	part, err := openZipPart(zr, name)
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	t := &table{}
+	var row []string
+	var cellType, value string
+	cellCol, cellStyle := 0, 0
+	inValue := false
+	dec := xml.NewDecoder(part)
+	for len(t.rows) < maxSheetRows {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "row":
+				row = nil
+			case "c":
+				cellType = xmlAttr(el, "t")
+				cellStyle, _ = strconv.Atoi(xmlAttr(el, "s"))
+				cellCol = columnIndex(xmlAttr(el, "r"), len(row))
+				value = ""
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				for len(row) < cellCol {
+					row = append(row, "")
+				}
+				row = append(row, cellValue(value, cellType, dateStyles[cellStyle], shared))
+			case "row":
+				t.rows = append(t.rows, row)
+			}
+		case xml.CharData:
+			if inValue {
+				value += string(el)
+			}
+		}
+	}
+	return t, nil
+}
+
+// columnIndex returns the 0-based column of a cell reference like "C7",
+// or fallback when the reference is missing.
+func columnIndex(ref string, fallback int) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	if col == 0 {
+		return fallback
+	}
+	return col - 1
+}
+
+func cellValue(v, cellType string, isDate bool, shared []string) string {
+	switch cellType {
+	case "s":
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || i < 0 || i >= len(shared) {
+			return ""
+		}
+		return shared[i]
+	case "b":
+		if v == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "inlineStr", "str", "e":
+		return v
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return v
+	}
+	if isDate {
+		return excelDate(f)
+	}
+	// Print at most 15 significant digits to hide floating point noise
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'g', 15, 64), 64)
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
+
+// excelDate converts an Excel serial date (1900 date system) to text.
+func excelDate(serial float64) string {
+	days, frac := math.Modf(serial)
+	t := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).
+		AddDate(0, 0, int(days)).
+		Add(time.Duration(math.Round(frac*86400)) * time.Second)
+	switch {
+	case days == 0:
+		return t.Format("15:04:05")
+	case frac == 0:
+		return t.Format("2006-01-02")
+	default:
+		return t.Format("2006-01-02 15:04:05")
+	}
+}
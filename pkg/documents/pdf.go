@@ -0,0 +1,504 @@
+package documents
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxPDFBytes = 100 * 1024 * 1024
+
+// pdfFile holds the objects of a PDF. Objects are found by scanning the
+// file rather than trusting the cross-reference table, which is often
+// damaged in files passed around by chat apps.
+type pdfFile struct {
+	objects map[int]interface{}
+	offsets map[int]int // where each object was defined; later wins
+	trailer pdfDict
+	fonts   map[pdfRef]*pdfFont
+}
+
+var (
+	pdfObjRe     = regexp.MustCompile(`(\d+)[\x00\t\n\f\r ]+(\d+)[\x00\t\n\f\r ]+obj\b`)
+	pdfTrailerRe = regexp.MustCompile(`trailer[\x00\t\n\f\r ]*<<`)
+)
+
+func extractPDF(path string, opts Options) (*Document, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if info.Size() > maxPDFBytes {
+		return nil, fmt.Errorf("PDF too large (%d bytes, limit %d)", info.Size(), maxPDFBytes)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	f, err := parsePDF(data)
+	if err != nil {
+		return nil, err
+	}
+	pages := f.pages()
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages found in PDF")
+	}
+
+	indexes, err := selectRange(opts.Pages, len(pages), "page", nil)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{Format: FormatPDF, Unit: "page", Total: len(pages)}
+	for _, i := range indexes {
+		section := Section{Index: i, Text: f.pageText(pages[i-1])}
+		if section.Text == "" {
+			section.Note = "no extractable text; the page may be scanned"
+		}
+		doc.Sections = append(doc.Sections, section)
+	}
+	return doc, nil
+}
+
+func parsePDF(data []byte) (*pdfFile, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF file")
+	}
+
+	f := &pdfFile{
+		objects: make(map[int]interface{}),
+		offsets: make(map[int]int),
+		fonts:   make(map[pdfRef]*pdfFont),
+	}
+
+	var objStreams []int
+	pos := 0
+	for pos < len(data) {
+		loc := pdfObjRe.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		offset := pos + loc[0]
+		l := &pdfLexer{data: data, pos: pos + loc[1]}
+		obj, err := l.object()
+		if err != nil && obj == nil {
+			pos += loc[1]
+			continue
+		}
+		if dict, ok := obj.(pdfDict); ok {
+			if raw, ok := l.streamBody(dict); ok {
+				obj = &pdfStream{dict: dict, raw: raw}
+				if dict["Type"] == pdfName("ObjStm") {
+					objStreams = append(objStreams, num)
+				}
+				if dict["Type"] == pdfName("XRef") {
+					f.trailer = dict
+				}
+			}
+		}
+		f.setObject(num, obj, offset)
+		pos = max(l.pos, pos+loc[1])
+	}
+
+	for _, num := range objStreams {
+		f.loadObjectStream(num)
+	}
+
+	for _, loc := range pdfTrailerRe.FindAllIndex(data, -1) {
+		l := &pdfLexer{data: data, pos: loc[1] - 2}
+		if dict, ok := mustObject(l).(pdfDict); ok && dict["Root"] != nil {
+			f.trailer = dict
+		}
+	}
+
+	if f.trailer != nil && f.trailer["Encrypt"] != nil {
+		return nil, fmt.Errorf("encrypted PDFs are not supported")
+	}
+	return f, nil
+}
+
+func mustObject(l *pdfLexer) interface{} {
+	obj, _ := l.object()
+	return obj
+}
+
+func (f *pdfFile) setObject(num int, obj interface{}, offset int) {
+	if prev, ok := f.offsets[num]; ok && prev > offset {
+		return
+	}
+	f.objects[num] = obj
+	f.offsets[num] = offset
+}
+
+// loadObjectStream adds the objects compressed into an object stream
+// (PDF 1.5 and later).
+func (f *pdfFile) loadObjectStream(num int) {
+	stream, ok := f.objects[num].(*pdfStream)
+	if !ok {
+		return
+	}
+	data, err := f.decodeStream(stream)
+	if err != nil {
+		return
+	}
+	n, _ := f.resolve(stream.dict["N"]).(float64)
+	first, _ := f.resolve(stream.dict["First"]).(float64)
+	if int(first) > len(data) {
+		return
+	}
+
+	header := &pdfLexer{data: data[:int(first)]}
+	for i := 0; i < int(n); i++ {
+		objNum, err1 := header.token()
+		objOffset, err2 := header.token()
+		on, ok1 := objNum.(float64)
+		oo, ok2 := objOffset.(float64)
+		if err1 != nil || err2 != nil || !ok1 || !ok2 {
+			break
+		}
+		start := int(first) + int(oo)
+		if start >= len(data) {
+			continue
+		}
+		obj := mustObject(&pdfLexer{data: data, pos: start})
+		// Objects inside the stream count as defined where the stream is
+		f.setObject(int(on), obj, f.offsets[num])
+	}
+}
+
+// resolve follows indirect references.
+func (f *pdfFile) resolve(v interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = f.objects[ref.num]
+	}
+	return nil
+}
+
+func (f *pdfFile) dict(v interface{}) pdfDict {
+	switch d := f.resolve(v).(type) {
+	case pdfDict:
+		return d
+	case *pdfStream:
+		return d.dict
+	}
+	return nil
+}
+
+func (f *pdfFile) array(v interface{}) pdfArray {
+	switch a := f.resolve(v).(type) {
+	case pdfArray:
+		return a
+	case nil:
+		return nil
+	default:
+		return pdfArray{a}
+	}
+}
+
+func (f *pdfFile) decodeStream(s *pdfStream) ([]byte, error) {
+	data := s.raw
+	if n, ok := f.resolve(s.dict["Length"]).(float64); ok && int(n) >= 0 && int(n) < len(data) {
+		data = data[:int(n)]
+	}
+
+	filters := f.array(s.dict["Filter"])
+	for _, filter := range filters {
+		name, _ := f.resolve(filter).(pdfName)
+		var err error
+		switch name {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+		case "ASCIIHexDecode", "AHx":
+			l := &pdfLexer{data: data}
+			data = l.hexString()
+		case "ASCII85Decode", "A85":
+			data, err = decodeASCII85(data)
+		default:
+			return nil, fmt.Errorf("unsupported stream filter %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate decompresses zlib data, keeping whatever was recovered from
+// truncated or slightly corrupt streams.
+func inflate(data []byte) ([]byte, error) {
+	var r io.ReadCloser
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err == nil {
+		r = zr
+	} else {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, maxPartBytes))
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("failed to inflate stream: %w", err)
+	}
+	return out, nil
+}
+
+func decodeASCII85(data []byte) ([]byte, error) {
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	out, err := io.ReadAll(ascii85.NewDecoder(bytes.NewReader(data)))
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("invalid ASCII85 data: %w", err)
+	}
+	return out, nil
+}
+
+// pdfPage is a page dictionary together with its inherited resources.
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages walks the page tree in reading order.
+func (f *pdfFile) pages() []pdfPage {
+	var root pdfDict
+	if f.trailer != nil {
+		root = f.dict(f.trailer["Root"])
+	}
+	if root == nil {
+		for _, obj := range f.objects {
+			if d, ok := obj.(pdfDict); ok && d["Type"] == pdfName("Catalog") {
+				root = d
+				break
+			}
+		}
+	}
+	if root == nil {
+		return nil
+	}
+
+	var pages []pdfPage
+	visited := make(map[interface{}]bool)
+	var walk func(node interface{}, resources pdfDict, depth int)
+	walk = func(node interface{}, resources pdfDict, depth int) {
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref] {
+				return
+			}
+			visited[ref] = true
+		}
+		d := f.dict(node)
+		if d == nil || depth > 64 {
+			return
+		}
+		if r := f.dict(d["Resources"]); r != nil {
+			resources = r
+		}
+		if kids := f.array(d["Kids"]); d["Type"] == pdfName("Pages") || kids != nil {
+			for _, kid := range kids {
+				walk(kid, resources, depth+1)
+			}
+			return
+		}
+		pages = append(pages, pdfPage{dict: d, resources: resources})
+	}
+	walk(root["Pages"], nil, 0)
+	return pages
+}
+
+// pageText extracts the text of a page from its content streams.
+func (f *pdfFile) pageText(page pdfPage) string {
+	var content []byte
+	for _, c := range f.array(page.dict["Contents"]) {
+		if s, ok := f.resolve(c).(*pdfStream); ok {
+			if data, err := f.decodeStream(s); err == nil {
+				content = append(content, data...)
+				content = append(content, '\n')
+			}
+		}
+	}
+
+	w := &pdfTextWriter{}
+	f.runContent(content, page.resources, w, 0)
+	return w.String()
+}
+
+// runContent interprets the text operators of a content stream.
+func (f *pdfFile) runContent(content []byte, resources pdfDict, w *pdfTextWriter, depth int) {
+	l := &pdfLexer{data: content}
+	var operands []interface{}
+	var font *pdfFont
+	var lastY float64
+	haveY := false
+
+	number := func(i int) float64 {
+		if i < len(operands) {
+			v, _ := operands[i].(float64)
+			return v
+		}
+		return 0
+	}
+
+	for {
+		obj, err := l.object()
+		if err != nil {
+			break
+		}
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) >= 1 {
+				if name, ok := operands[0].(pdfName); ok {
+					font = f.font(resources, name)
+				}
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				w.show(font.decode(operands[0]))
+			}
+		case "'":
+			w.newline()
+			if len(operands) >= 1 {
+				w.show(font.decode(operands[0]))
+			}
+		case "\"":
+			w.newline()
+			if len(operands) >= 3 {
+				w.show(font.decode(operands[2]))
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				arr, _ := operands[0].(pdfArray)
+				for _, item := range arr {
+					if n, ok := item.(float64); ok {
+						// Large negative adjustments separate words
+						if n < -150 {
+							w.space()
+						}
+						continue
+					}
+					w.show(font.decode(item))
+				}
+			}
+		case "Td", "TD":
+			if ty := number(1); ty > 0.01 || ty < -0.01 {
+				w.newline()
+			} else if number(0) != 0 {
+				w.space()
+			}
+		case "T*":
+			w.newline()
+		case "Tm":
+			if y := number(5); haveY && (y-lastY > 1 || lastY-y > 1) {
+				w.newline()
+			} else if haveY {
+				w.space()
+			}
+			lastY, haveY = number(5), true
+		case "Do":
+			if len(operands) >= 1 && depth < 8 {
+				name, _ := operands[0].(pdfName)
+				f.runXObject(resources, name, w, depth)
+			}
+		case "ID":
+			l.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+}
+
+// runXObject extracts the text of a form XObject drawn with Do.
+func (f *pdfFile) runXObject(resources pdfDict, name pdfName, w *pdfTextWriter, depth int) {
+	xobjects := f.dict(resources["XObject"])
+	if xobjects == nil {
+		return
+	}
+	stream, ok := f.resolve(xobjects[name]).(*pdfStream)
+	if !ok || stream.dict["Subtype"] != pdfName("Form") {
+		return
+	}
+	data, err := f.decodeStream(stream)
+	if err != nil {
+		return
+	}
+	if r := f.dict(stream.dict["Resources"]); r != nil {
+		resources = r
+	}
+	f.runContent(data, resources, w, depth+1)
+}
+
+// pdfTextWriter assembles shown text, inserting spaces and line breaks
+// lazily so that they never end up doubled or between CJK characters.
+type pdfTextWriter struct {
+	sb           strings.Builder
+	last         rune
+	pendingSpace bool
+	pendingLine  bool
+}
+
+func (w *pdfTextWriter) space() {
+	w.pendingSpace = true
+}
+
+func (w *pdfTextWriter) newline() {
+	w.pendingLine = true
+}
+
+func (w *pdfTextWriter) show(text string) {
+	if text == "" {
+		return
+	}
+	first := []rune(text)[0]
+	switch {
+	case w.sb.Len() == 0:
+	case w.pendingLine:
+		w.sb.WriteByte('\n')
+	case w.pendingSpace && w.last != ' ' && first != ' ' && !(isCJK(w.last) && isCJK(first)):
+		w.sb.WriteByte(' ')
+	}
+	w.pendingLine, w.pendingSpace = false, false
+	w.sb.WriteString(text)
+	runes := []rune(text)
+	w.last = runes[len(runes)-1]
+}
+
+func (w *pdfTextWriter) String() string {
+	lines := strings.Split(w.sb.String(), "\n")
+	var out []string
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+func isCJK(r rune) bool {
+	return (r >= 0x2E80 && r <= 0x9FFF) || (r >= 0xAC00 && r <= 0xD7AF) ||
+		(r >= 0xF900 && r <= 0xFAFF) || (r >= 0xFF00 && r <= 0xFFEF)
+}
@@ -0,0 +1,421 @@
+package documents
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/unicode/norm"
+)
+
+// pdfFont maps the character codes of shown strings to text.
+type pdfFont struct {
+	toUnicode *pdfCMap
+	composite bool              // Type0 font with multi-byte codes
+	utf16     bool              // predefined UCS-2/UTF-16 CMap
+	charset   encoding.Encoding // predefined legacy CJK CMap
+	simple    [256]string       // code table of a simple font
+}
+
+func (f *pdfFile) font(resources pdfDict, name pdfName) *pdfFont {
+	fonts := f.dict(resources["Font"])
+	if fonts == nil {
+		return nil
+	}
+	ref, isRef := fonts[name].(pdfRef)
+	if isRef {
+		if cached, ok := f.fonts[ref]; ok {
+			return cached
+		}
+	}
+	d := f.dict(fonts[name])
+	if d == nil {
+		return nil
+	}
+
+	font := &pdfFont{}
+	if s, ok := f.resolve(d["ToUnicode"]).(*pdfStream); ok {
+		if data, err := f.decodeStream(s); err == nil {
+			font.toUnicode = parseCMap(data)
+		}
+	}
+	if d["Subtype"] == pdfName("Type0") {
+		font.composite = true
+		if enc, ok := f.resolve(d["Encoding"]).(pdfName); ok {
+			font.utf16, font.charset = predefinedCMap(string(enc))
+		}
+	} else {
+		font.simple = f.simpleEncoding(d)
+	}
+
+	if isRef {
+		f.fonts[ref] = font
+	}
+	return font
+}
+
+// predefinedCMap maps the names of Adobe's predefined CJK CMaps to the
+// character encoding they use.
+func predefinedCMap(name string) (bool, encoding.Encoding) {
+	switch {
+	case strings.Contains(name, "UCS2"), strings.Contains(name, "UTF16"):
+		return true, nil
+	case strings.HasPrefix(name, "GBK2K"):
+		return false, simplifiedchinese.GB18030
+	case strings.HasPrefix(name, "GB"):
+		return false, simplifiedchinese.GBK
+	case strings.Contains(name, "B5"):
+		return false, traditionalchinese.Big5
+	case strings.Contains(name, "RKSJ"):
+		return false, japanese.ShiftJIS
+	case strings.HasPrefix(name, "EUC-"):
+		return false, japanese.EUCJP
+	case strings.HasPrefix(name, "KSC"):
+		return false, korean.EUCKR
+	}
+	return false, nil
+}
+
+// decode converts a shown string to text. Composite fonts without a
+// Unicode mapping draw glyph ids that cannot be turned into text, so they
+// yield nothing rather than garbage.
+func (font *pdfFont) decode(v interface{}) string {
+	s, ok := v.(pdfString)
+	if !ok || len(s) == 0 {
+		return ""
+	}
+	if font == nil {
+		return decodeSimple(s, &standardEncoding)
+	}
+
+	if font.toUnicode != nil {
+		var sb strings.Builder
+		for i := 0; i < len(s); {
+			n := font.toUnicode.codeLength(s[i:], font.composite)
+			code := s[i:min(i+n, len(s))]
+			if text, ok := font.toUnicode.lookup(code); ok {
+				sb.WriteString(text)
+			} else if !font.composite && n == 1 {
+				sb.WriteString(font.simple[code[0]])
+			}
+			i += n
+		}
+		return sb.String()
+	}
+
+	switch {
+	case font.utf16:
+		return utf16BE(s)
+	case font.charset != nil:
+		out, err := font.charset.NewDecoder().Bytes(s)
+		if err != nil {
+			return ""
+		}
+		return string(out)
+	case font.composite:
+		return ""
+	}
+	return decodeSimple(s, &font.simple)
+}
+
+func decodeSimple(s []byte, table *[256]string) string {
+	var sb strings.Builder
+	for _, b := range s {
+		sb.WriteString(table[b])
+	}
+	return sb.String()
+}
+
+var standardEncoding, macRomanEncoding [256]string
+
+func init() {
+	for i := 0; i < 256; i++ {
+		if r := charmap.Windows1252.DecodeByte(byte(i)); i >= 32 && r != utf8.RuneError {
+			standardEncoding[i] = string(r)
+		}
+		if r := charmap.Macintosh.DecodeByte(byte(i)); i >= 32 && r != utf8.RuneError {
+			macRomanEncoding[i] = string(r)
+		}
+	}
+	// Tabs and line breaks occasionally appear in shown strings
+	for _, c := range []byte{'\t', '\n', '\r'} {
+		standardEncoding[c] = " "
+		macRomanEncoding[c] = " "
+	}
+}
+
+// simpleEncoding builds the code table of a simple font from its base
+// encoding and /Differences.
+func (f *pdfFile) simpleEncoding(d pdfDict) [256]string {
+	table := standardEncoding
+	enc := f.resolve(d["Encoding"])
+	if ed, ok := enc.(pdfDict); ok {
+		enc = ed["BaseEncoding"]
+		code := 0
+		for _, item := range f.array(ed["Differences"]) {
+			switch v := f.resolve(item).(type) {
+			case float64:
+				code = int(v)
+			case pdfName:
+				if code >= 0 && code < 256 {
+					if text, ok := glyphText(string(v)); ok {
+						table[code] = text
+					}
+				}
+				code++
+			}
+		}
+	}
+	if enc == pdfName("MacRomanEncoding") {
+		mac := macRomanEncoding
+		for i := range mac {
+			if table[i] == standardEncoding[i] {
+				table[i] = mac[i]
+			}
+		}
+	}
+	return table
+}
+
+var glyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#", "dollar": "$",
+	"percent": "%", "ampersand": "&", "quotesingle": "'", "parenleft": "(", "parenright": ")",
+	"asterisk": "*", "plus": "+", "comma": ",", "hyphen": "-", "period": ".", "slash": "/",
+	"colon": ":", "semicolon": ";", "less": "<", "equal": "=", "greater": ">", "question": "?",
+	"at": "@", "bracketleft": "[", "backslash": "\\", "bracketright": "]", "asciicircum": "^",
+	"underscore": "_", "grave": "`", "braceleft": "{", "bar": "|", "braceright": "}",
+	"asciitilde": "~", "zero": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+	"quoteleft": "‘", "quoteright": "’", "quotedblleft": "“", "quotedblright": "”",
+	"quotesinglbase": "‚", "quotedblbase": "„", "bullet": "•", "endash": "–", "emdash": "—",
+	"ellipsis": "…", "fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl",
+	"copyright": "©", "registered": "®", "trademark": "™", "degree": "°", "section": "§",
+	"paragraph": "¶", "dagger": "†", "daggerdbl": "‡", "minus": "−", "multiply": "×",
+	"divide": "÷", "plusminus": "±", "Euro": "€", "sterling": "£", "yen": "¥", "cent": "¢",
+	"nbspace": " ", "nonbreakingspace": " ", "periodcentered": "·", "middot": "·",
+	"guillemotleft": "«", "guillemotright": "»", "germandbls": "ß", "dotlessi": "ı",
+}
+
+// glyphText returns the text of a glyph name: a known name, a single
+// letter, or a uniXXXX / uXXXXX code.
+func glyphText(name string) (string, bool) {
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	if text, ok := glyphNames[name]; ok {
+		return text, true
+	}
+	if len(name) == 1 && name[0] < utf8.RuneSelf {
+		return name, true
+	}
+	if hex, ok := strings.CutPrefix(name, "uni"); ok && len(hex) >= 4 && len(hex)%4 == 0 {
+		var sb strings.Builder
+		for i := 0; i < len(hex); i += 4 {
+			v, err := strconv.ParseUint(hex[i:i+4], 16, 16)
+			if err != nil {
+				return "", false
+			}
+			sb.WriteRune(rune(v))
+		}
+		return sb.String(), true
+	}
+	if hex, ok := strings.CutPrefix(name, "u"); ok && len(hex) >= 4 && len(hex) <= 6 {
+		if v, err := strconv.ParseUint(hex, 16, 32); err == nil {
+			return string(rune(v)), true
+		}
+	}
+	// Accented Latin letters: eacute, Adieresis, ...
+	for accent, mark := range accentMarks {
+		if base, ok := strings.CutSuffix(name, accent); ok && len(base) == 1 {
+			return norm.NFC.String(base + string(mark)), true
+		}
+	}
+	return "", false
+}
+
+var accentMarks = map[string]rune{
+	"acute": 0x301, "grave": 0x300, "circumflex": 0x302, "dieresis": 0x308,
+	"tilde": 0x303, "ring": 0x30A, "cedilla": 0x327, "caron": 0x30C,
+}
+
+func utf16BE(b []byte) string {
+	return string(utf16.Decode(utf16Units(b)))
+}
+
+// pdfCMap is a parsed ToUnicode CMap.
+type pdfCMap struct {
+	spaces [][2][]byte
+	chars  map[string]string
+	ranges []cmapRange
+	keyLen int
+}
+
+type cmapRange struct {
+	lo, hi uint32
+	n      int
+	dst    []uint16 // first destination, incremented through the range
+	dsts   []string // explicit destinations
+}
+
+func parseCMap(data []byte) *pdfCMap {
+	c := &pdfCMap{chars: make(map[string]string)}
+	l := &pdfLexer{data: data}
+	for {
+		obj, err := l.object()
+		if err != nil {
+			break
+		}
+		switch obj {
+		case pdfKeyword("begincodespacerange"):
+			items := readUntil(l, "endcodespacerange")
+			for i := 0; i+1 < len(items); i += 2 {
+				lo, ok1 := items[i].(pdfString)
+				hi, ok2 := items[i+1].(pdfString)
+				if ok1 && ok2 && len(lo) == len(hi) && len(lo) > 0 {
+					c.spaces = append(c.spaces, [2][]byte{lo, hi})
+				}
+			}
+		case pdfKeyword("beginbfchar"):
+			items := readUntil(l, "endbfchar")
+			for i := 0; i+1 < len(items); i += 2 {
+				src, ok := items[i].(pdfString)
+				if !ok || len(src) == 0 {
+					continue
+				}
+				var text string
+				switch dst := items[i+1].(type) {
+				case pdfString:
+					text = utf16BE(padUTF16(dst))
+				case pdfName:
+					text, _ = glyphText(string(dst))
+				}
+				c.chars[string(src)] = text
+				if c.keyLen == 0 {
+					c.keyLen = len(src)
+				}
+			}
+		case pdfKeyword("beginbfrange"):
+			items := readUntil(l, "endbfrange")
+			for i := 0; i+2 < len(items); i += 3 {
+				lo, ok1 := items[i].(pdfString)
+				hi, ok2 := items[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) == 0 || len(lo) != len(hi) || len(lo) > 4 {
+					continue
+				}
+				r := cmapRange{lo: codeValue(lo), hi: codeValue(hi), n: len(lo)}
+				switch dst := items[i+2].(type) {
+				case pdfString:
+					r.dst = utf16Units(padUTF16(dst))
+				case pdfArray:
+					for _, d := range dst {
+						s, _ := d.(pdfString)
+						r.dsts = append(r.dsts, utf16BE(padUTF16(s)))
+					}
+				}
+				if r.hi >= r.lo {
+					c.ranges = append(c.ranges, r)
+				}
+				if c.keyLen == 0 {
+					c.keyLen = len(lo)
+				}
+			}
+		}
+	}
+	return c
+}
+
+func readUntil(l *pdfLexer, end string) []interface{} {
+	var items []interface{}
+	for {
+		obj, err := l.object()
+		if err != nil || obj == pdfKeyword(end) {
+			return items
+		}
+		items = append(items, obj)
+	}
+}
+
+// padUTF16 fixes destinations written as single bytes.
+func padUTF16(b []byte) []byte {
+	if len(b)%2 == 1 {
+		return append([]byte{0}, b...)
+	}
+	return b
+}
+
+func utf16Units(b []byte) []uint16 {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return units
+}
+
+func codeValue(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+// codeLength returns the byte length of the character code starting s.
+func (c *pdfCMap) codeLength(s []byte, composite bool) int {
+	for n := 1; n <= 4 && n <= len(s); n++ {
+		for _, sp := range c.spaces {
+			if len(sp[0]) != n {
+				continue
+			}
+			in := true
+			for k := 0; k < n; k++ {
+				if s[k] < sp[0][k] || s[k] > sp[1][k] {
+					in = false
+					break
+				}
+			}
+			if in {
+				return n
+			}
+		}
+	}
+	switch {
+	case c.keyLen > 0:
+		return c.keyLen
+	case composite:
+		return 2
+	}
+	return 1
+}
+
+func (c *pdfCMap) lookup(code []byte) (string, bool) {
+	if text, ok := c.chars[string(code)]; ok {
+		return text, true
+	}
+	v := codeValue(code)
+	for _, r := range c.ranges {
+		if r.n != len(code) || v < r.lo || v > r.hi {
+			continue
+		}
+		offset := v - r.lo
+		if r.dsts != nil {
+			if int(offset) < len(r.dsts) {
+				return r.dsts[offset], true
+			}
+			return "", false
+		}
+		if len(r.dst) == 0 {
+			return "", false
+		}
+		units := append([]uint16(nil), r.dst...)
+		units[len(units)-1] += uint16(offset)
+		return string(utf16.Decode(units)), true
+	}
+	return "", false
+}
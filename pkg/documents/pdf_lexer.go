@@ -0,0 +1,361 @@
+package documents
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// PDF object model. Numbers are float64, booleans bool and null nil.
+type (
+	pdfName    string
+	pdfString  []byte
+	pdfKeyword string // operators and structural keywords
+	pdfArray   []interface{}
+	pdfDict    map[pdfName]interface{}
+	pdfRef     struct{ num, gen int }
+	pdfStream  struct {
+		dict pdfDict
+		raw  []byte
+	}
+)
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isPDFDelim(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// pdfLexer reads PDF objects from the file body or from a content stream.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// token returns the next token. Delimiters of arrays and dictionaries are
+// returned as keywords.
+func (l *pdfLexer) token() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return l.literalString(), nil
+	case c == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfKeyword("<<"), nil
+		}
+		l.pos++
+		return l.hexString(), nil
+	case c == '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfKeyword(">>"), nil
+		}
+		l.pos++
+		return l.token()
+	case c == '[' || c == ']' || c == '{' || c == '}':
+		l.pos++
+		return pdfKeyword(c), nil
+	case c == ')':
+		l.pos++
+		return l.token()
+	case c == '/':
+		l.pos++
+		return l.name(), nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+	if c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9') {
+		if f, err := strconv.ParseFloat(word, 64); err == nil {
+			return f, nil
+		}
+	}
+	return pdfKeyword(word), nil
+}
+
+func (l *pdfLexer) name() pdfName {
+	var b []byte
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isPDFSpace(c) || isPDFDelim(c) {
+			break
+		}
+		if c == '#' && l.pos+2 < len(l.data) {
+			if v, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+	return pdfName(b)
+}
+
+func (l *pdfLexer) literalString() pdfString {
+	var b []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return b
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return b
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+func (l *pdfLexer) hexString() pdfString {
+	var b []byte
+	var hi byte
+	odd := false
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		v, ok := hexValue(c)
+		if !ok {
+			continue
+		}
+		if odd {
+			b = append(b, hi<<4|v)
+		} else {
+			hi = v
+		}
+		odd = !odd
+	}
+	if odd {
+		b = append(b, hi<<4)
+	}
+	return b
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// object parses the next complete object, resolving "n g R" into a pdfRef.
+// The closing delimiters "]" and ">>" are returned as keywords.
+func (l *pdfLexer) object() (interface{}, error) {
+	tok, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case pdfKeyword:
+		switch t {
+		case "[":
+			var arr pdfArray
+			for {
+				v, err := l.object()
+				if err != nil {
+					return arr, err
+				}
+				if v == pdfKeyword("]") {
+					return arr, nil
+				}
+				arr = append(arr, v)
+			}
+		case "<<":
+			dict := make(pdfDict)
+			for {
+				k, err := l.object()
+				if err != nil {
+					return dict, err
+				}
+				if k == pdfKeyword(">>") {
+					return dict, nil
+				}
+				key, ok := k.(pdfName)
+				if !ok {
+					continue
+				}
+				v, err := l.object()
+				if err != nil {
+					return dict, err
+				}
+				if v == pdfKeyword(">>") {
+					return dict, nil
+				}
+				dict[key] = v
+			}
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t, nil
+
+	case float64:
+		if t == float64(int(t)) && t >= 0 {
+			save := l.pos
+			if gen, err := l.token(); err == nil {
+				if g, ok := gen.(float64); ok && g == float64(int(g)) {
+					if r, err := l.token(); err == nil && r == pdfKeyword("R") {
+						return pdfRef{int(t), int(g)}, nil
+					}
+				}
+			}
+			l.pos = save
+		}
+		return t, nil
+	}
+	return tok, nil
+}
+
+// streamBody reads the data following a stream dictionary, if any.
+func (l *pdfLexer) streamBody(dict pdfDict) ([]byte, bool) {
+	save := l.pos
+	l.skipSpace()
+	if !bytes.HasPrefix(l.data[l.pos:], []byte("stream")) {
+		l.pos = save
+		return nil, false
+	}
+	l.pos += len("stream")
+	if l.pos < len(l.data) && l.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+
+	// Trust /Length only when endstream follows it; it may also be an
+	// indirect reference we cannot resolve yet
+	if n, ok := dict["Length"].(float64); ok && n >= 0 && start+int(n) <= len(l.data) {
+		end := start + int(n)
+		rest := bytes.TrimLeft(l.data[end:min(end+16, len(l.data))], "\r\n \t")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			l.pos = end
+			l.skipSpace()
+			l.pos += len("endstream")
+			return l.data[start:end], true
+		}
+	}
+
+	idx := bytes.Index(l.data[start:], []byte("endstream"))
+	if idx < 0 {
+		l.pos = len(l.data)
+		return l.data[start:], true
+	}
+	end := start + idx
+	l.pos = end + len("endstream")
+	if end > start && l.data[end-1] == '\n' {
+		end--
+	}
+	if end > start && l.data[end-1] == '\r' {
+		end--
+	}
+	return l.data[start:end], true
+}
+
+// skipInlineImage moves past the binary data of an inline image, which
+// starts after the ID operator and ends with EI.
+func (l *pdfLexer) skipInlineImage() {
+	l.pos++
+	for l.pos+2 <= len(l.data) {
+		idx := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if idx < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		at := l.pos + idx
+		l.pos = at + 2
+		before := at == 0 || isPDFSpace(l.data[at-1])
+		after := l.pos >= len(l.data) || isPDFSpace(l.data[l.pos])
+		if before && after {
+			return
+		}
+	}
+}
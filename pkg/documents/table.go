@@ -0,0 +1,152 @@
+package documents
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const maxCellChars = 300
+
+// table is a grid of cells read from a spreadsheet or CSV file. The first
+// row may be a header.
+type table struct {
+	rows   [][]string
+	header bool // the first row is known to be a header
+}
+
+// looksLikeHeader reports whether row reads like column titles: mostly
+// filled, no duplicates and nothing numeric.
+func looksLikeHeader(row []string) bool {
+	filled := 0
+	seen := make(map[string]bool)
+	for _, cell := range row {
+		cell = strings.TrimSpace(cell)
+		if cell == "" {
+			continue
+		}
+		if isNumeric(cell) || seen[cell] {
+			return false
+		}
+		seen[cell] = true
+		filled++
+	}
+	return filled > 0 && filled*2 >= len(row)
+}
+
+func isNumeric(s string) bool {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(s, "%")
+	s = strings.TrimLeft(s, "$€£¥")
+	s = strings.ReplaceAll(s, ",", "")
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// render formats the table as Markdown. The header is detected from the
+// first row, otherwise columns are named A, B, C like a spreadsheet. Only
+// the 1-based data rows in selected are included.
+func (t *table) render(selected []int) string {
+	if len(t.rows) == 0 {
+		return "(empty)"
+	}
+
+	width := 0
+	for _, row := range t.rows {
+		for i := len(row) - 1; i >= 0; i-- {
+			if strings.TrimSpace(row[i]) != "" {
+				width = max(width, i+1)
+				break
+			}
+		}
+	}
+	if width == 0 {
+		return "(empty)"
+	}
+
+	header, body := t.split()
+	if header == nil {
+		header = make([]string, width)
+		for i := range header {
+			header[i] = columnName(i)
+		}
+	}
+
+	var sb strings.Builder
+	writeRow := func(row []string) {
+		sb.WriteString("|")
+		for i := 0; i < width; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = cleanCell(row[i])
+			}
+			sb.WriteString(" " + cell + " |")
+		}
+		sb.WriteString("\n")
+	}
+	writeRow(header)
+	sb.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+	for _, i := range selected {
+		if i >= 1 && i <= len(body) {
+			writeRow(body[i-1])
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// split separates the header row, if any, from the data rows. A lone row
+// is treated as data.
+func (t *table) split() ([]string, [][]string) {
+	if len(t.rows) > 0 && (t.header || (len(t.rows) > 1 && looksLikeHeader(t.rows[0]))) {
+		return t.rows[0], t.rows[1:]
+	}
+	return nil, t.rows
+}
+
+// dataRows returns the number of rows below the header.
+func (t *table) dataRows() int {
+	_, body := t.split()
+	return len(body)
+}
+
+func cleanCell(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "|", `\|`).Replace(s)
+	if r := []rune(s); len(r) > maxCellChars {
+		s = string(r[:maxCellChars]) + "…"
+	}
+	return s
+}
+
+// columnName returns the spreadsheet name of the 0-based column i.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// selectRows picks the data rows to show. Without a spec the first
+// DefaultMaxRows rows are returned, with a note when more exist.
+func selectRows(spec string, total int) ([]int, string, error) {
+	if total == 0 {
+		return nil, "no data rows", nil
+	}
+	if strings.TrimSpace(spec) == "" {
+		n := min(total, DefaultMaxRows)
+		rows, _ := selectRange("", n, "row", nil)
+		if n < total {
+			return rows, fmt.Sprintf("rows 1-%d of %d; use rows=\"%d-\" for more", n, total, n+1), nil
+		}
+		return rows, fmt.Sprintf("%d rows", total), nil
+	}
+	rows, err := selectRange(spec, total, "row", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(rows) == total {
+		return rows, fmt.Sprintf("%d rows", total), nil
+	}
+	return rows, fmt.Sprintf("rows %s of %d", strings.ReplaceAll(spec, " ", ""), total), nil
+}
@@ -0,0 +1,268 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// ModelID is the model name the OpenAI-compatible API reports.
+const ModelID = "mypicoclaw"
+
+// sessionHeader picks the session of a chat completion request; without it
+// the "user" field does.
+const sessionHeader = "X-Session-Key"
+
+// Replies passes on the messages the agent sends to an API chat besides
+// its direct reply.
+type Replies interface {
+	Subscribe(chatID string) (<-chan string, func())
+}
+
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	User     string        `json:"user"`
+}
+
+// text returns the text of a message whose content is a string or a list
+// of content parts.
+func (m chatMessage) text() string {
+	var s string
+	if json.Unmarshal(m.Content, &s) == nil {
+		return s
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(m.Content, &parts)
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data": []map[string]interface{}{{
+			"id":       ModelID,
+			"object":   "model",
+			"created":  0,
+			"owned_by": "mypicoclaw",
+		}},
+	})
+}
+
+// handleChatCompletions answers an OpenAI chat completion request with the
+// agent. The agent keeps the conversation history itself, so only the last
+// user message of the request is used.
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req chatRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	content := ""
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			content = req.Messages[i].text()
+			break
+		}
+	}
+	if strings.TrimSpace(content) == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "messages must end with a user message")
+		return
+	}
+
+	chatID := req.User
+	if chatID == "" {
+		chatID = "default"
+	}
+	sessionKey := r.Header.Get(sessionHeader)
+	if sessionKey == "" {
+		sessionKey = "api:" + chatID
+	}
+	if !validSessionKey(sessionKey) || !validSessionKey(chatID) {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid user or "+sessionHeader)
+		return
+	}
+	model := req.Model
+	if model == "" {
+		model = ModelID
+	}
+
+	logger.InfoCF("gateway", "Chat completion request",
+		map[string]interface{}{
+			"session_key": sessionKey,
+			"stream":      req.Stream,
+			"preview":     utils.Truncate(content, 80),
+		})
+
+	// Messages the agent sends with the message tool are part of the answer
+	var extra <-chan string
+	if s.replies != nil {
+		var cancel func()
+		extra, cancel = s.replies.Subscribe(chatID)
+		defer cancel()
+	}
+
+	type result struct {
+		reply string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		reply, err := s.agent.ProcessDirectWithChannel(r.Context(), content, sessionKey, "api", chatID)
+		done <- result{reply, err}
+	}()
+
+	id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+
+	if !req.Stream {
+		var parts []string
+		for {
+			select {
+			case msg := <-extra:
+				parts = append(parts, msg)
+				continue
+			case res := <-done:
+				if res.err != nil {
+					writeOpenAIError(w, http.StatusInternalServerError, res.err.Error())
+					return
+				}
+				parts = append(parts, drain(extra, res.reply == "" && len(parts) == 0)...)
+				if res.reply != "" {
+					parts = append(parts, res.reply)
+				}
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"id":      id,
+					"object":  "chat.completion",
+					"created": created,
+					"model":   model,
+					"choices": []map[string]interface{}{{
+						"index":         0,
+						"message":       map[string]string{"role": "assistant", "content": strings.Join(parts, "\n\n")},
+						"finish_reason": "stop",
+					}},
+					"usage": map[string]int{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0},
+				})
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	sent := 0
+	send := func(delta map[string]string, finish interface{}) {
+		chunk := map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finish,
+			}},
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	sendText := func(text string) {
+		if sent > 0 {
+			text = "\n\n" + text
+		}
+		send(map[string]string{"content": text}, nil)
+		sent++
+	}
+
+	send(map[string]string{"role": "assistant"}, nil)
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg := <-extra:
+			sendText(msg)
+		case res := <-done:
+			if res.err != nil {
+				sendText("Error: " + res.err.Error())
+			} else {
+				for _, msg := range drain(extra, res.reply == "" && sent == 0) {
+					sendText(msg)
+				}
+				if res.reply != "" {
+					sendText(res.reply)
+				}
+			}
+			send(map[string]string{}, "stop")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			flusher.Flush()
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// drain returns the messages already waiting on ch. Outbound messages are
+// dispatched asynchronously, so when the agent answered only through the
+// message tool, wait briefly for them to arrive.
+func drain(ch <-chan string, wait bool) []string {
+	var out []string
+	if wait {
+		select {
+		case msg := <-ch:
+			out = append(out, msg)
+		case <-time.After(500 * time.Millisecond):
+			return nil
+		}
+	}
+	for {
+		select {
+		case msg := <-ch:
+			out = append(out, msg)
+		default:
+			return out
+		}
+	}
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	errType := "invalid_request_error"
+	if status >= 500 {
+		errType = "server_error"
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"message": message, "type": errType},
+	})
+}
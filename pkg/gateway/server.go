@@ -0,0 +1,406 @@
+// Package gateway serves the HTTP API of a running gateway: injecting
+// messages into the agent, reading sessions, managing cron jobs, reporting
+// health, and an OpenAI-compatible chat completions endpoint. Every
+// endpoint requires the bearer token from the gateway config, except those
+// of handlers mounted by channels such as the web chat UI.
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/cron"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/session"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// maxBodyBytes bounds request bodies.
+const maxBodyBytes = 1 << 20
+
+// Agent processes an inbound message and returns the reply.
+type Agent interface {
+	ProcessInbound(ctx context.Context, msg bus.InboundMessage) (string, error)
+	ProcessDirectWithChannel(ctx context.Context, content, sessionKey, channel, chatID string) (string, error)
+}
+
+// StatusReporter reports the state of each channel.
+type StatusReporter interface {
+	GetStatus() map[string]interface{}
+}
+
+type Server struct {
+	cfg      config.GatewayConfig
+	agent    Agent
+	sessions *session.SessionManager
+	cron     *cron.CronService
+	channels StatusReporter
+	replies  Replies
+	mounts   map[string]http.Handler
+	server   *http.Server
+	client   *http.Client
+	mux      *http.ServeMux
+}
+
+func NewServer(cfg config.GatewayConfig, agent Agent, sessions *session.SessionManager, cronService *cron.CronService, channels StatusReporter) *Server {
+	s := &Server{
+		cfg:      cfg,
+		agent:    agent,
+		sessions: sessions,
+		cron:     cronService,
+		channels: channels,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	s.mux.HandleFunc("POST /v1/messages", s.handleMessage)
+	s.mux.HandleFunc("GET /v1/sessions", s.handleListSessions)
+	s.mux.HandleFunc("GET /v1/sessions/{key}", s.handleGetSession)
+	s.mux.HandleFunc("GET /v1/cron/jobs", s.handleListJobs)
+	s.mux.HandleFunc("POST /v1/cron/jobs", s.handleAddJob)
+	s.mux.HandleFunc("DELETE /v1/cron/jobs/{id}", s.handleRemoveJob)
+	s.mux.HandleFunc("GET /v1/models", s.handleModels)
+	s.mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+	return s
+}
+
+// SetReplies passes the messages the agent sends to API chats on to open
+// chat completion requests.
+func (s *Server) SetReplies(replies Replies) {
+	s.replies = replies
+}
+
+// Mount serves handler under prefix, e.g. "/web/". Mounted handlers do
+// their own authentication.
+func (s *Server) Mount(prefix string, handler http.Handler) {
+	if s.mounts == nil {
+		s.mounts = make(map[string]http.Handler)
+	}
+	s.mounts[prefix] = handler
+}
+
+// Handler returns the API with authentication applied, along with the
+// mounted handlers.
+func (s *Server) Handler() http.Handler {
+	api := s.authenticate(s.mux)
+	if len(s.mounts) == 0 {
+		return api
+	}
+	root := http.NewServeMux()
+	for prefix, handler := range s.mounts {
+		root.Handle(prefix, handler)
+	}
+	root.Handle("/", api)
+	return root
+}
+
+// Start listens on the configured host and port and serves in the
+// background.
+func (s *Server) Start() error {
+	if s.cfg.Token == "" {
+		return fmt.Errorf("gateway.token is not set")
+	}
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.ErrorCF("gateway", "HTTP server stopped",
+				map[string]interface{}{
+					"error": err.Error(),
+				})
+		}
+	}()
+	logger.InfoCF("gateway", "HTTP API listening",
+		map[string]interface{}{
+			"addr": addr,
+		})
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.cfg.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mypicoclaw"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := map[string]interface{}{"status": "ok"}
+	if s.channels != nil {
+		status["channels"] = s.channels.GetStatus()
+	}
+	if s.cron != nil {
+		status["cron"] = s.cron.Status()
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+type messageRequest struct {
+	Channel     string            `json:"channel"`
+	SenderID    string            `json:"sender_id"`
+	ChatID      string            `json:"chat_id"`
+	Content     string            `json:"content"`
+	Media       []string          `json:"media"`
+	SessionKey  string            `json:"session_key"`
+	Metadata    map[string]string `json:"metadata"`
+	CallbackURL string            `json:"callback_url"`
+}
+
+type messageResponse struct {
+	SessionKey string `json:"session_key"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// handleMessage runs a message through the agent. It replies with the
+// agent's answer, or with 202 Accepted when callback_url is set, in which
+// case the answer is POSTed there once ready.
+func (s *Server) handleMessage(w http.ResponseWriter, r *http.Request) {
+	var req messageRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = "api"
+	}
+	if req.SenderID == "" {
+		req.SenderID = "api"
+	}
+	if req.ChatID == "" {
+		req.ChatID = req.SenderID
+	}
+	if req.SessionKey == "" {
+		req.SessionKey = req.Channel + ":" + req.ChatID
+	}
+	if !validSessionKey(req.SessionKey) {
+		writeError(w, http.StatusBadRequest, "invalid session_key")
+		return
+	}
+	if req.CallbackURL != "" && !strings.HasPrefix(req.CallbackURL, "http://") && !strings.HasPrefix(req.CallbackURL, "https://") {
+		writeError(w, http.StatusBadRequest, "callback_url must be an http(s) URL")
+		return
+	}
+
+	msg := bus.InboundMessage{
+		Channel:    req.Channel,
+		SenderID:   req.SenderID,
+		ChatID:     req.ChatID,
+		Content:    req.Content,
+		Media:      req.Media,
+		SessionKey: req.SessionKey,
+		Metadata:   req.Metadata,
+		// Messages not posted on behalf of a channel user act for the owner
+		Trusted: req.Channel == "api",
+	}
+	logger.InfoCF("gateway", "Message received over HTTP",
+		map[string]interface{}{
+			"session_key": msg.SessionKey,
+			"preview":     utils.Truncate(msg.Content, 80),
+			"callback":    req.CallbackURL != "",
+		})
+
+	if req.CallbackURL == "" {
+		reply, err := s.agent.ProcessInbound(r.Context(), msg)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, messageResponse{SessionKey: msg.SessionKey, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, messageResponse{SessionKey: msg.SessionKey, Response: reply})
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		result := messageResponse{SessionKey: msg.SessionKey}
+		reply, err := s.agent.ProcessInbound(ctx, msg)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Response = reply
+		}
+		s.sendCallback(ctx, req.CallbackURL, result)
+	}()
+	writeJSON(w, http.StatusAccepted, messageResponse{SessionKey: msg.SessionKey})
+}
+
+func (s *Server) sendCallback(ctx context.Context, url string, result messageResponse) {
+	body, _ := json.Marshal(result)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		var resp *http.Response
+		if resp, err = s.client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("callback returned %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		logger.WarnCF("gateway", "Failed to deliver reply to callback",
+			map[string]interface{}{
+				"url":   url,
+				"error": err.Error(),
+			})
+	}
+}
+
+type sessionSummary struct {
+	Key      string    `json:"key"`
+	Messages int       `json:"messages"`
+	Summary  string    `json:"summary,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	list := s.sessions.List()
+	out := make([]sessionSummary, 0, len(list))
+	for _, sess := range list {
+		out = append(out, sessionSummary{
+			Key:      sess.Key,
+			Messages: len(sess.Messages),
+			Summary:  sess.Summary,
+			Created:  sess.Created,
+			Updated:  sess.Updated,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": out})
+}
+
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.sessions.Get(r.PathValue("key"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, sess)
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := s.cron.ListJobs(true)
+	if jobs == nil {
+		jobs = []cron.CronJob{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": jobs})
+}
+
+type jobRequest struct {
+	Name         string `json:"name"`
+	Message      string `json:"message"`
+	AtSeconds    int64  `json:"at_seconds"`
+	EverySeconds int64  `json:"every_seconds"`
+	CronExpr     string `json:"cron_expr"`
+	Deliver      bool   `json:"deliver"`
+	Channel      string `json:"channel"`
+	To           string `json:"to"`
+}
+
+// handleAddJob adds a cron job. Like `cron add`, the message is run
+// through the agent unless deliver is set, in which case it is sent as is.
+func (s *Server) handleAddJob(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeError(w, http.StatusBadRequest, "message is required")
+		return
+	}
+
+	var schedule cron.CronSchedule
+	switch {
+	case req.AtSeconds > 0:
+		atMS := time.Now().UnixMilli() + req.AtSeconds*1000
+		schedule = cron.CronSchedule{Kind: "at", AtMS: &atMS}
+	case req.EverySeconds > 0:
+		everyMS := req.EverySeconds * 1000
+		schedule = cron.CronSchedule{Kind: "every", EveryMS: &everyMS}
+	case req.CronExpr != "":
+		schedule = cron.CronSchedule{Kind: "cron", Expr: req.CronExpr}
+	default:
+		writeError(w, http.StatusBadRequest, "one of at_seconds, every_seconds or cron_expr is required")
+		return
+	}
+	if req.Name == "" {
+		req.Name = utils.Truncate(req.Message, 30)
+	}
+
+	job, err := s.cron.AddJob(req.Name, schedule, req.Message, req.Deliver, req.Channel, req.To)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func (s *Server) handleRemoveJob(w http.ResponseWriter, r *http.Request) {
+	if !s.cron.RemoveJob(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var sessionKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.:@-]{1,128}$`)
+
+// validSessionKey rejects keys that could escape the sessions directory,
+// since a session is saved as <key>.json.
+func validSessionKey(key string) bool {
+	return sessionKeyPattern.MatchString(key) && !strings.Contains(key, "..")
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,232 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/cron"
+	"github.com/weiwei929/mypicoclaw/pkg/session"
+)
+
+type echoAgent struct {
+	sessions *session.SessionManager
+}
+
+func (a *echoAgent) ProcessDirectWithChannel(ctx context.Context, content, sessionKey, channel, chatID string) (string, error) {
+	return a.ProcessInbound(ctx, bus.InboundMessage{Channel: channel, ChatID: chatID, Content: content, SessionKey: sessionKey})
+}
+
+func (a *echoAgent) ProcessInbound(ctx context.Context, msg bus.InboundMessage) (string, error) {
+	a.sessions.AddMessage(msg.SessionKey, "user", msg.Content)
+	return "echo: " + msg.Content, nil
+}
+
+type fakeChannels struct{}
+
+func (fakeChannels) GetStatus() map[string]interface{} {
+	return map[string]interface{}{"telegram": map[string]interface{}{"enabled": true, "running": true}}
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *session.SessionManager) {
+	dir := t.TempDir()
+	sessions := session.NewSessionManager(filepath.Join(dir, "sessions"))
+	cronService := cron.NewCronService(filepath.Join(dir, "cron", "jobs.json"), nil)
+	s := NewServer(config.GatewayConfig{Token: "secret"}, &echoAgent{sessions}, sessions, cronService, fakeChannels{})
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts, sessions
+}
+
+func call(t *testing.T, ts *httptest.Server, method, path, token, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var out map[string]interface{}
+	json.Unmarshal(data, &out)
+	return resp.StatusCode, out
+}
+
+func TestAuth(t *testing.T) {
+	ts, _ := newTestServer(t)
+	for _, token := range []string{"", "wrong"} {
+		if code, _ := call(t, ts, "GET", "/healthz", token, ""); code != http.StatusUnauthorized {
+			t.Errorf("token %q: status %d", token, code)
+		}
+	}
+	code, body := call(t, ts, "GET", "/healthz", "secret", "")
+	if code != http.StatusOK || body["channels"].(map[string]interface{})["telegram"] == nil {
+		t.Fatalf("healthz = %d %v", code, body)
+	}
+}
+
+func TestMount(t *testing.T) {
+	s := NewServer(config.GatewayConfig{Token: "secret"}, nil, nil, nil, fakeChannels{})
+	s.Mount("/web/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"mounted":true}`))
+	}))
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	if code, body := call(t, ts, "GET", "/web/", "", ""); code != http.StatusOK || body["mounted"] != true {
+		t.Errorf("mounted handler = %d %v", code, body)
+	}
+	if code, _ := call(t, ts, "GET", "/healthz", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("API without token = %d", code)
+	}
+}
+
+func TestMessagesAndSessions(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	code, body := call(t, ts, "POST", "/v1/messages", "secret", `{"content": "hi", "chat_id": "ops"}`)
+	if code != http.StatusOK || body["response"] != "echo: hi" || body["session_key"] != "api:ops" {
+		t.Fatalf("POST /v1/messages = %d %v", code, body)
+	}
+	if code, _ := call(t, ts, "POST", "/v1/messages", "secret", `{"content": "x", "session_key": "../../etc/passwd"}`); code != http.StatusBadRequest {
+		t.Errorf("path-like session key: status %d", code)
+	}
+
+	code, body = call(t, ts, "GET", "/v1/sessions", "secret", "")
+	list := body["sessions"].([]interface{})
+	if code != http.StatusOK || len(list) != 1 || list[0].(map[string]interface{})["messages"] != 1.0 {
+		t.Fatalf("GET /v1/sessions = %d %v", code, body)
+	}
+	if code, body = call(t, ts, "GET", "/v1/sessions/api:ops", "secret", ""); code != http.StatusOK || body["key"] != "api:ops" {
+		t.Fatalf("GET /v1/sessions/api:ops = %d %v", code, body)
+	}
+	if code, _ = call(t, ts, "GET", "/v1/sessions/nope", "secret", ""); code != http.StatusNotFound {
+		t.Errorf("missing session: status %d", code)
+	}
+}
+
+func TestMessageCallback(t *testing.T) {
+	ts, _ := newTestServer(t)
+	got := make(chan messageResponse, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res messageResponse
+		json.NewDecoder(r.Body).Decode(&res)
+		got <- res
+	}))
+	defer callback.Close()
+
+	code, _ := call(t, ts, "POST", "/v1/messages", "secret", `{"content": "later", "callback_url": "`+callback.URL+`"}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("status %d", code)
+	}
+	select {
+	case res := <-got:
+		if res.Response != "echo: later" || res.SessionKey != "api:api" {
+			t.Fatalf("callback got %+v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback was not called")
+	}
+}
+
+func TestCronJobs(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	if code, _ := call(t, ts, "POST", "/v1/cron/jobs", "secret", `{"message": "ping"}`); code != http.StatusBadRequest {
+		t.Errorf("job without schedule: status %d", code)
+	}
+	code, job := call(t, ts, "POST", "/v1/cron/jobs", "secret", `{"message": "check the backups", "every_seconds": 3600}`)
+	if code != http.StatusCreated || job["id"] == "" {
+		t.Fatalf("POST /v1/cron/jobs = %d %v", code, job)
+	}
+	_, body := call(t, ts, "GET", "/v1/cron/jobs", "secret", "")
+	if jobs := body["jobs"].([]interface{}); len(jobs) != 1 {
+		t.Fatalf("listed %d jobs", len(jobs))
+	}
+	if code, _ := call(t, ts, "DELETE", "/v1/cron/jobs/"+job["id"].(string), "secret", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE status %d", code)
+	}
+	if code, _ := call(t, ts, "DELETE", "/v1/cron/jobs/"+job["id"].(string), "secret", ""); code != http.StatusNotFound {
+		t.Errorf("second DELETE status %d", code)
+	}
+}
+
+func TestChatCompletions(t *testing.T) {
+	ts, sessions := newTestServer(t)
+
+	code, body := call(t, ts, "GET", "/v1/models", "secret", "")
+	if code != http.StatusOK || body["data"].([]interface{})[0].(map[string]interface{})["id"] != ModelID {
+		t.Fatalf("GET /v1/models = %d %v", code, body)
+	}
+
+	code, body = call(t, ts, "POST", "/v1/chat/completions", "secret",
+		`{"model": "mypicoclaw", "user": "alice", "messages": [{"role": "system", "content": "be brief"}, {"role": "user", "content": [{"type": "text", "text": "hi"}]}]}`)
+	if code != http.StatusOK {
+		t.Fatalf("POST /v1/chat/completions = %d %v", code, body)
+	}
+	message := body["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})
+	if message["content"] != "echo: hi" {
+		t.Errorf("content = %v", message["content"])
+	}
+	if _, ok := sessions.Get("api:alice"); !ok {
+		t.Error("user field did not select session api:alice")
+	}
+	if code, _ := call(t, ts, "POST", "/v1/chat/completions", "secret", `{"messages": [{"role": "assistant", "content": "hi"}]}`); code != http.StatusBadRequest {
+		t.Errorf("no user message: status %d", code)
+	}
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	ts, sessions := newTestServer(t)
+
+	req, _ := http.NewRequest("POST", ts.URL+"/v1/chat/completions",
+		strings.NewReader(`{"stream": true, "messages": [{"role": "user", "content": "hi"}]}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set(sessionHeader, "api:team")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+
+	var content strings.Builder
+	var events []string
+	for _, line := range strings.Split(string(data), "\n") {
+		payload, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		events = append(events, payload)
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if json.Unmarshal([]byte(payload), &chunk) == nil && len(chunk.Choices) > 0 {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if len(events) == 0 || events[len(events)-1] != "[DONE]" {
+		t.Fatalf("stream did not end with [DONE]: %q", data)
+	}
+	if content.String() != "echo: hi" {
+		t.Errorf("streamed content = %q", content.String())
+	}
+	if _, ok := sessions.Get("api:team"); !ok {
+		t.Errorf("%s header did not select the session", sessionHeader)
+	}
+}
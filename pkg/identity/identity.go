@@ -0,0 +1,325 @@
+// Package identity links the accounts one person uses on different channels,
+// such as "telegram:123" and "discord:456", to a single canonical user.
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// DefaultPairingTTL is how long a pairing code stays valid.
+const DefaultPairingTTL = 10 * time.Minute
+
+// maxFailedConfirms is how many wrong codes, sent from any account, a
+// pending code survives. Any of them may have been a guess at it, so it is
+// then dropped and has to be issued again.
+const maxFailedConfirms = 5
+
+// Identity is one person and the channel accounts known to belong to them.
+type Identity struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Accounts  []string  `json:"accounts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type pairing struct {
+	account string
+	expires time.Time
+	misses  int
+}
+
+// Registry is a JSON file of identities, reloaded when another process such
+// as the CLI changes it. Pairing codes are kept in memory only. It is safe
+// for concurrent use.
+type Registry struct {
+	path string
+	ttl  time.Duration
+
+	mu         sync.Mutex
+	modTime    time.Time
+	size       int64
+	identities []*Identity
+	byAccount  map[string]*Identity
+	codes      map[string]*pairing
+}
+
+type registryFile struct {
+	Identities []*Identity `json:"identities"`
+}
+
+// Open loads the identities kept in path; a missing file means none.
+func Open(path string, ttl time.Duration) (*Registry, error) {
+	if ttl <= 0 {
+		ttl = DefaultPairingTTL
+	}
+	r := &Registry{
+		path:  path,
+		ttl:   ttl,
+		codes: make(map[string]*pairing),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Registry) load() error {
+	r.identities = nil
+	r.byAccount = make(map[string]*Identity)
+	r.modTime, r.size = time.Time{}, 0
+
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to read identities: %w", err)
+	}
+	var f registryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", r.path, err)
+	}
+	r.modTime, r.size = info.ModTime(), info.Size()
+	r.identities = f.Identities
+	for _, id := range r.identities {
+		for _, acc := range id.Accounts {
+			r.byAccount[acc] = id
+		}
+	}
+	return nil
+}
+
+// refresh reloads the file if it changed since it was read. A file that
+// cannot be parsed keeps the identities already loaded.
+func (r *Registry) refresh() {
+	info, err := os.Stat(r.path)
+	if err != nil || info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return
+	}
+	identities, byAccount := r.identities, r.byAccount
+	if err := r.load(); err != nil {
+		r.identities, r.byAccount = identities, byAccount
+	}
+}
+
+func (r *Registry) save() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(registryFile{Identities: r.identities}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime, r.size = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+// Account returns the account key of a sender on a channel. Telegram sender
+// IDs carry "|username", which is dropped so a rename keeps the same
+// account.
+func Account(channel, senderID string) string {
+	if idx := strings.Index(senderID, "|"); idx > 0 {
+		senderID = senderID[:idx]
+	}
+	return channel + ":" + senderID
+}
+
+// Resolve returns the identity an account is linked to.
+func (r *Registry) Resolve(account string) (Identity, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh()
+	if id, ok := r.byAccount[account]; ok {
+		return copyIdentity(id), true
+	}
+	return Identity{}, false
+}
+
+// Get returns the identity with the given ID.
+func (r *Registry) Get(id string) (Identity, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh()
+	for _, ident := range r.identities {
+		if ident.ID == id {
+			return copyIdentity(ident), true
+		}
+	}
+	return Identity{}, false
+}
+
+// List returns all identities.
+func (r *Registry) List() []Identity {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh()
+	out := make([]Identity, 0, len(r.identities))
+	for _, id := range r.identities {
+		out = append(out, copyIdentity(id))
+	}
+	return out
+}
+
+// StartPairing issues a one-time code for account. Sending the code from
+// another account with ConfirmPairing links the two. A new code replaces
+// the account's previous one.
+func (r *Registry) StartPairing(account string) (string, time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for code, p := range r.codes {
+		if p.account == account || now.After(p.expires) {
+			delete(r.codes, code)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		code := fmt.Sprintf("%06d", n.Int64())
+		if _, taken := r.codes[code]; taken {
+			continue
+		}
+		expires := now.Add(r.ttl)
+		r.codes[code] = &pairing{account: account, expires: expires}
+		return code, expires, nil
+	}
+	return "", time.Time{}, fmt.Errorf("failed to issue a pairing code, try again")
+}
+
+// ConfirmPairing links account to the account that issued code and returns
+// the identity they now share.
+func (r *Registry) ConfirmPairing(account, code string) (Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh()
+
+	code = strings.TrimSpace(code)
+	now := time.Now()
+	p, ok := r.codes[code]
+	if !ok || now.After(p.expires) {
+		// A miss counts against every pending code, so guessing from many
+		// accounts gets no more tries at a code than guessing from one
+		for c, pending := range r.codes {
+			pending.misses++
+			if pending.misses >= maxFailedConfirms || now.After(pending.expires) {
+				delete(r.codes, c)
+			}
+		}
+		return Identity{}, fmt.Errorf("unknown or expired pairing code")
+	}
+	if p.account == account {
+		return Identity{}, fmt.Errorf("send the code from your other account")
+	}
+	delete(r.codes, code)
+
+	ident, err := r.link(p.account, account)
+	if err != nil {
+		return Identity{}, err
+	}
+	return copyIdentity(ident), nil
+}
+
+// Link links two accounts directly, as an administrator would.
+func (r *Registry) Link(a, b string) (Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh()
+	ident, err := r.link(a, b)
+	if err != nil {
+		return Identity{}, err
+	}
+	return copyIdentity(ident), nil
+}
+
+// link puts a and b in one identity: the one either already belongs to, or
+// a new one. Accounts linked to two different identities are refused.
+func (r *Registry) link(a, b string) (*Identity, error) {
+	ia, ib := r.byAccount[a], r.byAccount[b]
+	var ident *Identity
+	switch {
+	case ia != nil && ib != nil && ia != ib:
+		return nil, fmt.Errorf("%s and %s belong to different identities; unlink one first", a, b)
+	case ia != nil:
+		ident = ia
+	case ib != nil:
+		ident = ib
+	default:
+		ident = &Identity{ID: "user-" + utils.RandomID(4), CreatedAt: time.Now()}
+		r.identities = append(r.identities, ident)
+	}
+	for _, acc := range []string{a, b} {
+		if r.byAccount[acc] == nil {
+			ident.Accounts = append(ident.Accounts, acc)
+			r.byAccount[acc] = ident
+		}
+	}
+	sort.Strings(ident.Accounts)
+	if err := r.save(); err != nil {
+		return nil, fmt.Errorf("failed to save identities: %w", err)
+	}
+	return ident, nil
+}
+
+// Unlink removes account from its identity. The identity, and the memory
+// kept under its ID, stays with the remaining accounts.
+func (r *Registry) Unlink(account string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh()
+
+	ident, ok := r.byAccount[account]
+	if !ok {
+		return fmt.Errorf("%s is not linked", account)
+	}
+	delete(r.byAccount, account)
+	accounts := ident.Accounts[:0]
+	for _, acc := range ident.Accounts {
+		if acc != account {
+			accounts = append(accounts, acc)
+		}
+	}
+	ident.Accounts = accounts
+
+	if len(ident.Accounts) == 0 {
+		for i, id := range r.identities {
+			if id == ident {
+				r.identities = append(r.identities[:i], r.identities[i+1:]...)
+				break
+			}
+		}
+	}
+	if err := r.save(); err != nil {
+		return fmt.Errorf("failed to save identities: %w", err)
+	}
+	return nil
+}
+
+func copyIdentity(id *Identity) Identity {
+	c := *id
+	c.Accounts = append([]string(nil), id.Accounts...)
+	return c
+}
@@ -0,0 +1,86 @@
+package identity
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPairingLinksAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	r, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tg := Account("telegram", "123|alice")
+	if tg != "telegram:123" {
+		t.Fatalf("Account = %q", tg)
+	}
+	code, _, err := r.StartPairing(tg)
+	if err != nil || len(code) != 6 {
+		t.Fatalf("StartPairing = %q, %v", code, err)
+	}
+	if _, err := r.ConfirmPairing(tg, code); err == nil {
+		t.Fatal("confirming from the issuing account should fail")
+	}
+	ident, err := r.ConfirmPairing("discord:456", " "+code+" ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ident.Accounts, ",") != "discord:456,telegram:123" {
+		t.Fatalf("accounts = %v", ident.Accounts)
+	}
+	if _, err := r.ConfirmPairing("feishu:ou_1", code); err == nil {
+		t.Fatal("a code must only work once")
+	}
+
+	// A third account joins the same identity
+	code, _, _ = r.StartPairing("feishu:ou_1")
+	if joined, err := r.ConfirmPairing("discord:456", code); err != nil || joined.ID != ident.ID || len(joined.Accounts) != 3 {
+		t.Fatalf("ConfirmPairing = %+v, %v", joined, err)
+	}
+
+	// Reopening reads the same links; unlinking keeps the rest together
+	r2, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := r2.Resolve("feishu:ou_1"); !ok || got.ID != ident.ID {
+		t.Fatalf("Resolve after reopen = %+v, %v", got, ok)
+	}
+	if err := r2.Unlink(tg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Resolve(tg); ok {
+		t.Fatal("registry did not pick up the change made by another instance")
+	}
+	if got, ok := r.Resolve("discord:456"); !ok || got.ID != ident.ID {
+		t.Fatalf("remaining account lost its identity: %+v", got)
+	}
+}
+
+func TestLinkConflictsAndWrongCodes(t *testing.T) {
+	r, _ := Open(filepath.Join(t.TempDir(), "identities.json"), 0)
+	r.Link("telegram:1", "discord:1")
+	r.Link("telegram:2", "discord:2")
+	if _, err := r.Link("telegram:1", "discord:2"); err == nil {
+		t.Fatal("linking accounts of two identities should fail")
+	}
+
+	// Wrong codes from different accounts all count against the pending code
+	code, _, _ := r.StartPairing("telegram:3")
+	for i := 0; i < maxFailedConfirms; i++ {
+		r.ConfirmPairing(fmt.Sprintf("slack:%d", i), "bad")
+	}
+	if _, err := r.ConfirmPairing("discord:3", code); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("expected the code to be dropped after wrong codes, got %v", err)
+	}
+
+	code, _, _ = r.StartPairing("telegram:3")
+	r.ConfirmPairing("slack:9", "bad")
+	if _, err := r.ConfirmPairing("discord:3", code); err != nil {
+		t.Fatalf("a reissued code should work: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package kb
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// chunkPiece is a slice of a text file ready to be embedded.
+type chunkPiece struct {
+	Text      string
+	StartLine int // 1-based, inclusive
+	EndLine   int
+	Heading   string // Markdown heading in effect at StartLine
+}
+
+type chunkLine struct {
+	text  string
+	num   int
+	runes int
+}
+
+// chunkText splits text into pieces of at most size characters. Pieces end
+// at a blank line when one falls in their second half, and each piece
+// repeats up to overlap characters of trailing lines from the previous one.
+// Lines longer than size are split on their own.
+func chunkText(text string, size, overlap int) []chunkPiece {
+	if size <= 0 {
+		size = 1200
+	}
+	if overlap < 0 || overlap >= size/2 {
+		overlap = size / 4
+	}
+
+	lines := splitLines(text, size)
+	headings := make([]string, len(lines))
+	heading, inFence := "", false
+	for i, l := range lines {
+		t := strings.TrimSpace(l.text)
+		switch {
+		case strings.HasPrefix(t, "```") || strings.HasPrefix(t, "~~~"):
+			inFence = !inFence
+		case !inFence && isHeading(t):
+			heading = t
+		}
+		headings[i] = heading
+	}
+
+	var pieces []chunkPiece
+	start := 0
+	for {
+		for start < len(lines) && isBlank(lines[start]) {
+			start++
+		}
+		if start >= len(lines) {
+			break
+		}
+
+		end, used := start, 0
+		lastBreak, usedAtBreak := -1, 0
+		lastHeading, usedAtHeading := -1, 0
+		for end < len(lines) {
+			n := lines[end].runes + 1
+			if used+n > size && end > start {
+				break
+			}
+			switch {
+			case end > start && headings[end] != headings[end-1]:
+				lastHeading, usedAtHeading = end, used
+			case isBlank(lines[end]):
+				lastBreak, usedAtBreak = end, used
+			}
+			used += n
+			end++
+		}
+		if end < len(lines) {
+			switch {
+			case lastHeading > start && usedAtHeading >= size/4:
+				end = lastHeading
+			case lastBreak > start && usedAtBreak >= size/2:
+				end = lastBreak
+			}
+		}
+
+		piece := chunkPiece{
+			StartLine: lines[start].num,
+			EndLine:   lines[end-1].num,
+			Heading:   headings[start],
+		}
+		var sb strings.Builder
+		for i := start; i < end; i++ {
+			if i > start && lines[i].num == lines[i-1].num {
+				sb.WriteString(lines[i].text)
+				continue
+			}
+			if i > start {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(lines[i].text)
+		}
+		piece.Text = strings.TrimRight(sb.String(), " \t\n")
+		if strings.TrimSpace(lines[start].text) == piece.Heading {
+			piece.Heading = ""
+		}
+		pieces = append(pieces, piece)
+
+		if end >= len(lines) {
+			break
+		}
+		// No overlap into a new section
+		next := end
+		for next < len(lines) && isBlank(lines[next]) {
+			next++
+		}
+		if next < len(lines) && headings[next] != headings[end-1] {
+			start = next
+			continue
+		}
+		next, carried := end, 0
+		for next > start+1 {
+			n := lines[next-1].runes + 1
+			if carried+n > overlap {
+				break
+			}
+			carried += n
+			next--
+		}
+		start = next
+	}
+	return pieces
+}
+
+// splitLines breaks text into lines, cutting lines longer than size into
+// several parts that share a line number.
+func splitLines(text string, size int) []chunkLine {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var lines []chunkLine
+	for i, line := range strings.Split(text, "\n") {
+		for utf8.RuneCountInString(line) > size {
+			r := []rune(line)
+			cut := size
+			if sp := strings.LastIndexAny(string(r[:size]), " \t"); sp > 0 {
+				if n := utf8.RuneCountInString(string(r[:size])[:sp]); n >= size/2 {
+					cut = n + 1
+				}
+			}
+			lines = append(lines, chunkLine{text: string(r[:cut]), num: i + 1, runes: cut})
+			line = string(r[cut:])
+		}
+		lines = append(lines, chunkLine{text: line, num: i + 1, runes: utf8.RuneCountInString(line)})
+	}
+	return lines
+}
+
+func isBlank(l chunkLine) bool {
+	return strings.TrimSpace(l.text) == ""
+}
+
+func isHeading(line string) bool {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	return level > 0 && level <= 6 && level < len(line) && line[level] == ' '
+}
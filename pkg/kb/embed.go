@@ -0,0 +1,152 @@
+package kb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// Embedder turns texts into vectors. Implementations must return one vector
+// per input text, in order.
+type Embedder interface {
+	Model() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// OpenAIEmbedder calls an OpenAI-compatible /embeddings endpoint.
+type OpenAIEmbedder struct {
+	apiBase    string
+	apiKey     string
+	model      string
+	dimensions int
+	batchSize  int
+	httpClient *http.Client
+}
+
+// NewOpenAIEmbedder creates an embedder from the kb.embedding config section.
+func NewOpenAIEmbedder(cfg config.EmbeddingConfig) *OpenAIEmbedder {
+	e := &OpenAIEmbedder{
+		apiBase:    strings.TrimRight(cfg.APIBase, "/"),
+		apiKey:     cfg.APIKey,
+		model:      cfg.Model,
+		dimensions: cfg.Dimensions,
+		batchSize:  cfg.BatchSize,
+	}
+	if e.apiBase == "" {
+		e.apiBase = "https://api.openai.com/v1"
+	}
+	if e.model == "" {
+		e.model = "text-embedding-3-small"
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = 32
+	}
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	e.httpClient = &http.Client{Timeout: timeout}
+	return e
+}
+
+// NewEmbedderFromConfig creates the embedder for cfg.KB, borrowing the
+// OpenAI provider's key and base URL when the embedding section leaves them
+// empty.
+func NewEmbedderFromConfig(cfg *config.Config) *OpenAIEmbedder {
+	ec := cfg.KB.Embedding
+	if ec.APIBase == "" {
+		ec.APIBase = cfg.Providers.OpenAI.APIBase
+	}
+	if ec.APIKey == "" {
+		ec.APIKey = cfg.Providers.OpenAI.APIKey
+	}
+	return NewOpenAIEmbedder(ec)
+}
+
+func (e *OpenAIEmbedder) Model() string {
+	return e.model
+}
+
+type embeddingRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed sends texts in batches of the configured size.
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += e.batchSize {
+		end := min(start+e.batchSize, len(texts))
+		batch, err := e.embedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func (e *OpenAIEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	payload, err := json.Marshal(embeddingRequest{Model: e.model, Input: texts, Dimensions: e.dimensions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode embedding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.apiBase+"/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding API error (status %d): %s", resp.StatusCode, utils.Truncate(string(body), 300))
+	}
+
+	var parsed embeddingResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %w", err)
+	}
+	if len(parsed.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding API returned %d vectors for %d inputs", len(parsed.Data), len(texts))
+	}
+	sort.Slice(parsed.Data, func(i, j int) bool { return parsed.Data[i].Index < parsed.Data[j].Index })
+
+	vectors := make([][]float32, len(texts))
+	for i, d := range parsed.Data {
+		if len(d.Embedding) == 0 {
+			return nil, fmt.Errorf("embedding API returned an empty vector")
+		}
+		vectors[i] = d.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,793 @@
+// Package kb is a local knowledge base: workspace files are split into
+// chunks, embedded through an OpenAI-compatible endpoint and searched by
+// cosine similarity.
+package kb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/documents"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+)
+
+const (
+	metaFile    = "index.json"
+	vectorFile  = "index.vec"
+	metaVersion = 1
+)
+
+// skipDirs are never descended into when ingesting a directory, in addition
+// to hidden directories.
+var skipDirs = map[string]bool{
+	"node_modules": true,
+	"__pycache__":  true,
+}
+
+// FileEntry records the state of an indexed file so unchanged files can be
+// skipped on the next ingest.
+type FileEntry struct {
+	Hash    string    `json:"hash"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	Chunks  int       `json:"chunks"`
+}
+
+// Chunk is one embedded piece of a file. Its vector is stored in index.vec
+// at the same position.
+type Chunk struct {
+	Path     string `json:"path"`
+	Location string `json:"location"`
+	Heading  string `json:"heading,omitempty"`
+	Text     string `json:"text"`
+}
+
+type indexMeta struct {
+	Version int                   `json:"version"`
+	Model   string                `json:"model"`
+	Dims    int                   `json:"dims"`
+	Updated time.Time             `json:"updated"`
+	Sources []string              `json:"sources"`
+	Files   map[string]*FileEntry `json:"files"`
+	Chunks  []Chunk               `json:"chunks"`
+}
+
+// Result is a chunk matching a search.
+type Result struct {
+	Path     string // relative to the workspace when inside it
+	Location string // e.g. "lines 10-42", "page 3"
+	Heading  string
+	Text     string
+	Score    float32
+}
+
+// IngestStats summarises an Ingest call.
+type IngestStats struct {
+	Added     int
+	Updated   int
+	Unchanged int
+	Removed   int
+	Skipped   int
+	Chunks    int // chunks embedded during this call
+	Errors    []string
+}
+
+func (s IngestStats) String() string {
+	out := fmt.Sprintf("%d added, %d updated, %d unchanged, %d removed, %d skipped (%d chunks embedded)",
+		s.Added, s.Updated, s.Unchanged, s.Removed, s.Skipped, s.Chunks)
+	for _, e := range s.Errors {
+		out += "\n  " + e
+	}
+	return out
+}
+
+// Stats describes the index.
+type Stats struct {
+	Dir     string
+	Model   string
+	Dims    int
+	Files   int
+	Chunks  int
+	Bytes   int64
+	Updated time.Time
+	Sources []string
+}
+
+// Index is an on-disk vector index. It is safe for concurrent use and
+// reloads itself when another process rewrites it.
+type Index struct {
+	dir       string
+	workspace string
+	embedder  Embedder
+	cfg       config.KBConfig
+	exclude   []string
+
+	mu       sync.Mutex
+	meta     indexMeta
+	vectors  [][]float32
+	loadedAt time.Time
+	loadSize int64
+}
+
+// Open loads the index stored in dir, creating the directory if needed.
+// Paths inside workspace are shown relative to it.
+func Open(dir, workspace string, embedder Embedder, cfg config.KBConfig) (*Index, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create knowledge base directory: %w", err)
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 1200
+	}
+	if cfg.TopK <= 0 {
+		cfg.TopK = 5
+	}
+	if cfg.MaxFileBytes <= 0 {
+		cfg.MaxFileBytes = 10 * 1024 * 1024
+	}
+	idx := &Index{dir: dir, workspace: workspace, embedder: embedder, cfg: cfg}
+	if err := idx.load(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Exclude keeps dirs out of the index, for data that must not be shared
+// with everyone who can search, such as each user's own memory. Files
+// already indexed there are dropped on the next ingest and never returned
+// by Search.
+func (idx *Index) Exclude(dirs ...string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, dir := range dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			idx.exclude = append(idx.exclude, abs)
+		}
+	}
+}
+
+func (idx *Index) excluded(path string) bool {
+	for _, dir := range idx.exclude {
+		if within(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// DirFor returns the index directory configured in cfg.
+func DirFor(cfg *config.Config) string {
+	if cfg.KB.Dir != "" {
+		return config.ExpandHome(cfg.KB.Dir)
+	}
+	return filepath.Join(cfg.WorkspacePath(), "kb")
+}
+
+func (idx *Index) load() error {
+	idx.meta = indexMeta{Version: metaVersion, Files: make(map[string]*FileEntry)}
+	idx.vectors = nil
+	idx.loadedAt, idx.loadSize = time.Time{}, 0
+
+	metaPath := filepath.Join(idx.dir, metaFile)
+	info, err := os.Stat(metaPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return err
+	}
+	var meta indexMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("knowledge base index %s is corrupted: %w", metaPath, err)
+	}
+	if meta.Files == nil {
+		meta.Files = make(map[string]*FileEntry)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(idx.dir, vectorFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(raw) != len(meta.Chunks)*meta.Dims*4 {
+		logger.WarnCF("kb", "Vector file does not match index, starting over",
+			map[string]interface{}{
+				"dir":    idx.dir,
+				"chunks": len(meta.Chunks),
+			})
+		return nil
+	}
+	vectors := make([][]float32, len(meta.Chunks))
+	for i := range vectors {
+		v := make([]float32, meta.Dims)
+		for j := range v {
+			off := (i*meta.Dims + j) * 4
+			v[j] = math.Float32frombits(binary.LittleEndian.Uint32(raw[off:]))
+		}
+		vectors[i] = v
+	}
+
+	idx.meta, idx.vectors = meta, vectors
+	idx.loadedAt, idx.loadSize = info.ModTime(), info.Size()
+	return nil
+}
+
+// refresh reloads the index if index.json changed since it was read.
+func (idx *Index) refresh() error {
+	info, err := os.Stat(filepath.Join(idx.dir, metaFile))
+	if err != nil {
+		if os.IsNotExist(err) && !idx.loadedAt.IsZero() {
+			return idx.load()
+		}
+		return nil
+	}
+	if info.ModTime().Equal(idx.loadedAt) && info.Size() == idx.loadSize {
+		return nil
+	}
+	return idx.load()
+}
+
+func (idx *Index) save() error {
+	idx.meta.Version = metaVersion
+	idx.meta.Updated = time.Now()
+
+	var vec bytes.Buffer
+	vec.Grow(len(idx.vectors) * idx.meta.Dims * 4)
+	buf := make([]byte, 4)
+	for _, v := range idx.vectors {
+		for _, f := range v {
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(f))
+			vec.Write(buf)
+		}
+	}
+	if err := writeAtomic(filepath.Join(idx.dir, vectorFile), vec.Bytes()); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&idx.meta)
+	if err != nil {
+		return err
+	}
+	metaPath := filepath.Join(idx.dir, metaFile)
+	if err := writeAtomic(metaPath, data); err != nil {
+		return err
+	}
+	if info, err := os.Stat(metaPath); err == nil {
+		idx.loadedAt, idx.loadSize = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pendingFile is a new or changed file waiting for its chunks to be embedded.
+type pendingFile struct {
+	path   string
+	entry  *FileEntry
+	chunks []Chunk
+	isNew  bool
+}
+
+// Ingest adds or re-indexes files and directories. Files whose size and
+// modification time are unchanged are skipped without reading them, and
+// files that disappeared from an ingested directory are removed. With no
+// paths, the previously ingested sources are synced again.
+func (idx *Index) Ingest(ctx context.Context, paths []string) (IngestStats, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	stats, err := idx.ingest(ctx, paths)
+	if err != nil {
+		// Discard the half-applied changes
+		if loadErr := idx.load(); loadErr != nil {
+			logger.ErrorCF("kb", "Failed to reload index", map[string]interface{}{"error": loadErr.Error()})
+		}
+	}
+	return stats, err
+}
+
+func (idx *Index) ingest(ctx context.Context, paths []string) (IngestStats, error) {
+	var stats IngestStats
+	if err := idx.refresh(); err != nil {
+		return stats, err
+	}
+
+	roots := make([]string, 0, len(paths))
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return stats, err
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return stats, fmt.Errorf("cannot ingest %s: %w", p, err)
+		}
+		if idx.excluded(abs) {
+			return stats, fmt.Errorf("cannot ingest %s: it is excluded from the knowledge base", p)
+		}
+		roots = append(roots, abs)
+	}
+
+	if len(idx.meta.Chunks) > 0 && idx.meta.Model != idx.embedder.Model() {
+		logger.InfoCF("kb", "Embedding model changed, re-indexing everything",
+			map[string]interface{}{
+				"old_model": idx.meta.Model,
+				"new_model": idx.embedder.Model(),
+			})
+		roots = append(roots, idx.meta.Sources...)
+		idx.meta.Files = make(map[string]*FileEntry)
+		idx.meta.Chunks, idx.vectors = nil, nil
+	}
+	if len(paths) == 0 {
+		roots = append(roots, idx.meta.Sources...)
+	}
+	if len(roots) == 0 {
+		return stats, fmt.Errorf("nothing to ingest: pass files or directories to add")
+	}
+
+	seen := make(map[string]bool)
+	var pending []*pendingFile
+	for _, root := range dedupe(roots) {
+		info, err := os.Stat(root)
+		if os.IsNotExist(err) || idx.excluded(root) {
+			// A source that no longer exists or may not be indexed
+			stats.Removed += idx.removeLocked(root)
+			idx.dropSources(root)
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		idx.addSource(root)
+
+		if !info.IsDir() {
+			seen[root] = true
+			if p := idx.checkFile(root, info, &stats); p != nil {
+				pending = append(pending, p)
+			}
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if skipDirs[d.Name()] || path == idx.dir || idx.excluded(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			seen[path] = true
+			if p := idx.checkFile(path, info, &stats); p != nil {
+				pending = append(pending, p)
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return stats, err
+		}
+
+		// Drop files that were deleted from the directory
+		for path := range idx.meta.Files {
+			if !seen[path] && within(path, root) {
+				stats.Removed += idx.removeLocked(path)
+			}
+		}
+	}
+
+	if err := idx.embedPending(ctx, pending, &stats); err != nil {
+		return stats, err
+	}
+	idx.meta.Model = idx.embedder.Model()
+	return stats, idx.save()
+}
+
+// checkFile compares a file against its index entry and returns it as
+// pending when its chunks must be (re)computed.
+func (idx *Index) checkFile(path string, info fs.FileInfo, stats *IngestStats) *pendingFile {
+	old := idx.meta.Files[path]
+	if old != nil && old.Size == info.Size() && old.ModTime.Equal(info.ModTime()) {
+		stats.Unchanged++
+		return nil
+	}
+	if info.Size() > idx.cfg.MaxFileBytes {
+		stats.Skipped++
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		stats.Skipped++
+		stats.Errors = append(stats.Errors, fmt.Sprintf("%s: %v", idx.displayPath(path), err))
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if old != nil && old.Hash == hash {
+		old.ModTime = info.ModTime()
+		stats.Unchanged++
+		return nil
+	}
+
+	chunks, err := idx.chunkFile(path, data)
+	if err != nil {
+		stats.Skipped++
+		stats.Errors = append(stats.Errors, fmt.Sprintf("%s: %v", idx.displayPath(path), err))
+		return nil
+	}
+	if chunks == nil {
+		// Binary or empty
+		stats.Skipped++
+		return nil
+	}
+	return &pendingFile{
+		path:   path,
+		entry:  &FileEntry{Hash: hash, ModTime: info.ModTime(), Size: info.Size(), Chunks: len(chunks)},
+		chunks: chunks,
+		isNew:  old == nil,
+	}
+}
+
+// chunkFile splits a file into chunks. It returns nil for files that have no
+// text to index.
+func (idx *Index) chunkFile(path string, data []byte) ([]Chunk, error) {
+	if documents.FormatFromName(path) != "" {
+		doc, err := documents.Extract(path, documents.Options{Rows: "1-"})
+		if err != nil {
+			return nil, err
+		}
+		var chunks []Chunk
+		for _, s := range doc.Sections {
+			location := ""
+			switch doc.Unit {
+			case "page":
+				location = fmt.Sprintf("page %d", s.Index)
+			case "sheet":
+				location = fmt.Sprintf("sheet %s", s.Name)
+			}
+			header := ""
+			if strings.HasPrefix(s.Text, "|") {
+				// Repeat the table header row in every chunk
+				header, _, _ = strings.Cut(s.Text, "\n")
+			}
+			for _, p := range chunkText(s.Text, idx.cfg.ChunkSize, idx.cfg.ChunkOverlap) {
+				c := Chunk{Path: path, Location: location, Heading: p.Heading, Text: p.Text}
+				if header != "" && p.StartLine > 2 {
+					c.Heading = header
+				}
+				if location == "" {
+					c.Location = fmt.Sprintf("lines %d-%d", p.StartLine, p.EndLine)
+				}
+				chunks = append(chunks, c)
+			}
+		}
+		return chunks, nil
+	}
+
+	if bytes.IndexByte(data[:min(len(data), 8192)], 0) >= 0 || !utf8.Valid(data) {
+		return nil, nil
+	}
+	var chunks []Chunk
+	for _, p := range chunkText(string(data), idx.cfg.ChunkSize, idx.cfg.ChunkOverlap) {
+		chunks = append(chunks, Chunk{
+			Path:     path,
+			Location: fmt.Sprintf("lines %d-%d", p.StartLine, p.EndLine),
+			Heading:  p.Heading,
+			Text:     p.Text,
+		})
+	}
+	return chunks, nil
+}
+
+func (idx *Index) embedPending(ctx context.Context, pending []*pendingFile, stats *IngestStats) error {
+	var inputs []string
+	for _, p := range pending {
+		for _, c := range p.chunks {
+			inputs = append(inputs, idx.embeddingInput(c))
+		}
+	}
+	var vectors [][]float32
+	if len(inputs) > 0 {
+		var err error
+		vectors, err = idx.embedder.Embed(ctx, inputs)
+		if err != nil {
+			return err
+		}
+		if len(vectors) != len(inputs) {
+			return fmt.Errorf("embedder returned %d vectors for %d chunks", len(vectors), len(inputs))
+		}
+	}
+
+	for _, v := range vectors {
+		if idx.meta.Dims == 0 || len(idx.meta.Chunks) == 0 {
+			idx.meta.Dims = len(v)
+		}
+		if len(v) != idx.meta.Dims {
+			return fmt.Errorf("embedding has %d dimensions, index has %d; remove %s to rebuild", len(v), idx.meta.Dims, idx.dir)
+		}
+		normalize(v)
+	}
+
+	next := 0
+	for _, p := range pending {
+		idx.removeLocked(p.path)
+		idx.meta.Files[p.path] = p.entry
+		idx.meta.Chunks = append(idx.meta.Chunks, p.chunks...)
+		idx.vectors = append(idx.vectors, vectors[next:next+len(p.chunks)]...)
+		next += len(p.chunks)
+		if p.isNew {
+			stats.Added++
+		} else {
+			stats.Updated++
+		}
+		stats.Chunks += len(p.chunks)
+	}
+	return nil
+}
+
+// embeddingInput prefixes a chunk with its file name and heading so that
+// chunks are found by what they belong to, not just their own words.
+func (idx *Index) embeddingInput(c Chunk) string {
+	s := "File: " + idx.displayPath(c.Path) + "\n"
+	if c.Heading != "" {
+		s += c.Heading + "\n"
+	}
+	return s + "\n" + c.Text
+}
+
+// Search returns the k chunks most similar to query. k <= 0 uses the
+// configured top_k. A non-nil allow limits the results to files it accepts.
+func (idx *Index) Search(ctx context.Context, query string, k int, allow func(path string) bool) ([]Result, error) {
+	// The embedding API call can be slow, so it runs before taking the lock
+	// rather than holding up indexing and other searches
+	vectors, err := idx.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedder returned %d vectors for 1 query", len(vectors))
+	}
+	q := vectors[0]
+	normalize(q)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.refresh(); err != nil {
+		return nil, err
+	}
+	if len(idx.meta.Chunks) == 0 {
+		return nil, nil
+	}
+	if idx.meta.Model != idx.embedder.Model() {
+		return nil, fmt.Errorf("index was built with embedding model %s but %s is configured; run `mypicoclaw kb add` to re-index",
+			idx.meta.Model, idx.embedder.Model())
+	}
+	if len(q) != idx.meta.Dims {
+		return nil, fmt.Errorf("query embedding does not match the index dimensions")
+	}
+	if k <= 0 {
+		k = idx.cfg.TopK
+	}
+
+	type scored struct {
+		i     int
+		score float32
+	}
+	scores := make([]scored, 0, len(idx.vectors))
+	for i, v := range idx.vectors {
+		path := idx.meta.Chunks[i].Path
+		if idx.excluded(path) || (allow != nil && !allow(path)) {
+			continue
+		}
+		var dot float32
+		for j := range v {
+			dot += v[j] * q[j]
+		}
+		scores = append(scores, scored{i, dot})
+	}
+	sort.Slice(scores, func(a, b int) bool { return scores[a].score > scores[b].score })
+
+	results := make([]Result, 0, k)
+	for _, s := range scores[:min(k, len(scores))] {
+		c := idx.meta.Chunks[s.i]
+		results = append(results, Result{
+			Path:     idx.displayPath(c.Path),
+			Location: c.Location,
+			Heading:  c.Heading,
+			Text:     c.Text,
+			Score:    s.score,
+		})
+	}
+	return results, nil
+}
+
+// Remove deletes a file, or every file under a directory, from the index
+// and stops syncing it. It returns the number of files removed.
+func (idx *Index) Remove(path string) (int, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.refresh(); err != nil {
+		return 0, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	removed := idx.removeLocked(abs)
+	if !idx.dropSources(abs) && removed == 0 {
+		return 0, nil
+	}
+	return removed, idx.save()
+}
+
+// removeLocked drops the files and chunks of path, a file or directory. It
+// returns the number of files removed.
+func (idx *Index) removeLocked(path string) int {
+	removed := 0
+	for p := range idx.meta.Files {
+		if within(p, path) {
+			delete(idx.meta.Files, p)
+			removed++
+		}
+	}
+	if removed > 0 {
+		chunks := idx.meta.Chunks[:0]
+		vectors := idx.vectors[:0]
+		for i, c := range idx.meta.Chunks {
+			if !within(c.Path, path) {
+				chunks = append(chunks, c)
+				vectors = append(vectors, idx.vectors[i])
+			}
+		}
+		idx.meta.Chunks, idx.vectors = chunks, vectors
+	}
+	return removed
+}
+
+// dropSources stops syncing path and any source inside it. It reports
+// whether a source was dropped.
+func (idx *Index) dropSources(path string) bool {
+	sources := idx.meta.Sources[:0]
+	for _, s := range idx.meta.Sources {
+		if !within(s, path) {
+			sources = append(sources, s)
+		}
+	}
+	dropped := len(sources) != len(idx.meta.Sources)
+	idx.meta.Sources = sources
+	return dropped
+}
+
+// addSource records root for later syncs unless a recorded directory
+// already covers it. Sources inside root are replaced by it.
+func (idx *Index) addSource(root string) {
+	for _, s := range idx.meta.Sources {
+		if within(root, s) {
+			return
+		}
+	}
+	sources := idx.meta.Sources[:0]
+	for _, s := range idx.meta.Sources {
+		if !within(s, root) {
+			sources = append(sources, s)
+		}
+	}
+	idx.meta.Sources = append(sources, root)
+	sort.Strings(idx.meta.Sources)
+}
+
+// Stats describes the current index.
+func (idx *Index) Stats() (Stats, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.refresh(); err != nil {
+		return Stats{}, err
+	}
+	st := Stats{
+		Dir:     idx.dir,
+		Model:   idx.meta.Model,
+		Dims:    idx.meta.Dims,
+		Files:   len(idx.meta.Files),
+		Chunks:  len(idx.meta.Chunks),
+		Updated: idx.meta.Updated,
+		Sources: make([]string, len(idx.meta.Sources)),
+	}
+	for i, s := range idx.meta.Sources {
+		st.Sources[i] = idx.displayPath(s)
+	}
+	for _, name := range []string{metaFile, vectorFile} {
+		if info, err := os.Stat(filepath.Join(idx.dir, name)); err == nil {
+			st.Bytes += info.Size()
+		}
+	}
+	return st, nil
+}
+
+// displayPath shows paths inside the workspace relative to it.
+func (idx *Index) displayPath(path string) string {
+	if idx.workspace != "" {
+		if rel, err := filepath.Rel(idx.workspace, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rel
+		}
+	}
+	return path
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func dedupe(paths []string) []string {
+	seen := make(map[string]bool, len(paths))
+	out := paths[:0:0]
+	for _, p := range paths {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func normalize(v []float32) {
+	var sum float64
+	for _, f := range v {
+		sum += float64(f) * float64(f)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(1 / math.Sqrt(sum))
+	for i := range v {
+		v[i] *= norm
+	}
+}
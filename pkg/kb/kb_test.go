@@ -0,0 +1,233 @@
+package kb
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+// fakeEmbeddings serves /embeddings with hashed bag-of-words vectors, so
+// texts sharing words are similar.
+func fakeEmbeddings(t *testing.T, inputs *atomic.Int32) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		var req embeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		inputs.Add(int32(len(req.Input)))
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []item
+		// Answer in reverse order to check that results are sorted by index
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			v := make([]float32, 64)
+			for _, word := range strings.FieldsFunc(strings.ToLower(req.Input[i]), func(r rune) bool {
+				return !unicode.IsLetter(r)
+			}) {
+				h := fnv.New32a()
+				h.Write([]byte(word))
+				v[h.Sum32()%64]++
+			}
+			data = append(data, item{Index: i, Embedding: v})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestChunkText(t *testing.T) {
+	text := "# Title\n\n" + strings.Repeat("alpha beta gamma\n", 10) + "\n## Second\n\n" + strings.Repeat("delta epsilon\n", 10)
+	pieces := chunkText(text, 200, 40)
+	if len(pieces) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(pieces))
+	}
+	for _, p := range pieces {
+		if n := len([]rune(p.Text)); n > 200 {
+			t.Errorf("chunk of %d characters exceeds the size", n)
+		}
+		if p.StartLine > p.EndLine {
+			t.Errorf("bad line range %d-%d", p.StartLine, p.EndLine)
+		}
+	}
+	last := pieces[len(pieces)-1]
+	if !strings.Contains(last.Text, "delta") || strings.Contains(last.Text, "alpha") ||
+		(last.Heading != "## Second" && !strings.HasPrefix(last.Text, "## Second")) {
+		t.Errorf("last chunk lost its heading: %+v", last)
+	}
+	for i := 1; i < len(pieces); i++ {
+		if pieces[i].StartLine > pieces[i-1].EndLine+1 {
+			t.Errorf("gap between chunk %d and %d", i-1, i)
+		}
+	}
+
+	long := chunkText(strings.Repeat("word ", 100), 120, 0)
+	if len(long) < 4 {
+		t.Errorf("long line not split: %d pieces", len(long))
+	}
+	for _, p := range long {
+		if p.StartLine != 1 || p.EndLine != 1 {
+			t.Errorf("split line has range %d-%d", p.StartLine, p.EndLine)
+		}
+	}
+}
+
+func TestIngestSearchAndReindex(t *testing.T) {
+	var inputs atomic.Int32
+	srv := fakeEmbeddings(t, &inputs)
+	defer srv.Close()
+
+	workspace := t.TempDir()
+	docs := filepath.Join(workspace, "docs")
+	os.MkdirAll(filepath.Join(docs, ".hidden"), 0755)
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(docs, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("cooking.md", "# Recipes\n\nBake the bread with flour, water and yeast in a hot oven.")
+	write("garden.txt", "Water the tomatoes and prune the roses every morning.")
+	write("binary.dat", "\x00\x01\x02")
+	write(".hidden/secret.md", "bread secret")
+
+	cfg := config.DefaultConfig().KB
+	cfg.Embedding.APIBase = srv.URL
+	cfg.Embedding.BatchSize = 1
+	idx, err := Open(filepath.Join(workspace, "kb"), workspace, NewOpenAIEmbedder(cfg.Embedding), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	stats, err := idx.Ingest(ctx, []string{docs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Added != 2 || stats.Skipped != 1 || stats.Chunks != 2 {
+		t.Fatalf("unexpected ingest stats: %s", stats)
+	}
+
+	results, err := idx.Search(ctx, "how to bake bread", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Path != filepath.Join("docs", "cooking.md") {
+		t.Fatalf("cooking.md should rank first: %+v", results)
+	}
+	if results[0].Location != "lines 1-3" {
+		t.Errorf("unexpected location %q", results[0].Location)
+	}
+
+	// Nothing changed: no embedding calls
+	before := inputs.Load()
+	stats, err = idx.Ingest(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Unchanged != 2 || stats.Chunks != 0 || inputs.Load() != before {
+		t.Fatalf("unchanged files were re-embedded: %s", stats)
+	}
+
+	// Touched but identical content is not re-embedded either
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(docs, "garden.txt"), later, later)
+	if stats, _ = idx.Ingest(ctx, nil); stats.Unchanged != 2 || stats.Chunks != 0 {
+		t.Fatalf("touched file was re-embedded: %s", stats)
+	}
+
+	// Changed and deleted files
+	write("garden.txt", "Sourdough bread needs a starter and a long, slow rise.")
+	os.Remove(filepath.Join(docs, "cooking.md"))
+	stats, err = idx.Ingest(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Updated != 1 || stats.Removed != 1 {
+		t.Fatalf("unexpected re-index stats: %s", stats)
+	}
+	results, _ = idx.Search(ctx, "bread", 5, nil)
+	if len(results) != 1 || results[0].Path != filepath.Join("docs", "garden.txt") {
+		t.Fatalf("unexpected results after re-index: %+v", results)
+	}
+
+	// A second handle sees the saved index
+	reopened, err := Open(filepath.Join(workspace, "kb"), workspace, NewOpenAIEmbedder(cfg.Embedding), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := reopened.Stats()
+	if st.Files != 1 || st.Chunks != 1 || st.Dims != 64 || len(st.Sources) != 1 {
+		t.Fatalf("unexpected stats after reopen: %+v", st)
+	}
+
+	removed, err := reopened.Remove(docs)
+	if err != nil || removed != 1 {
+		t.Fatalf("Remove = %d, %v", removed, err)
+	}
+	if results, _ = idx.Search(ctx, "bread", 5, nil); len(results) != 0 {
+		t.Fatalf("first handle did not pick up the removal: %+v", results)
+	}
+	if _, err := idx.Ingest(ctx, nil); err == nil {
+		t.Fatal("expected an error with no sources left")
+	}
+}
+
+func TestExcludeAndFilter(t *testing.T) {
+	var inputs atomic.Int32
+	srv := fakeEmbeddings(t, &inputs)
+	defer srv.Close()
+
+	workspace := t.TempDir()
+	memory := filepath.Join(workspace, "memory")
+	private := filepath.Join(memory, "users", "telegram_1")
+	os.MkdirAll(private, 0755)
+	os.WriteFile(filepath.Join(memory, "notes.md"), []byte("bread recipes are shared"), 0644)
+	os.WriteFile(filepath.Join(private, "MEMORY.md"), []byte("bread is alice's secret"), 0644)
+
+	cfg := config.DefaultConfig().KB
+	cfg.Embedding.APIBase = srv.URL
+	idx, err := Open(filepath.Join(workspace, "kb"), workspace, NewOpenAIEmbedder(cfg.Embedding), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Indexed before the exclusion was set up
+	if _, err := idx.Ingest(ctx, []string{memory}); err != nil {
+		t.Fatal(err)
+	}
+	idx.Exclude(filepath.Join(memory, "users"))
+	results, _ := idx.Search(ctx, "bread", 5, nil)
+	if len(results) != 1 || results[0].Path != filepath.Join("memory", "notes.md") {
+		t.Fatalf("excluded file searched: %+v", results)
+	}
+	if stats, _ := idx.Ingest(ctx, nil); stats.Removed != 1 {
+		t.Errorf("excluded file not dropped on re-sync: %s", stats)
+	}
+	if _, err := idx.Ingest(ctx, []string{private}); err == nil {
+		t.Error("ingested an excluded directory")
+	}
+
+	results, _ = idx.Search(ctx, "bread", 5, func(path string) bool { return filepath.Base(path) != "notes.md" })
+	if len(results) != 0 {
+		t.Errorf("filtered file returned: %+v", results)
+	}
+}
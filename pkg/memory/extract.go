@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/providers"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// Facts extracted with less confidence than this are dropped.
+const minExtractConfidence = 0.5
+
+const extractPrompt = `You maintain the long-term memory of a personal assistant. Read the conversation below and list durable facts worth remembering in future conversations: who the user is, their preferences, people and things in their life, ongoing projects, decisions and commitments.
+
+Rules:
+- Skip small talk, one-off requests, and anything only relevant to this conversation.
+- Skip facts already in KNOWN FACTS unless the conversation changes them; to change one, set "replaces" to its id.
+- Write each fact as one short, self-contained sentence in the language of the conversation.
+- "subject" is who or what the fact is about, e.g. "user", a person's name, or a project.
+- "confidence" is between 0 and 1: 0.9+ when stated directly, lower when inferred.
+
+Reply with only a JSON array, e.g.
+[{"subject": "user", "content": "Prefers short answers", "confidence": 0.9, "replaces": ""}]
+Reply [] if there is nothing worth remembering.
+
+KNOWN FACTS:
+%s
+
+CONVERSATION:
+%s`
+
+// Extractor asks the LLM for durable facts in finished conversations.
+type Extractor struct {
+	provider providers.LLMProvider
+	model    string
+}
+
+func NewExtractor(provider providers.LLMProvider, model string) *Extractor {
+	return &Extractor{provider: provider, model: model}
+}
+
+type extractedFact struct {
+	Subject    string  `json:"subject"`
+	Content    string  `json:"content"`
+	Confidence float64 `json:"confidence"`
+	Replaces   string  `json:"replaces"`
+}
+
+// Extract saves the facts found in messages to store, attributing them to
+// source. It returns how many facts were added and how many existing ones
+// updated.
+func (e *Extractor) Extract(ctx context.Context, store *Store, messages []providers.Message, source string) (added, updated int, err error) {
+	transcript, userText := formatTranscript(messages, 24000)
+	if userText == "" {
+		return 0, 0, nil
+	}
+
+	var known strings.Builder
+	for _, m := range store.Search(userText, 30) {
+		known.WriteString(FormatFact(m.Fact) + "\n")
+	}
+	if known.Len() == 0 {
+		known.WriteString("(none)\n")
+	}
+
+	prompt := fmt.Sprintf(extractPrompt, known.String(), transcript)
+	resp, err := e.provider.Chat(ctx, []providers.Message{{Role: "user", Content: &prompt}}, nil, e.model, map[string]interface{}{
+		"max_tokens":  1024,
+		"temperature": 0.2,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("memory extraction failed: %w", err)
+	}
+
+	facts, err := parseExtracted(resp.Content)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, f := range facts {
+		if f.Confidence == 0 {
+			f.Confidence = 0.7
+		}
+		if f.Confidence < minExtractConfidence || strings.TrimSpace(f.Content) == "" {
+			continue
+		}
+		fact := Fact{Subject: f.Subject, Content: f.Content, Confidence: f.Confidence, Source: source}
+		if _, ok := store.Get(f.Replaces); ok {
+			fact.ID = f.Replaces
+		}
+		_, merged, err := store.Save(fact)
+		if err != nil {
+			logger.WarnCF("memory", "Failed to save extracted fact",
+				map[string]interface{}{
+					"error": err.Error(),
+				})
+			continue
+		}
+		if merged {
+			updated++
+		} else {
+			added++
+		}
+	}
+	return added, updated, nil
+}
+
+// formatTranscript renders the user and assistant turns of messages, keeping
+// the most recent ones within maxChars. It also returns the user's words
+// alone, used to look up related known facts.
+func formatTranscript(messages []providers.Message, maxChars int) (string, string) {
+	var lines, userLines []string
+	for _, m := range messages {
+		if (m.Role != "user" && m.Role != "assistant") || m.Content == nil {
+			continue
+		}
+		content := strings.TrimSpace(*m.Content)
+		if content == "" {
+			continue
+		}
+		if r := []rune(content); len(r) > 2000 {
+			content = string(r[:2000]) + "..."
+		}
+		lines = append(lines, m.Role+": "+content)
+		if m.Role == "user" {
+			userLines = append(userLines, content)
+		}
+	}
+
+	used, start := 0, len(lines)
+	for start > 0 && used+len(lines[start-1]) <= maxChars {
+		used += len(lines[start-1]) + 1
+		start--
+	}
+	return strings.Join(lines[start:], "\n"), strings.Join(userLines, "\n")
+}
+
+// parseExtracted reads the JSON array from an LLM reply, tolerating code
+// fences and text around it.
+func parseExtracted(reply string) ([]extractedFact, error) {
+	start := strings.Index(reply, "[")
+	end := strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("memory extraction returned no JSON array: %s", utils.Truncate(reply, 200))
+	}
+	var facts []extractedFact
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &facts); err != nil {
+		return nil, fmt.Errorf("memory extraction returned invalid JSON: %w", err)
+	}
+	return facts, nil
+}
@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weiwei929/mypicoclaw/pkg/providers"
+)
+
+func TestSaveMergesSimilarFacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facts.json")
+	s := NewStore(path, 0)
+
+	first, updated, err := s.Save(Fact{Subject: "user", Content: "Lives in Berlin with two cats", Confidence: 0.7})
+	if err != nil || updated {
+		t.Fatalf("Save = %v, %v", updated, err)
+	}
+	again, updated, _ := s.Save(Fact{Subject: "User", Content: "lives in Berlin with two cats.", Confidence: 0.9})
+	if !updated || again.ID != first.ID || again.Confidence != 0.9 {
+		t.Fatalf("restated fact was not merged: %+v updated=%v", again, updated)
+	}
+	if _, updated, _ = s.Save(Fact{Subject: "user", Content: "Works as a nurse"}); updated {
+		t.Fatal("unrelated fact was merged")
+	}
+	if _, updated, _ = s.Save(Fact{Subject: "Anna", Content: "Lives in Berlin"}); updated {
+		t.Fatal("fact about another subject was merged")
+	}
+
+	// Explicit replacement by ID
+	moved, updated, err := s.Save(Fact{ID: first.ID, Subject: "user", Content: "Moved to Paris", Confidence: 0.8})
+	if err != nil || !updated || moved.Content != "Moved to Paris" || moved.Confidence != 0.8 {
+		t.Fatalf("replace by id = %+v, %v, %v", moved, updated, err)
+	}
+	if _, _, err := s.Save(Fact{ID: "missing", Content: "x"}); err == nil {
+		t.Fatal("expected an error for an unknown id")
+	}
+
+	reloaded := NewStore(path, 0)
+	if reloaded.Len() != 3 {
+		t.Fatalf("reloaded %d facts, want 3", reloaded.Len())
+	}
+	if n, _ := reloaded.ForgetSubject("USER"); n != 2 || reloaded.Len() != 1 {
+		t.Fatalf("ForgetSubject removed %d, %d left", n, reloaded.Len())
+	}
+}
+
+func TestSearchAndSelect(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "facts.json"), 0)
+	s.Save(Fact{Subject: "user", Content: "Is allergic to peanuts"})
+	s.Save(Fact{Subject: "user", Content: "喜欢喝乌龙茶，不喝咖啡"})
+	s.Save(Fact{Subject: "project", Content: "The garden shed build starts in May"})
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"any peanuts in this recipe?", "peanuts"},
+		{"给我推荐一款茶", "乌龙茶"},
+		{"when does the shed build start", "shed"},
+	}
+	for _, tt := range tests {
+		matches := s.Search(tt.query, 1)
+		if len(matches) != 1 || !strings.Contains(matches[0].Content, tt.want) {
+			t.Errorf("Search(%q) = %+v, want %q", tt.query, matches, tt.want)
+		}
+	}
+	if matches := s.Search("quantum physics", 5); len(matches) != 0 {
+		t.Errorf("unrelated query matched %+v", matches)
+	}
+
+	// A budget for one fact keeps the relevant one
+	one := EstimateTokens(FormatFact(s.Search("peanuts", 1)[0].Fact)) + 1
+	selected := s.Select("peanut butter? peanuts", one)
+	if len(selected) != 1 || !strings.Contains(selected[0].Content, "peanuts") {
+		t.Fatalf("Select = %+v", selected)
+	}
+	if all := s.Select("", 10000); len(all) != 3 {
+		t.Fatalf("large budget selected %d facts", len(all))
+	}
+}
+
+type stubProvider struct {
+	reply  string
+	prompt string
+}
+
+func (p *stubProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	p.prompt = *messages[0].Content
+	return &providers.LLMResponse{Content: p.reply}, nil
+}
+
+func (p *stubProvider) GetDefaultModel() string {
+	return "stub"
+}
+
+func TestExtract(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "facts.json"), 0)
+	old, _, _ := s.Save(Fact{Subject: "user", Content: "Drives a red bike to work"})
+
+	provider := &stubProvider{reply: "Here you go:\n```json\n[" +
+		`{"subject": "user", "content": "Has a daughter named Mia", "confidence": 0.95},` +
+		`{"subject": "user", "content": "Takes the train to work", "confidence": 0.9, "replaces": "` + old.ID + `"},` +
+		`{"subject": "user", "content": "Might like jazz", "confidence": 0.3}` +
+		"]\n```"}
+	text := func(s string) *string { return &s }
+	messages := []providers.Message{
+		{Role: "user", Content: text("My daughter Mia starts school; I take the train to work now instead of my bike")},
+		{Role: "assistant", Content: text("Good luck to Mia!")},
+		{Role: "tool", Content: text("ignored")},
+	}
+
+	added, updated, err := NewExtractor(provider, "stub").Extract(context.Background(), s, messages, "telegram:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 || updated != 1 || s.Len() != 2 {
+		t.Fatalf("added %d updated %d, store has %d", added, updated, s.Len())
+	}
+	if !strings.Contains(provider.prompt, old.ID) || strings.Contains(provider.prompt, "ignored") {
+		t.Error("prompt should list known facts and skip tool messages")
+	}
+	if f, _ := s.Get(old.ID); f.Content != "Takes the train to work" || f.Source != "telegram:1" {
+		t.Errorf("replaced fact = %+v", f)
+	}
+
+	if _, err := parseExtracted("nothing to remember"); err == nil {
+		t.Error("expected an error for a reply without JSON")
+	}
+}
+
+func TestRegistryIsolatesUsers(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRegistry(dir, 0)
+	alice := r.Scope("telegram:1", "")
+	bob := r.Scope("discord:2", "")
+	if alice == bob || alice == r.Global() || r.Scope("telegram:1", "") != alice {
+		t.Fatal("expected one store per user, reused across calls")
+	}
+	if r.Scope("", "") != r.Global() || r.Scope("telegram:1", "group") == alice {
+		t.Fatal("empty user should be global and chats separate")
+	}
+
+	alice.Save(Fact{Subject: "user", Content: "Is allergic to peanuts"})
+	if len(bob.Search("peanuts", 5)) != 0 || len(r.Global().Search("peanuts", 5)) != 0 {
+		t.Fatal("a user's fact leaked into another store")
+	}
+	if NewRegistry(dir, 0).Scope("telegram:1", "").Len() != 1 {
+		t.Fatal("user facts were not persisted")
+	}
+	if got := safeName("../..|x"); strings.Contains(got, "/") || strings.Contains(got, "|") {
+		t.Errorf("safeName = %q", got)
+	}
+}
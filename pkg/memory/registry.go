@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Registry holds the shared fact store and one store per user, or per user
+// and chat. Stores live under dir:
+//
+//	facts.json                              shared by everyone
+//	users/<user>/facts.json                 one user
+//	users/<user>/chats/<chat>/facts.json    one user in one chat
+type Registry struct {
+	dir      string
+	maxFacts int
+	global   *Store
+
+	mu     sync.Mutex
+	scoped map[string]*Store
+}
+
+func NewRegistry(dir string, maxFacts int) *Registry {
+	return &Registry{
+		dir:      dir,
+		maxFacts: maxFacts,
+		global:   NewStore(filepath.Join(dir, "facts.json"), maxFacts),
+		scoped:   make(map[string]*Store),
+	}
+}
+
+// Global returns the store shared by all users.
+func (r *Registry) Global() *Store {
+	return r.global
+}
+
+// Scope returns the store for user, or for user within chat when chat is
+// not empty. An empty user means the global store.
+func (r *Registry) Scope(user, chat string) *Store {
+	if user == "" {
+		return r.global
+	}
+	dir := r.UserDir(user)
+	if chat != "" {
+		dir = filepath.Join(dir, "chats", safeName(chat))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.scoped[dir]; ok {
+		return s
+	}
+	s := NewStore(filepath.Join(dir, "facts.json"), r.maxFacts)
+	r.scoped[dir] = s
+	return s
+}
+
+// UserDir returns the directory holding a user's memory and profile.
+func (r *Registry) UserDir(user string) string {
+	return filepath.Join(r.dir, "users", safeName(user))
+}
+
+// safeName turns an ID such as "telegram:12345" into a directory name.
+func safeName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.', r == '@':
+			return r
+		}
+		return '_'
+	}, id)
+	if strings.Trim(name, ".") == "" {
+		return "_"
+	}
+	return name
+}
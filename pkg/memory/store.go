@@ -0,0 +1,360 @@
+// Package memory keeps structured long-term facts that the agent saves
+// explicitly or extracts from conversations, and picks the ones relevant to
+// a message for the system prompt.
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// Fact is one remembered statement, e.g. subject "user", content "prefers
+// replies in Chinese".
+type Fact struct {
+	ID         string    `json:"id"`
+	Subject    string    `json:"subject"`
+	Content    string    `json:"content"`
+	Source     string    `json:"source,omitempty"` // session the fact came from
+	Confidence float64   `json:"confidence"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Match is a fact found by Search.
+type Match struct {
+	Fact
+	Score float64
+}
+
+// Facts with the same subject and at least this much word overlap are
+// treated as the same fact; facts with different subjects need dupOverlap.
+const (
+	mergeOverlap = 0.6
+	dupOverlap   = 0.9
+)
+
+// Store is a JSON file of facts. It is safe for concurrent use.
+type Store struct {
+	path     string
+	maxFacts int
+
+	mu    sync.Mutex
+	facts []*Fact
+}
+
+type storeFile struct {
+	Facts []*Fact `json:"facts"`
+}
+
+// NewStore loads the facts kept in path. Once more than maxFacts are
+// stored, the least confident and oldest are dropped.
+func NewStore(path string, maxFacts int) *Store {
+	if maxFacts <= 0 {
+		maxFacts = 2000
+	}
+	s := &Store{path: path, maxFacts: maxFacts}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.WarnCF("memory", "Failed to read memory facts",
+				map[string]interface{}{
+					"path":  path,
+					"error": err.Error(),
+				})
+		}
+		return s
+	}
+	var f storeFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		logger.WarnCF("memory", "Memory facts file is corrupted, starting empty",
+			map[string]interface{}{
+				"path":  path,
+				"error": err.Error(),
+			})
+		return s
+	}
+	s.facts = f.Facts
+	return s
+}
+
+func (s *Store) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(storeFile{Facts: s.facts}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Save stores f. A fact with the ID of a stored fact replaces it; otherwise
+// a stored fact saying nearly the same thing is updated instead of adding a
+// duplicate. It returns the stored fact and whether an existing one was
+// updated.
+func (s *Store) Save(f Fact) (Fact, bool, error) {
+	f.Subject = strings.TrimSpace(f.Subject)
+	f.Content = strings.TrimSpace(f.Content)
+	if f.Content == "" {
+		return Fact{}, false, fmt.Errorf("fact content is empty")
+	}
+	if f.Subject == "" {
+		f.Subject = "general"
+	}
+	if f.Confidence <= 0 || f.Confidence > 1 {
+		f.Confidence = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	existing := s.find(f.ID)
+	switch {
+	case existing != nil:
+		existing.Confidence = f.Confidence
+	case f.ID != "":
+		return Fact{}, false, fmt.Errorf("memory %s not found", f.ID)
+	default:
+		if existing = s.similar(f); existing != nil {
+			existing.Confidence = math.Max(existing.Confidence, f.Confidence)
+		}
+	}
+
+	if existing != nil {
+		existing.Subject = f.Subject
+		existing.Content = f.Content
+		if f.Source != "" {
+			existing.Source = f.Source
+		}
+		existing.UpdatedAt = now
+		return *existing, true, s.save()
+	}
+
+	f.ID = utils.RandomID(4)
+	f.CreatedAt, f.UpdatedAt = now, now
+	stored := f
+	s.facts = append(s.facts, &stored)
+	s.prune()
+	return stored, false, s.save()
+}
+
+// Get returns the fact with the given ID.
+func (s *Store) Get(id string) (Fact, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f := s.find(id); f != nil {
+		return *f, true
+	}
+	return Fact{}, false
+}
+
+// find returns the fact with the given ID, or nil.
+func (s *Store) find(id string) *Fact {
+	if id == "" {
+		return nil
+	}
+	for _, f := range s.facts {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// similar returns a stored fact that f restates, or nil.
+func (s *Store) similar(f Fact) *Fact {
+	words := tokenSet(f.Content)
+	var best *Fact
+	bestOverlap := 0.0
+	for _, old := range s.facts {
+		overlap := jaccard(words, tokenSet(old.Content))
+		need := dupOverlap
+		if strings.EqualFold(old.Subject, f.Subject) {
+			need = mergeOverlap
+		}
+		if overlap >= need && overlap > bestOverlap {
+			best, bestOverlap = old, overlap
+		}
+	}
+	return best
+}
+
+// prune drops the weakest facts beyond maxFacts.
+func (s *Store) prune() {
+	if len(s.facts) <= s.maxFacts {
+		return
+	}
+	sort.SliceStable(s.facts, func(i, j int) bool {
+		a, b := s.facts[i], s.facts[j]
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		return a.UpdatedAt.After(b.UpdatedAt)
+	})
+	s.facts = s.facts[:s.maxFacts]
+}
+
+// Forget deletes the fact with the given ID.
+func (s *Store) Forget(id string) (Fact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.facts {
+		if f.ID == id {
+			s.facts = append(s.facts[:i], s.facts[i+1:]...)
+			return *f, s.save()
+		}
+	}
+	return Fact{}, fmt.Errorf("memory %s not found", id)
+}
+
+// ForgetSubject deletes every fact about subject and returns how many were
+// removed.
+func (s *Store) ForgetSubject(subject string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.facts[:0]
+	for _, f := range s.facts {
+		if !strings.EqualFold(f.Subject, strings.TrimSpace(subject)) {
+			kept = append(kept, f)
+		}
+	}
+	removed := len(s.facts) - len(kept)
+	s.facts = kept
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.save()
+}
+
+// All returns every stored fact, most recently updated first.
+func (s *Store) All() []Fact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Fact, len(s.facts))
+	for i, f := range s.facts {
+		out[i] = *f
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+	return out
+}
+
+// Len returns the number of stored facts.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.facts)
+}
+
+// Search returns up to limit facts sharing words with query, best first.
+// An empty query returns the most recently updated facts.
+func (s *Store) Search(query string, limit int) []Match {
+	if limit <= 0 {
+		limit = 10
+	}
+	if strings.TrimSpace(query) == "" {
+		all := s.All()
+		matches := make([]Match, 0, min(limit, len(all)))
+		for _, f := range all[:min(limit, len(all))] {
+			matches = append(matches, Match{Fact: f})
+		}
+		return matches
+	}
+
+	var matches []Match
+	for _, m := range s.score(query) {
+		if m.Score > 0 {
+			matches = append(matches, m)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	return matches[:min(limit, len(matches))]
+}
+
+// Select picks facts for a system prompt within a budget of tokens,
+// preferring facts relevant to query, then confident and recent ones.
+func (s *Store) Select(query string, budget int) []Fact {
+	matches := s.score(query)
+	now := time.Now()
+	for i := range matches {
+		f := matches[i].Fact
+		age := now.Sub(f.UpdatedAt).Hours() / 24
+		matches[i].Score = matches[i].Score + 0.15*f.Confidence + 0.1*math.Exp(-age/30)
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+
+	var selected []Fact
+	used := 0
+	for _, m := range matches {
+		n := EstimateTokens(FormatFact(m.Fact)) + 1
+		if used+n > budget {
+			continue
+		}
+		used += n
+		selected = append(selected, m.Fact)
+	}
+	return selected
+}
+
+// score rates every fact by how much of query it covers, weighting rare
+// words higher. Scores are between 0 and 1.
+func (s *Store) score(query string) []Match {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := make([]Match, len(s.facts))
+	sets := make([]map[string]bool, len(s.facts))
+	df := make(map[string]int)
+	for i, f := range s.facts {
+		matches[i] = Match{Fact: *f}
+		sets[i] = tokenSet(f.Subject + " " + f.Content)
+		for t := range sets[i] {
+			df[t]++
+		}
+	}
+
+	queryTokens := tokenSet(query)
+	if len(queryTokens) == 0 {
+		return matches
+	}
+	idf := func(t string) float64 {
+		return math.Log(1 + float64(len(s.facts)+1)/float64(df[t]+1))
+	}
+	total := 0.0
+	for t := range queryTokens {
+		total += idf(t)
+	}
+	for i := range matches {
+		hit := 0.0
+		for t := range queryTokens {
+			if sets[i][t] {
+				hit += idf(t)
+			}
+		}
+		matches[i].Score = hit / total
+	}
+	return matches
+}
+
+// FormatFact renders a fact as a prompt line.
+func FormatFact(f Fact) string {
+	return fmt.Sprintf("- [%s] %s: %s", f.ID, f.Subject, f.Content)
+}
@@ -0,0 +1,110 @@
+package memory
+
+import (
+	"strings"
+	"unicode"
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "do": true, "for": true, "from": true,
+	"has": true, "have": true, "he": true, "her": true, "his": true, "i": true,
+	"in": true, "is": true, "it": true, "its": true, "me": true, "my": true,
+	"of": true, "on": true, "or": true, "she": true, "so": true, "that": true,
+	"the": true, "their": true, "they": true, "this": true, "to": true,
+	"was": true, "we": true, "what": true, "with": true, "you": true, "your": true,
+}
+
+// stopChars are common CJK function characters left out as single-character
+// tokens.
+var stopChars = map[rune]bool{
+	'的': true, '了': true, '是': true, '我': true, '你': true, '他': true,
+	'她': true, '它': true, '在': true, '和': true, '就': true, '也': true,
+	'都': true, '吗': true, '呢': true, '吧': true, '啊': true, '个': true,
+	'这': true, '那': true, '有': true, '给': true, '一': true,
+}
+
+// tokens splits text into lowercase words. Runs of CJK characters, which
+// are written without spaces, become their single characters plus
+// overlapping pairs, so both loose and exact matches score.
+func tokens(text string) []string {
+	var out []string
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			if w := string(word); !stopWords[w] {
+				out = append(out, w)
+			}
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		for i, r := range cjk {
+			if !stopChars[r] {
+				out = append(out, string(r))
+			}
+			if i+1 < len(cjk) {
+				out = append(out, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return out
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+func tokenSet(text string) map[string]bool {
+	set := make(map[string]bool)
+	for _, t := range tokens(text) {
+		set[t] = true
+	}
+	return set
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range a {
+		if b[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// EstimateTokens approximates the number of model tokens in s: about four
+// characters per token for Latin text and one per CJK character.
+func EstimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < 0x80 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
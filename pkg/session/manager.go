@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -101,6 +102,37 @@ func (sm *SessionManager) GetHistory(key string) []providers.Message {
 	return history
 }
 
+// Get returns a copy of the session with the given key.
+func (sm *SessionManager) Get(key string) (Session, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, ok := sm.sessions[key]
+	if !ok {
+		return Session{}, false
+	}
+	c := *session
+	c.Messages = make([]providers.Message, len(session.Messages))
+	copy(c.Messages, session.Messages)
+	return c, true
+}
+
+// List returns copies of all sessions, most recently updated first.
+func (sm *SessionManager) List() []Session {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	list := make([]Session, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		c := *session
+		c.Messages = make([]providers.Message, len(session.Messages))
+		copy(c.Messages, session.Messages)
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	return list
+}
+
 func (sm *SessionManager) GetSummary(key string) string {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
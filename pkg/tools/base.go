@@ -10,7 +10,8 @@ type Tool interface {
 }
 
 // ContextualTool is an optional interface that tools can implement
-// to receive the current message context (channel, chatID)
+// to receive a default message context (channel, chatID). The chat of a
+// WithChat context takes precedence over it.
 type ContextualTool interface {
 	Tool
 	SetContext(channel, chatID string)
@@ -101,7 +101,7 @@ func (t *CronTool) Execute(ctx context.Context, args map[string]interface{}) (st
 
 	switch action {
 	case "add":
-		return t.addJob(args)
+		return t.addJob(ctx, args)
 	case "list":
 		return t.listJobs()
 	case "remove":
@@ -115,10 +115,9 @@ func (t *CronTool) Execute(ctx context.Context, args map[string]interface{}) (st
 	}
 }
 
-func (t *CronTool) addJob(args map[string]interface{}) (string, error) {
+func (t *CronTool) addJob(ctx context.Context, args map[string]interface{}) (string, error) {
 	t.mu.RLock()
-	channel := t.channel
-	chatID := t.chatID
+	channel, chatID := chatFor(ctx, t.channel, t.chatID)
 	t.mu.RUnlock()
 
 	if channel == "" || chatID == "" {
@@ -241,8 +240,7 @@ func (t *CronTool) enableJob(args map[string]interface{}, enable bool) (string,
 // ExecuteJob executes a cron job through the agent
 func (t *CronTool) ExecuteJob(ctx context.Context, job *cron.CronJob) string {
 	// Get channel/chatID from job payload
-	channel := job.Payload.Channel
-	chatID := job.Payload.To
+	channel, chatID := splitTarget(job.Payload.Channel, job.Payload.To)
 
 	// Default values if not set
 	if channel == "" {
@@ -278,7 +276,13 @@ func (t *CronTool) ExecuteJob(ctx context.Context, job *cron.CronJob) string {
 		return fmt.Sprintf("Error: %v", err)
 	}
 
-	// Response is automatically sent via MessageBus by AgentLoop
-	_ = response // Will be sent by AgentLoop
+	// The response is empty when the agent already used the message tool
+	if response != "" && channel != "cli" {
+		t.msgBus.PublishOutbound(bus.OutboundMessage{
+			Channel: channel,
+			ChatID:  chatID,
+			Content: response,
+		})
+	}
 	return "ok"
 }
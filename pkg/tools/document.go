@@ -0,0 +1,94 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/weiwei929/mypicoclaw/pkg/documents"
+)
+
+// ReadDocumentTool extracts text from PDF, DOCX, XLSX and CSV files.
+type ReadDocumentTool struct {
+	paths    *PathResolver
+	maxChars int
+}
+
+// NewReadDocumentTool creates a ReadDocumentTool confined by paths that
+// returns at most maxChars characters per call.
+func NewReadDocumentTool(paths *PathResolver, maxChars int) *ReadDocumentTool {
+	if maxChars <= 0 {
+		maxChars = 64 * 1024
+	}
+	return &ReadDocumentTool{paths: paths, maxChars: maxChars}
+}
+
+func (t *ReadDocumentTool) Name() string {
+	return "read_document"
+}
+
+func (t *ReadDocumentTool) Description() string {
+	return "Extract the text of a PDF, Word (.docx), Excel (.xlsx) or CSV file. Use pages for PDF/DOCX, sheets and rows for spreadsheets; the result tells you where to continue."
+}
+
+func (t *ReadDocumentTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Path to the document (relative paths are resolved against the workspace)",
+			},
+			"pages": map[string]interface{}{
+				"type":        "string",
+				"description": "PDF/DOCX pages to read, e.g. \"1-3\", \"5\" or \"2,7-\" (default: all)",
+			},
+			"sheets": map[string]interface{}{
+				"type":        "string",
+				"description": "XLSX sheets to read by number or name, e.g. \"1\" or \"Sales,Costs\" (default: all)",
+			},
+			"rows": map[string]interface{}{
+				"type":        "string",
+				"description": fmt.Sprintf("Data rows of XLSX/CSV tables to read, e.g. \"201-400\" (default: the first %d)", documents.DefaultMaxRows),
+			},
+		},
+		"required": []string{"path"},
+	}
+}
+
+func (t *ReadDocumentTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	path, ok := args["path"].(string)
+	if !ok {
+		return "", fmt.Errorf("path is required")
+	}
+
+	resolved, err := t.paths.ResolveContext(ctx, path, false)
+	if err != nil {
+		return "", err
+	}
+
+	opts := documents.Options{}
+	opts.Pages, _ = args["pages"].(string)
+	opts.Sheets, _ = args["sheets"].(string)
+	opts.Rows, _ = args["rows"].(string)
+
+	doc, err := documents.Extract(resolved, opts)
+	if err != nil {
+		return "", err
+	}
+
+	text, next := doc.Render(t.maxChars)
+	result := fmt.Sprintf("[document: %s]\n%s", doc.Summary(), text)
+	if next != 0 {
+		switch doc.Unit {
+		case "page":
+			result += fmt.Sprintf("\n\n[Output truncated at %d characters in page %d. Use pages=\"%d-\" to continue]",
+				t.maxChars, next, next)
+		case "sheet":
+			result += fmt.Sprintf("\n\n[Output truncated at %d characters in sheet %d. Use sheets=\"%d\" with a smaller rows range to continue]",
+				t.maxChars, next, next)
+		default:
+			result += fmt.Sprintf("\n\n[Output truncated at %d characters. Request a smaller rows range to continue]", t.maxChars)
+		}
+	}
+	return result, nil
+}
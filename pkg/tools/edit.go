@@ -4,20 +4,20 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
 // EditFileTool edits a file by replacing old_text with new_text.
-// The old_text must exist exactly in the file.
+// The old_text must exist exactly in the file, or match uniquely when
+// whitespace is ignored. Several edits can be applied in one call.
 type EditFileTool struct {
-	allowedDir string // Optional directory restriction for security
+	paths *PathResolver // Confines edits to the allowed roots
 }
 
-// NewEditFileTool creates a new EditFileTool with optional directory restriction.
-func NewEditFileTool(allowedDir string) *EditFileTool {
+// NewEditFileTool creates a new EditFileTool confined by paths.
+func NewEditFileTool(paths *PathResolver) *EditFileTool {
 	return &EditFileTool{
-		allowedDir: allowedDir,
+		paths: paths,
 	}
 }
 
@@ -26,7 +26,7 @@ func (t *EditFileTool) Name() string {
 }
 
 func (t *EditFileTool) Description() string {
-	return "Edit a file by replacing old_text with new_text. The old_text must exist exactly in the file."
+	return "Edit a file by replacing old_text with new_text. The old_text must exist exactly in the file. Pass edits to apply several replacements at once (all or nothing), and dry_run to preview the diff without writing."
 }
 
 func (t *EditFileTool) Parameters() map[string]interface{} {
@@ -45,83 +45,178 @@ func (t *EditFileTool) Parameters() map[string]interface{} {
 				"type":        "string",
 				"description": "The text to replace with",
 			},
+			"edits": map[string]interface{}{
+				"type":        "array",
+				"description": "Multiple replacements applied in order, instead of old_text/new_text",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"old_text": map[string]interface{}{
+							"type": "string",
+						},
+						"new_text": map[string]interface{}{
+							"type": "string",
+						},
+						"replace_all": map[string]interface{}{
+							"type":        "boolean",
+							"description": "Replace every occurrence instead of requiring a unique match",
+						},
+					},
+					"required": []string{"old_text", "new_text"},
+				},
+			},
+			"dry_run": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Return the resulting diff without writing the file",
+			},
 		},
-		"required": []string{"path", "old_text", "new_text"},
+		"required": []string{"path"},
 	}
 }
 
+type textEdit struct {
+	oldText    string
+	newText    string
+	replaceAll bool
+}
+
 func (t *EditFileTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
 	path, ok := args["path"].(string)
 	if !ok {
 		return "", fmt.Errorf("path is required")
 	}
 
-	oldText, ok := args["old_text"].(string)
-	if !ok {
-		return "", fmt.Errorf("old_text is required")
+	edits, err := parseEdits(args)
+	if err != nil {
+		return "", err
 	}
+	dryRun, _ := args["dry_run"].(bool)
 
-	newText, ok := args["new_text"].(string)
-	if !ok {
-		return "", fmt.Errorf("new_text is required")
+	// Resolve path and enforce directory restriction
+	resolvedPath, err := t.paths.ResolveContext(ctx, path, true)
+	if err != nil {
+		return "", err
 	}
 
-	// Resolve path and enforce directory restriction if configured
-	resolvedPath := path
-	if filepath.IsAbs(path) {
-		resolvedPath = filepath.Clean(path)
-	} else {
-		abs, err := filepath.Abs(path)
-		if err != nil {
-			return "", fmt.Errorf("failed to resolve path: %w", err)
-		}
-		resolvedPath = abs
+	if _, err := os.Stat(resolvedPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("file not found: %s", path)
 	}
 
-	// Check directory restriction
-	if t.allowedDir != "" {
-		allowedAbs, err := filepath.Abs(t.allowedDir)
+	content, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	contentStr := string(content)
+	newContent := contentStr
+	var notes []string
+	for i, edit := range edits {
+		var note string
+		newContent, note, err = applyEdit(newContent, edit)
 		if err != nil {
-			return "", fmt.Errorf("failed to resolve allowed directory: %w", err)
+			if len(edits) > 1 {
+				return "", fmt.Errorf("edit %d: %w. No changes were written", i+1, err)
+			}
+			return "", err
 		}
-		if !strings.HasPrefix(resolvedPath, allowedAbs) {
-			return "", fmt.Errorf("path %s is outside allowed directory %s", path, t.allowedDir)
+		if note != "" {
+			notes = append(notes, fmt.Sprintf("edit %d %s", i+1, note))
 		}
 	}
 
-	if _, err := os.Stat(resolvedPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("file not found: %s", path)
+	if dryRun {
+		diff := unifiedDiff(path, contentStr, newContent)
+		if diff == "" {
+			diff = "(no changes)"
+		}
+		return fmt.Sprintf("Dry run for %s:\n%s", path, diff), nil
 	}
 
-	content, err := os.ReadFile(resolvedPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
+	if err := os.WriteFile(resolvedPath, []byte(newContent), 0644); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
 	}
 
-	contentStr := string(content)
+	result := fmt.Sprintf("Successfully edited %s", path)
+	if len(edits) > 1 {
+		result = fmt.Sprintf("Successfully applied %d edits to %s", len(edits), path)
+	}
+	if len(notes) > 0 {
+		result += " (" + strings.Join(notes, "; ") + ")"
+	}
+	return result, nil
+}
 
-	if !strings.Contains(contentStr, oldText) {
-		return "", fmt.Errorf("old_text not found in file. Make sure it matches exactly")
+func parseEdits(args map[string]interface{}) ([]textEdit, error) {
+	raw, ok := args["edits"].([]interface{})
+	if !ok || len(raw) == 0 {
+		oldText, ok := args["old_text"].(string)
+		if !ok {
+			return nil, fmt.Errorf("old_text is required")
+		}
+		newText, ok := args["new_text"].(string)
+		if !ok {
+			return nil, fmt.Errorf("new_text is required")
+		}
+		return []textEdit{{oldText: oldText, newText: newText}}, nil
 	}
 
-	count := strings.Count(contentStr, oldText)
-	if count > 1 {
-		return "", fmt.Errorf("old_text appears %d times. Please provide more context to make it unique", count)
+	edits := make([]textEdit, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("edit %d must be an object", i+1)
+		}
+		oldText, ok := m["old_text"].(string)
+		if !ok || oldText == "" {
+			return nil, fmt.Errorf("edit %d: old_text is required", i+1)
+		}
+		newText, ok := m["new_text"].(string)
+		if !ok {
+			return nil, fmt.Errorf("edit %d: new_text is required", i+1)
+		}
+		replaceAll, _ := m["replace_all"].(bool)
+		edits = append(edits, textEdit{oldText: oldText, newText: newText, replaceAll: replaceAll})
 	}
+	return edits, nil
+}
 
-	newContent := strings.Replace(contentStr, oldText, newText, 1)
+// applyEdit performs one replacement. When old_text is not found exactly it
+// falls back to a whole-line match that ignores whitespace, as long as that
+// match is unique; the returned note says so.
+func applyEdit(content string, edit textEdit) (string, string, error) {
+	count := strings.Count(content, edit.oldText)
+	if count == 1 || (count > 1 && edit.replaceAll) {
+		return strings.Replace(content, edit.oldText, edit.newText, -1), "", nil
+	}
+	if count > 1 {
+		return "", "", fmt.Errorf("old_text appears %d times. Please provide more context to make it unique", count)
+	}
 
-	if err := os.WriteFile(resolvedPath, []byte(newContent), 0644); err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+	lines, trailingNewline := splitLines(content)
+	oldLines, _ := splitLines(edit.oldText)
+	if len(oldLines) > 0 {
+		found := findLinesLoose(lines, oldLines)
+		if len(found) == 1 {
+			newLines, _ := splitLines(edit.newText)
+			pos := found[0]
+			result := append(append(append([]string{}, lines[:pos]...), newLines...), lines[pos+len(oldLines):]...)
+			return joinLines(result, trailingNewline), "matched ignoring whitespace", nil
+		}
+		if len(found) > 1 {
+			return "", "", fmt.Errorf("old_text matches %d places when ignoring whitespace. Please provide more context to make it unique", len(found))
+		}
 	}
 
-	return fmt.Sprintf("Successfully edited %s", path), nil
+	return "", "", fmt.Errorf("old_text not found in file. Make sure it matches exactly")
 }
 
-type AppendFileTool struct{}
+type AppendFileTool struct {
+	paths *PathResolver
+}
 
-func NewAppendFileTool() *AppendFileTool {
-	return &AppendFileTool{}
+// NewAppendFileTool creates an AppendFileTool confined by paths.
+func NewAppendFileTool(paths *PathResolver) *AppendFileTool {
+	return &AppendFileTool{paths: paths}
 }
 
 func (t *AppendFileTool) Name() string {
@@ -160,7 +255,10 @@ func (t *AppendFileTool) Execute(ctx context.Context, args map[string]interface{
 		return "", fmt.Errorf("content is required")
 	}
 
-	filePath := filepath.Clean(path)
+	filePath, err := t.paths.ResolveContext(ctx, path, true)
+	if err != nil {
+		return "", err
+	}
 
 	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -1,20 +1,40 @@
 package tools
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/weiwei929/mypicoclaw/pkg/documents"
 )
 
-type ReadFileTool struct{}
+type ReadFileTool struct {
+	paths    *PathResolver
+	maxBytes int
+}
+
+// NewReadFileTool creates a ReadFileTool confined by paths that returns at most
+// maxBytes per call.
+func NewReadFileTool(paths *PathResolver, maxBytes int) *ReadFileTool {
+	if maxBytes <= 0 {
+		maxBytes = 64 * 1024
+	}
+	return &ReadFileTool{paths: paths, maxBytes: maxBytes}
+}
 
 func (t *ReadFileTool) Name() string {
 	return "read_file"
 }
 
 func (t *ReadFileTool) Description() string {
-	return "Read the contents of a file"
+	return "Read the contents of a text file. For large files, use offset and limit to read a range of lines; the result tells you where to continue."
 }
 
 func (t *ReadFileTool) Parameters() map[string]interface{} {
@@ -23,7 +43,22 @@ func (t *ReadFileTool) Parameters() map[string]interface{} {
 		"properties": map[string]interface{}{
 			"path": map[string]interface{}{
 				"type":        "string",
-				"description": "Path to the file to read",
+				"description": "Path to the file to read (relative paths are resolved against the workspace)",
+			},
+			"offset": map[string]interface{}{
+				"type":        "integer",
+				"description": "Line number to start reading from (1-based, default: 1)",
+				"minimum":     1.0,
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of lines to read",
+				"minimum":     1.0,
+			},
+			"max_bytes": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum bytes to return (capped by the configured limit)",
+				"minimum":     1.0,
 			},
 		},
 		"required": []string{"path"},
@@ -36,15 +71,147 @@ func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{})
 		return "", fmt.Errorf("path is required")
 	}
 
-	content, err := os.ReadFile(path)
+	resolved, err := t.paths.ResolveContext(ctx, path, false)
+	if err != nil {
+		return "", err
+	}
+
+	offset := 1
+	if o, ok := args["offset"].(float64); ok && int(o) > 1 {
+		offset = int(o)
+	}
+	limit := 0
+	if l, ok := args["limit"].(float64); ok && int(l) > 0 {
+		limit = int(l)
+	}
+	maxBytes := t.maxBytes
+	if maxBytes <= 0 {
+		maxBytes = 64 * 1024
+	}
+	if mb, ok := args["max_bytes"].(float64); ok && int(mb) > 0 && int(mb) < maxBytes {
+		maxBytes = int(mb)
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
 	if err != nil {
 		return "", fmt.Errorf("failed to read file: %w", err)
 	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory; use list_dir or glob", path)
+	}
+
+	head := make([]byte, 8000)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if isBinary(head) {
+		hint := ""
+		if documents.Detect(resolved) != "" {
+			hint = " Use read_document to extract its text."
+		}
+		return fmt.Sprintf("Binary file %s (%d bytes, %s); not shown as text.%s",
+			path, info.Size(), http.DetectContentType(head), hint), nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var sb strings.Builder
+	reader := bufio.NewReader(f)
+	lineNo := 0
+	lastLine := 0
+	truncated := false
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			lineNo++
+			inRange := lineNo >= offset && (limit == 0 || lineNo < offset+limit)
+			if inRange && !truncated {
+				if sb.Len()+len(line) > maxBytes {
+					truncated = true
+					if sb.Len() == 0 {
+						// A single huge line: return its head rather than nothing
+						sb.WriteString(cutUTF8(line, maxBytes))
+						lastLine = lineNo
+					}
+				} else {
+					sb.WriteString(line)
+					lastLine = lineNo
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	totalLines := lineNo
 
-	return string(content), nil
+	if offset > totalLines && totalLines > 0 {
+		return fmt.Sprintf("[offset %d is past the end of %s (%d lines)]", offset, path, totalLines), nil
+	}
+
+	content := sb.String()
+	ranged := offset > 1 || lastLine < totalLines
+	if !ranged && !truncated {
+		return content, nil
+	}
+
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	notice := fmt.Sprintf("\n[Showing lines %d-%d of %d", offset, lastLine, totalLines)
+	if truncated {
+		notice += fmt.Sprintf(", truncated at %d bytes", maxBytes)
+	}
+	if lastLine < totalLines {
+		notice += fmt.Sprintf(". Use offset=%d to continue", lastLine+1)
+	}
+	notice += "]"
+
+	return content + notice, nil
 }
 
-type WriteFileTool struct{}
+// isBinary reports whether data looks like a binary file: it contains a NUL
+// byte or is mostly not valid UTF-8.
+func isBinary(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	if utf8.Valid(data) {
+		return false
+	}
+
+	invalid := 0
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			// A multi-byte rune cut off at the end of the sample is not a sign of binary
+			if len(data)-i < utf8.UTFMax {
+				break
+			}
+			invalid++
+		}
+		i += size
+	}
+	return invalid*10 > len(data)
+}
+
+type WriteFileTool struct {
+	paths *PathResolver
+}
+
+// NewWriteFileTool creates a WriteFileTool confined by paths.
+func NewWriteFileTool(paths *PathResolver) *WriteFileTool {
+	return &WriteFileTool{paths: paths}
+}
 
 func (t *WriteFileTool) Name() string {
 	return "write_file"
@@ -60,7 +227,7 @@ func (t *WriteFileTool) Parameters() map[string]interface{} {
 		"properties": map[string]interface{}{
 			"path": map[string]interface{}{
 				"type":        "string",
-				"description": "Path to the file to write",
+				"description": "Path to the file to write (relative paths are resolved against the workspace)",
 			},
 			"content": map[string]interface{}{
 				"type":        "string",
@@ -82,19 +249,31 @@ func (t *WriteFileTool) Execute(ctx context.Context, args map[string]interface{}
 		return "", fmt.Errorf("content is required")
 	}
 
-	dir := filepath.Dir(path)
+	resolved, err := t.paths.ResolveContext(ctx, path, true)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(resolved)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+	if err := os.WriteFile(resolved, []byte(content), 0644); err != nil {
 		return "", fmt.Errorf("failed to write file: %w", err)
 	}
 
 	return "File written successfully", nil
 }
 
-type ListDirTool struct{}
+type ListDirTool struct {
+	paths *PathResolver
+}
+
+// NewListDirTool creates a ListDirTool confined by paths.
+func NewListDirTool(paths *PathResolver) *ListDirTool {
+	return &ListDirTool{paths: paths}
+}
 
 func (t *ListDirTool) Name() string {
 	return "list_dir"
@@ -119,11 +298,16 @@ func (t *ListDirTool) Parameters() map[string]interface{} {
 
 func (t *ListDirTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
 	path, ok := args["path"].(string)
-	if !ok {
+	if !ok || path == "" {
 		path = "."
 	}
 
-	entries, err := os.ReadDir(path)
+	resolved, err := t.paths.ResolveContext(ctx, path, false)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(resolved)
 	if err != nil {
 		return "", fmt.Errorf("failed to read directory: %w", err)
 	}
@@ -0,0 +1,531 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	grepMaxFileSize = 4 * 1024 * 1024
+	grepMaxLineLen  = 500
+)
+
+var errSearchLimit = errors.New("search limit reached")
+
+// ignoreMatcher decides which files and directories the search tools skip.
+// Patterns without a slash match any path component (".git", "*.log");
+// patterns with a slash match the path relative to the search root.
+type ignoreMatcher struct {
+	patterns []string
+	hidden   bool
+}
+
+func newIgnoreMatcher(root string, patterns []string, includeHidden bool) *ignoreMatcher {
+	m := &ignoreMatcher{hidden: !includeHidden}
+	for _, p := range patterns {
+		m.add(p)
+	}
+	m.loadGitignore(filepath.Join(root, ".gitignore"))
+	return m
+}
+
+func (m *ignoreMatcher) add(pattern string) {
+	pattern = strings.TrimSpace(pattern)
+	pattern = strings.TrimSuffix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" || strings.HasPrefix(pattern, "#") || strings.HasPrefix(pattern, "!") {
+		return
+	}
+	m.patterns = append(m.patterns, pattern)
+}
+
+// loadGitignore reads the simple subset of .gitignore syntax: one pattern per
+// line, comments and negations are skipped.
+func (m *ignoreMatcher) loadGitignore(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m.add(scanner.Text())
+	}
+}
+
+// match reports whether rel (slash-separated, relative to the search root) is ignored.
+func (m *ignoreMatcher) match(rel string) bool {
+	parts := strings.Split(rel, "/")
+	if m.hidden {
+		for _, part := range parts {
+			if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+				return true
+			}
+		}
+	}
+
+	for _, p := range m.patterns {
+		if strings.Contains(p, "/") {
+			if matchGlob(p, rel) || matchGlob(p+"/**", rel) {
+				return true
+			}
+			continue
+		}
+		for _, part := range parts {
+			if ok, _ := filepath.Match(p, part); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walkFiles calls fn for every regular file under root that is not ignored.
+// rel is the slash-separated path relative to root.
+func walkFiles(ctx context.Context, root string, ignore *ignoreMatcher, fn func(path, rel string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries are skipped rather than aborting the search
+			if d != nil && d.IsDir() && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if path == root {
+			return nil
+		}
+		// Directories are walked so files the filter allows below them are found
+		if !d.IsDir() && !pathAllowed(ctx, path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if ignore.match(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || (!d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0) {
+			return nil
+		}
+		return fn(path, rel)
+	})
+}
+
+// matchGlob matches a slash-separated path against a glob pattern in which
+// "**" matches zero or more whole path segments.
+func matchGlob(pattern, path string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(rest, path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		path = path[1:]
+	}
+	return len(path) == 0
+}
+
+// GlobTool finds files by name pattern.
+type GlobTool struct {
+	paths  *PathResolver
+	ignore []string
+}
+
+func NewGlobTool(paths *PathResolver, ignore []string) *GlobTool {
+	return &GlobTool{paths: paths, ignore: ignore}
+}
+
+func (t *GlobTool) Name() string {
+	return "glob"
+}
+
+func (t *GlobTool) Description() string {
+	return "Find files by glob pattern, e.g. \"**/*.go\" or \"docs/*.md\". \"**\" matches any number of directories. Skips .git, node_modules, hidden files and .gitignore entries."
+}
+
+func (t *GlobTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"pattern": map[string]interface{}{
+				"type":        "string",
+				"description": "Glob pattern relative to path, e.g. \"**/*.go\"",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Directory to search in (default: workspace)",
+			},
+			"ignore": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Additional patterns to skip",
+			},
+			"include_hidden": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Include files and directories whose names start with a dot",
+			},
+			"max_results": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of paths to return (default: 200)",
+				"minimum":     1.0,
+			},
+		},
+		"required": []string{"pattern"},
+	}
+}
+
+func (t *GlobTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	pattern, ok := args["pattern"].(string)
+	if !ok || pattern == "" {
+		return "", fmt.Errorf("pattern is required")
+	}
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+
+	root, err := resolveSearchRoot(ctx, t.paths, args)
+	if err != nil {
+		return "", err
+	}
+
+	maxResults := 200
+	if m, ok := args["max_results"].(float64); ok && m > 0 {
+		maxResults = int(m)
+	}
+	includeHidden, _ := args["include_hidden"].(bool)
+	ignore := newIgnoreMatcher(root, append(append([]string{}, t.ignore...), stringArgs(args["ignore"])...), includeHidden)
+
+	var matches []string
+	truncated := false
+	err = walkFiles(ctx, root, ignore, func(path, rel string) error {
+		if !matchGlob(pattern, rel) {
+			return nil
+		}
+		if len(matches) >= maxResults {
+			truncated = true
+			return errSearchLimit
+		}
+		matches = append(matches, rel)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSearchLimit) {
+		return "", fmt.Errorf("failed to search %s: %w", root, err)
+	}
+
+	if len(matches) == 0 {
+		return fmt.Sprintf("No files matching %q in %s", pattern, root), nil
+	}
+
+	sort.Strings(matches)
+	result := fmt.Sprintf("Files matching %q in %s:\n%s", pattern, root, strings.Join(matches, "\n"))
+	if truncated {
+		result += fmt.Sprintf("\n... (stopped after %d results; narrow the pattern or raise max_results)", maxResults)
+	}
+	return result, nil
+}
+
+// GrepTool searches file contents with a regular expression.
+type GrepTool struct {
+	paths  *PathResolver
+	ignore []string
+}
+
+func NewGrepTool(paths *PathResolver, ignore []string) *GrepTool {
+	return &GrepTool{paths: paths, ignore: ignore}
+}
+
+func (t *GrepTool) Name() string {
+	return "grep"
+}
+
+func (t *GrepTool) Description() string {
+	return "Search file contents with a regular expression (Go RE2 syntax). Returns matching lines as path:line:text, with optional context lines. Skips binary files, .git, node_modules and .gitignore entries."
+}
+
+func (t *GrepTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"pattern": map[string]interface{}{
+				"type":        "string",
+				"description": "Regular expression to search for",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "File or directory to search in (default: workspace)",
+			},
+			"include": map[string]interface{}{
+				"type":        "string",
+				"description": "Only search files matching this glob, e.g. \"*.go\" or \"src/**/*.ts\"",
+			},
+			"ignore_case": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Case-insensitive matching",
+			},
+			"context": map[string]interface{}{
+				"type":        "integer",
+				"description": "Lines of context to show around each match (default: 0, max: 10)",
+				"minimum":     0.0,
+				"maximum":     10.0,
+			},
+			"max_results": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of matching lines to return (default: 100)",
+				"minimum":     1.0,
+			},
+		},
+		"required": []string{"pattern"},
+	}
+}
+
+func (t *GrepTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	pattern, ok := args["pattern"].(string)
+	if !ok || pattern == "" {
+		return "", fmt.Errorf("pattern is required")
+	}
+	if ignoreCase, _ := args["ignore_case"].(bool); ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	root, err := resolveSearchRoot(ctx, t.paths, args)
+	if err != nil {
+		return "", err
+	}
+
+	include, _ := args["include"].(string)
+	include = strings.TrimPrefix(filepath.ToSlash(include), "./")
+
+	contextLines := 0
+	if c, ok := args["context"].(float64); ok && c > 0 {
+		contextLines = int(c)
+		if contextLines > 10 {
+			contextLines = 10
+		}
+	}
+	maxResults := 100
+	if m, ok := args["max_results"].(float64); ok && m > 0 {
+		maxResults = int(m)
+	}
+
+	var sb strings.Builder
+	matchCount := 0
+	fileCount := 0
+	skipped := 0
+
+	search := func(path, rel string) error {
+		if include != "" {
+			target := rel
+			if !strings.Contains(include, "/") {
+				target = filepath.Base(rel)
+			}
+			if !matchGlob(include, target) {
+				return nil
+			}
+		}
+
+		// Symlinked files must not lead outside the allowed roots
+		if _, err := t.paths.ResolveContext(ctx, path, false); err != nil {
+			skipped++
+			return nil
+		}
+
+		found, err := grepFile(path, rel, re, contextLines, maxResults-matchCount, &sb)
+		if err != nil {
+			skipped++
+			return nil
+		}
+		if found > 0 {
+			fileCount++
+			matchCount += found
+		}
+		if matchCount >= maxResults {
+			return errSearchLimit
+		}
+		return nil
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to search: %w", err)
+	}
+	if info.IsDir() {
+		ignore := newIgnoreMatcher(root, t.ignore, false)
+		err = walkFiles(ctx, root, ignore, search)
+	} else {
+		err = search(root, filepath.Base(root))
+	}
+	if err != nil && !errors.Is(err, errSearchLimit) {
+		return "", fmt.Errorf("failed to search %s: %w", root, err)
+	}
+
+	if matchCount == 0 {
+		return fmt.Sprintf("No matches for %q in %s", pattern, root), nil
+	}
+
+	result := strings.TrimRight(sb.String(), "\n")
+	result += fmt.Sprintf("\n\n[%d matching lines in %d files", matchCount, fileCount)
+	if errors.Is(err, errSearchLimit) {
+		result += fmt.Sprintf("; stopped at max_results=%d", maxResults)
+	}
+	if skipped > 0 {
+		result += fmt.Sprintf("; %d files skipped", skipped)
+	}
+	result += "]"
+	return result, nil
+}
+
+// grepFile writes matches from one file to out in grep -n format and returns
+// the number of matching lines. Binary and oversized files return an error.
+func grepFile(path, rel string, re *regexp.Regexp, contextLines, limit int, out *strings.Builder) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > grepMaxFileSize {
+		return 0, fmt.Errorf("file too large")
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	head := data
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if isBinary(head) {
+		return 0, fmt.Errorf("binary file")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var matched []int
+	for i, line := range lines {
+		if len(matched) >= limit {
+			break
+		}
+		if re.MatchString(line) {
+			matched = append(matched, i)
+		}
+	}
+
+	isMatch := make(map[int]bool, len(matched))
+	for _, i := range matched {
+		isMatch[i] = true
+	}
+
+	lastPrinted := -1
+	for _, i := range matched {
+		start := i - contextLines
+		if start < 0 {
+			start = 0
+		}
+		if start <= lastPrinted {
+			start = lastPrinted + 1
+		} else if out.Len() > 0 {
+			out.WriteString("--\n")
+		}
+		end := i + contextLines
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+		for j := start; j <= end; j++ {
+			sep := "-"
+			if isMatch[j] {
+				sep = ":"
+			}
+			fmt.Fprintf(out, "%s%s%d%s%s\n", rel, sep, j+1, sep, truncateLine(lines[j]))
+		}
+		lastPrinted = end
+	}
+	return len(matched), nil
+}
+
+func truncateLine(line string) string {
+	line = strings.TrimRight(line, "\r")
+	if len(line) <= grepMaxLineLen {
+		return line
+	}
+	return cutUTF8(line, grepMaxLineLen) + "..."
+}
+
+// cutUTF8 returns at most the first n bytes of s, backing up so that no
+// rune is split.
+func cutUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// resolveSearchRoot resolves the optional "path" argument, defaulting to the workspace.
+func resolveSearchRoot(ctx context.Context, paths *PathResolver, args map[string]interface{}) (string, error) {
+	path, _ := args["path"].(string)
+	if path == "" {
+		if paths == nil || paths.Workspace() == "" {
+			return os.Getwd()
+		}
+		path = paths.Workspace()
+	}
+	return paths.ResolveContext(ctx, path, false)
+}
+
+func stringArgs(v interface{}) []string {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
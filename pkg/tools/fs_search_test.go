@@ -0,0 +1,175 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGlobTool(t *testing.T) {
+	workspace := t.TempDir()
+	writeTree(t, workspace, map[string]string{
+		"main.go":            "",
+		"pkg/a/a.go":         "",
+		"pkg/a/b/c.go":       "",
+		"pkg/a/readme.md":    "",
+		"node_modules/x.go":  "",
+		".hidden/secret.go":  "",
+		"docs/guide.md":      "",
+		"docs/deep/notes.md": "",
+	})
+	tool := NewGlobTool(NewPathResolver(workspace, true), config.DefaultConfig().Tools.Filesystem.IgnorePatterns)
+
+	tests := []struct {
+		args map[string]interface{}
+		want []string
+		skip []string
+	}{
+		{map[string]interface{}{"pattern": "**/*.go"}, []string{"main.go", "pkg/a/a.go", "pkg/a/b/c.go"}, []string{"node_modules", ".hidden"}},
+		{map[string]interface{}{"pattern": "pkg/**/c.go"}, []string{"pkg/a/b/c.go"}, []string{"main.go"}},
+		{map[string]interface{}{"pattern": "docs/*.md"}, []string{"docs/guide.md"}, []string{"notes.md"}},
+		{map[string]interface{}{"pattern": "**/*.go", "include_hidden": true}, []string{".hidden/secret.go"}, nil},
+		{map[string]interface{}{"pattern": "**/*.go", "max_results": 1.0}, []string{"stopped after 1 results"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := tool.Execute(context.Background(), tt.args)
+		if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%v: missing %q in %q", tt.args, w, got)
+			}
+		}
+		for _, s := range tt.skip {
+			if strings.Contains(got, s) {
+				t.Errorf("%v: unexpected %q in %q", tt.args, s, got)
+			}
+		}
+	}
+}
+
+func TestGrepTool(t *testing.T) {
+	workspace := t.TempDir()
+	writeTree(t, workspace, map[string]string{
+		"a.txt":     "one\ntwo\nneedle here\nfour\nfive\n",
+		"b.txt":     "needle\nneedle\nneedle\n",
+		"sub/c.log": "needle in a log\n",
+	})
+	tool := NewGrepTool(NewPathResolver(workspace, true), nil)
+
+	got, err := tool.Execute(context.Background(), map[string]interface{}{"pattern": "needle here", "context": 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"a.txt-2-two", "a.txt:3:needle here", "a.txt-4-four"} {
+		if !strings.Contains(got, w) {
+			t.Errorf("context: missing %q in %q", w, got)
+		}
+	}
+	if strings.Contains(got, "one") || strings.Contains(got, "five") {
+		t.Errorf("context: too many lines in %q", got)
+	}
+
+	got, _ = tool.Execute(context.Background(), map[string]interface{}{"pattern": "needle", "max_results": 2.0})
+	if !strings.Contains(got, "[2 matching lines") || !strings.Contains(got, "stopped at max_results=2") {
+		t.Errorf("max_results: %q", got)
+	}
+
+	got, _ = tool.Execute(context.Background(), map[string]interface{}{"pattern": "needle", "include": "*.log"})
+	if !strings.Contains(got, "sub/c.log:1:") || strings.Contains(got, ".txt") {
+		t.Errorf("include: %q", got)
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"pattern": "needle", "path": ".."}); err == nil {
+		t.Error("searched outside the workspace")
+	}
+}
+
+func TestTruncateLineKeepsRunes(t *testing.T) {
+	line := strings.Repeat("a", grepMaxLineLen-1) + "龙虾"
+	got := truncateLine(line)
+	if !utf8.ValidString(got) {
+		t.Errorf("truncateLine split a rune: %q", got[len(got)-8:])
+	}
+	if got != strings.Repeat("a", grepMaxLineLen-1)+"..." {
+		t.Errorf("truncateLine = ...%q", got[len(got)-8:])
+	}
+
+	// read_file cuts a single huge line at max_bytes the same way
+	workspace := t.TempDir()
+	writeTree(t, workspace, map[string]string{"long.txt": strings.Repeat("龙虾", 100) + "\n"})
+	tool := NewReadFileTool(NewPathResolver(workspace, true), 0)
+	got, err := tool.Execute(context.Background(), map[string]interface{}{"path": "long.txt", "max_bytes": 100.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _, _ := strings.Cut(got, "\n[Showing")
+	if !utf8.ValidString(got) || content != strings.Repeat("龙虾", 16)+"龙\n" {
+		t.Errorf("read_file cut a rune: %q", content)
+	}
+}
+
+func TestReadFileRange(t *testing.T) {
+	workspace := t.TempDir()
+	var lines []string
+	for i := 1; i <= 10; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	writeTree(t, workspace, map[string]string{"ten.txt": strings.Join(lines, "\n") + "\n"})
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	os.WriteFile(outside, []byte("secret\n"), 0644)
+	tool := NewReadFileTool(NewPathResolver(workspace, true), 0)
+
+	tests := []struct {
+		args map[string]interface{}
+		want []string
+		skip []string
+	}{
+		{map[string]interface{}{"path": "ten.txt"}, []string{"line 1\n", "line 10\n"}, []string{"Showing"}},
+		{map[string]interface{}{"path": "ten.txt", "offset": 3.0, "limit": 2.0}, []string{"line 3\nline 4\n", "[Showing lines 3-4 of 10. Use offset=5 to continue]"}, []string{"line 2\n", "line 5\n"}},
+		{map[string]interface{}{"path": "ten.txt", "offset": 9.0}, []string{"line 9\nline 10\n", "[Showing lines 9-10 of 10]"}, []string{"continue"}},
+		{map[string]interface{}{"path": "ten.txt", "offset": 11.0}, []string{"offset 11 is past the end"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := tool.Execute(context.Background(), tt.args)
+		if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%v: missing %q in %q", tt.args, w, got)
+			}
+		}
+		for _, s := range tt.skip {
+			if strings.Contains(got, s) {
+				t.Errorf("%v: unexpected %q in %q", tt.args, s, got)
+			}
+		}
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"path": outside}); err == nil {
+		t.Error("read a file outside the workspace")
+	}
+}
@@ -0,0 +1,685 @@
+package tools
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// Sentinels protect indentation and preformatted text from whitespace
+// normalization until the final output.
+const (
+	mdSpace   = '\x00'
+	mdNewline = '\x01'
+)
+
+var (
+	boilerplateTags = map[atom.Atom]bool{
+		atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Iframe: true,
+		atom.Svg: true, atom.Nav: true, atom.Aside: true, atom.Form: true,
+		atom.Button: true, atom.Template: true, atom.Select: true, atom.Input: true,
+		atom.Textarea: true, atom.Canvas: true, atom.Object: true, atom.Embed: true,
+		atom.Dialog: true, atom.Head: true,
+	}
+	boilerplateRoles = map[string]bool{
+		"navigation": true, "banner": true, "contentinfo": true,
+		"complementary": true, "dialog": true, "search": true,
+	}
+	unlikelyRe = regexp.MustCompile(`(?i)\b(nav|navbar|menu|footer|sidebar|side-bar|comments?|share|sharing|social|ads?|advert\w*|sponsor\w*|banner|cookies?|consent|popup|modal|related|breadcrumbs?|subscribe|newsletter|promo\w*|widget|toolbar|pagination|pager|masthead)\b`)
+	likelyRe   = regexp.MustCompile(`(?i)\b(article|content|main|post|entry|story|body)\b`)
+	langRe     = regexp.MustCompile(`(?:^|\s)(?:language|lang)-(\S+)`)
+)
+
+// htmlToMarkdown extracts the main content of an HTML page as Markdown.
+// Boilerplate (navigation, footers, ads, forms) is dropped, the main content
+// block is chosen by text density, and links are collected as numbered
+// references at the end.
+func htmlToMarkdown(doc string, base *url.URL) (title, markdown string) {
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return "", ""
+	}
+
+	title = pageTitle(root)
+	body := findElement(root, atom.Body)
+	if body == nil {
+		body = root
+	}
+	pruneBoilerplate(body, false)
+
+	r := &mdRenderer{base: base, refIndex: make(map[string]int)}
+	content := normalizeMarkdown(r.children(findMainContent(body)))
+
+	if len(r.refs) > 0 {
+		var sb strings.Builder
+		sb.WriteString(content)
+		sb.WriteString("\n\n")
+		for i, ref := range r.refs {
+			sb.WriteString("[" + strconv.Itoa(i+1) + "]: " + ref + "\n")
+		}
+		content = sb.String()
+	}
+
+	content = strings.NewReplacer(string(mdSpace), " ", string(mdNewline), "\n").Replace(content)
+	return title, strings.TrimSpace(content)
+}
+
+func pageTitle(root *html.Node) string {
+	if t := findElement(root, atom.Title); t != nil {
+		if s := collapseSpace(textContent(t)); s != "" {
+			return s
+		}
+	}
+	if h := findElement(root, atom.H1); h != nil {
+		return collapseSpace(textContent(h))
+	}
+	return ""
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// pruneBoilerplate removes elements that are never part of the main content.
+// Headers and footers are kept inside <article> and <main>, where they often
+// hold the headline or byline.
+func pruneBoilerplate(n *html.Node, inArticle bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.CommentNode:
+			n.RemoveChild(c)
+		case html.ElementNode:
+			if isBoilerplate(c, inArticle) {
+				n.RemoveChild(c)
+			} else {
+				pruneBoilerplate(c, inArticle || c.DataAtom == atom.Article || c.DataAtom == atom.Main)
+			}
+		}
+		c = next
+	}
+}
+
+func isBoilerplate(n *html.Node, inArticle bool) bool {
+	if boilerplateTags[n.DataAtom] {
+		return true
+	}
+	if !inArticle && (n.DataAtom == atom.Header || n.DataAtom == atom.Footer) {
+		return true
+	}
+	if _, hidden := findAttr(n, "hidden"); hidden || attr(n, "aria-hidden") == "true" {
+		return true
+	}
+	if style := strings.ReplaceAll(strings.ToLower(attr(n, "style")), " ", ""); strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+		return true
+	}
+	if boilerplateRoles[strings.ToLower(attr(n, "role"))] {
+		return true
+	}
+
+	switch n.DataAtom {
+	case atom.Html, atom.Body, atom.Article, atom.Main, atom.Table, atom.Tbody, atom.Tr, atom.Td, atom.Th, atom.Pre, atom.Code:
+		return false
+	}
+	names := attr(n, "class") + " " + attr(n, "id")
+	return unlikelyRe.MatchString(names) && !likelyRe.MatchString(names)
+}
+
+func findAttr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// findMainContent scores elements by the text of the paragraphs they
+// contain, readability-style: each paragraph adds to its parent and half as
+// much to its grandparent, and link-heavy candidates are penalized.
+func findMainContent(body *html.Node) *html.Node {
+	scores := make(map[*html.Node]float64)
+
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.P, atom.Pre, atom.Td, atom.Blockquote, atom.Li, atom.Dd:
+				text := textContent(n)
+				weight := textWeight(text)
+				if weight >= 25 && n.Parent != nil {
+					score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，")+strings.Count(text, "。"))
+					score += minFloat(float64(weight)/100, 3)
+					scores[n.Parent] += score
+					if gp := n.Parent.Parent; gp != nil {
+						scores[gp] += score / 2
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(body)
+
+	var best *html.Node
+	bestScore := 0.0
+	for n, score := range scores {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		if n.DataAtom == atom.Article || n.DataAtom == atom.Main || likelyRe.MatchString(attr(n, "class")+" "+attr(n, "id")) {
+			score *= 1.25
+		}
+		score *= 1 - linkDensity(n)
+		if score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+
+	if best == nil || textWeight(textContent(best)) < 140 {
+		return body
+	}
+	// Prefer the enclosing <article> so its headline comes along
+	for p := best.Parent; p != nil && p != body; p = p.Parent {
+		if p.DataAtom == atom.Article {
+			return p
+		}
+	}
+	return best
+}
+
+// textWeight is the length of text in characters, counting CJK characters
+// double since they carry more content than Latin letters.
+func textWeight(text string) int {
+	weight := 0
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			weight += 2
+		default:
+			weight++
+		}
+	}
+	return weight
+}
+
+func linkDensity(n *html.Node) float64 {
+	total := textWeight(textContent(n))
+	if total == 0 {
+		return 0
+	}
+	links := 0
+	var visit func(*html.Node)
+	visit = func(c *html.Node) {
+		if c.Type == html.ElementNode && c.DataAtom == atom.A {
+			links += textWeight(textContent(c))
+			return
+		}
+		for cc := c.FirstChild; cc != nil; cc = cc.NextSibling {
+			visit(cc)
+		}
+	}
+	visit(n)
+	return float64(links) / float64(total)
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// mdRenderer converts a DOM subtree to Markdown. Every method returns the
+// Markdown for its node; block elements surround themselves with blank lines
+// and normalizeMarkdown cleans up the result.
+type mdRenderer struct {
+	base     *url.URL
+	refs     []string
+	refIndex map[string]int
+}
+
+func (r *mdRenderer) children(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(r.node(c))
+	}
+	return sb.String()
+}
+
+func (r *mdRenderer) node(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return whitespaceRun.ReplaceAllString(n.Data, " ")
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := collapseSpace(r.children(n))
+		if text == "" {
+			return ""
+		}
+		level := int(n.Data[1] - '0')
+		return "\n\n" + strings.Repeat("#", level) + " " + text + "\n\n"
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Header, atom.Footer,
+		atom.Figure, atom.Figcaption, atom.Address, atom.Details, atom.Summary, atom.Center:
+		return "\n\n" + r.children(n) + "\n\n"
+	case atom.Br:
+		return "\n"
+	case atom.Hr:
+		return "\n\n---\n\n"
+	case atom.Strong, atom.B:
+		return wrapInline(r.children(n), "**")
+	case atom.Em, atom.I:
+		return wrapInline(r.children(n), "*")
+	case atom.Del, atom.S, atom.Strike:
+		return wrapInline(r.children(n), "~~")
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		text := collapseSpace(textContent(n))
+		if text == "" {
+			return ""
+		}
+		if strings.Contains(text, "`") {
+			return "`` " + text + " ``"
+		}
+		return "`" + text + "`"
+	case atom.Pre:
+		return r.pre(n)
+	case atom.Blockquote:
+		inner := normalizeMarkdown(r.children(n))
+		if inner == "" {
+			return ""
+		}
+		lines := strings.Split(inner, "\n")
+		for i, line := range lines {
+			lines[i] = "> " + line
+		}
+		return "\n\n" + strings.Join(lines, "\n") + "\n\n"
+	case atom.Ul, atom.Ol:
+		return r.list(n)
+	case atom.Li:
+		return "\n" + r.listItem(n, "- ")
+	case atom.A:
+		return r.link(n)
+	case atom.Img:
+		return r.image(n)
+	case atom.Table:
+		return r.table(n)
+	case atom.Dt:
+		return "\n\n**" + collapseSpace(r.children(n)) + "**\n"
+	case atom.Dd:
+		return "\n" + collapseSpace(r.children(n)) + "\n"
+	}
+	return r.children(n)
+}
+
+var whitespaceRun = regexp.MustCompile(`\s+`)
+
+// wrapInline surrounds text with marker, keeping surrounding whitespace outside it.
+func wrapInline(s, marker string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	lead := s[:strings.Index(s, trimmed)]
+	trail := s[len(lead)+len(trimmed):]
+	return lead + marker + trimmed + marker + trail
+}
+
+func (r *mdRenderer) pre(n *html.Node) string {
+	code := strings.Trim(textContent(n), "\n")
+	if strings.TrimSpace(code) == "" {
+		return ""
+	}
+
+	lang := ""
+	classes := attr(n, "class")
+	if c := findElement(n, atom.Code); c != nil && c != n {
+		classes += " " + attr(c, "class")
+	}
+	if m := langRe.FindStringSubmatch(classes); m != nil {
+		lang = m[1]
+	}
+
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	protected := strings.NewReplacer(" ", string(mdSpace), "\t", string(mdSpace)+string(mdSpace)+string(mdSpace)+string(mdSpace), "\n", string(mdNewline)).Replace(code)
+	return "\n\n" + fence + lang + string(mdNewline) + protected + string(mdNewline) + fence + "\n\n"
+}
+
+func (r *mdRenderer) list(n *html.Node) string {
+	ordered := n.DataAtom == atom.Ol
+	index := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil && ordered {
+		index = start
+	}
+
+	var items []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if c.DataAtom != atom.Li {
+			// Nested lists placed directly in the list, outside an <li>
+			if s := normalizeMarkdown(r.node(c)); s != "" {
+				items = append(items, indentLines(s, "  "))
+			}
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(index) + ". "
+			index++
+		}
+		if item := r.listItem(c, marker); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	return "\n\n" + strings.Join(items, "\n") + "\n\n"
+}
+
+func (r *mdRenderer) listItem(n *html.Node, marker string) string {
+	content := normalizeMarkdown(r.children(n))
+	if content == "" {
+		return ""
+	}
+	// Keep list items tight
+	for strings.Contains(content, "\n\n") {
+		content = strings.ReplaceAll(content, "\n\n", "\n")
+	}
+	lines := strings.Split(content, "\n")
+	indent := strings.Repeat(string(mdSpace), len(marker))
+	for i := range lines {
+		if i == 0 {
+			lines[i] = marker + lines[i]
+		} else {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func indentLines(s, indent string) string {
+	indent = strings.ReplaceAll(indent, " ", string(mdSpace))
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *mdRenderer) link(n *html.Node) string {
+	text := collapseSpace(r.children(n))
+	href := strings.TrimSpace(attr(n, "href"))
+	if text == "" {
+		return ""
+	}
+	target := r.resolve(href)
+	if target == "" {
+		return text
+	}
+	return "[" + text + "][" + strconv.Itoa(r.ref(target)) + "]"
+}
+
+func (r *mdRenderer) image(n *html.Node) string {
+	src := attr(n, "src")
+	if src == "" {
+		src = attr(n, "data-src")
+	}
+	target := r.resolve(src)
+	if target == "" {
+		return ""
+	}
+	alt := collapseSpace(attr(n, "alt"))
+	return "![" + alt + "][" + strconv.Itoa(r.ref(target)) + "]"
+}
+
+// resolve returns an absolute http(s) URL for href, or "" for fragments,
+// javascript: links and inline data.
+func (r *mdRenderer) resolve(href string) string {
+	if href == "" || strings.HasPrefix(href, "#") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if r.base != nil {
+		u = r.base.ResolveReference(u)
+	}
+	switch u.Scheme {
+	case "http", "https", "mailto":
+		return u.String()
+	}
+	return ""
+}
+
+func (r *mdRenderer) ref(target string) int {
+	if i, ok := r.refIndex[target]; ok {
+		return i
+	}
+	r.refs = append(r.refs, target)
+	r.refIndex[target] = len(r.refs)
+	return len(r.refs)
+}
+
+// table renders a data table as a Markdown table. Layout tables with a single
+// column are rendered as plain blocks.
+func (r *mdRenderer) table(n *html.Node) string {
+	var rows [][]string
+	headerRow := false
+
+	var collect func(*html.Node)
+	collect = func(c *html.Node) {
+		for cc := c.FirstChild; cc != nil; cc = cc.NextSibling {
+			if cc.Type != html.ElementNode || cc.DataAtom == atom.Table {
+				continue
+			}
+			if cc.DataAtom != atom.Tr {
+				collect(cc)
+				continue
+			}
+			var row []string
+			allHeaders := true
+			for cell := cc.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type != html.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+					continue
+				}
+				if cell.DataAtom != atom.Th {
+					allHeaders = false
+				}
+				text := normalizeMarkdown(r.children(cell))
+				text = strings.ReplaceAll(collapseSpace(strings.ReplaceAll(text, "\n", " ")), "|", "\\|")
+				row = append(row, text)
+				if span, err := strconv.Atoi(attr(cell, "colspan")); err == nil {
+					for i := 1; i < span && i < 20; i++ {
+						row = append(row, "")
+					}
+				}
+			}
+			if len(row) == 0 {
+				continue
+			}
+			if len(rows) == 0 && allHeaders {
+				headerRow = true
+			}
+			rows = append(rows, row)
+		}
+	}
+	collect(n)
+
+	cols := 0
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+	if cols <= 1 {
+		return "\n\n" + r.children(n) + "\n\n"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n\n")
+	writeRow := func(row []string) {
+		sb.WriteString("|")
+		for i := 0; i < cols; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			sb.WriteString(" " + cell + " |")
+		}
+		sb.WriteString("\n")
+	}
+
+	body := rows
+	if headerRow {
+		writeRow(rows[0])
+		body = rows[1:]
+	} else {
+		writeRow(make([]string, cols))
+	}
+	sb.WriteString("|" + strings.Repeat(" --- |", cols) + "\n")
+	for _, row := range body {
+		writeRow(row)
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// normalizeMarkdown trims every line and collapses runs of blank lines.
+func normalizeMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	blank := true
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		out = append(out, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// commonHanzi are frequent characters in both simplified and traditional
+// Chinese, used to tell GBK from Big5 when a page declares no charset.
+const commonHanzi = "的一是不了在人有我他这個个們们中來来上大為为和國国地到以說说時时要就出會会可也你對对生能而子那得於于著着下自之年過过發发後后作裡里"
+
+// decodeBody converts a response body to UTF-8. The charset comes from a BOM,
+// the Content-Type header or a <meta> tag; undeclared non-UTF-8 bodies are
+// tried as GB18030 and Big5, which covers most Chinese sites.
+func decodeBody(body []byte, contentType string) (string, string) {
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && (name == "windows-1252" || name == "utf-8") {
+		if utf8.Valid(body) {
+			return string(body), "utf-8"
+		}
+		if guess, guessName := guessCJKEncoding(body); guess != nil {
+			enc, name = guess, guessName
+		}
+	}
+	if name == "utf-8" {
+		return strings.ToValidUTF8(string(body), "�"), name
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return strings.ToValidUTF8(string(body), "�"), "utf-8"
+	}
+	return string(decoded), name
+}
+
+func guessCJKEncoding(body []byte) (encoding.Encoding, string) {
+	candidates := []struct {
+		name string
+		enc  encoding.Encoding
+	}{
+		{"gb18030", simplifiedchinese.GB18030},
+		{"big5", traditionalchinese.Big5},
+	}
+
+	var best encoding.Encoding
+	bestName := ""
+	bestScore := 0
+	for _, c := range candidates {
+		decoded, err := c.enc.NewDecoder().Bytes(body)
+		if err != nil {
+			continue
+		}
+		score := 0
+		for _, r := range string(decoded) {
+			switch {
+			case r == utf8.RuneError:
+				score -= 10
+			case strings.ContainsRune(commonHanzi, r):
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestName, bestScore = c.enc, c.name, score
+		}
+	}
+	return best, bestName
+}
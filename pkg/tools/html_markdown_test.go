@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+const articlePage = `<!DOCTYPE html>
+<html><head><title>Release notes</title><style>body{}</style></head>
+<body>
+<header><nav><a href="/">Home</a> <a href="/blog">Blog</a></nav></header>
+<div class="sidebar"><p>Subscribe to our newsletter, it is great, really, trust us.</p></div>
+<main>
+  <article>
+    <h1>Version 2.0</h1>
+    <p>This release rewrites the scheduler, improves memory use, and adds
+       <a href="/docs/cron">cron support</a>, among other things.</p>
+    <p>Upgrading is <strong>safe</strong>: configuration files are migrated automatically, and old ones are kept.</p>
+    <pre><code class="language-go">func main() {
+    run()
+}</code></pre>
+    <table>
+      <tr><th>Setting</th><th>Default</th></tr>
+      <tr><td>timeout</td><td>60</td></tr>
+    </table>
+    <ul><li>First item</li><li>Second item<ul><li>Nested</li></ul></li></ul>
+  </article>
+</main>
+<footer class="footer">Copyright 2026, all rights reserved, do not copy.</footer>
+<script>alert(1)</script>
+</body></html>`
+
+func TestHTMLToMarkdown(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/v2")
+	title, md := htmlToMarkdown(articlePage, base)
+
+	if title != "Release notes" {
+		t.Errorf("title = %q", title)
+	}
+
+	for _, want := range []string{
+		"# Version 2.0",
+		"[cron support][1]",
+		"[1]: https://example.com/docs/cron",
+		"**safe**",
+		"```go\nfunc main() {\n    run()\n}\n```",
+		"| Setting | Default |\n| --- | --- |\n| timeout | 60 |",
+		"- First item\n- Second item\n  - Nested",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+
+	for _, unwanted := range []string{"Home", "newsletter", "Copyright", "alert", "body{}"} {
+		if strings.Contains(md, unwanted) {
+			t.Errorf("markdown contains boilerplate %q:\n%s", unwanted, md)
+		}
+	}
+}
+
+func TestDecodeBodyChinese(t *testing.T) {
+	text := "这是一个中文网页，我们的内容在这里。"
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String(text)
+	big5, _ := traditionalchinese.Big5.NewEncoder().String("這是一個中文網頁，我們的內容在這裡。")
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        string
+	}{
+		{"Declared GBK", gbk, "text/html; charset=gbk", text},
+		{"Meta GBK", `<meta charset="gbk">` + gbk, "text/html", text},
+		{"Undeclared GBK", gbk, "text/html", text},
+		{"Undeclared Big5", big5, "text/html", "這是一個中文網頁，我們的內容在這裡。"},
+		{"UTF-8", text, "text/html", text},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := decodeBody([]byte(tt.body), tt.contentType)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("decodeBody = %q, want containing %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaginateText(t *testing.T) {
+	text := strings.Repeat("word ", 30) + "\n\n" + strings.Repeat("next ", 30)
+
+	page, next, total := paginateText(text, 0, 170)
+	if !strings.HasSuffix(page, "\n\n") || next != len(page) {
+		t.Errorf("first page should end at the paragraph break, got next=%d page=%q", next, page)
+	}
+
+	rest, after, _ := paginateText(text, next, 200)
+	if after != 0 || page+rest != text || total != len(text) {
+		t.Errorf("pages do not reassemble the text: next=%d", after)
+	}
+}
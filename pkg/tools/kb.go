@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/weiwei929/mypicoclaw/pkg/kb"
+)
+
+// KBSearchTool searches the local knowledge base.
+type KBSearchTool struct {
+	index *kb.Index
+}
+
+func NewKBSearchTool(index *kb.Index) *KBSearchTool {
+	return &KBSearchTool{index: index}
+}
+
+func (t *KBSearchTool) Name() string {
+	return "kb_search"
+}
+
+func (t *KBSearchTool) Description() string {
+	return "Search the local knowledge base (ingested notes and documents) by meaning. Returns the most relevant passages with their file and location; use read_file or read_document for more context."
+}
+
+func (t *KBSearchTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"query": map[string]interface{}{
+				"type":        "string",
+				"description": "What to look for, phrased as a question or description",
+			},
+			"top_k": map[string]interface{}{
+				"type":        "integer",
+				"description": "Number of passages to return (default from config)",
+				"minimum":     1.0,
+				"maximum":     20.0,
+			},
+		},
+		"required": []string{"query"},
+	}
+}
+
+func (t *KBSearchTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	query, ok := args["query"].(string)
+	if !ok || strings.TrimSpace(query) == "" {
+		return "", fmt.Errorf("query is required")
+	}
+	k := 0
+	if n, ok := args["top_k"].(float64); ok && n > 0 {
+		k = min(int(n), 20)
+	}
+
+	// Files the sender may not read, such as other users' memory, are
+	// not searched either
+	results, err := t.index.Search(ctx, query, k, func(path string) bool {
+		return pathAllowed(ctx, resolveSymlinks(path))
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "No passages found. The knowledge base may be empty; use kb_ingest to add files.", nil
+	}
+
+	var sb strings.Builder
+	for i, r := range results {
+		fmt.Fprintf(&sb, "%d. %s (%s, score %.3f)\n", i+1, r.Path, r.Location, r.Score)
+		if r.Heading != "" {
+			sb.WriteString(r.Heading + "\n")
+		}
+		sb.WriteString(r.Text)
+		sb.WriteString("\n\n")
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// KBIngestTool adds files or directories to the knowledge base.
+type KBIngestTool struct {
+	index *kb.Index
+	paths *PathResolver
+}
+
+func NewKBIngestTool(index *kb.Index, paths *PathResolver) *KBIngestTool {
+	return &KBIngestTool{index: index, paths: paths}
+}
+
+func (t *KBIngestTool) Name() string {
+	return "kb_ingest"
+}
+
+func (t *KBIngestTool) Description() string {
+	return "Add a file or directory to the knowledge base so kb_search can find it. Re-running it re-indexes changed files only; omit path to re-sync everything ingested before."
+}
+
+func (t *KBIngestTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "File or directory to ingest (relative paths are resolved against the workspace)",
+			},
+		},
+	}
+}
+
+func (t *KBIngestTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	var paths []string
+	if path, _ := args["path"].(string); path != "" {
+		resolved, err := t.paths.ResolveContext(ctx, path, false)
+		if err != nil {
+			return "", err
+		}
+		paths = append(paths, resolved)
+	}
+
+	stats, err := t.index.Ingest(ctx, paths)
+	if err != nil {
+		return "", err
+	}
+	return "Knowledge base updated: " + stats.String(), nil
+}
@@ -0,0 +1,272 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/weiwei929/mypicoclaw/pkg/memory"
+)
+
+// memoryStores is the fact store of the current user and the one shared
+// by everyone. They are the same when memory is not kept per user.
+type memoryStores struct {
+	shared *memory.Store
+	user   *memory.Store
+}
+
+type factStoreKey struct{}
+
+// WithFactStore returns a context in which the memory tools use the fact
+// store of the user being served; nil means the shared store.
+func WithFactStore(ctx context.Context, user *memory.Store) context.Context {
+	return context.WithValue(ctx, factStoreKey{}, user)
+}
+
+// SetScope switches to the fact store of the user being served when the
+// context names none; nil means the shared store.
+func (m *memoryStores) SetScope(user *memory.Store) {
+	if user == nil {
+		user = m.shared
+	}
+	m.user = user
+}
+
+func (m *memoryStores) userStore(ctx context.Context) *memory.Store {
+	if user, ok := ctx.Value(factStoreKey{}).(*memory.Store); ok {
+		return user
+	}
+	return m.user
+}
+
+func (m *memoryStores) store(ctx context.Context, shared bool) *memory.Store {
+	if user := m.userStore(ctx); !shared && user != nil {
+		return user
+	}
+	return m.shared
+}
+
+func (m *memoryStores) scoped(ctx context.Context) bool {
+	user := m.userStore(ctx)
+	return user != nil && user != m.shared
+}
+
+// MemorySaveTool stores a fact in long-term memory.
+type MemorySaveTool struct {
+	memoryStores
+	channel string
+	chatID  string
+}
+
+func NewMemorySaveTool(shared *memory.Store) *MemorySaveTool {
+	return &MemorySaveTool{memoryStores: memoryStores{shared: shared}}
+}
+
+// SetContext records the session that saved facts come from.
+func (t *MemorySaveTool) SetContext(channel, chatID string) {
+	t.channel = channel
+	t.chatID = chatID
+}
+
+func (t *MemorySaveTool) Name() string {
+	return "memory_save"
+}
+
+func (t *MemorySaveTool) Description() string {
+	return "Remember a durable fact for future conversations, e.g. a user preference, a person's details or a decision. Restating a known fact updates it instead of duplicating it; pass id to correct a specific memory."
+}
+
+func (t *MemorySaveTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"subject": map[string]interface{}{
+				"type":        "string",
+				"description": "Who or what the fact is about, e.g. \"user\", a person's name or a project",
+			},
+			"content": map[string]interface{}{
+				"type":        "string",
+				"description": "The fact as one short, self-contained sentence",
+			},
+			"confidence": map[string]interface{}{
+				"type":        "number",
+				"description": "How sure you are, from 0 to 1 (default 1 for things the user stated)",
+				"minimum":     0.0,
+				"maximum":     1.0,
+			},
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "ID of an existing memory to replace",
+			},
+			"shared": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Save to the memory shared with all users (e.g. household facts) instead of the current user's",
+			},
+		},
+		"required": []string{"subject", "content"},
+	}
+}
+
+func (t *MemorySaveTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	content, _ := args["content"].(string)
+	if strings.TrimSpace(content) == "" {
+		return "", fmt.Errorf("content is required")
+	}
+	fact := memory.Fact{Content: content}
+	fact.Subject, _ = args["subject"].(string)
+	fact.ID, _ = args["id"].(string)
+	fact.Confidence, _ = args["confidence"].(float64)
+	if channel, chatID := chatFor(ctx, t.channel, t.chatID); channel != "" {
+		fact.Source = channel + ":" + chatID
+	}
+
+	shared, _ := args["shared"].(bool)
+	saved, updated, err := t.store(ctx, shared).Save(fact)
+	if err != nil {
+		return "", err
+	}
+	if updated {
+		return fmt.Sprintf("Updated memory %s: %s: %s", saved.ID, saved.Subject, saved.Content), nil
+	}
+	return fmt.Sprintf("Saved memory %s: %s: %s", saved.ID, saved.Subject, saved.Content), nil
+}
+
+// MemorySearchTool looks up facts in long-term memory.
+type MemorySearchTool struct {
+	memoryStores
+}
+
+func NewMemorySearchTool(shared *memory.Store) *MemorySearchTool {
+	return &MemorySearchTool{memoryStores{shared: shared}}
+}
+
+func (t *MemorySearchTool) Name() string {
+	return "memory_search"
+}
+
+func (t *MemorySearchTool) Description() string {
+	return "Search remembered facts by keywords. Use it before answering questions about the user or earlier conversations when the facts in your prompt are not enough. An empty query lists the latest memories."
+}
+
+func (t *MemorySearchTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"query": map[string]interface{}{
+				"type":        "string",
+				"description": "Keywords to look for",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of memories to return (default 10)",
+				"minimum":     1.0,
+				"maximum":     50.0,
+			},
+		},
+	}
+}
+
+func (t *MemorySearchTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	query, _ := args["query"].(string)
+	limit := 10
+	if n, ok := args["limit"].(float64); ok && n > 0 {
+		limit = min(int(n), 50)
+	}
+
+	matches := t.store(ctx, false).Search(query, limit)
+	sharedIDs := make(map[string]bool)
+	if t.scoped(ctx) {
+		for _, m := range t.shared.Search(query, limit) {
+			sharedIDs[m.ID] = true
+			matches = append(matches, m)
+		}
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+		matches = matches[:min(limit, len(matches))]
+	}
+	if len(matches) == 0 {
+		if t.store(ctx, false).Len() == 0 && t.shared.Len() == 0 {
+			return "No memories saved yet.", nil
+		}
+		return fmt.Sprintf("No memories match %q.", query), nil
+	}
+
+	var sb strings.Builder
+	for _, m := range matches {
+		sb.WriteString(memory.FormatFact(m.Fact))
+		fmt.Fprintf(&sb, " (confidence %.1f, updated %s", m.Confidence, m.UpdatedAt.Format("2006-01-02"))
+		if m.Source != "" {
+			sb.WriteString(", from " + m.Source)
+		}
+		if sharedIDs[m.ID] {
+			sb.WriteString(", shared")
+		}
+		sb.WriteString(")\n")
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// MemoryForgetTool deletes facts from long-term memory.
+type MemoryForgetTool struct {
+	memoryStores
+}
+
+func NewMemoryForgetTool(shared *memory.Store) *MemoryForgetTool {
+	return &MemoryForgetTool{memoryStores{shared: shared}}
+}
+
+func (t *MemoryForgetTool) Name() string {
+	return "memory_forget"
+}
+
+func (t *MemoryForgetTool) Description() string {
+	return "Delete a remembered fact by id (shown in brackets by memory_search and in your prompt), or every fact about a subject."
+}
+
+func (t *MemoryForgetTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "ID of the memory to delete",
+			},
+			"subject": map[string]interface{}{
+				"type":        "string",
+				"description": "Delete all memories about this subject instead",
+			},
+			"shared": map[string]interface{}{
+				"type":        "boolean",
+				"description": "With subject, delete from the memory shared with all users",
+			},
+		},
+	}
+}
+
+func (t *MemoryForgetTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	id, _ := args["id"].(string)
+	subject, _ := args["subject"].(string)
+	shared, _ := args["shared"].(bool)
+
+	switch {
+	case id != "":
+		id = strings.Trim(id, "[] ")
+		store := t.store(ctx, false)
+		if _, ok := store.Get(id); !ok {
+			store = t.shared
+		}
+		fact, err := store.Forget(id)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Forgot memory %s: %s: %s", fact.ID, fact.Subject, fact.Content), nil
+	case subject != "":
+		n, err := t.store(ctx, shared).ForgetSubject(subject)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Forgot %d memories about %s", n, subject), nil
+	default:
+		return "", fmt.Errorf("id or subject is required")
+	}
+}
@@ -3,6 +3,7 @@ package tools
 import (
 	"context"
 	"fmt"
+	"strings"
 )
 
 type SendCallback func(channel, chatID, content string) error
@@ -35,7 +36,7 @@ func (t *MessageTool) Parameters() map[string]interface{} {
 			},
 			"channel": map[string]interface{}{
 				"type":        "string",
-				"description": "Optional: target channel (telegram, whatsapp, etc.)",
+				"description": "Optional: target channel (telegram, whatsapp, etc.), or channel:chat_id such as http:<name> for a configured HTTP target",
 			},
 			"chat_id": map[string]interface{}{
 				"type":        "string",
@@ -46,6 +47,18 @@ func (t *MessageTool) Parameters() map[string]interface{} {
 	}
 }
 
+// splitTarget splits a "channel:chat_id" target such as "http:ntfy" given
+// as the channel when no chat ID is given separately.
+func splitTarget(channel, chatID string) (string, string) {
+	if chatID != "" {
+		return channel, chatID
+	}
+	if c, id, ok := strings.Cut(channel, ":"); ok && c != "" && id != "" {
+		return c, id
+	}
+	return channel, chatID
+}
+
 func (t *MessageTool) SetContext(channel, chatID string) {
 	t.defaultChannel = channel
 	t.defaultChatID = chatID
@@ -63,12 +76,14 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 
 	channel, _ := args["channel"].(string)
 	chatID, _ := args["chat_id"].(string)
+	channel, chatID = splitTarget(channel, chatID)
 
+	defaultChannel, defaultChatID := chatFor(ctx, t.defaultChannel, t.defaultChatID)
 	if channel == "" {
-		channel = t.defaultChannel
+		channel = defaultChannel
 	}
 	if chatID == "" {
-		chatID = t.defaultChatID
+		chatID = defaultChatID
 	}
 
 	if channel == "" || chatID == "" {
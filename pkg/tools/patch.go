@@ -0,0 +1,735 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	diffContextLines = 3
+	patchMaxFuzz     = 2
+	diffMaxCells     = 4_000_000
+)
+
+// ApplyPatchTool applies a unified diff to one or more files. Hunks are
+// located with an offset search, whitespace-insensitive matching and reduced
+// context, like patch(1) fuzz. Nothing is written unless every hunk applies.
+type ApplyPatchTool struct {
+	paths *PathResolver
+}
+
+// NewApplyPatchTool creates an ApplyPatchTool confined by paths.
+func NewApplyPatchTool(paths *PathResolver) *ApplyPatchTool {
+	return &ApplyPatchTool{paths: paths}
+}
+
+func (t *ApplyPatchTool) Name() string {
+	return "apply_patch"
+}
+
+func (t *ApplyPatchTool) Description() string {
+	return "Apply a unified diff (as produced by diff -u or git diff) to one or more files. Hunks are matched even if line numbers or whitespace are slightly off; a per-hunk report is returned. If any hunk fails, no files are changed. Use /dev/null as the old path to create a file."
+}
+
+func (t *ApplyPatchTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"patch": map[string]interface{}{
+				"type":        "string",
+				"description": "The unified diff to apply",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Target file, for a patch that has only @@ hunks and no ---/+++ headers",
+			},
+			"dry_run": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Check the patch and return the resulting diff without writing",
+			},
+		},
+		"required": []string{"patch"},
+	}
+}
+
+func (t *ApplyPatchTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	patchText, ok := args["patch"].(string)
+	if !ok || strings.TrimSpace(patchText) == "" {
+		return "", fmt.Errorf("patch is required")
+	}
+	defaultPath, _ := args["path"].(string)
+	dryRun, _ := args["dry_run"].(bool)
+
+	files, err := parsePatch(patchText, defaultPath)
+	if err != nil {
+		return "", err
+	}
+
+	type change struct {
+		fp       *filePatch
+		src, dst string
+		hunks    []*patchHunk
+		before   string
+		after    string
+		results  []hunkResult
+		mode     os.FileMode
+	}
+
+	// Sections for the same file are merged so their hunks apply to one
+	// version of it instead of the later one overwriting the earlier
+	var changes []*change
+	byPath := map[string]*change{}
+	for _, fp := range files {
+		c := &change{fp: fp, mode: 0644}
+		if fp.oldPath != "" {
+			if c.src, err = t.paths.ResolveContext(ctx, fp.oldPath, true); err != nil {
+				return "", err
+			}
+		}
+		if fp.newPath != "" {
+			if c.dst, err = t.paths.ResolveContext(ctx, fp.newPath, true); err != nil {
+				return "", err
+			}
+		}
+		if prev, ok := byPath[c.src+"\x00"+c.dst]; ok {
+			prev.hunks = append(prev.hunks, fp.hunks...)
+			continue
+		}
+		for _, p := range []string{c.src, c.dst} {
+			if p == "" {
+				continue
+			}
+			if prev, ok := byPath[p]; ok && prev != c {
+				return "", fmt.Errorf("patch changes %s in both %s and %s; combine them into one section",
+					p, prev.fp.displayName(), fp.displayName())
+			}
+			byPath[p] = c
+		}
+		c.hunks = fp.hunks
+		byPath[c.src+"\x00"+c.dst] = c
+		changes = append(changes, c)
+	}
+
+	failed := 0
+	for _, c := range changes {
+		if c.src != "" {
+			data, err := os.ReadFile(c.src)
+			if err != nil {
+				return "", fmt.Errorf("failed to read %s: %w", c.fp.oldPath, err)
+			}
+			c.before = string(data)
+			if info, err := os.Stat(c.src); err == nil {
+				c.mode = info.Mode().Perm()
+			}
+			if c.dst != "" && c.dst != c.src {
+				if _, err := os.Lstat(c.dst); err == nil {
+					return "", fmt.Errorf("cannot rename %s to %s: file already exists", c.fp.oldPath, c.fp.newPath)
+				}
+			}
+		} else if _, err := os.Stat(c.dst); err == nil {
+			return "", fmt.Errorf("cannot create %s: file already exists", c.fp.newPath)
+		}
+
+		c.after, c.results = applyHunks(c.before, sortHunks(c.hunks))
+		for _, h := range c.results {
+			if !h.ok {
+				failed++
+			}
+		}
+	}
+
+	var report strings.Builder
+	for _, c := range changes {
+		report.WriteString(c.fp.displayName() + "\n")
+		for _, h := range c.results {
+			fmt.Fprintf(&report, "  %s\n", h)
+		}
+	}
+
+	if failed > 0 {
+		return "", fmt.Errorf("patch not applied: %d hunk(s) failed, no files were changed\n%s",
+			failed, strings.TrimRight(report.String(), "\n"))
+	}
+
+	if dryRun {
+		var diff strings.Builder
+		for _, c := range changes {
+			diff.WriteString(unifiedDiff(c.fp.displayName(), c.before, c.after))
+		}
+		return fmt.Sprintf("Dry run: patch applies cleanly to %d file(s)\n%s\n%s",
+			len(changes), report.String(), diff.String()), nil
+	}
+
+	// Every new version is written to a temporary file first, so a failed
+	// write leaves all files untouched; the renames then replace them
+	temps := make([]string, len(changes))
+	cleanup := func() {
+		for _, tmp := range temps {
+			if tmp != "" {
+				os.Remove(tmp)
+			}
+		}
+	}
+	for i, c := range changes {
+		if c.dst == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(c.dst), 0755); err != nil {
+			cleanup()
+			return "", fmt.Errorf("failed to create directory: %w", err)
+		}
+		tmp, err := writeTemp(c.dst, []byte(c.after), c.mode)
+		if err != nil {
+			cleanup()
+			return "", fmt.Errorf("failed to write %s: %w", c.fp.newPath, err)
+		}
+		temps[i] = tmp
+	}
+	for i, c := range changes {
+		if c.dst == "" {
+			continue
+		}
+		if err := os.Rename(temps[i], c.dst); err != nil {
+			cleanup()
+			return "", fmt.Errorf("failed to write %s: %w", c.fp.newPath, err)
+		}
+		temps[i] = ""
+	}
+	for _, c := range changes {
+		if c.src != "" && c.src != c.dst {
+			if err := os.Remove(c.src); err != nil {
+				return "", fmt.Errorf("failed to remove %s: %w", c.fp.oldPath, err)
+			}
+		}
+	}
+
+	return fmt.Sprintf("Patch applied to %d file(s)\n%s", len(changes), strings.TrimRight(report.String(), "\n")), nil
+}
+
+// writeTemp writes data to a new temporary file next to path and returns its name.
+func writeTemp(path string, data []byte, mode os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// sortHunks orders hunks merged from several sections by their line
+// numbers. Hunks without line numbers keep the order they were given in.
+func sortHunks(hunks []*patchHunk) []*patchHunk {
+	for _, h := range hunks {
+		if h.oldStart == 0 {
+			return hunks
+		}
+	}
+	sorted := append([]*patchHunk(nil), hunks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].oldStart < sorted[j].oldStart })
+	return sorted
+}
+
+// filePatch is the part of a unified diff that applies to a single file.
+// An empty oldPath creates the file, an empty newPath deletes it.
+type filePatch struct {
+	oldPath string
+	newPath string
+	hunks   []*patchHunk
+}
+
+func (fp *filePatch) displayName() string {
+	switch {
+	case fp.oldPath == "":
+		return fp.newPath + " (new file)"
+	case fp.newPath == "":
+		return fp.oldPath + " (deleted)"
+	case fp.oldPath != fp.newPath:
+		return fp.oldPath + " -> " + fp.newPath
+	}
+	return fp.newPath
+}
+
+type patchHunk struct {
+	header   string
+	oldStart int
+	lines    []patchLine
+}
+
+type patchLine struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// parsePatch parses a unified diff. Line counts in hunk headers are ignored
+// because hand-written diffs often get them wrong; a hunk runs until the next
+// hunk or file header. defaultPath is used for hunks without file headers.
+func parsePatch(text, defaultPath string) ([]*filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var files []*filePatch
+	var cur *filePatch
+	var hunk *patchHunk
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			cur = &filePatch{
+				oldPath: cleanPatchPath(line[4:]),
+				newPath: cleanPatchPath(lines[i+1][4:]),
+			}
+			if cur.oldPath == "" && cur.newPath == "" {
+				return nil, fmt.Errorf("invalid file header at line %d", i+1)
+			}
+			files = append(files, cur)
+			hunk = nil
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(line, "@@") {
+			if cur == nil {
+				if defaultPath == "" {
+					return nil, fmt.Errorf("hunk at line %d has no ---/+++ file header; pass path", i+1)
+				}
+				cur = &filePatch{oldPath: defaultPath, newPath: defaultPath}
+				files = append(files, cur)
+			}
+			hunk = &patchHunk{header: line}
+			if m := hunkHeaderRe.FindStringSubmatch(line); m != nil {
+				hunk.oldStart, _ = strconv.Atoi(m[1])
+			}
+			cur.hunks = append(cur.hunks, hunk)
+			continue
+		}
+
+		if hunk == nil {
+			// Preamble such as "diff --git" or "index" lines
+			continue
+		}
+
+		switch {
+		case line == "":
+			hunk.lines = append(hunk.lines, patchLine{kind: ' '})
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			hunk.lines = append(hunk.lines, patchLine{kind: line[0], text: line[1:]})
+		case line[0] == '\\':
+			// "\ No newline at end of file"
+		default:
+			hunk = nil
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no hunks found in patch")
+	}
+	for _, fp := range files {
+		if len(fp.hunks) == 0 && fp.newPath != "" {
+			return nil, fmt.Errorf("no hunks for %s", fp.displayName())
+		}
+	}
+	return files, nil
+}
+
+// cleanPatchPath strips timestamps and the a/ b/ prefixes git adds.
+// /dev/null becomes the empty string.
+func cleanPatchPath(p string) string {
+	if i := strings.IndexByte(p, '\t'); i >= 0 {
+		p = p[:i]
+	}
+	p = strings.TrimSpace(p)
+	if p == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(p, "a/") || strings.HasPrefix(p, "b/") {
+		p = p[2:]
+	}
+	return p
+}
+
+// hunkResult describes where (or whether) a hunk applied.
+type hunkResult struct {
+	index  int
+	header string
+	ok     bool
+	line   int
+	offset int
+	fuzz   int
+	loose  bool
+	reason string
+}
+
+func (h hunkResult) String() string {
+	prefix := fmt.Sprintf("hunk %d", h.index)
+	if h.header != "" {
+		prefix += " " + h.header
+	}
+	if !h.ok {
+		return prefix + ": FAILED, " + h.reason
+	}
+
+	var notes []string
+	if h.offset != 0 {
+		notes = append(notes, fmt.Sprintf("offset %+d", h.offset))
+	}
+	if h.loose {
+		notes = append(notes, "whitespace-insensitive")
+	}
+	if h.fuzz > 0 {
+		notes = append(notes, fmt.Sprintf("fuzz %d", h.fuzz))
+	}
+	result := fmt.Sprintf("%s: applied at line %d", prefix, h.line)
+	if len(notes) > 0 {
+		result += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return result
+}
+
+// applyHunks applies hunks in order to content and returns the new content
+// together with a result per hunk.
+func applyHunks(content string, hunks []*patchHunk) (string, []hunkResult) {
+	lines, trailingNewline := splitLines(content)
+	if content == "" {
+		trailingNewline = true
+	}
+
+	results := make([]hunkResult, 0, len(hunks))
+	delta := 0  // lines added minus lines removed by earlier hunks
+	minPos := 0 // hunks apply in order and must not overlap
+	for i, h := range hunks {
+		res := hunkResult{index: i + 1, header: h.header}
+		expected := h.oldStart - 1 + delta
+		if h.oldStart == 0 {
+			expected = 0
+		}
+
+		pos, trimmed, fuzz, loose, ok := locateHunk(lines, h.lines, expected, minPos)
+		if !ok {
+			res.reason = "could not find the context"
+			for _, l := range h.lines {
+				if l.kind != '+' {
+					res.reason += fmt.Sprintf(" starting with %q", l.text)
+					break
+				}
+			}
+			if h.oldStart > 0 {
+				res.reason += fmt.Sprintf(" near line %d", h.oldStart)
+			}
+			results = append(results, res)
+			continue
+		}
+
+		// Context lines keep the file's own text; only -/+ lines come from the patch
+		var replacement []string
+		consumed := 0
+		for _, l := range trimmed {
+			switch l.kind {
+			case ' ':
+				replacement = append(replacement, lines[pos+consumed])
+				consumed++
+			case '-':
+				consumed++
+			case '+':
+				replacement = append(replacement, l.text)
+			}
+		}
+
+		lines = append(lines[:pos], append(replacement, lines[pos+consumed:]...)...)
+		delta += len(replacement) - consumed
+		minPos = pos + len(replacement)
+
+		res.ok = true
+		res.line = pos + 1
+		res.fuzz = fuzz
+		res.loose = loose
+		if h.oldStart > 0 {
+			res.offset = pos - expected
+		}
+		results = append(results, res)
+	}
+
+	return joinLines(lines, trailingNewline), results
+}
+
+// locateHunk finds where a hunk's old lines occur, trying exact matches
+// first, then whitespace-insensitive ones, then with up to patchMaxFuzz
+// context lines dropped from each end. It returns the position and the hunk
+// lines actually used.
+func locateHunk(lines []string, hunk []patchLine, expected, minPos int) (int, []patchLine, int, bool, bool) {
+	for fuzz := 0; fuzz <= patchMaxFuzz; fuzz++ {
+		trimmed, skipped, ok := trimContext(hunk, fuzz)
+		if !ok {
+			break
+		}
+		var old []string
+		for _, l := range trimmed {
+			if l.kind != '+' {
+				old = append(old, l.text)
+			}
+		}
+
+		for _, loose := range []bool{false, true} {
+			eq := linesEqual
+			if loose {
+				eq = linesEqualLoose
+			}
+			if pos, ok := nearestMatch(lines, old, expected+skipped, minPos, eq); ok {
+				return pos, trimmed, fuzz, loose, true
+			}
+		}
+	}
+	return 0, nil, 0, false, false
+}
+
+// trimContext drops up to fuzz leading and trailing context lines. skipped is
+// the number of leading lines dropped. It fails when there is no context left
+// to drop, so fuzz never removes -/+ lines.
+func trimContext(hunk []patchLine, fuzz int) ([]patchLine, int, bool) {
+	if fuzz == 0 {
+		return hunk, 0, true
+	}
+	start, end := 0, len(hunk)
+	for i := 0; i < fuzz && start < end && hunk[start].kind == ' '; i++ {
+		start++
+	}
+	for i := 0; i < fuzz && end > start && hunk[end-1].kind == ' '; i++ {
+		end--
+	}
+	if start == 0 && end == len(hunk) {
+		return nil, 0, false
+	}
+	return hunk[start:end], start, true
+}
+
+// nearestMatch returns the match of needle in lines at or after minPos that is
+// closest to expected.
+func nearestMatch(lines, needle []string, expected, minPos int, eq func(a, b string) bool) (int, bool) {
+	maxPos := len(lines) - len(needle)
+	if maxPos < minPos {
+		return 0, false
+	}
+	if expected < minPos {
+		expected = minPos
+	}
+	if expected > maxPos {
+		expected = maxPos
+	}
+
+	for d := 0; expected-d >= minPos || expected+d <= maxPos; d++ {
+		if p := expected - d; p >= minPos && matchAt(lines, needle, p, eq) {
+			return p, true
+		}
+		if p := expected + d; d > 0 && p <= maxPos && matchAt(lines, needle, p, eq) {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+func matchAt(lines, needle []string, pos int, eq func(a, b string) bool) bool {
+	for i, n := range needle {
+		if !eq(lines[pos+i], n) {
+			return false
+		}
+	}
+	return true
+}
+
+func linesEqual(a, b string) bool {
+	return a == b
+}
+
+// linesEqualLoose compares lines ignoring differences in whitespace.
+func linesEqualLoose(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// findLinesLoose returns every line index at which needle occurs in lines,
+// ignoring whitespace differences.
+func findLinesLoose(lines, needle []string) []int {
+	var found []int
+	for p := 0; p+len(needle) <= len(lines); p++ {
+		if matchAt(lines, needle, p, linesEqualLoose) {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+func splitLines(s string) ([]string, bool) {
+	if s == "" {
+		return nil, false
+	}
+	trailing := strings.HasSuffix(s, "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n"), trailing
+}
+
+func joinLines(lines []string, trailingNewline bool) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	s := strings.Join(lines, "\n")
+	if trailingNewline {
+		s += "\n"
+	}
+	return s
+}
+
+// unifiedDiff returns a unified diff between before and after, or "" if
+// they are equal.
+func unifiedDiff(name, before, after string) string {
+	if before == after {
+		return ""
+	}
+	a, _ := splitLines(before)
+	b, _ := splitLines(after)
+	ops := diffLines(a, b)
+
+	// Line positions before each op, for hunk headers
+	oldPos := make([]int, len(ops)+1)
+	newPos := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if op.kind != '+' {
+			oldPos[i+1]++
+		}
+		if op.kind != '-' {
+			newPos[i+1]++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", name, name)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk while the next change is within two context windows
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*diffContextLines {
+				break
+			}
+		}
+		stop := end + diffContextLines + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		oldCount := oldPos[stop] - oldPos[start]
+		newCount := newPos[stop] - newPos[start]
+		oldStart, newStart := oldPos[start]+1, newPos[start]+1
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, op := range ops[start:stop] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.text)
+			sb.WriteByte('\n')
+		}
+		i = stop
+	}
+
+	return sb.String()
+}
+
+// diffLines computes a line diff using an LCS table over the lines between
+// the common prefix and suffix. Very large inputs degrade to a full
+// replacement of the differing region.
+func diffLines(a, b []string) []patchLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []patchLine
+	for _, l := range a[:prefix] {
+		ops = append(ops, patchLine{kind: ' ', text: l})
+	}
+
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(am), len(bm)
+	if n*m > diffMaxCells {
+		for _, l := range am {
+			ops = append(ops, patchLine{kind: '-', text: l})
+		}
+		for _, l := range bm {
+			ops = append(ops, patchLine{kind: '+', text: l})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of am[i:] and bm[j:]
+		lcs := make([][]int32, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if am[i] == bm[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && am[i] == bm[j]:
+				ops = append(ops, patchLine{kind: ' ', text: am[i]})
+				i++
+				j++
+			case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+				ops = append(ops, patchLine{kind: '+', text: bm[j]})
+				j++
+			default:
+				ops = append(ops, patchLine{kind: '-', text: am[i]})
+				i++
+			}
+		}
+	}
+
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, patchLine{kind: ' ', text: l})
+	}
+	return ops
+}
@@ -0,0 +1,246 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const patchBase = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+	fmt.Println("world")
+}
+
+func helper() int {
+	return 1
+}
+`
+
+func TestApplyHunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		patch    string
+		want     string
+		wantNote string
+	}{
+		{
+			name:    "Exact match",
+			content: patchBase,
+			patch: `@@ -5,4 +5,4 @@
+ func main() {
+-	fmt.Println("hello")
++	fmt.Println("hi")
+ 	fmt.Println("world")
+ }`,
+			want:     strings.Replace(patchBase, `"hello"`, `"hi"`, 1),
+			wantNote: "applied at line 5",
+		},
+		{
+			name:    "Wrong line numbers",
+			content: patchBase,
+			patch: `@@ -1,3 +1,3 @@
+ func helper() int {
+-	return 1
++	return 2
+ }`,
+			want:     strings.Replace(patchBase, "return 1", "return 2", 1),
+			wantNote: "offset +9",
+		},
+		{
+			name:    "Whitespace differences",
+			content: patchBase,
+			patch: `@@ -10,3 +10,3 @@
+ func helper()  int {
+-    return 1
++	return 3
+ }`,
+			want:     strings.Replace(patchBase, "return 1", "return 3", 1),
+			wantNote: "whitespace-insensitive",
+		},
+		{
+			name:    "Stale context needs fuzz",
+			content: patchBase,
+			patch: `@@ -5,5 +5,5 @@
+ func main() {
+ 	fmt.Println("hello")
+-	fmt.Println("world")
++	fmt.Println("there")
+ }
+ // this comment is not in the file`,
+			want:     strings.Replace(patchBase, `"world"`, `"there"`, 1),
+			wantNote: "fuzz 1",
+		},
+		{
+			name:    "Missing context fails",
+			content: patchBase,
+			patch: `@@ -5,3 +5,3 @@
+-	fmt.Println("goodbye")
++	fmt.Println("hi")`,
+			wantNote: "FAILED",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := parsePatch(tt.patch, "main.go")
+			if err != nil {
+				t.Fatalf("parsePatch: %v", err)
+			}
+			got, results := applyHunks(tt.content, files[0].hunks)
+			if !strings.Contains(results[0].String(), tt.wantNote) {
+				t.Errorf("result = %q, want containing %q", results[0].String(), tt.wantNote)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("content =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffRoundTrip(t *testing.T) {
+	after := strings.Replace(patchBase, "return 1", "return 42", 1)
+	after = strings.Replace(after, "import \"fmt\"\n", "import (\n\t\"fmt\"\n\t\"os\"\n)\n", 1)
+
+	diff := unifiedDiff("main.go", patchBase, after)
+	files, err := parsePatch(diff, "")
+	if err != nil {
+		t.Fatalf("parsePatch: %v", err)
+	}
+	got, results := applyHunks(patchBase, files[0].hunks)
+	for _, r := range results {
+		if !r.ok {
+			t.Fatalf("hunk failed: %s\n%s", r, diff)
+		}
+	}
+	if got != after {
+		t.Errorf("round trip mismatch:\n%s", got)
+	}
+}
+
+func TestApplyPatchToolAllOrNothing(t *testing.T) {
+	workspace := t.TempDir()
+	target := filepath.Join(workspace, "main.go")
+	if err := os.WriteFile(target, []byte(patchBase), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewApplyPatchTool(NewPathResolver(workspace, true))
+
+	patch := `--- a/main.go
++++ b/main.go
+@@ -6,1 +6,1 @@
+-	fmt.Println("hello")
++	fmt.Println("hi")
+@@ -11,1 +11,1 @@
+-	return 99
++	return 2
+--- /dev/null
++++ b/new.txt
+@@ -0,0 +1,1 @@
++created`
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"patch": patch}); err == nil {
+		t.Fatal("expected failure for unmatched hunk")
+	}
+	data, _ := os.ReadFile(target)
+	if string(data) != patchBase {
+		t.Error("file was modified although a hunk failed")
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "new.txt")); !os.IsNotExist(err) {
+		t.Error("new file was created although a hunk failed")
+	}
+
+	patch = strings.Replace(patch, "return 99", "return 1", 1)
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"patch": patch}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	created, _ := os.ReadFile(filepath.Join(workspace, "new.txt"))
+	if string(created) != "created\n" {
+		t.Errorf("new.txt = %q", created)
+	}
+}
+
+func TestApplyPatchToolSameFileTwice(t *testing.T) {
+	workspace := t.TempDir()
+	path := filepath.Join(workspace, "main.go")
+	if err := os.WriteFile(path, []byte(patchBase), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewApplyPatchTool(NewPathResolver(workspace, true))
+
+	// Two sections for main.go, the later one touching an earlier line
+	patch := `--- a/main.go
++++ b/main.go
+@@ -10,3 +10,3 @@
+ func helper() int {
+-	return 1
++	return 2
+ }
+--- a/main.go
++++ b/main.go
+@@ -5,3 +5,3 @@
+ func main() {
+-	fmt.Println("hello")
++	fmt.Println("hi")
+ 	fmt.Println("world")
+`
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"patch": patch}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	want := strings.Replace(strings.Replace(patchBase, "return 1", "return 2", 1), `"hello"`, `"hi"`, 1)
+	if string(data) != want {
+		t.Errorf("content =\n%s\nwant\n%s", data, want)
+	}
+	entries, _ := os.ReadDir(workspace)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	// A rename and an edit of the renamed file cannot both apply
+	patch = `--- a/main.go
++++ b/app.go
+@@ -10,3 +10,3 @@
+ func helper() int {
+-	return 2
++	return 3
+ }
+--- a/app.go
++++ b/app.go
+@@ -5,3 +5,3 @@
+ func main() {
+-	fmt.Println("hi")
++	fmt.Println("hey")
+ 	fmt.Println("world")
+`
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"patch": patch}); err == nil || !strings.Contains(err.Error(), "combine them") {
+		t.Errorf("overlapping sections: err = %v", err)
+	}
+
+	// A rename does not overwrite a file already at its destination
+	if err := os.WriteFile(filepath.Join(workspace, "app.go"), []byte("keep\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patch = `--- a/main.go
++++ b/app.go
+@@ -10,3 +10,3 @@
+ func helper() int {
+-	return 2
++	return 3
+ }
+`
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"patch": patch}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("rename onto an existing file: err = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(workspace, "app.go")); string(data) != "keep\n" {
+		t.Errorf("app.go = %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Error("main.go changed by a refused rename")
+	}
+}
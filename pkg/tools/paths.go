@@ -0,0 +1,236 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+// PathRoot is a directory the file tools may access.
+type PathRoot struct {
+	Path     string
+	ReadOnly bool
+}
+
+// PathFilter reports whether a resolved path may be accessed.
+type PathFilter func(path string) bool
+
+type pathFilterKey struct{}
+
+// WithPathFilter returns a context in which the file tools refuse the paths
+// that allow rejects, on top of the resolver's roots.
+func WithPathFilter(ctx context.Context, allow PathFilter) context.Context {
+	return context.WithValue(ctx, pathFilterKey{}, allow)
+}
+
+func pathAllowed(ctx context.Context, path string) bool {
+	allow, _ := ctx.Value(pathFilterKey{}).(PathFilter)
+	return allow == nil || allow(path)
+}
+
+// DenyWithin returns a PathFilter that rejects paths inside dir, except
+// those inside one of the exceptions.
+func DenyWithin(dir string, except ...string) PathFilter {
+	dir = resolveSymlinks(absPath(dir))
+	allowed := make([]string, 0, len(except))
+	for _, e := range except {
+		if e != "" {
+			allowed = append(allowed, resolveSymlinks(absPath(e)))
+		}
+	}
+	return func(path string) bool {
+		if !isWithin(dir, path) {
+			return true
+		}
+		for _, e := range allowed {
+			if isWithin(e, path) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PathResolver turns user-supplied paths into absolute, symlink-free paths and
+// confines them to a set of allowed roots. Every file tool resolves its paths
+// through it. A nil *PathResolver allows any path.
+type PathResolver struct {
+	workspace string
+	roots     []PathRoot
+	restrict  bool
+}
+
+// NewPathResolver creates a resolver rooted at workspace (read-write) plus extra roots.
+// Relative paths are resolved against the workspace.
+func NewPathResolver(workspace string, restrict bool, extra ...PathRoot) *PathResolver {
+	r := &PathResolver{
+		workspace: resolveSymlinks(absPath(workspace)),
+		restrict:  restrict,
+	}
+	r.roots = append(r.roots, PathRoot{Path: r.workspace})
+	for _, root := range extra {
+		if root.Path == "" {
+			continue
+		}
+		r.roots = append(r.roots, PathRoot{
+			Path:     resolveSymlinks(absPath(config.ExpandHome(root.Path))),
+			ReadOnly: root.ReadOnly,
+		})
+	}
+	return r
+}
+
+// NewPathResolverFromConfig creates a resolver from the tools.filesystem config section.
+func NewPathResolverFromConfig(workspace string, cfg config.FilesystemConfig, extra ...PathRoot) *PathResolver {
+	roots := make([]PathRoot, 0, len(cfg.AllowedRoots)+len(extra))
+	for _, root := range cfg.AllowedRoots {
+		roots = append(roots, PathRoot{Path: root.Path, ReadOnly: root.ReadOnly})
+	}
+	roots = append(roots, extra...)
+	return NewPathResolver(workspace, cfg.RestrictToWorkspace, roots...)
+}
+
+// Workspace returns the resolved workspace directory.
+func (r *PathResolver) Workspace() string {
+	if r == nil {
+		return ""
+	}
+	return r.workspace
+}
+
+// Roots returns the allowed roots, workspace first.
+func (r *PathResolver) Roots() []PathRoot {
+	if r == nil {
+		return nil
+	}
+	return r.roots
+}
+
+// Resolve returns the absolute, symlink-resolved form of path and checks it
+// against the allowed roots. write must be true for operations that modify
+// the file system.
+func (r *PathResolver) Resolve(path string, write bool) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+
+	path = config.ExpandHome(path)
+	if r == nil {
+		return absPath(path), nil
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.workspace, path)
+	}
+	resolved := resolveSymlinks(filepath.Clean(path))
+
+	if !r.restrict {
+		return resolved, nil
+	}
+
+	root, ok := r.rootFor(resolved)
+	if !ok {
+		return "", fmt.Errorf("access denied: %s is outside the allowed directories (%s)", path, r.describeRoots())
+	}
+	if write && root.ReadOnly {
+		return "", fmt.Errorf("access denied: %s is in read-only directory %s", path, root.Path)
+	}
+
+	return resolved, nil
+}
+
+// ResolveContext is Resolve that also applies the PathFilter of ctx.
+func (r *PathResolver) ResolveContext(ctx context.Context, path string, write bool) (string, error) {
+	resolved, err := r.Resolve(path, write)
+	if err != nil {
+		return "", err
+	}
+	if !pathAllowed(ctx, resolved) {
+		return "", fmt.Errorf("access denied: %s is not accessible to this user", path)
+	}
+	return resolved, nil
+}
+
+// rootFor returns the most specific allowed root containing path.
+func (r *PathResolver) rootFor(path string) (PathRoot, bool) {
+	var best PathRoot
+	found := false
+	for _, root := range r.roots {
+		if !isWithin(root.Path, path) {
+			continue
+		}
+		if !found || len(root.Path) > len(best.Path) {
+			best = root
+			found = true
+		}
+	}
+	return best, found
+}
+
+func (r *PathResolver) describeRoots() string {
+	parts := make([]string, 0, len(r.roots))
+	for _, root := range r.roots {
+		if root.ReadOnly {
+			parts = append(parts, root.Path+" (read-only)")
+		} else {
+			parts = append(parts, root.Path)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// isWithin reports whether path equals root or lies beneath it. Unlike a raw
+// prefix check it does not treat /root/workspace-evil as inside /root/workspace.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// resolveSymlinks resolves symlinks in the longest existing prefix of path and
+// re-appends the non-existent remainder, so paths of files about to be
+// created are resolved too. Dangling symlinks are followed to their target.
+func resolveSymlinks(path string) string {
+	return resolveSymlinksDepth(path, 0)
+}
+
+func resolveSymlinksDepth(path string, depth int) string {
+	existing := path
+	var rest []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+
+		// A dangling symlink: follow it by hand so writes through it are checked
+		// against where they would actually land.
+		if info, err := os.Lstat(existing); err == nil && info.Mode()&os.ModeSymlink != 0 && depth < 40 {
+			if target, err := os.Readlink(existing); err == nil {
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(filepath.Dir(existing), target)
+				}
+				return resolveSymlinksDepth(filepath.Join(append([]string{target}, rest...)...), depth+1)
+			}
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = parent
+	}
+}
@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathResolverConfinement(t *testing.T) {
+	base := t.TempDir()
+	workspace := filepath.Join(base, "workspace")
+	evil := filepath.Join(base, "workspace-evil")
+	shared := filepath.Join(base, "shared")
+	for _, dir := range []string{workspace, evil, shared} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(evil, filepath.Join(workspace, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(evil, "missing.txt"), filepath.Join(workspace, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewPathResolver(workspace, true, PathRoot{Path: shared, ReadOnly: true})
+
+	tests := []struct {
+		name    string
+		path    string
+		write   bool
+		wantErr string
+	}{
+		{"Relative path inside workspace", "notes/todo.md", true, ""},
+		{"Absolute path inside workspace", filepath.Join(workspace, "a.txt"), true, ""},
+		{"Sibling with workspace prefix", filepath.Join(evil, "a.txt"), false, "outside the allowed directories"},
+		{"Dot-dot traversal", "../workspace-evil/a.txt", false, "outside the allowed directories"},
+		{"Symlinked directory escape", "escape/a.txt", false, "outside the allowed directories"},
+		{"Dangling symlink escape", "dangling", true, "outside the allowed directories"},
+		{"Read from read-only root", filepath.Join(shared, "a.txt"), false, ""},
+		{"Write to read-only root", filepath.Join(shared, "a.txt"), true, "read-only"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := r.Resolve(tt.path, tt.write)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Resolve(%q) unexpected error: %v", tt.path, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Resolve(%q) error = %v, want containing %q", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPathResolverUnrestricted(t *testing.T) {
+	workspace := t.TempDir()
+	outside := filepath.Join(resolveSymlinks(t.TempDir()), "a.txt")
+	r := NewPathResolver(workspace, false)
+
+	got, err := r.Resolve(outside, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != outside {
+		t.Errorf("Resolve = %q, want %q", got, outside)
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	workspace := t.TempDir()
+	users := filepath.Join(workspace, "memory", "users")
+	for _, user := range []string{"telegram_1", "telegram_2"} {
+		os.MkdirAll(filepath.Join(users, user), 0755)
+		os.WriteFile(filepath.Join(users, user, "facts.json"), []byte("secret of "+user), 0644)
+	}
+	r := NewPathResolver(workspace, true)
+	ctx := WithPathFilter(context.Background(), DenyWithin(users, filepath.Join(users, "telegram_1")))
+
+	if _, err := r.ResolveContext(ctx, "memory/users/telegram_1/facts.json", false); err != nil {
+		t.Errorf("own memory denied: %v", err)
+	}
+	if _, err := r.ResolveContext(ctx, "memory/users/telegram_2/facts.json", false); err == nil || !strings.Contains(err.Error(), "not accessible") {
+		t.Errorf("other user's memory = %v", err)
+	}
+	if _, err := r.ResolveContext(context.Background(), "memory/users/telegram_2/facts.json", false); err != nil {
+		t.Errorf("unfiltered context denied: %v", err)
+	}
+
+	out, err := NewGrepTool(r, nil).Execute(ctx, map[string]interface{}{"pattern": "secret"})
+	if err != nil || strings.Contains(out, "telegram_2") || !strings.Contains(out, "telegram_1") {
+		t.Errorf("grep = %q, %v", out, err)
+	}
+	out, _ = NewGlobTool(r, nil).Execute(ctx, map[string]interface{}{"pattern": "**/facts.json"})
+	if strings.Contains(out, "telegram_2") {
+		t.Errorf("glob listed another user's memory: %q", out)
+	}
+}
@@ -0,0 +1,463 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/bus"
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// ringBuffer keeps the most recent bytes written to it while tracking absolute
+// offsets, so readers can resume where they left off even after old output
+// has been discarded. Once full, new output overwrites the oldest in place.
+type ringBuffer struct {
+	mu    sync.Mutex
+	data  []byte // Grows up to limit before it starts wrapping around
+	limit int
+	head  int   // Index of the oldest retained byte
+	size  int   // Number of retained bytes
+	total int64 // Bytes ever written
+}
+
+func newRingBuffer(limit int) *ringBuffer {
+	return &ringBuffer{limit: limit}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	r.total += int64(n)
+	if len(p) > r.limit {
+		p = p[len(p)-r.limit:]
+	}
+	if r.size+len(p) > len(r.data) && len(r.data) < r.limit {
+		grown := make([]byte, min(r.limit, max(2*len(r.data), r.size+len(p))))
+		copy(grown, r.read(0, r.size))
+		r.data, r.head = grown, 0
+	}
+	for len(p) > 0 {
+		c := copy(r.data[(r.head+r.size)%len(r.data):], p)
+		p = p[c:]
+		r.size += c
+		if over := r.size - len(r.data); over > 0 {
+			r.head = (r.head + over) % len(r.data)
+			r.size = len(r.data)
+		}
+	}
+	return n, nil
+}
+
+// read returns n retained bytes starting i bytes after the oldest one.
+func (r *ringBuffer) read(i, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		start := (r.head + i + len(out)) % len(r.data)
+		end := min(start+n-len(out), len(r.data))
+		out = append(out, r.data[start:end]...)
+	}
+	return out
+}
+
+// Since returns up to max bytes starting at the absolute offset. If offset
+// points at discarded output, reading starts at the oldest retained byte.
+// It returns the chunk, the offset it actually starts at, and the total
+// number of bytes written so far.
+func (r *ringBuffer) Since(offset int64, max int) ([]byte, int64, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	first := r.total - int64(r.size)
+	if offset < first {
+		offset = first
+	}
+	if offset > r.total {
+		offset = r.total
+	}
+
+	n := int(r.total - offset)
+	if max > 0 && n > max {
+		n = max
+	}
+	return r.read(int(offset-first), n), offset, r.total
+}
+
+// Tail returns the last n retained bytes.
+func (r *ringBuffer) Tail(n int) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n > r.size {
+		n = r.size
+	}
+	return string(r.read(r.size-n, n))
+}
+
+// ManagedProcess is a background command started by the agent.
+type ManagedProcess struct {
+	ID            string
+	Command       string
+	Label         string
+	Dir           string
+	OriginChannel string
+	OriginChatID  string
+	Started       time.Time
+
+	mu       sync.RWMutex
+	status   string // running, exited, killed, failed
+	exitCode int
+	finished time.Time
+	killed   bool
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	input  chan struct{} // Held while a write to stdin is in flight
+	stdout *ringBuffer
+	stderr *ringBuffer
+	done   chan struct{}
+}
+
+// startedIn reports whether the process was started from the given chat.
+func (p *ManagedProcess) startedIn(channel, chatID string) bool {
+	return p.OriginChannel == channel && p.OriginChatID == chatID
+}
+
+// Status returns the process status, exit code and finish time.
+func (p *ManagedProcess) Status() (string, int, time.Time) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.status, p.exitCode, p.finished
+}
+
+// Pid returns the OS process ID.
+func (p *ManagedProcess) Pid() int {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// Output returns the ring buffer for "stdout" or "stderr".
+func (p *ManagedProcess) Output(stream string) *ringBuffer {
+	if stream == "stderr" {
+		return p.stderr
+	}
+	return p.stdout
+}
+
+// ProcessManager runs long-lived commands outside the request lifecycle so
+// they survive across agent turns, and reports their exit to the chat that
+// started them.
+type ProcessManager struct {
+	processes    map[string]*ManagedProcess
+	mu           sync.RWMutex
+	policy       *ExecPolicy
+	bus          *bus.MessageBus
+	workingDir   string
+	maxProcesses int
+	bufferBytes  int
+	notifyOnExit bool
+	nextID       int
+}
+
+// maxFinishedProcesses bounds how many exited processes are kept for inspection.
+const maxFinishedProcesses = 16
+
+// processInputTimeout bounds how long a tool call waits for a process to
+// take its input.
+var processInputTimeout = 5 * time.Second
+
+func NewProcessManager(workingDir string, policy *ExecPolicy, msgBus *bus.MessageBus, cfg config.ProcessConfig) *ProcessManager {
+	maxProcesses := cfg.MaxProcesses
+	if maxProcesses <= 0 {
+		maxProcesses = 8
+	}
+	bufferBytes := cfg.OutputBufferBytes
+	if bufferBytes <= 0 {
+		bufferBytes = 256 * 1024
+	}
+	return &ProcessManager{
+		processes:    make(map[string]*ManagedProcess),
+		policy:       policy,
+		bus:          msgBus,
+		workingDir:   workingDir,
+		maxProcesses: maxProcesses,
+		bufferBytes:  bufferBytes,
+		notifyOnExit: cfg.NotifyOnExit,
+		nextID:       1,
+	}
+}
+
+// Start launches command through the exec policy and returns immediately.
+func (pm *ProcessManager) Start(command, dir, label, originChannel, originChatID string) (*ManagedProcess, error) {
+	if dir == "" {
+		dir = pm.workingDir
+	}
+
+	if pm.policy != nil {
+		decision := pm.policy.Evaluate(command, dir)
+		switch decision.Action {
+		case ExecActionDeny:
+			log.Printf("[SECURITY] ⛔ BLOCKED process: %s (rule: %s %s)", command, decision.Rule, decision.Pattern)
+			return nil, fmt.Errorf("command blocked by safety guard (%s)", decision.Reason)
+		case ExecActionWarn:
+			log.Printf("[SECURITY] ⚠️ RISKY process allowed: %s (matched: %s)", command, decision.Pattern)
+		}
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if running := pm.countRunning(); running >= pm.maxProcesses {
+		return nil, fmt.Errorf("too many running processes (%d/%d); kill one first", running, pm.maxProcesses)
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	if pm.policy != nil {
+		cmd.Env = pm.policy.Environ()
+	}
+	setProcessGroup(cmd)
+
+	proc := &ManagedProcess{
+		ID:            fmt.Sprintf("proc-%d", pm.nextID),
+		Command:       command,
+		Label:         label,
+		Dir:           dir,
+		OriginChannel: originChannel,
+		OriginChatID:  originChatID,
+		status:        "running",
+		cmd:           cmd,
+		stdout:        newRingBuffer(pm.bufferBytes),
+		stderr:        newRingBuffer(pm.bufferBytes),
+		input:         make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	cmd.Stdout = proc.stdout
+	cmd.Stderr = proc.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin: %w", err)
+	}
+	proc.stdin = stdin
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start process: %w", err)
+	}
+	proc.Started = time.Now()
+
+	pm.nextID++
+	pm.processes[proc.ID] = proc
+	pm.pruneFinished()
+
+	go pm.wait(proc)
+
+	return proc, nil
+}
+
+func (pm *ProcessManager) wait(proc *ManagedProcess) {
+	err := proc.cmd.Wait()
+
+	proc.mu.Lock()
+	proc.finished = time.Now()
+	proc.exitCode = 0
+	if proc.cmd.ProcessState != nil {
+		proc.exitCode = proc.cmd.ProcessState.ExitCode()
+	}
+	switch {
+	case proc.killed:
+		proc.status = "killed"
+	case err != nil && proc.exitCode == 0:
+		proc.status = "failed"
+	default:
+		proc.status = "exited"
+	}
+	status, exitCode := proc.status, proc.exitCode
+	proc.mu.Unlock()
+	close(proc.done)
+
+	log.Printf("Process %s %s (code %d): %s", proc.ID, status, exitCode, utils.Truncate(proc.Command, 80))
+
+	if !pm.notifyOnExit || pm.bus == nil || proc.OriginChannel == "" || proc.OriginChatID == "" {
+		return
+	}
+
+	name := proc.Label
+	if name == "" {
+		name = utils.Truncate(proc.Command, 60)
+	}
+	content := fmt.Sprintf("⚙️ Process %s (%s) %s with code %d after %s.",
+		proc.ID, name, status, exitCode, proc.finished.Sub(proc.Started).Round(time.Second))
+	if tail := proc.stdout.Tail(500); tail != "" {
+		content += fmt.Sprintf("\n\nLast output:\n```\n%s\n```", tail)
+	}
+
+	pm.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: proc.OriginChannel,
+		ChatID:  proc.OriginChatID,
+		Content: content,
+	})
+}
+
+// Get returns a process by ID if it was started from the given chat.
+// Processes of other chats are reported as not found.
+func (pm *ProcessManager) Get(id, channel, chatID string) (*ManagedProcess, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	proc, ok := pm.processes[id]
+	if !ok || !proc.startedIn(channel, chatID) {
+		return nil, false
+	}
+	return proc, true
+}
+
+// List returns the processes started from the given chat ordered by start time.
+func (pm *ProcessManager) List(channel, chatID string) []*ManagedProcess {
+	var procs []*ManagedProcess
+	for _, proc := range pm.all() {
+		if proc.startedIn(channel, chatID) {
+			procs = append(procs, proc)
+		}
+	}
+	return procs
+}
+
+// all returns every known process ordered by start time.
+func (pm *ProcessManager) all() []*ManagedProcess {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	procs := make([]*ManagedProcess, 0, len(pm.processes))
+	for _, proc := range pm.processes {
+		procs = append(procs, proc)
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].Started.Before(procs[j].Started)
+	})
+	return procs
+}
+
+// SendInput writes input to the stdin of a process started from the given
+// chat, optionally closing it afterwards. A process that does not read its
+// input within processInputTimeout makes it fail instead of blocking; the
+// write still completes once the process reads, and no other input is
+// accepted until then.
+func (pm *ProcessManager) SendInput(id, channel, chatID, input string, closeStdin bool) error {
+	proc, ok := pm.Get(id, channel, chatID)
+	if !ok {
+		return fmt.Errorf("process %s not found", id)
+	}
+	if status, _, _ := proc.Status(); status != "running" {
+		return fmt.Errorf("process %s is not running (%s)", id, status)
+	}
+
+	select {
+	case proc.input <- struct{}{}:
+	default:
+		return fmt.Errorf("process %s has not read the previous input yet", id)
+	}
+	written := make(chan error, 1)
+	go func() {
+		defer func() { <-proc.input }()
+		var err error
+		if input != "" {
+			if _, err = io.WriteString(proc.stdin, input); err != nil {
+				err = fmt.Errorf("failed to write to stdin: %w", err)
+			}
+		}
+		if err == nil && closeStdin {
+			err = proc.stdin.Close()
+		}
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		return err
+	case <-time.After(processInputTimeout):
+		return fmt.Errorf("process %s did not read its input within %s", id, processInputTimeout)
+	}
+}
+
+// Kill signals the process group of a process started from the given chat.
+// "term" and "int" escalate to "kill" if the process is still alive after a
+// grace period.
+func (pm *ProcessManager) Kill(id, channel, chatID, signal string) error {
+	proc, ok := pm.Get(id, channel, chatID)
+	if !ok {
+		return fmt.Errorf("process %s not found", id)
+	}
+	return pm.kill(proc, signal)
+}
+
+func (pm *ProcessManager) kill(proc *ManagedProcess, signal string) error {
+	if status, _, _ := proc.Status(); status != "running" {
+		return fmt.Errorf("process %s is not running (%s)", proc.ID, status)
+	}
+
+	proc.mu.Lock()
+	proc.killed = true
+	proc.mu.Unlock()
+
+	if err := signalProcess(proc.cmd, signal); err != nil {
+		return fmt.Errorf("failed to signal process: %w", err)
+	}
+
+	if signal != "kill" {
+		go func() {
+			select {
+			case <-proc.done:
+			case <-time.After(5 * time.Second):
+				signalProcess(proc.cmd, "kill")
+			}
+		}()
+	}
+	return nil
+}
+
+// KillAll force-kills every running process. Used on gateway shutdown.
+func (pm *ProcessManager) KillAll() {
+	for _, proc := range pm.all() {
+		if status, _, _ := proc.Status(); status == "running" {
+			pm.kill(proc, "kill")
+		}
+	}
+}
+
+func (pm *ProcessManager) countRunning() int {
+	count := 0
+	for _, proc := range pm.processes {
+		if status, _, _ := proc.Status(); status == "running" {
+			count++
+		}
+	}
+	return count
+}
+
+// pruneFinished drops the oldest exited processes beyond maxFinishedProcesses.
+// Caller must hold pm.mu.
+func (pm *ProcessManager) pruneFinished() {
+	var finished []*ManagedProcess
+	for _, proc := range pm.processes {
+		if status, _, _ := proc.Status(); status != "running" {
+			finished = append(finished, proc)
+		}
+	}
+	if len(finished) <= maxFinishedProcesses {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Started.Before(finished[j].Started)
+	})
+	for _, proc := range finished[:len(finished)-maxFinishedProcesses] {
+		delete(pm.processes, proc.ID)
+	}
+}
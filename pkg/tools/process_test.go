@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := newRingBuffer(8)
+	for _, s := range []string{"abc", "defg", "hij", "klmnopqrstu"} {
+		r.Write([]byte(s))
+	}
+	// 21 bytes written, the last 8 retained
+	tests := []struct {
+		offset    int64
+		max       int
+		want      string
+		wantStart int64
+	}{
+		{0, 0, "nopqrstu", 13},
+		{15, 0, "pqrstu", 15},
+		{15, 3, "pqr", 15},
+		{21, 0, "", 21},
+		{99, 0, "", 21},
+	}
+	for _, tt := range tests {
+		got, start, total := r.Since(tt.offset, tt.max)
+		if string(got) != tt.want || start != tt.wantStart || total != 21 {
+			t.Errorf("Since(%d, %d) = %q, %d, %d; want %q, %d, 21", tt.offset, tt.max, got, start, total, tt.want, tt.wantStart)
+		}
+	}
+	if got := r.Tail(3); got != "stu" {
+		t.Errorf("Tail(3) = %q", got)
+	}
+
+	// Writes that wrap around the end of the buffer keep the order
+	r = newRingBuffer(5)
+	var all string
+	for i := 0; i < 40; i++ {
+		s := strings.Repeat(string(rune('a'+i%26)), i%4+1)
+		all += s
+		r.Write([]byte(s))
+		if got := r.Tail(5); got != all[max(0, len(all)-5):] {
+			t.Fatalf("after write %d: Tail = %q, want %q", i, got, all[max(0, len(all)-5):])
+		}
+	}
+}
+
+func TestProcessManager(t *testing.T) {
+	policy, _ := NewExecPolicy(config.DefaultConfig().Tools.Exec)
+	pm := NewProcessManager(t.TempDir(), policy, nil, config.ProcessConfig{MaxProcesses: 2})
+
+	proc, err := pm.Start("echo hello; echo oops >&2", "", "greet", "telegram", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-proc.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit")
+	}
+	if status, code, _ := proc.Status(); status != "exited" || code != 0 {
+		t.Errorf("status = %s %d", status, code)
+	}
+	output := NewProcessOutputTool(pm)
+	ctx := WithChat(context.Background(), "telegram", "1")
+	out, _ := output.Execute(ctx, map[string]interface{}{"id": proc.ID})
+	if !strings.Contains(out, "hello") || !strings.Contains(out, "next_offset=6") {
+		t.Errorf("stdout = %q", out)
+	}
+	out, _ = output.Execute(ctx, map[string]interface{}{"id": proc.ID, "stream": "stderr"})
+	if !strings.Contains(out, "oops") {
+		t.Errorf("stderr = %q", out)
+	}
+
+	if _, err := pm.Start("rm -rf /", "", "", "", ""); err == nil {
+		t.Error("denied command was started")
+	}
+
+	sleeper, err := pm.Start("sleep 30", "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Kill(sleeper.ID, "", "", "term"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sleeper.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("killed process is still running")
+	}
+	if status, _, _ := sleeper.Status(); status != "killed" {
+		t.Errorf("status after kill = %s", status)
+	}
+
+	// Processes are only visible from the chat that started them
+	if _, ok := pm.Get(proc.ID, "telegram", "2"); ok {
+		t.Error("another chat sees the process")
+	}
+	if len(pm.List("telegram", "1")) != 1 || len(pm.List("telegram", "2")) != 0 {
+		t.Error("List is not scoped to the chat")
+	}
+	other := WithChat(context.Background(), "telegram", "2")
+	if out, _ := output.Execute(other, map[string]interface{}{"id": proc.ID}); !strings.Contains(out, "not found") {
+		t.Errorf("output from another chat = %q", out)
+	}
+	if out, _ := NewProcessKillTool(pm).Execute(other, map[string]interface{}{"id": sleeper.ID}); !strings.Contains(out, "not found") {
+		t.Errorf("kill from another chat = %q", out)
+	}
+
+	// KillAll, as run when the agent stops, ends everything still running
+	a, _ := pm.Start("sleep 30", "", "", "", "")
+	b, _ := pm.Start("sleep 30", "", "", "", "")
+	if _, err := pm.Start("sleep 30", "", "", "", ""); err == nil {
+		t.Error("started more than max_processes")
+	}
+	pm.KillAll()
+	for _, p := range []*ManagedProcess{a, b} {
+		select {
+		case <-p.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s survived KillAll", p.ID)
+		}
+	}
+}
+
+func TestProcessSendInput(t *testing.T) {
+	defer func(timeout time.Duration) { processInputTimeout = timeout }(processInputTimeout)
+	processInputTimeout = 200 * time.Millisecond
+	pm := NewProcessManager(t.TempDir(), nil, nil, config.ProcessConfig{})
+	defer pm.KillAll()
+
+	cat, err := pm.Start("cat", "", "", "cli", "direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.SendInput(cat.ID, "cli", "direct", "hello\n", true); err != nil {
+		t.Fatal(err)
+	}
+	<-cat.done
+	if got := cat.stdout.Tail(100); got != "hello\n" {
+		t.Errorf("cat printed %q", got)
+	}
+
+	// A process that never reads its stdin does not block the tool call
+	sleeper, err := pm.Start("sleep 30", "", "", "cli", "direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := pm.SendInput(sleeper.ID, "cli", "direct", strings.Repeat("x", 1<<20), false); err == nil {
+		t.Error("input to a process that does not read it succeeded")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("SendInput blocked")
+	}
+	if err := pm.SendInput(sleeper.ID, "cli", "direct", "more", false); err == nil {
+		t.Error("accepted input while the previous write is pending")
+	}
+}
@@ -0,0 +1,347 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/utils"
+)
+
+// processChat is the chat the process tools act for. A process can only be
+// seen and controlled from the chat that started it.
+type processChat struct {
+	channel string
+	chatID  string
+}
+
+func newProcessChat() processChat {
+	return processChat{channel: "cli", chatID: "direct"}
+}
+
+func (c *processChat) SetContext(channel, chatID string) {
+	c.channel = channel
+	c.chatID = chatID
+}
+
+func (c *processChat) chat(ctx context.Context) (string, string) {
+	return chatFor(ctx, c.channel, c.chatID)
+}
+
+// ProcessStartTool starts a long-running command in the background.
+type ProcessStartTool struct {
+	processChat
+	manager *ProcessManager
+}
+
+func NewProcessStartTool(manager *ProcessManager) *ProcessStartTool {
+	return &ProcessStartTool{processChat: newProcessChat(), manager: manager}
+}
+
+func (t *ProcessStartTool) Name() string {
+	return "process_start"
+}
+
+func (t *ProcessStartTool) Description() string {
+	return "Start a long-running shell command in the background (dev servers, log tails, backups). Returns a process ID immediately; use process_output to read its output. You will be notified when it exits."
+}
+
+func (t *ProcessStartTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"command": map[string]interface{}{
+				"type":        "string",
+				"description": "The shell command to run",
+			},
+			"working_dir": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional working directory for the command",
+			},
+			"label": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional short label for the process (for display)",
+			},
+		},
+		"required": []string{"command"},
+	}
+}
+
+func (t *ProcessStartTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	command, ok := args["command"].(string)
+	if !ok || command == "" {
+		return "", fmt.Errorf("command is required")
+	}
+	dir, _ := args["working_dir"].(string)
+	label, _ := args["label"].(string)
+
+	originChannel, originChatID := t.chat(ctx)
+	proc, err := t.manager.Start(command, dir, label, originChannel, originChatID)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), nil
+	}
+
+	return fmt.Sprintf("Started process %s (pid %d): %s\nUse process_output with id=%s to read its output.",
+		proc.ID, proc.Pid(), command, proc.ID), nil
+}
+
+// ProcessOutputTool reads buffered output of a background process by offset.
+type ProcessOutputTool struct {
+	processChat
+	manager *ProcessManager
+}
+
+func NewProcessOutputTool(manager *ProcessManager) *ProcessOutputTool {
+	return &ProcessOutputTool{processChat: newProcessChat(), manager: manager}
+}
+
+func (t *ProcessOutputTool) Name() string {
+	return "process_output"
+}
+
+func (t *ProcessOutputTool) Description() string {
+	return "Read output of a background process. Pass the next_offset from the previous call as offset to read only new output."
+}
+
+func (t *ProcessOutputTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "Process ID returned by process_start",
+			},
+			"stream": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"stdout", "stderr"},
+				"description": "Which stream to read (default: stdout)",
+			},
+			"offset": map[string]interface{}{
+				"type":        "integer",
+				"description": "Byte offset to start reading from (default: 0)",
+				"minimum":     0.0,
+			},
+			"max_bytes": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum bytes to return (default: 8000)",
+				"minimum":     1.0,
+			},
+		},
+		"required": []string{"id"},
+	}
+}
+
+func (t *ProcessOutputTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	id, ok := args["id"].(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("id is required")
+	}
+
+	channel, chatID := t.chat(ctx)
+	proc, ok := t.manager.Get(id, channel, chatID)
+	if !ok {
+		return fmt.Sprintf("Error: process %s not found", id), nil
+	}
+
+	stream := "stdout"
+	if s, ok := args["stream"].(string); ok && s == "stderr" {
+		stream = s
+	}
+
+	var offset int64
+	if o, ok := args["offset"].(float64); ok && o > 0 {
+		offset = int64(o)
+	}
+
+	maxBytes := 8000
+	if m, ok := args["max_bytes"].(float64); ok && m > 0 {
+		maxBytes = int(m)
+	}
+
+	chunk, start, total := proc.Output(stream).Since(offset, maxBytes)
+	next := start + int64(len(chunk))
+	status, exitCode, _ := proc.Status()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Process %s [%s", proc.ID, status)
+	if status != "running" {
+		fmt.Fprintf(&sb, ", code %d", exitCode)
+	}
+	fmt.Fprintf(&sb, "] %s bytes %d-%d of %d, next_offset=%d\n", stream, start, next, total, next)
+	if start > offset {
+		fmt.Fprintf(&sb, "(%d bytes before offset %d were dropped from the buffer)\n", start-offset, start)
+	}
+	if len(chunk) == 0 {
+		sb.WriteString("(no new output)")
+	} else {
+		sb.Write(chunk)
+	}
+	if next < total {
+		fmt.Fprintf(&sb, "\n... (%d more bytes available)", total-next)
+	}
+
+	return sb.String(), nil
+}
+
+// ProcessSendInputTool writes to the stdin of a background process.
+type ProcessSendInputTool struct {
+	processChat
+	manager *ProcessManager
+}
+
+func NewProcessSendInputTool(manager *ProcessManager) *ProcessSendInputTool {
+	return &ProcessSendInputTool{processChat: newProcessChat(), manager: manager}
+}
+
+func (t *ProcessSendInputTool) Name() string {
+	return "process_send_input"
+}
+
+func (t *ProcessSendInputTool) Description() string {
+	return "Send text to the stdin of a background process. Include a trailing newline to submit a line."
+}
+
+func (t *ProcessSendInputTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "Process ID returned by process_start",
+			},
+			"input": map[string]interface{}{
+				"type":        "string",
+				"description": "Text to write to stdin",
+			},
+			"close_stdin": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Close stdin after writing (sends EOF)",
+			},
+		},
+		"required": []string{"id"},
+	}
+}
+
+func (t *ProcessSendInputTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	id, ok := args["id"].(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("id is required")
+	}
+	input, _ := args["input"].(string)
+	closeStdin, _ := args["close_stdin"].(bool)
+
+	channel, chatID := t.chat(ctx)
+	if err := t.manager.SendInput(id, channel, chatID, input, closeStdin); err != nil {
+		return fmt.Sprintf("Error: %v", err), nil
+	}
+
+	result := fmt.Sprintf("Sent %d bytes to %s", len(input), id)
+	if closeStdin {
+		result += " and closed stdin"
+	}
+	return result, nil
+}
+
+// ProcessKillTool stops a background process.
+type ProcessKillTool struct {
+	processChat
+	manager *ProcessManager
+}
+
+func NewProcessKillTool(manager *ProcessManager) *ProcessKillTool {
+	return &ProcessKillTool{processChat: newProcessChat(), manager: manager}
+}
+
+func (t *ProcessKillTool) Name() string {
+	return "process_kill"
+}
+
+func (t *ProcessKillTool) Description() string {
+	return "Stop a background process. Sends SIGTERM by default and force-kills it if it does not exit within 5 seconds."
+}
+
+func (t *ProcessKillTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "Process ID returned by process_start",
+			},
+			"signal": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"term", "int", "kill"},
+				"description": "Signal to send (default: term)",
+			},
+		},
+		"required": []string{"id"},
+	}
+}
+
+func (t *ProcessKillTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	id, ok := args["id"].(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("id is required")
+	}
+
+	signal := "term"
+	if s, ok := args["signal"].(string); ok && (s == "int" || s == "kill") {
+		signal = s
+	}
+
+	channel, chatID := t.chat(ctx)
+	if err := t.manager.Kill(id, channel, chatID, signal); err != nil {
+		return fmt.Sprintf("Error: %v", err), nil
+	}
+	return fmt.Sprintf("Sent %s to %s", signal, id), nil
+}
+
+// ProcessListTool lists background processes.
+type ProcessListTool struct {
+	processChat
+	manager *ProcessManager
+}
+
+func NewProcessListTool(manager *ProcessManager) *ProcessListTool {
+	return &ProcessListTool{processChat: newProcessChat(), manager: manager}
+}
+
+func (t *ProcessListTool) Name() string {
+	return "process_list"
+}
+
+func (t *ProcessListTool) Description() string {
+	return "List background processes started from this chat with process_start and their status."
+}
+
+func (t *ProcessListTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{},
+	}
+}
+
+func (t *ProcessListTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	procs := t.manager.List(t.chat(ctx))
+	if len(procs) == 0 {
+		return "No background processes.", nil
+	}
+
+	result := "Background processes:\n"
+	for _, proc := range procs {
+		status, exitCode, finished := proc.Status()
+		var runtime string
+		if status == "running" {
+			runtime = fmt.Sprintf("running for %s", time.Since(proc.Started).Round(time.Second))
+		} else {
+			runtime = fmt.Sprintf("%s with code %d after %s", status, exitCode, finished.Sub(proc.Started).Round(time.Second))
+		}
+		name := utils.Truncate(proc.Command, 60)
+		if proc.Label != "" {
+			name = proc.Label + ": " + name
+		}
+		result += fmt.Sprintf("- %s (pid %d, %s) %s\n", proc.ID, proc.Pid(), runtime, name)
+	}
+	return result, nil
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package tools
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup puts the command in its own process group so that signals
+// reach the children of "sh -c" too.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalProcess(cmd *exec.Cmd, signal string) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	sig := syscall.SIGTERM
+	switch signal {
+	case "kill":
+		sig = syscall.SIGKILL
+	case "int":
+		sig = syscall.SIGINT
+	}
+
+	if err := syscall.Kill(-cmd.Process.Pid, sig); err != nil {
+		return cmd.Process.Signal(sig)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package tools
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcess kills the process; Windows has no equivalent of SIGTERM for console processes.
+func signalProcess(cmd *exec.Cmd, signal string) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
@@ -9,6 +9,44 @@ import (
 	"github.com/weiwei929/mypicoclaw/pkg/logger"
 )
 
+// ToolFilter reports whether a tool may be used.
+type ToolFilter func(name string) bool
+
+type toolFilterKey struct{}
+
+// WithToolFilter returns a context in which ExecuteWithContext refuses the
+// tools that allow rejects.
+func WithToolFilter(ctx context.Context, allow ToolFilter) context.Context {
+	return context.WithValue(ctx, toolFilterKey{}, allow)
+}
+
+func toolAllowed(ctx context.Context, name string) bool {
+	allow, _ := ctx.Value(toolFilterKey{}).(ToolFilter)
+	return allow == nil || allow(name)
+}
+
+type chatKey struct{}
+
+type chatRef struct {
+	channel string
+	chatID  string
+}
+
+// WithChat returns a context telling tools which chat they act for, such as
+// where the message tool sends by default. It takes precedence over what
+// SetContext recorded, so one tool can serve several chats at once.
+func WithChat(ctx context.Context, channel, chatID string) context.Context {
+	return context.WithValue(ctx, chatKey{}, chatRef{channel: channel, chatID: chatID})
+}
+
+// chatFor returns the chat of ctx, or channel and chatID when it has none.
+func chatFor(ctx context.Context, channel, chatID string) (string, string) {
+	if c, ok := ctx.Value(chatKey{}).(chatRef); ok && c.channel != "" && c.chatID != "" {
+		return c.channel, c.chatID
+	}
+	return channel, chatID
+}
+
 type ToolRegistry struct {
 	tools map[string]Tool
 	mu    sync.RWMutex
@@ -53,9 +91,17 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 		return "", fmt.Errorf("tool '%s' not found", name)
 	}
 
-	// If tool implements ContextualTool, set context
-	if contextualTool, ok := tool.(ContextualTool); ok && channel != "" && chatID != "" {
-		contextualTool.SetContext(channel, chatID)
+	if !toolAllowed(ctx, name) {
+		logger.WarnCF("tool", "Tool not permitted for this user",
+			map[string]interface{}{
+				"tool": name,
+			})
+		return "", fmt.Errorf("tool '%s' is not permitted for this user", name)
+	}
+
+	// Tools learn the chat from the context rather than shared fields
+	if channel != "" && chatID != "" {
+		ctx = WithChat(ctx, channel, chatID)
 	}
 
 	start := time.Now()
@@ -82,12 +128,20 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 }
 
 func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
+	return r.GetAllowedDefinitions(nil)
+}
+
+// GetAllowedDefinitions returns the definitions of the tools that allow
+// accepts; a nil filter accepts all.
+func (r *ToolRegistry) GetAllowedDefinitions(allow ToolFilter) []map[string]interface{} {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	definitions := make([]map[string]interface{}, 0, len(r.tools))
-	for _, tool := range r.tools {
-		definitions = append(definitions, ToolToSchema(tool))
+	for name, tool := range r.tools {
+		if allow == nil || allow(name) {
+			definitions = append(definitions, ToolToSchema(tool))
+		}
 	}
 	return definitions
 }
@@ -114,12 +168,20 @@ func (r *ToolRegistry) Count() int {
 // GetSummaries returns human-readable summaries of all registered tools.
 // Returns a slice of "name - description" strings.
 func (r *ToolRegistry) GetSummaries() []string {
+	return r.GetAllowedSummaries(nil)
+}
+
+// GetAllowedSummaries returns the summaries of the tools that allow
+// accepts; a nil filter accepts all.
+func (r *ToolRegistry) GetAllowedSummaries(allow ToolFilter) []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	summaries := make([]string, 0, len(r.tools))
-	for _, tool := range r.tools {
-		summaries = append(summaries, fmt.Sprintf("- `%s` - %s", tool.Name(), tool.Description()))
+	for name, tool := range r.tools {
+		if allow == nil || allow(name) {
+			summaries = append(summaries, fmt.Sprintf("- `%s` - %s", tool.Name(), tool.Description()))
+		}
 	}
 	return summaries
 }
@@ -0,0 +1,69 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type nopTool struct{ name string }
+
+func (t nopTool) Name() string                       { return t.name }
+func (t nopTool) Description() string                { return "test tool" }
+func (t nopTool) Parameters() map[string]interface{} { return map[string]interface{}{"type": "object"} }
+func (t nopTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	return "ran " + t.name, nil
+}
+
+func TestToolFilter(t *testing.T) {
+	r := NewToolRegistry()
+	r.Register(nopTool{"exec"})
+	r.Register(nopTool{"web_search"})
+	allow := func(name string) bool { return name == "web_search" }
+
+	if defs := r.GetAllowedDefinitions(allow); len(defs) != 1 {
+		t.Fatalf("got %d definitions, want 1", len(defs))
+	}
+	ctx := WithToolFilter(context.Background(), allow)
+	if _, err := r.ExecuteWithContext(ctx, "exec", nil, "", ""); err == nil {
+		t.Fatal("filtered tool was executed")
+	}
+	if out, err := r.ExecuteWithContext(ctx, "web_search", nil, "", ""); err != nil || out != "ran web_search" {
+		t.Fatalf("allowed tool = %q, %v", out, err)
+	}
+	if _, err := r.Execute(context.Background(), "exec", nil); err != nil {
+		t.Fatal("tools are unrestricted without a filter")
+	}
+}
+
+func TestConcurrentChats(t *testing.T) {
+	r := NewToolRegistry()
+	msg := NewMessageTool()
+	var mu sync.Mutex
+	sent := map[string]string{}
+	msg.SetSendCallback(func(channel, chatID, content string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent[content] = channel + ":" + chatID
+		return nil
+	})
+	r.Register(msg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chatID := fmt.Sprint(i)
+			r.ExecuteWithContext(context.Background(), "message", map[string]interface{}{"content": "hi " + chatID}, "telegram", chatID)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		if got, want := sent[fmt.Sprint("hi ", i)], fmt.Sprint("telegram:", i); got != want {
+			t.Errorf("message %d went to %q, want %q", i, got, want)
+		}
+	}
+}
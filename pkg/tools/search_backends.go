@@ -0,0 +1,341 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+// SearchResult is a normalized web search result.
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+	Date    string `json:"date,omitempty"`
+}
+
+// SearchBackend is a web search provider used by web_search.
+type SearchBackend interface {
+	Name() string
+	Search(ctx context.Context, query string, count int) ([]SearchResult, error)
+}
+
+// NewSearchBackends builds the backends named in cfg.Backends, in order.
+func NewSearchBackends(cfg config.WebSearchConfig) ([]SearchBackend, error) {
+	client := &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+	if cfg.TimeoutSeconds <= 0 {
+		client.Timeout = 10 * time.Second
+	}
+
+	names := cfg.Backends
+	if len(names) == 0 {
+		names = []string{"brave"}
+	}
+
+	var backends []SearchBackend
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "brave":
+			backends = append(backends, &BraveSearchBackend{apiKey: cfg.APIKey, client: client})
+		case "searxng":
+			if cfg.SearXNG.BaseURL == "" {
+				return nil, fmt.Errorf("search backend searxng: base_url is required")
+			}
+			backends = append(backends, &SearXNGSearchBackend{cfg: cfg.SearXNG, client: client})
+		default:
+			custom, ok := findCustomBackend(cfg.Custom, name)
+			if !ok {
+				return nil, fmt.Errorf("unknown search backend %q", name)
+			}
+			if custom.URL == "" {
+				return nil, fmt.Errorf("search backend %s: url is required", name)
+			}
+			backends = append(backends, &JSONSearchBackend{cfg: custom, client: client})
+		}
+	}
+	return backends, nil
+}
+
+func findCustomBackend(custom []config.JSONSearchBackendConfig, name string) (config.JSONSearchBackendConfig, bool) {
+	for _, c := range custom {
+		if strings.EqualFold(c.Name, name) {
+			return c, true
+		}
+	}
+	return config.JSONSearchBackendConfig{}, false
+}
+
+// BraveSearchBackend queries the Brave Search API.
+type BraveSearchBackend struct {
+	apiKey string
+	client *http.Client
+}
+
+func (b *BraveSearchBackend) Name() string {
+	return "brave"
+}
+
+func (b *BraveSearchBackend) Search(ctx context.Context, query string, count int) ([]SearchResult, error) {
+	if b.apiKey == "" {
+		return nil, fmt.Errorf("BRAVE_API_KEY not configured")
+	}
+
+	searchURL := fmt.Sprintf("https://api.search.brave.com/res/v1/web/search?q=%s&count=%d",
+		url.QueryEscape(query), count)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Subscription-Token", b.apiKey)
+
+	var searchResp struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+				Age         string `json:"age"`
+				PageAge     string `json:"page_age"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	if err := doSearchRequest(b.client, req, &searchResp); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(searchResp.Web.Results))
+	for _, item := range searchResp.Web.Results {
+		date := item.PageAge
+		if date == "" {
+			date = item.Age
+		}
+		results = append(results, SearchResult{Title: item.Title, URL: item.URL, Snippet: item.Description, Date: date})
+	}
+	return results, nil
+}
+
+// SearXNGSearchBackend queries a SearXNG instance through its JSON API.
+type SearXNGSearchBackend struct {
+	cfg    config.SearXNGConfig
+	client *http.Client
+}
+
+func (b *SearXNGSearchBackend) Name() string {
+	return "searxng"
+}
+
+func (b *SearXNGSearchBackend) Search(ctx context.Context, query string, count int) ([]SearchResult, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "json")
+	if b.cfg.Language != "" {
+		params.Set("language", b.cfg.Language)
+	}
+	if b.cfg.Categories != "" {
+		params.Set("categories", b.cfg.Categories)
+	}
+	searchURL := strings.TrimRight(b.cfg.BaseURL, "/") + "/search?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	var searchResp struct {
+		Results []struct {
+			Title         string `json:"title"`
+			URL           string `json:"url"`
+			Content       string `json:"content"`
+			PublishedDate string `json:"publishedDate"`
+		} `json:"results"`
+	}
+	if err := doSearchRequest(b.client, req, &searchResp); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(searchResp.Results))
+	for _, item := range searchResp.Results {
+		results = append(results, SearchResult{Title: item.Title, URL: item.URL, Snippet: item.Content, Date: item.PublishedDate})
+		if len(results) >= count {
+			break
+		}
+	}
+	return results, nil
+}
+
+// JSONSearchBackend queries any search API that answers with JSON, mapped
+// to results through configurable paths.
+type JSONSearchBackend struct {
+	cfg    config.JSONSearchBackendConfig
+	client *http.Client
+}
+
+func (b *JSONSearchBackend) Name() string {
+	return b.cfg.Name
+}
+
+func (b *JSONSearchBackend) Search(ctx context.Context, query string, count int) ([]SearchResult, error) {
+	method := strings.ToUpper(b.cfg.Method)
+	if method == "" {
+		method = "GET"
+		if b.cfg.BodyTemplate != "" {
+			method = "POST"
+		}
+	}
+
+	countStr := strconv.Itoa(count)
+	searchURL := strings.NewReplacer(
+		"{query}", url.QueryEscape(query),
+		"{count}", countStr,
+		"{api_key}", url.QueryEscape(b.cfg.APIKey),
+	).Replace(b.cfg.URL)
+
+	var body io.Reader
+	if b.cfg.BodyTemplate != "" {
+		body = strings.NewReader(strings.NewReplacer(
+			"{query}", jsonEscape(query),
+			"{count}", countStr,
+			"{api_key}", jsonEscape(b.cfg.APIKey),
+		).Replace(b.cfg.BodyTemplate))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, searchURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range b.cfg.Headers {
+		req.Header.Set(k, strings.ReplaceAll(v, "{api_key}", b.cfg.APIKey))
+	}
+
+	var searchResp interface{}
+	if err := doSearchRequest(b.client, req, &searchResp); err != nil {
+		return nil, err
+	}
+
+	items, ok := jsonPath(searchResp, b.cfg.ResultsPath).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no result list at %q in response", b.cfg.ResultsPath)
+	}
+
+	results := make([]SearchResult, 0, len(items))
+	for _, item := range items {
+		results = append(results, SearchResult{
+			Title:   jsonString(item, b.cfg.TitleField, "title", "name"),
+			URL:     jsonString(item, b.cfg.URLField, "url", "link"),
+			Snippet: jsonString(item, b.cfg.SnippetField, "snippet", "content", "description"),
+			Date:    jsonString(item, b.cfg.DateField, "date", "published_date", "publishedDate"),
+		})
+		if len(results) >= count {
+			break
+		}
+	}
+	return results, nil
+}
+
+func doSearchRequest(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body[:min(len(body), 200)])))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// jsonPath follows a dot-separated path of object keys and array indexes.
+func jsonPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// jsonString returns the first non-empty string found at path or, when path
+// is empty, at any of the fallback keys.
+func jsonString(item interface{}, path string, fallbacks ...string) string {
+	paths := fallbacks
+	if path != "" {
+		paths = []string{path}
+	}
+	for _, p := range paths {
+		switch v := jsonPath(item, p).(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+var tagRe = regexp.MustCompile(`<[^>]*>`)
+
+// normalizeResults strips markup from titles and snippets, drops results
+// without a URL and removes duplicate URLs.
+func normalizeResults(results []SearchResult) []SearchResult {
+	seen := make(map[string]bool)
+	normalized := make([]SearchResult, 0, len(results))
+	for _, r := range results {
+		r.URL = strings.TrimSpace(r.URL)
+		if r.URL == "" || seen[r.URL] {
+			continue
+		}
+		seen[r.URL] = true
+		r.Title = collapseSpace(html.UnescapeString(tagRe.ReplaceAllString(r.Title, "")))
+		r.Snippet = collapseSpace(html.UnescapeString(tagRe.ReplaceAllString(r.Snippet, "")))
+		r.Date = strings.TrimSpace(r.Date)
+		if r.Title == "" {
+			r.Title = r.URL
+		}
+		normalized = append(normalized, r)
+	}
+	return normalized
+}
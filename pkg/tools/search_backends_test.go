@@ -0,0 +1,89 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func TestWebSearchFallbackAndCache(t *testing.T) {
+	var searxngCalls, customCalls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/searxng/search":
+			searxngCalls.Add(1)
+			http.Error(w, "format json is disabled", http.StatusForbidden)
+		case "/custom":
+			customCalls.Add(1)
+			if r.Header.Get("X-API-KEY") != "secret" {
+				http.Error(w, "bad key", http.StatusUnauthorized)
+				return
+			}
+			var req map[string]interface{}
+			body, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &req); err != nil || req["q"] != `go "generics"` {
+				http.Error(w, "bad body: "+string(body), http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"organic": [
+				{"title": "Go <b>generics</b>", "link": "https://go.dev/doc/generics", "snippet": "An &amp; intro", "date": "2024-01-02"},
+				{"title": "Duplicate", "link": "https://go.dev/doc/generics"},
+				{"title": "No URL"}
+			]}`))
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.DefaultConfig().Tools.Web.Search
+	cfg.Backends = []string{"searxng", "serper"}
+	cfg.SearXNG.BaseURL = srv.URL + "/searxng"
+	cfg.Custom = []config.JSONSearchBackendConfig{{
+		Name:         "serper",
+		URL:          srv.URL + "/custom",
+		APIKey:       "secret",
+		Headers:      map[string]string{"X-API-KEY": "{api_key}"},
+		BodyTemplate: `{"q": "{query}", "num": {count}}`,
+		ResultsPath:  "organic",
+		URLField:     "link",
+	}}
+
+	tool, err := NewWebSearchToolWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := map[string]interface{}{"query": `go "generics"`}
+	out, err := tool.Execute(context.Background(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"via serper", "1. Go generics", "https://go.dev/doc/generics", "Date: 2024-01-02", "An & intro"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Duplicate") || strings.Contains(out, "No URL") {
+		t.Errorf("duplicate or URL-less results not removed:\n%s", out)
+	}
+
+	out, _ = tool.Execute(context.Background(), args)
+	if !strings.Contains(out, "cached") || customCalls.Load() != 1 || searxngCalls.Load() != 1 {
+		t.Errorf("second query was not served from cache (searxng=%d, custom=%d):\n%s",
+			searxngCalls.Load(), customCalls.Load(), out)
+	}
+}
+
+func TestNewSearchBackendsUnknown(t *testing.T) {
+	cfg := config.DefaultConfig().Tools.Web.Search
+	cfg.Backends = []string{"bing"}
+	if _, err := NewSearchBackends(cfg); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
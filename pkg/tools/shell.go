@@ -7,69 +7,37 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
 )
 
 type ExecTool struct {
-	workingDir          string
-	timeout             time.Duration
-	denyPatterns        []*regexp.Regexp
-	warnPatterns        []*regexp.Regexp
-	allowPatterns       []*regexp.Regexp
-	restrictToWorkspace bool
+	workingDir string
+	policy     *ExecPolicy
 }
 
+// NewExecTool creates an ExecTool with the default policy from config.DefaultConfig.
 func NewExecTool(workingDir string) *ExecTool {
-	// 🔴 Blocked: immediately rejected, never executed
-	denyPatterns := []*regexp.Regexp{
-		// Destructive file operations
-		regexp.MustCompile(`\brm\s+-[rf]{1,2}\s+/`),               // rm -rf / (root path)
-		regexp.MustCompile(`\bdel\s+/[fq]\b`),                      // Windows del /f
-		regexp.MustCompile(`\brmdir\s+/s\b`),                       // Windows rmdir /s
-		// Disk wiping
-		regexp.MustCompile(`\b(format|mkfs|diskpart)\b\s`),
-		regexp.MustCompile(`\bdd\s+if=`),
-		regexp.MustCompile(`>\s*/dev/sd[a-z]\b`),
-		// System control
-		regexp.MustCompile(`\b(shutdown|reboot|poweroff|halt)\b`),
-		regexp.MustCompile(`\bsystemctl\s+(stop|disable)\s+mypicoclaw`), // Don't let it stop itself
-		// Fork bomb
-		regexp.MustCompile(`:\(\)\s*\{.*\};\s*:`),
-		// System file overwrite
-		regexp.MustCompile(`>\s*/etc/(passwd|shadow|sudoers|fstab)`),
-		// Firewall flush (locked out of VPS)
-		regexp.MustCompile(`\biptables\s+-F`),
-		// Dangerous permission changes on root
-		regexp.MustCompile(`\bchmod\s+(-R\s+)?777\s+/\s*$`),
-		// User deletion
-		regexp.MustCompile(`\buserdel\b`),
+	tool, err := NewExecToolWithConfig(workingDir, config.DefaultConfig().Tools.Exec)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default exec policy: %v", err))
 	}
+	return tool
+}
 
-	// 🟡 Warned: logged as warning but still executed
-	warnPatterns := []*regexp.Regexp{
-		regexp.MustCompile(`\brm\b`),                               // Any file deletion
-		regexp.MustCompile(`\b(kill|pkill|killall)\b`),              // Process killing
-		regexp.MustCompile(`\b(apt|yum|dnf|pacman)\s+install\b`),    // Package installation
-		regexp.MustCompile(`\bcurl\b.*\|\s*(ba)?sh`),                // Pipe-to-shell
-		regexp.MustCompile(`\bwget\b.*\|\s*(ba)?sh`),                // Pipe-to-shell
-		regexp.MustCompile(`\bchmod\b`),                             // Permission changes
-		regexp.MustCompile(`\bchown\b`),                             // Ownership changes
-		regexp.MustCompile(`\bsystemctl\s+(restart|start|enable)\b`), // Service management
-		regexp.MustCompile(`\bcrontab\b`),                           // Scheduled tasks
-		regexp.MustCompile(`\bnohup\b.*&`),                          // Background daemons
+// NewExecToolWithConfig creates an ExecTool whose deny/warn/allow rules, timeouts,
+// output limit and environment scrubbing come from cfg.
+func NewExecToolWithConfig(workingDir string, cfg config.ExecConfig) (*ExecTool, error) {
+	policy, err := NewExecPolicy(cfg)
+	if err != nil {
+		return nil, err
 	}
-
 	return &ExecTool{
-		workingDir:          workingDir,
-		timeout:             60 * time.Second,
-		denyPatterns:        denyPatterns,
-		warnPatterns:        warnPatterns,
-		allowPatterns:       nil,
-		restrictToWorkspace: false,
-	}
+		workingDir: workingDir,
+		policy:     policy,
+	}, nil
 }
 
 func (t *ExecTool) Name() string {
@@ -119,13 +87,15 @@ func (t *ExecTool) Execute(ctx context.Context, args map[string]interface{}) (st
 		return fmt.Sprintf("Error: %s", guardError), nil
 	}
 
-	cmdCtx, cancel := context.WithTimeout(ctx, t.timeout)
+	timeout := t.policy.TimeoutFor(command)
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(cmdCtx, "sh", "-c", command)
 	if cwd != "" {
 		cmd.Dir = cwd
 	}
+	cmd.Env = t.policy.Environ()
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -139,7 +109,7 @@ func (t *ExecTool) Execute(ctx context.Context, args map[string]interface{}) (st
 
 	if err != nil {
 		if cmdCtx.Err() == context.DeadlineExceeded {
-			return fmt.Sprintf("Error: Command timed out after %v", t.timeout), nil
+			return fmt.Sprintf("Error: Command timed out after %v", timeout), nil
 		}
 		output += fmt.Sprintf("\nExit code: %v", err)
 	}
@@ -148,7 +118,7 @@ func (t *ExecTool) Execute(ctx context.Context, args map[string]interface{}) (st
 		output = "(no output)"
 	}
 
-	maxLen := 10000
+	maxLen := t.policy.MaxOutputChars()
 	if len(output) > maxLen {
 		output = output[:maxLen] + fmt.Sprintf("\n... (truncated, %d more chars)", len(output)-maxLen)
 	}
@@ -158,87 +128,41 @@ func (t *ExecTool) Execute(ctx context.Context, args map[string]interface{}) (st
 
 func (t *ExecTool) guardCommand(command, cwd string) string {
 	cmd := strings.TrimSpace(command)
-	lower := strings.ToLower(cmd)
+	decision := t.policy.Evaluate(cmd, cwd)
 
-	// 🔴 Check deny patterns (block immediately)
-	for _, pattern := range t.denyPatterns {
-		if pattern.MatchString(lower) {
-			log.Printf("[SECURITY] ⛔ BLOCKED command: %s (matched: %s)", cmd, pattern.String())
+	switch decision.Action {
+	case ExecActionDeny:
+		log.Printf("[SECURITY] ⛔ BLOCKED command: %s (rule: %s %s)", cmd, decision.Rule, decision.Pattern)
+		if decision.Pattern != "" {
 			return "⛔ Command blocked by safety guard (dangerous pattern detected)"
 		}
-	}
-
-	// 🟡 Check warn patterns (log warning but allow)
-	for _, pattern := range t.warnPatterns {
-		if pattern.MatchString(lower) {
-			log.Printf("[SECURITY] ⚠️ RISKY command allowed: %s (matched: %s)", cmd, pattern.String())
-			break // Only log once even if multiple patterns match
-		}
-	}
-
-	// Check allowlist if configured
-	if len(t.allowPatterns) > 0 {
-		allowed := false
-		for _, pattern := range t.allowPatterns {
-			if pattern.MatchString(lower) {
-				allowed = true
-				break
-			}
-		}
-		if !allowed {
-			return "Command blocked by safety guard (not in allowlist)"
-		}
-	}
-
-	if t.restrictToWorkspace {
-		if strings.Contains(cmd, "..\\") || strings.Contains(cmd, "../") {
-			return "Command blocked by safety guard (path traversal detected)"
-		}
-
-		cwdPath, err := filepath.Abs(cwd)
-		if err != nil {
-			return ""
-		}
-
-		pathPattern := regexp.MustCompile(`[A-Za-z]:\\[^\\\"']+|/[^\s\"']+`)
-		matches := pathPattern.FindAllString(cmd, -1)
-
-		for _, raw := range matches {
-			p, err := filepath.Abs(raw)
-			if err != nil {
-				continue
-			}
-
-			rel, err := filepath.Rel(cwdPath, p)
-			if err != nil {
-				continue
-			}
-
-			if strings.HasPrefix(rel, "..") {
-				return "Command blocked by safety guard (path outside working dir)"
-			}
-		}
+		return fmt.Sprintf("Command blocked by safety guard (%s)", decision.Reason)
+	case ExecActionWarn:
+		log.Printf("[SECURITY] ⚠️ RISKY command allowed: %s (matched: %s)", cmd, decision.Pattern)
 	}
 
 	return ""
 }
 
+// Policy returns the policy the tool enforces.
+func (t *ExecTool) Policy() *ExecPolicy {
+	return t.policy
+}
+
 func (t *ExecTool) SetTimeout(timeout time.Duration) {
-	t.timeout = timeout
+	t.policy.timeout = timeout
 }
 
 func (t *ExecTool) SetRestrictToWorkspace(restrict bool) {
-	t.restrictToWorkspace = restrict
+	t.policy.restrictToWorkspace = restrict
 }
 
 func (t *ExecTool) SetAllowPatterns(patterns []string) error {
-	t.allowPatterns = make([]*regexp.Regexp, 0, len(patterns))
-	for _, p := range patterns {
-		re, err := regexp.Compile(p)
-		if err != nil {
-			return fmt.Errorf("invalid allow pattern %q: %w", p, err)
-		}
-		t.allowPatterns = append(t.allowPatterns, re)
+	compiled, err := compilePatterns("allow_patterns", patterns)
+	if err != nil {
+		return err
 	}
+	t.policy.allowPatterns = compiled
+	t.policy.allowlistOnly = len(compiled) > 0
 	return nil
 }
@@ -0,0 +1,267 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+// Exec policy actions, in the order they are checked.
+const (
+	ExecActionDeny  = "deny"
+	ExecActionWarn  = "warn"
+	ExecActionAllow = "allow"
+)
+
+// ExecDecision describes what the exec policy would do with a command.
+type ExecDecision struct {
+	Action  string        // deny, warn or allow
+	Rule    string        // Which rule fired, e.g. "deny_patterns[4]"
+	Pattern string        // The pattern (or other condition) that fired
+	Reason  string        // Human-readable explanation
+	Timeout time.Duration // Timeout the command would run with
+}
+
+type execTimeoutRule struct {
+	pattern *regexp.Regexp
+	timeout time.Duration
+}
+
+// ExecPolicy decides whether a shell command may run and with which limits.
+// It is built from config.ExecConfig so operators can tune it without recompiling.
+type ExecPolicy struct {
+	denyPatterns        []*regexp.Regexp
+	warnPatterns        []*regexp.Regexp
+	allowPatterns       []*regexp.Regexp
+	allowlistOnly       bool
+	timeout             time.Duration
+	commandTimeouts     []execTimeoutRule
+	maxOutputChars      int
+	restrictToWorkspace bool
+	scrubEnv            bool
+	scrubEnvPatterns    []string
+}
+
+// NewExecPolicy compiles an ExecPolicy from config. It fails on the first invalid pattern.
+func NewExecPolicy(cfg config.ExecConfig) (*ExecPolicy, error) {
+	p := &ExecPolicy{
+		allowlistOnly:       cfg.AllowlistOnly,
+		timeout:             time.Duration(cfg.TimeoutSeconds) * time.Second,
+		maxOutputChars:      cfg.MaxOutputChars,
+		restrictToWorkspace: cfg.RestrictToWorkspace,
+		scrubEnv:            cfg.ScrubEnv,
+		scrubEnvPatterns:    cfg.ScrubEnvPatterns,
+	}
+	if p.timeout <= 0 {
+		p.timeout = 60 * time.Second
+	}
+	if p.maxOutputChars <= 0 {
+		p.maxOutputChars = 10000
+	}
+
+	var err error
+	if p.denyPatterns, err = compilePatterns("deny_patterns", cfg.DenyPatterns); err != nil {
+		return nil, err
+	}
+	if p.warnPatterns, err = compilePatterns("warn_patterns", cfg.WarnPatterns); err != nil {
+		return nil, err
+	}
+	if p.allowPatterns, err = compilePatterns("allow_patterns", cfg.AllowPatterns); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range cfg.CommandTimeouts {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command_timeouts[%d] pattern %q: %w", i, rule.Pattern, err)
+		}
+		if rule.TimeoutSeconds <= 0 {
+			return nil, fmt.Errorf("command_timeouts[%d] must have a positive timeout_seconds", i)
+		}
+		p.commandTimeouts = append(p.commandTimeouts, execTimeoutRule{
+			pattern: re,
+			timeout: time.Duration(rule.TimeoutSeconds) * time.Second,
+		})
+	}
+
+	for _, pattern := range p.scrubEnvPatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid scrub_env_patterns entry %q: %w", pattern, err)
+		}
+	}
+
+	return p, nil
+}
+
+func compilePatterns(field string, patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d] %q: %w", field, i, p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// Evaluate checks command against the policy as if it were run in cwd.
+func (p *ExecPolicy) Evaluate(command, cwd string) ExecDecision {
+	cmd := strings.TrimSpace(command)
+	lower := strings.ToLower(cmd)
+	timeout := p.TimeoutFor(lower)
+
+	// 🔴 Check deny patterns (block immediately)
+	for i, pattern := range p.denyPatterns {
+		if pattern.MatchString(lower) {
+			return ExecDecision{
+				Action:  ExecActionDeny,
+				Rule:    fmt.Sprintf("deny_patterns[%d]", i),
+				Pattern: pattern.String(),
+				Reason:  "dangerous pattern detected",
+				Timeout: timeout,
+			}
+		}
+	}
+
+	// Check allowlist if configured
+	if p.allowlistOnly {
+		allowed := false
+		for _, pattern := range p.allowPatterns {
+			if pattern.MatchString(lower) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ExecDecision{
+				Action:  ExecActionDeny,
+				Rule:    "allowlist_only",
+				Reason:  "not in allowlist",
+				Timeout: timeout,
+			}
+		}
+	}
+
+	if p.restrictToWorkspace {
+		if reason := checkWorkspacePaths(cmd, cwd); reason != "" {
+			return ExecDecision{
+				Action:  ExecActionDeny,
+				Rule:    "restrict_to_workspace",
+				Reason:  reason,
+				Timeout: timeout,
+			}
+		}
+	}
+
+	// 🟡 Check warn patterns (log warning but allow)
+	for i, pattern := range p.warnPatterns {
+		if pattern.MatchString(lower) {
+			return ExecDecision{
+				Action:  ExecActionWarn,
+				Rule:    fmt.Sprintf("warn_patterns[%d]", i),
+				Pattern: pattern.String(),
+				Reason:  "risky command allowed",
+				Timeout: timeout,
+			}
+		}
+	}
+
+	decision := ExecDecision{
+		Action:  ExecActionAllow,
+		Rule:    "default",
+		Reason:  "no rule matched",
+		Timeout: timeout,
+	}
+	if p.allowlistOnly {
+		decision.Rule = "allow_patterns"
+		decision.Reason = "matched allowlist"
+	}
+	return decision
+}
+
+func checkWorkspacePaths(cmd, cwd string) string {
+	if strings.Contains(cmd, "..\\") || strings.Contains(cmd, "../") {
+		return "path traversal detected"
+	}
+
+	cwdPath, err := filepath.Abs(cwd)
+	if err != nil {
+		return ""
+	}
+
+	pathPattern := regexp.MustCompile(`[A-Za-z]:\\[^\\\"']+|/[^\s\"']+`)
+	matches := pathPattern.FindAllString(cmd, -1)
+
+	for _, raw := range matches {
+		p, err := filepath.Abs(raw)
+		if err != nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(cwdPath, p)
+		if err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(rel, "..") {
+			return "path outside working dir"
+		}
+	}
+
+	return ""
+}
+
+// TimeoutFor returns the timeout of the first command_timeouts rule matching
+// command, or the default timeout.
+func (p *ExecPolicy) TimeoutFor(command string) time.Duration {
+	lower := strings.ToLower(command)
+	for _, rule := range p.commandTimeouts {
+		if rule.pattern.MatchString(lower) {
+			return rule.timeout
+		}
+	}
+	return p.timeout
+}
+
+// MaxOutputChars returns the output truncation limit.
+func (p *ExecPolicy) MaxOutputChars() int {
+	return p.maxOutputChars
+}
+
+// Environ returns the environment commands run with. When scrubbing is enabled,
+// variables matching scrub_env_patterns (e.g. MYPICOCLAW_*) are removed so
+// API keys passed to the gateway are not visible to commands.
+func (p *ExecPolicy) Environ() []string {
+	env := os.Environ()
+	if !p.scrubEnv || len(p.scrubEnvPatterns) == 0 {
+		return env
+	}
+
+	filtered := make([]string, 0, len(env))
+	for _, kv := range env {
+		name := kv
+		if idx := strings.Index(kv, "="); idx >= 0 {
+			name = kv[:idx]
+		}
+		if p.isScrubbed(name) {
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
+
+func (p *ExecPolicy) isScrubbed(name string) bool {
+	for _, pattern := range p.scrubEnvPatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func TestExecPolicyEvaluate(t *testing.T) {
+	defaults, err := NewExecPolicy(config.DefaultConfig().Tools.Exec)
+	if err != nil {
+		t.Fatalf("default policy: %v", err)
+	}
+	allowlist, err := NewExecPolicy(config.ExecConfig{
+		DenyPatterns:    []string{`\bsudo\b`},
+		AllowPatterns:   []string{`^git\s`, `^ls\b`},
+		AllowlistOnly:   true,
+		CommandTimeouts: []config.ExecTimeoutRule{{Pattern: `^git\s+clone\b`, TimeoutSeconds: 300}},
+	})
+	if err != nil {
+		t.Fatalf("allowlist policy: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		policy  *ExecPolicy
+		command string
+		action  string
+		rule    string
+	}{
+		{"deny rm -rf /", defaults, "rm -rf /", ExecActionDeny, "deny_patterns["},
+		{"deny is case-insensitive", defaults, "RM -RF /", ExecActionDeny, "deny_patterns["},
+		{"harmless command", defaults, "echo hello", ExecActionAllow, "default"},
+		{"allowlisted", allowlist, "git status", ExecActionAllow, "allow_patterns"},
+		{"not allowlisted", allowlist, "curl http://example.org", ExecActionDeny, "allowlist_only"},
+		{"deny before allowlist", allowlist, "git status && sudo reboot", ExecActionDeny, "deny_patterns[0]"},
+	}
+	for _, tt := range tests {
+		d := tt.policy.Evaluate(tt.command, t.TempDir())
+		if d.Action != tt.action || !strings.HasPrefix(d.Rule, tt.rule) {
+			t.Errorf("%s: Evaluate(%q) = %s by %s, want %s by %s", tt.name, tt.command, d.Action, d.Rule, tt.action, tt.rule)
+		}
+	}
+
+	if got := allowlist.TimeoutFor("git clone https://example.org/repo"); got != 300*time.Second {
+		t.Errorf("git clone timeout = %v", got)
+	}
+	if got := allowlist.TimeoutFor("git status"); got != 60*time.Second {
+		t.Errorf("default timeout = %v", got)
+	}
+}
+
+func TestExecPolicyConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ExecConfig
+		wantErr string
+	}{
+		{"bad deny pattern", config.ExecConfig{DenyPatterns: []string{"ok", "(unclosed"}}, "deny_patterns[1]"},
+		{"bad warn pattern", config.ExecConfig{WarnPatterns: []string{"[z-a]"}}, "warn_patterns[0]"},
+		{"bad allow pattern", config.ExecConfig{AllowPatterns: []string{"*git"}}, "allow_patterns[0]"},
+		{"bad timeout pattern", config.ExecConfig{CommandTimeouts: []config.ExecTimeoutRule{{Pattern: "(", TimeoutSeconds: 5}}}, "command_timeouts[0]"},
+		{"zero timeout", config.ExecConfig{CommandTimeouts: []config.ExecTimeoutRule{{Pattern: "make", TimeoutSeconds: 0}}}, "positive timeout_seconds"},
+		{"bad scrub pattern", config.ExecConfig{ScrubEnvPatterns: []string{"[MY"}}, "scrub_env_patterns"},
+	}
+	for _, tt := range tests {
+		if _, err := NewExecPolicy(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// The example config must not weaken the exec policy of anyone who copies it:
+// its lists replace the defaults rather than adding to them.
+func TestExampleConfigKeepsExecDefaults(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "config.example.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var example config.Config
+	if err := json.Unmarshal(data, &example); err != nil {
+		t.Fatal(err)
+	}
+	defaults := config.DefaultConfig().Tools.Exec
+	if !slices.Equal(example.Tools.Exec.DenyPatterns, defaults.DenyPatterns) {
+		t.Errorf("example deny_patterns differ from the defaults:\n%q\n%q", example.Tools.Exec.DenyPatterns, defaults.DenyPatterns)
+	}
+	if !slices.Equal(example.Tools.Exec.WarnPatterns, defaults.WarnPatterns) {
+		t.Errorf("example warn_patterns differ from the defaults:\n%q\n%q", example.Tools.Exec.WarnPatterns, defaults.WarnPatterns)
+	}
+}
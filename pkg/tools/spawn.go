@@ -61,7 +61,8 @@ func (t *SpawnTool) Execute(ctx context.Context, args map[string]interface{}) (s
 		return "Error: Subagent manager not configured", nil
 	}
 
-	result, err := t.manager.Spawn(ctx, task, label, t.originChannel, t.originChatID)
+	originChannel, originChatID := chatFor(ctx, t.originChannel, t.originChatID)
+	result, err := t.manager.Spawn(ctx, task, label, originChannel, originChatID)
 	if err != nil {
 		return "", fmt.Errorf("failed to spawn subagent: %w", err)
 	}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
 )
 
 const (
@@ -17,18 +19,44 @@ const (
 )
 
 type WebSearchTool struct {
-	apiKey     string
+	backends   []SearchBackend
 	maxResults int
+	cache      *WebCache
+	cacheTTL   time.Duration
+}
+
+// NewWebSearchTool creates a WebSearchTool backed by Brave Search only.
+func NewWebSearchTool(apiKey string, maxResults int) (*WebSearchTool, error) {
+	cfg := config.DefaultConfig().Tools.Web.Search
+	cfg.APIKey = apiKey
+	cfg.MaxResults = maxResults
+	return NewWebSearchToolWithConfig(cfg)
 }
 
-func NewWebSearchTool(apiKey string, maxResults int) *WebSearchTool {
+// NewWebSearchToolWithConfig creates a WebSearchTool from the tools.web.search config section.
+func NewWebSearchToolWithConfig(cfg config.WebSearchConfig) (*WebSearchTool, error) {
+	backends, err := NewSearchBackends(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	maxResults := cfg.MaxResults
 	if maxResults <= 0 || maxResults > 10 {
 		maxResults = 5
 	}
+	cacheTTL := time.Duration(cfg.CacheTTLSeconds) * time.Second
 	return &WebSearchTool{
-		apiKey:     apiKey,
+		backends:   backends,
 		maxResults: maxResults,
-	}
+		cache:      NewMemoryWebCache(8*1024*1024, cacheTTL),
+		cacheTTL:   cacheTTL,
+	}, nil
+}
+
+// SetCache makes the tool keep results in cache, typically the on-disk cache
+// shared with web_fetch. A nil cache disables caching.
+func (t *WebSearchTool) SetCache(cache *WebCache) {
+	t.cache = cache
 }
 
 func (t *WebSearchTool) Name() string {
@@ -36,7 +64,7 @@ func (t *WebSearchTool) Name() string {
 }
 
 func (t *WebSearchTool) Description() string {
-	return "Search the web for current information. Returns titles, URLs, and snippets from search results."
+	return "Search the web for current information. Returns titles, URLs, dates, and snippets from search results."
 }
 
 func (t *WebSearchTool) Parameters() map[string]interface{} {
@@ -59,10 +87,6 @@ func (t *WebSearchTool) Parameters() map[string]interface{} {
 }
 
 func (t *WebSearchTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
-	if t.apiKey == "" {
-		return "Error: BRAVE_API_KEY not configured", nil
-	}
-
 	query, ok := args["query"].(string)
 	if !ok {
 		return "", fmt.Errorf("query is required")
@@ -75,82 +99,119 @@ func (t *WebSearchTool) Execute(ctx context.Context, args map[string]interface{}
 		}
 	}
 
-	searchURL := fmt.Sprintf("https://api.search.brave.com/res/v1/web/search?q=%s&count=%d",
-		url.QueryEscape(query), count)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("X-Subscription-Token", t.apiKey)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-
-	var searchResp struct {
-		Web struct {
-			Results []struct {
-				Title       string `json:"title"`
-				URL         string `json:"url"`
-				Description string `json:"description"`
-			} `json:"results"`
-		} `json:"web"`
-	}
-
-	if err := json.Unmarshal(body, &searchResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+	cacheKey := searchCacheKey(query, count)
+	results, backend, cached := t.cachedResults(cacheKey)
+
+	if !cached {
+		var errs []string
+		for _, b := range t.backends {
+			found, err := b.Search(ctx, query, count)
+			if err != nil {
+				logger.WarnCF("tool", "Search backend failed",
+					map[string]interface{}{
+						"backend": b.Name(),
+						"error":   err.Error(),
+					})
+				errs = append(errs, fmt.Sprintf("%s: %v", b.Name(), err))
+				continue
+			}
+			results, backend = normalizeResults(found), b.Name()
+			if len(results) > 0 {
+				break
+			}
+		}
+		if backend == "" {
+			return fmt.Sprintf("Error: all search backends failed (%s)", strings.Join(errs, "; ")), nil
+		}
+		if t.cacheTTL > 0 {
+			if data, err := json.Marshal(results); err == nil {
+				t.cache.Put(&WebCacheEntry{
+					Key:       cacheKey,
+					ExpiresAt: time.Now().Add(t.cacheTTL),
+					Source:    backend,
+				}, data)
+			}
+		}
 	}
 
-	results := searchResp.Web.Results
 	if len(results) == 0 {
 		return fmt.Sprintf("No results for: %s", query), nil
 	}
 
 	var lines []string
-	lines = append(lines, fmt.Sprintf("Results for: %s", query))
+	header := fmt.Sprintf("Results for: %s (via %s", query, backend)
+	if cached {
+		header += ", cached"
+	}
+	lines = append(lines, header+")")
 	for i, item := range results {
 		if i >= count {
 			break
 		}
 		lines = append(lines, fmt.Sprintf("%d. %s\n   %s", i+1, item.Title, item.URL))
-		if item.Description != "" {
-			lines = append(lines, fmt.Sprintf("   %s", item.Description))
+		if item.Date != "" {
+			lines = append(lines, fmt.Sprintf("   Date: %s", item.Date))
+		}
+		if item.Snippet != "" {
+			lines = append(lines, fmt.Sprintf("   %s", item.Snippet))
 		}
 	}
 
 	return strings.Join(lines, "\n"), nil
 }
 
+func (t *WebSearchTool) cachedResults(key string) ([]SearchResult, string, bool) {
+	if t.cacheTTL <= 0 {
+		return nil, "", false
+	}
+	entry, data, ok := t.cache.Get(key)
+	if !ok || !entry.Fresh() {
+		return nil, "", false
+	}
+	var results []SearchResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, "", false
+	}
+	return results, entry.Source, true
+}
+
 type WebFetchTool struct {
 	maxChars int
+	policy   *FetchPolicy
+	cache    *WebCache
 }
 
+// NewWebFetchTool creates a WebFetchTool with the default fetch policy.
 func NewWebFetchTool(maxChars int) *WebFetchTool {
+	cfg := config.DefaultConfig().Tools.Web.Fetch
+	cfg.MaxChars = maxChars
+	return NewWebFetchToolWithConfig(cfg)
+}
+
+// NewWebFetchToolWithConfig creates a WebFetchTool from the tools.web.fetch config section.
+func NewWebFetchToolWithConfig(cfg config.WebFetchConfig) *WebFetchTool {
+	maxChars := cfg.MaxChars
 	if maxChars <= 0 {
 		maxChars = 8000
 	}
 	return &WebFetchTool{
 		maxChars: maxChars,
+		policy:   NewFetchPolicy(cfg),
 	}
 }
 
+// SetCache makes the tool keep responses in cache and revalidate them with
+// conditional requests. A nil cache disables caching.
+func (t *WebFetchTool) SetCache(cache *WebCache) {
+	t.cache = cache
+}
+
 func (t *WebFetchTool) Name() string {
 	return "web_fetch"
 }
 
 func (t *WebFetchTool) Description() string {
-	return "Fetch a URL and extract readable content (HTML to text). Use this to get weather info, news, articles, or any web content."
+	return "Fetch a URL and extract readable content (main article as Markdown, with links listed as references). Use this to get weather info, news, articles, or any web content. Long pages are returned in chunks; pass next_index as start_index to continue."
 }
 
 func (t *WebFetchTool) Parameters() map[string]interface{} {
@@ -166,6 +227,11 @@ func (t *WebFetchTool) Parameters() map[string]interface{} {
 				"description": "Maximum characters to extract",
 				"minimum":     100.0,
 			},
+			"start_index": map[string]interface{}{
+				"type":        "integer",
+				"description": "Character offset to start from, for reading long pages in chunks (use next_index from the previous result)",
+				"minimum":     0.0,
+			},
 		},
 		"required": []string{"url"},
 	}
@@ -182,12 +248,8 @@ func (t *WebFetchTool) Execute(ctx context.Context, args map[string]interface{})
 		return "", fmt.Errorf("invalid URL: %w", err)
 	}
 
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return "", fmt.Errorf("only http/https URLs are allowed")
-	}
-
-	if parsedURL.Host == "" {
-		return "", fmt.Errorf("missing domain in URL")
+	if err := t.policy.CheckURL(ctx, parsedURL); err != nil {
+		return "", err
 	}
 
 	maxChars := t.maxChars
@@ -201,102 +263,210 @@ func (t *WebFetchTool) Execute(ctx context.Context, args map[string]interface{})
 		maxChars = 20000
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("User-Agent", userAgent)
-
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        10,
-			IdleConnTimeout:     30 * time.Second,
-			DisableCompression:  false,
-			TLSHandshakeTimeout: 15 * time.Second,
-		},
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 5 {
-				return fmt.Errorf("stopped after 5 redirects")
-			}
-			return nil
-		},
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
+	startIndex := 0
+	if si, ok := args["start_index"].(float64); ok && si > 0 {
+		startIndex = int(si)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	page, err := t.fetch(ctx, parsedURL)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return "", err
 	}
+	body, contentType := page.Body, page.ContentType
 
-	contentType := resp.Header.Get("Content-Type")
+	decoded, encodingName := decodeBody(body, contentType)
 
-	var text, extractor string
+	var text, extractor, title string
 
 	if strings.Contains(contentType, "application/json") {
 		var jsonData interface{}
-		if err := json.Unmarshal(body, &jsonData); err == nil {
+		if err := json.Unmarshal([]byte(decoded), &jsonData); err == nil {
 			formatted, _ := json.MarshalIndent(jsonData, "", "  ")
 			text = string(formatted)
 			extractor = "json"
 		} else {
-			text = string(body)
+			text = decoded
 			extractor = "raw"
 		}
-	} else if strings.Contains(contentType, "text/html") || len(body) > 0 &&
-		(strings.HasPrefix(string(body), "<!DOCTYPE") || strings.HasPrefix(strings.ToLower(string(body)), "<html")) {
-		text = t.extractText(string(body))
-		extractor = "text"
+	} else if strings.Contains(contentType, "text/html") || strings.Contains(contentType, "application/xhtml") ||
+		len(decoded) > 0 && (strings.HasPrefix(decoded, "<!DOCTYPE") || strings.HasPrefix(strings.ToLower(decoded), "<html")) {
+		title, text = htmlToMarkdown(decoded, page.FinalURL)
+		extractor = "markdown"
 	} else {
-		text = string(body)
+		text = decoded
 		extractor = "raw"
 	}
 
-	truncated := len(text) > maxChars
-	if truncated {
-		text = text[:maxChars]
+	chunk, nextIndex, total := paginateText(text, startIndex, maxChars)
+	if startIndex > 0 && startIndex >= total {
+		return "", fmt.Errorf("start_index %d is past the end of the content (%d characters)", startIndex, total)
 	}
 
 	result := map[string]interface{}{
-		"url":       urlStr,
-		"status":    resp.StatusCode,
-		"extractor": extractor,
-		"truncated": truncated,
-		"length":    len(text),
-		"text":      text,
+		"url":          urlStr,
+		"status":       page.Status,
+		"extractor":    extractor,
+		"charset":      encodingName,
+		"truncated":    nextIndex > 0 || page.Truncated,
+		"start_index":  startIndex,
+		"length":       utf8.RuneCountInString(chunk),
+		"total_length": total,
+		"cached":       page.Cached,
+		"text":         chunk,
+	}
+	if title != "" {
+		result["title"] = title
+	}
+	if nextIndex > 0 {
+		result["next_index"] = nextIndex
+	}
+	if page.Truncated {
+		result["note"] = fmt.Sprintf("response exceeded %d bytes; only the beginning was read", t.policy.MaxBytes())
+	}
+
+	if finalURL := page.FinalURL.String(); finalURL != urlStr {
+		result["final_url"] = finalURL
 	}
 
 	resultJSON, _ := json.MarshalIndent(result, "", "  ")
 	return string(resultJSON), nil
 }
 
-func (t *WebFetchTool) extractText(htmlContent string) string {
-	re := regexp.MustCompile(`<script[\s\S]*?</script>`)
-	result := re.ReplaceAllLiteralString(htmlContent, "")
-	re = regexp.MustCompile(`<style[\s\S]*?</style>`)
-	result = re.ReplaceAllLiteralString(result, "")
-	re = regexp.MustCompile(`<[^>]+>`)
-	result = re.ReplaceAllLiteralString(result, "")
-
-	result = strings.TrimSpace(result)
-
-	re = regexp.MustCompile(`\s+`)
-	result = re.ReplaceAllLiteralString(result, " ")
-
-	lines := strings.Split(result, "\n")
-	var cleanLines []string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			cleanLines = append(cleanLines, line)
+// fetchedPage is a web_fetch response, fresh from the network or from the cache.
+type fetchedPage struct {
+	Status      int
+	ContentType string
+	FinalURL    *url.URL
+	Body        []byte
+	Truncated   bool
+	Cached      bool
+}
+
+// fetch downloads u under the fetch policy. Fresh cached responses are
+// returned without a request; stale ones with an ETag or Last-Modified are
+// revalidated with a conditional request.
+func (t *WebFetchTool) fetch(ctx context.Context, u *url.URL) (*fetchedPage, error) {
+	key := fetchCacheKey(u)
+	entry, cachedBody, hit := t.cache.Get(key)
+	if hit && entry.Fresh() {
+		return cachedPage(entry, cachedBody, u), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if hit {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := t.policy.Client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if hit && resp.StatusCode == http.StatusNotModified {
+		expires, _ := cacheExpiry(resp.Header, t.cache.DefaultTTL())
+		t.cache.Refresh(key, expires)
+		return cachedPage(entry, cachedBody, u), nil
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !t.policy.AllowsContentType(contentType) {
+		return nil, fmt.Errorf("content type %q is not allowed by the fetch policy", contentType)
+	}
+
+	body, truncated, err := t.policy.ReadBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+		if !t.policy.AllowsContentType(contentType) {
+			return nil, fmt.Errorf("content type %q is not allowed by the fetch policy", contentType)
+		}
+	}
+
+	page := &fetchedPage{
+		Status:      resp.StatusCode,
+		ContentType: contentType,
+		FinalURL:    resp.Request.URL,
+		Body:        body,
+		Truncated:   truncated,
+	}
+
+	if t.cache != nil && resp.StatusCode == http.StatusOK {
+		if expires, store := cacheExpiry(resp.Header, t.cache.DefaultTTL()); store {
+			t.cache.Put(&WebCacheEntry{
+				Key:          key,
+				ExpiresAt:    expires,
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+				Status:       resp.StatusCode,
+				ContentType:  contentType,
+				FinalURL:     page.FinalURL.String(),
+				Truncated:    truncated,
+			}, body)
 		}
 	}
 
-	return strings.Join(cleanLines, "\n")
+	return page, nil
+}
+
+func cachedPage(entry *WebCacheEntry, body []byte, requested *url.URL) *fetchedPage {
+	finalURL := requested
+	if u, err := url.Parse(entry.FinalURL); err == nil && entry.FinalURL != "" {
+		finalURL = u
+	}
+	return &fetchedPage{
+		Status:      entry.Status,
+		ContentType: entry.ContentType,
+		FinalURL:    finalURL,
+		Body:        body,
+		Truncated:   entry.Truncated,
+		Cached:      true,
+	}
+}
+
+// paginateText returns up to maxChars characters of text starting at
+// startIndex, cut at a paragraph, line, sentence or word boundary when
+// possible. nextIndex is 0 when the end of the text was reached.
+func paginateText(text string, startIndex, maxChars int) (page string, nextIndex, total int) {
+	runes := []rune(text)
+	total = len(runes)
+	if startIndex >= total {
+		return "", 0, total
+	}
+
+	end := startIndex + maxChars
+	if end >= total {
+		return string(runes[startIndex:]), 0, total
+	}
+
+	// Only look back over the last fifth of the window for a boundary
+	floor := end - maxChars/5
+	cut := -1
+	for _, boundary := range []string{"\n\n", "\n", "。！？.!?", " "} {
+		for i := end; i > floor; i-- {
+			if strings.ContainsRune(boundary, runes[i-1]) && (boundary != "\n\n" || (i >= 2 && runes[i-2] == '\n')) {
+				cut = i
+				break
+			}
+		}
+		if cut > 0 {
+			break
+		}
+	}
+	if cut < 0 {
+		cut = end
+	}
+	return string(runes[startIndex:cut]), cut, total
 }
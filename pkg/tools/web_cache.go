@@ -0,0 +1,350 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+	"github.com/weiwei929/mypicoclaw/pkg/logger"
+)
+
+// WebCacheEntry describes a cached web_fetch response or web_search result
+// list. The body is stored next to it on disk.
+type WebCacheEntry struct {
+	Key          string    `json:"key"`
+	StoredAt     time.Time `json:"stored_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	LastAccess   time.Time `json:"last_access"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Status       int       `json:"status,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	FinalURL     string    `json:"final_url,omitempty"`
+	Truncated    bool      `json:"truncated,omitempty"`
+	Source       string    `json:"source,omitempty"`
+
+	body []byte // only used by in-memory caches
+}
+
+// Fresh reports whether the entry can be used without revalidation.
+func (e *WebCacheEntry) Fresh() bool {
+	return time.Now().Before(e.ExpiresAt)
+}
+
+// CanRevalidate reports whether a stale entry can be checked with a conditional request.
+func (e *WebCacheEntry) CanRevalidate() bool {
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// WebCache is a size-bounded LRU cache of web responses, shared by web_search
+// and web_fetch. With an empty directory it keeps everything in memory.
+type WebCache struct {
+	dir        string
+	maxBytes   int64
+	defaultTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*WebCacheEntry
+	size    int64
+}
+
+// NewWebCache creates a cache in dir (created if needed) from the
+// tools.web.cache config section. Existing entries are loaded from disk.
+func NewWebCache(dir string, cfg config.WebCacheConfig) *WebCache {
+	c := &WebCache{
+		dir:        dir,
+		maxBytes:   int64(cfg.MaxSizeMB) * 1024 * 1024,
+		defaultTTL: time.Duration(cfg.TTLSeconds) * time.Second,
+		entries:    make(map[string]*WebCacheEntry),
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = 100 * 1024 * 1024
+	}
+	if c.defaultTTL <= 0 {
+		c.defaultTTL = time.Hour
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			logger.WarnCF("tool", "Web cache directory unavailable, caching in memory",
+				map[string]interface{}{
+					"dir":   dir,
+					"error": err.Error(),
+				})
+			c.dir = ""
+		} else {
+			c.load()
+		}
+	}
+	return c
+}
+
+// NewMemoryWebCache creates a cache that is not persisted.
+func NewMemoryWebCache(maxBytes int64, ttl time.Duration) *WebCache {
+	return &WebCache{
+		maxBytes:   maxBytes,
+		defaultTTL: ttl,
+		entries:    make(map[string]*WebCacheEntry),
+	}
+}
+
+// DefaultTTL returns the TTL used when a response carries no caching headers.
+func (c *WebCache) DefaultTTL() time.Duration {
+	return c.defaultTTL
+}
+
+func (c *WebCache) load() {
+	matches, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	for _, metaPath := range matches {
+		data, err := os.ReadFile(metaPath)
+		if err != nil {
+			continue
+		}
+		var entry WebCacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Key == "" {
+			os.Remove(metaPath)
+			continue
+		}
+		if _, err := os.Stat(c.bodyPath(entry.Key)); err != nil {
+			os.Remove(metaPath)
+			continue
+		}
+		c.entries[entry.Key] = &entry
+		c.size += entry.Size
+	}
+	c.evict()
+}
+
+// Get returns the entry and body for key, including stale entries that can
+// still be revalidated. Expired entries without validators are dropped.
+func (c *WebCache) Get(key string) (*WebCacheEntry, []byte, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	if !entry.Fresh() && !entry.CanRevalidate() {
+		c.remove(key)
+		return nil, nil, false
+	}
+
+	body := entry.body
+	if c.dir != "" {
+		var err error
+		if body, err = os.ReadFile(c.bodyPath(key)); err != nil {
+			c.remove(key)
+			return nil, nil, false
+		}
+	}
+
+	entry.LastAccess = time.Now()
+	c.writeMeta(entry)
+	copied := *entry
+	return &copied, body, true
+}
+
+// Put stores body under entry.Key, replacing any previous entry, and evicts
+// least recently used entries beyond the size limit.
+func (c *WebCache) Put(entry *WebCacheEntry, body []byte) {
+	if c == nil || int64(len(body)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored := *entry
+	now := time.Now()
+	stored.StoredAt = now
+	stored.LastAccess = now
+	stored.Size = int64(len(body))
+	if stored.ExpiresAt.IsZero() {
+		stored.ExpiresAt = now.Add(c.defaultTTL)
+	}
+
+	c.remove(stored.Key)
+	if c.dir == "" {
+		stored.body = body
+	} else if err := os.WriteFile(c.bodyPath(stored.Key), body, 0644); err != nil {
+		logger.WarnCF("tool", "Failed to write web cache entry",
+			map[string]interface{}{
+				"key":   stored.Key,
+				"error": err.Error(),
+			})
+		return
+	}
+
+	c.entries[stored.Key] = &stored
+	c.size += stored.Size
+	c.writeMeta(&stored)
+	c.evict()
+}
+
+// Refresh extends the lifetime of an entry after a 304 Not Modified response.
+func (c *WebCache) Refresh(key string, expires time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		entry.ExpiresAt = expires
+		entry.LastAccess = time.Now()
+		c.writeMeta(entry)
+	}
+}
+
+func (c *WebCache) evict() {
+	if c.size <= c.maxBytes {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].LastAccess.Before(c.entries[keys[j]].LastAccess)
+	})
+	for _, k := range keys {
+		if c.size <= c.maxBytes {
+			break
+		}
+		c.remove(k)
+	}
+}
+
+func (c *WebCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.size -= entry.Size
+	delete(c.entries, key)
+	if c.dir != "" {
+		os.Remove(c.metaPath(key))
+		os.Remove(c.bodyPath(key))
+	}
+}
+
+func (c *WebCache) writeMeta(entry *WebCacheEntry) {
+	if c.dir == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	os.WriteFile(c.metaPath(entry.Key), data, 0644)
+}
+
+func (c *WebCache) fileBase(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16]))
+}
+
+func (c *WebCache) metaPath(key string) string {
+	return c.fileBase(key) + ".json"
+}
+
+func (c *WebCache) bodyPath(key string) string {
+	return c.fileBase(key) + ".body"
+}
+
+// fetchCacheKey returns the cache key for a URL: scheme and host lower-cased,
+// default ports, fragments and utm_* tracking parameters removed, and query
+// parameters sorted.
+func fetchCacheKey(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	host := strings.ToLower(n.Hostname())
+	port := n.Port()
+	if (n.Scheme == "http" && port == "80") || (n.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	n.Host = host
+	if port != "" {
+		n.Host = host + ":" + port
+	}
+	n.Fragment = ""
+	n.RawFragment = ""
+	if n.Path == "" {
+		n.Path = "/"
+	}
+
+	query := n.Query()
+	for k := range query {
+		if strings.HasPrefix(strings.ToLower(k), "utm_") {
+			query.Del(k)
+		}
+	}
+	n.RawQuery = query.Encode()
+
+	return "fetch:" + n.String()
+}
+
+// searchCacheKey returns the cache key for a search query.
+func searchCacheKey(query string, count int) string {
+	return "search:" + strconv.Itoa(count) + ":" + strings.ToLower(collapseSpace(query))
+}
+
+// cacheExpiry computes when a response stops being fresh from its
+// Cache-Control and Expires headers, never later than maxTTL from now.
+// store is false for responses that must not be cached at all.
+func cacheExpiry(h http.Header, maxTTL time.Duration) (expires time.Time, store bool) {
+	now := time.Now()
+	ttl := maxTTL
+
+	cc := strings.ToLower(h.Get("Cache-Control"))
+	if strings.Contains(cc, "no-store") {
+		return time.Time{}, false
+	}
+
+	hasValidator := h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+	if strings.Contains(cc, "no-cache") {
+		return now, hasValidator
+	}
+
+	maxAge := -1
+	for _, directive := range strings.Split(cc, ",") {
+		directive = strings.TrimSpace(directive)
+		if v, ok := strings.CutPrefix(directive, "max-age="); ok {
+			if n, err := strconv.Atoi(strings.Trim(v, `"`)); err == nil {
+				maxAge = n
+			}
+		}
+	}
+
+	switch {
+	case maxAge >= 0:
+		ttl = time.Duration(maxAge) * time.Second
+	case h.Get("Expires") != "":
+		if t, err := http.ParseTime(h.Get("Expires")); err == nil {
+			ttl = t.Sub(now)
+		} else {
+			ttl = 0
+		}
+	}
+
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	if ttl <= 0 {
+		return now, hasValidator
+	}
+	return now.Add(ttl), true
+}
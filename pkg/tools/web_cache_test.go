@@ -0,0 +1,109 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func TestWebFetchCacheRevalidation(t *testing.T) {
+	var requests, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/fresh" {
+			w.Header().Set("Cache-Control", "max-age=300")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		w.Write([]byte("hello from " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	fetchCfg := config.DefaultConfig().Tools.Web.Fetch
+	fetchCfg.AllowPrivateNetworks = true
+	tool := NewWebFetchToolWithConfig(fetchCfg)
+	tool.SetCache(NewWebCache(t.TempDir(), config.DefaultConfig().Tools.Web.Cache))
+
+	fetch := func(path string) map[string]interface{} {
+		t.Helper()
+		out, err := tool.Execute(context.Background(), map[string]interface{}{"url": srv.URL + path})
+		if err != nil {
+			t.Fatalf("fetch %s: %v", path, err)
+		}
+		var result map[string]interface{}
+		if err := json.Unmarshal([]byte(out), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if r := fetch("/fresh"); r["cached"] != false {
+		t.Errorf("first fetch cached = %v", r["cached"])
+	}
+	if r := fetch("/fresh#section"); r["cached"] != true || r["text"] != "hello from /fresh" {
+		t.Errorf("second fetch = %v", r)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("fresh entry caused %d requests, want 1", requests.Load())
+	}
+
+	fetch("/revalidate")
+	if r := fetch("/revalidate"); r["cached"] != true || r["text"] != "hello from /revalidate" {
+		t.Errorf("revalidated fetch = %v", r)
+	}
+	if notModified.Load() != 1 {
+		t.Errorf("expected one conditional request, got %d", notModified.Load())
+	}
+}
+
+func TestWebCacheLRU(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewWebCache(dir, config.WebCacheConfig{MaxSizeMB: 1, TTLSeconds: 60})
+	chunk := strings.Repeat("x", 400*1024)
+
+	cache.Put(&WebCacheEntry{Key: "a"}, []byte(chunk))
+	time.Sleep(time.Millisecond)
+	cache.Put(&WebCacheEntry{Key: "b"}, []byte(chunk))
+	time.Sleep(time.Millisecond)
+	cache.Get("a") // a is now more recently used than b
+	time.Sleep(time.Millisecond)
+	cache.Put(&WebCacheEntry{Key: "c"}, []byte(chunk))
+
+	if _, _, ok := cache.Get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, _, ok := cache.Get(key); !ok {
+			t.Errorf("entry %s missing", key)
+		}
+	}
+
+	// Entries survive a restart
+	reopened := NewWebCache(dir, config.WebCacheConfig{MaxSizeMB: 1, TTLSeconds: 60})
+	if _, body, ok := reopened.Get("c"); !ok || len(body) != len(chunk) {
+		t.Error("entry not loaded from disk")
+	}
+}
+
+func TestFetchCacheKey(t *testing.T) {
+	a, _ := url.Parse("HTTPS://Example.com:443/page?b=2&a=1&utm_source=x#top")
+	b, _ := url.Parse("https://example.com/page?a=1&b=2")
+	if fetchCacheKey(a) != fetchCacheKey(b) {
+		t.Errorf("keys differ: %s vs %s", fetchCacheKey(a), fetchCacheKey(b))
+	}
+}
@@ -0,0 +1,242 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+// blockedPrefixes are address ranges web_fetch never connects to unless
+// private networks are allowed: loopback, RFC 1918, link-local (including
+// cloud metadata at 169.254.169.254), CGNAT, and other special-use ranges.
+var blockedPrefixes = mustParsePrefixes(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParsePrefixes(cidrs ...string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		prefixes = append(prefixes, netip.MustParsePrefix(c))
+	}
+	return prefixes
+}
+
+// FetchPolicy decides which URLs web_fetch may request and bounds what it reads.
+// Addresses are checked when a URL is accepted, on every redirect, and again
+// on the connected socket, so DNS rebinding cannot slip a private address past
+// the first check.
+type FetchPolicy struct {
+	allowPrivate bool
+	allowed      []string
+	denied       []string
+	contentTypes []string
+	maxBytes     int64
+	maxRedirects int
+	timeout      time.Duration
+}
+
+// NewFetchPolicy creates a FetchPolicy from the tools.web.fetch config section.
+func NewFetchPolicy(cfg config.WebFetchConfig) *FetchPolicy {
+	p := &FetchPolicy{
+		allowPrivate: cfg.AllowPrivateNetworks,
+		allowed:      normalizeDomains(cfg.AllowedDomains),
+		denied:       normalizeDomains(cfg.DeniedDomains),
+		maxBytes:     cfg.MaxResponseBytes,
+		maxRedirects: cfg.MaxRedirects,
+		timeout:      time.Duration(cfg.TimeoutSeconds) * time.Second,
+	}
+	for _, ct := range cfg.AllowedContentTypes {
+		if ct = strings.ToLower(strings.TrimSpace(ct)); ct != "" {
+			p.contentTypes = append(p.contentTypes, ct)
+		}
+	}
+	if p.maxBytes <= 0 {
+		p.maxBytes = 5 * 1024 * 1024
+	}
+	if p.maxRedirects <= 0 {
+		p.maxRedirects = 5
+	}
+	if p.timeout <= 0 {
+		p.timeout = 60 * time.Second
+	}
+	return p
+}
+
+func normalizeDomains(domains []string) []string {
+	var result []string
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		d = strings.TrimPrefix(d, "*.")
+		d = strings.TrimSuffix(d, ".")
+		if d != "" {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// MaxBytes returns the maximum number of response bytes read.
+func (p *FetchPolicy) MaxBytes() int64 {
+	return p.maxBytes
+}
+
+// CheckURL validates scheme, host and domain lists, and resolves the host to
+// make sure it does not point into a blocked range.
+func (p *FetchPolicy) CheckURL(ctx context.Context, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http/https URLs are allowed")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return fmt.Errorf("missing domain in URL")
+	}
+
+	if domainMatches(host, p.denied) {
+		return fmt.Errorf("fetch blocked: %s is in the denied domains", host)
+	}
+	if len(p.allowed) > 0 && !domainMatches(host, p.allowed) {
+		return fmt.Errorf("fetch blocked: %s is not in the allowed domains", host)
+	}
+
+	if p.allowPrivate {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.checkAddr(addr)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("fetch blocked: %s is a local address", host)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err := p.checkAddr(addr); err != nil {
+			return fmt.Errorf("fetch blocked: %s resolves to %s, a private or reserved address", host, addr.Unmap())
+		}
+	}
+	return nil
+}
+
+func (p *FetchPolicy) checkAddr(addr netip.Addr) error {
+	if p.allowPrivate {
+		return nil
+	}
+	addr = addr.Unmap()
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("fetch blocked: %s is a private or reserved address", addr)
+		}
+	}
+	return nil
+}
+
+// domainMatches reports whether host equals one of domains or is a subdomain of it.
+func domainMatches(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsContentType reports whether a response with the given Content-Type
+// header may be read. Entries may end in "/*" to allow a whole type.
+func (p *FetchPolicy) AllowsContentType(contentType string) bool {
+	if len(p.contentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	for _, allowed := range p.contentTypes {
+		if allowed == mediaType || allowed == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client returns an HTTP client that enforces the policy on redirects and at
+// dial time. Proxies from the environment are not used, since the dial check
+// would then only see the proxy's address.
+func (p *FetchPolicy) Client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return fmt.Errorf("fetch blocked: unexpected dial address %s", address)
+			}
+			return p.checkAddr(addr)
+		},
+	}
+
+	return &http.Client{
+		Timeout: p.timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     30 * time.Second,
+			TLSHandshakeTimeout: 15 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= p.maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", p.maxRedirects)
+			}
+			return p.CheckURL(req.Context(), req.URL)
+		},
+	}
+}
+
+// ReadBody reads at most MaxBytes from r. truncated is true when the
+// response was longer; the rest is never read into memory.
+func (p *FetchPolicy) ReadBody(r io.Reader) (body []byte, truncated bool, err error) {
+	body, err = io.ReadAll(io.LimitReader(r, p.maxBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) > p.maxBytes {
+		return body[:p.maxBytes], true, nil
+	}
+	return body, false, nil
+}
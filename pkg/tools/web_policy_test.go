@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/weiwei929/mypicoclaw/pkg/config"
+)
+
+func TestFetchPolicyCheckURL(t *testing.T) {
+	cfg := config.DefaultConfig().Tools.Web.Fetch
+	cfg.DeniedDomains = []string{"evil.example"}
+	p := NewFetchPolicy(cfg)
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{"Cloud metadata", "http://169.254.169.254/latest/meta-data/", "private or reserved"},
+		{"Loopback", "http://127.0.0.1:18790/", "private or reserved"},
+		{"Localhost name", "http://localhost:18790/", "local address"},
+		{"Private range", "http://10.1.2.3/", "private or reserved"},
+		{"IPv6 loopback", "http://[::1]/", "private or reserved"},
+		{"IPv4-mapped IPv6", "http://[::ffff:127.0.0.1]/", "private or reserved"},
+		{"Unspecified", "http://0.0.0.0/", "private or reserved"},
+		{"Denied subdomain", "https://www.evil.example/", "denied domains"},
+		{"File scheme", "file:///etc/passwd", "only http/https"},
+		{"Public address", "http://93.184.215.14/", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.CheckURL(context.Background(), u)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckURL(%s) unexpected error: %v", tt.url, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("CheckURL(%s) error = %v, want containing %q", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchPolicyAllowedDomains(t *testing.T) {
+	cfg := config.DefaultConfig().Tools.Web.Fetch
+	cfg.AllowedDomains = []string{"*.example.org"}
+	cfg.AllowPrivateNetworks = true
+	p := NewFetchPolicy(cfg)
+
+	for host, want := range map[string]bool{
+		"example.org":      true,
+		"docs.example.org": true,
+		"example.org.evil": false,
+		"badexample.org":   false,
+	} {
+		err := p.CheckURL(context.Background(), &url.URL{Scheme: "https", Host: host})
+		if (err == nil) != want {
+			t.Errorf("CheckURL(%s) error = %v, want allowed=%v", host, err, want)
+		}
+	}
+}
+
+func TestFetchPolicyDialCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer srv.Close()
+
+	// The URL check is bypassed here on purpose: the dial-time check alone must
+	// refuse the loopback connection, as it would after a DNS rebind.
+	blocked := NewFetchPolicy(config.DefaultConfig().Tools.Web.Fetch)
+	if _, err := blocked.Client().Get(srv.URL); err == nil || !strings.Contains(err.Error(), "private or reserved") {
+		t.Fatalf("expected dial to be blocked, got %v", err)
+	}
+
+	cfg := config.DefaultConfig().Tools.Web.Fetch
+	cfg.AllowPrivateNetworks = true
+	cfg.MaxResponseBytes = 10
+	allowed := NewFetchPolicy(cfg)
+	resp, err := allowed.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, truncated, err := allowed.ReadBody(resp.Body)
+	if err != nil || len(body) != 10 || !truncated {
+		t.Errorf("ReadBody = %d bytes, truncated=%v, err=%v; want 10 bytes truncated", len(body), truncated, err)
+	}
+}
+
+func TestFetchPolicyContentTypes(t *testing.T) {
+	p := NewFetchPolicy(config.DefaultConfig().Tools.Web.Fetch)
+	for ct, want := range map[string]bool{
+		"text/html; charset=utf-8": true,
+		"application/json":         true,
+		"application/octet-stream": false,
+		"image/png":                false,
+	} {
+		if got := p.AllowsContentType(ct); got != want {
+			t.Errorf("AllowsContentType(%q) = %v, want %v", ct, got, want)
+		}
+	}
+}
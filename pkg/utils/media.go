@@ -0,0 +1,11 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// MediaDir returns the directory where channels store downloaded attachments.
+func MediaDir() string {
+	return filepath.Join(os.TempDir(), "picoclaw_media")
+}